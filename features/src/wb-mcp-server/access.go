@@ -0,0 +1,397 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// access_review gathers workspace roles (direct and through groups), group
+// memberships and, optionally, pod roles into one flat list of grants, and
+// flags the ones worth a second look in a periodic review.
+
+// accessGrant is one row of the review.
+type accessGrant struct {
+	Scope        string   `json:"scope"` // workspace, group or pod
+	Resource     string   `json:"resource"`
+	ResourceName string   `json:"resourceName,omitempty"`
+	Email        string   `json:"email"`
+	Role         string   `json:"role"`
+	Via          string   `json:"via"` // "direct" or "group:<name>"
+	LastActivity string   `json:"lastActivity,omitempty"`
+	Flags        []string `json:"flags,omitempty"`
+}
+
+// roleRank orders workspace roles so implied grants can be spotted.
+var roleRank = map[string]int{"READER": 1, "WRITER": 2, "OWNER": 3}
+
+// accessGroup is a group with its members' roles, by lowercased email.
+type accessGroup struct {
+	Name    string
+	Email   string
+	Members map[string][]string
+}
+
+// wbJSON runs a wb command and decodes its JSON output into v.
+func wbJSON(ctx context.Context, args []string, v interface{}) error {
+	out, err := executeWbCommand(ctx, args)
+	if err != nil {
+		return fmt.Errorf("%v: %s", err, truncate(strings.TrimSpace(out), 200))
+	}
+	if err := json.Unmarshal([]byte(out), v); err != nil {
+		return fmt.Errorf("failed to parse output of wb %s: %w", strings.Join(args[:2], " "), err)
+	}
+	return nil
+}
+
+// principalRoles pulls the email and roles out of a member or role-binding
+// entry; the CLI reports them as "role", "roles" or "policies".
+func principalRoles(v interface{}) (string, []string) {
+	m, ok := v.(map[string]interface{})
+	if !ok {
+		s, _ := v.(string)
+		return strings.ToLower(s), nil
+	}
+	email := firstNonEmpty(str(m["email"]), str(m["principal"]), str(m["member"]))
+	var roles []string
+	if r := str(m["role"]); r != "" {
+		roles = append(roles, strings.ToUpper(r))
+	}
+	for _, key := range []string{"roles", "policies"} {
+		if list, ok := m[key].([]interface{}); ok {
+			for _, r := range list {
+				if s := str(r); s != "" {
+					roles = append(roles, strings.ToUpper(s))
+				}
+			}
+		}
+	}
+	return strings.ToLower(email), roles
+}
+
+func str(v interface{}) string {
+	s, _ := v.(string)
+	return s
+}
+
+// loadAccessGroups lists the caller's groups and their members. Groups whose
+// members can't be read are skipped with a warning.
+func loadAccessGroups(ctx context.Context, only []string, warnings *[]string) map[string]*accessGroup {
+	var list []map[string]interface{}
+	if err := wbJSON(ctx, []string{"group", "list", "--format=json"}, &list); err != nil {
+		*warnings = append(*warnings, fmt.Sprintf("could not list groups: %v", err))
+		return nil
+	}
+	wanted := map[string]bool{}
+	for _, g := range only {
+		wanted[strings.ToLower(g)] = true
+	}
+	groups := map[string]*accessGroup{} // by lowercased group email
+	for _, g := range list {
+		name := firstNonEmpty(str(g["name"]), str(g["id"]))
+		if len(wanted) > 0 && !wanted[strings.ToLower(name)] {
+			continue
+		}
+		var members []interface{}
+		if err := wbJSON(ctx, []string{"group", "member", "list", "--group-id=" + name, "--format=json"}, &members); err != nil {
+			*warnings = append(*warnings, fmt.Sprintf("could not list members of group %s: %v", name, err))
+			continue
+		}
+		ag := &accessGroup{Name: name, Email: strings.ToLower(str(g["email"])), Members: map[string][]string{}}
+		for _, m := range members {
+			email, roles := principalRoles(m)
+			if email == "" {
+				continue
+			}
+			if len(roles) == 0 {
+				roles = []string{"MEMBER"}
+			}
+			ag.Members[email] = append(ag.Members[email], roles...)
+		}
+		key := ag.Email
+		if key == "" {
+			key = strings.ToLower(name)
+		}
+		groups[key] = ag
+	}
+	return groups
+}
+
+// reviewWorkspaces lists the workspaces to review: the given IDs, or every
+// workspace the caller can see (up to max).
+func reviewWorkspaces(ctx context.Context, ids []string, max int) ([]map[string]interface{}, error) {
+	respBody, err := makeAPIRequest(ctx, "GET", fmt.Sprintf("%s/api/workspaces/v1?offset=0&limit=%d", workspaceBaseURL, 5000), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list workspaces: %w", err)
+	}
+	var data struct {
+		Workspaces []map[string]interface{} `json:"workspaces"`
+	}
+	if err := json.Unmarshal(respBody, &data); err != nil {
+		return nil, fmt.Errorf("failed to parse workspaces: %w", err)
+	}
+	if len(ids) == 0 {
+		if len(data.Workspaces) > max {
+			data.Workspaces = data.Workspaces[:max]
+		}
+		return data.Workspaces, nil
+	}
+	var out []map[string]interface{}
+	var known []string
+	for _, id := range ids {
+		found := false
+		for _, ws := range data.Workspaces {
+			if str(ws["userFacingId"]) == id || str(ws["id"]) == id {
+				out = append(out, ws)
+				found = true
+				break
+			}
+		}
+		if !found {
+			if known == nil {
+				for _, ws := range data.Workspaces {
+					known = append(known, str(ws["userFacingId"]))
+				}
+			}
+			return nil, unknownName("workspace", id, "", known)
+		}
+	}
+	return out, nil
+}
+
+// flagGrants marks grants for review:
+//   - stale: the workspace hasn't changed in staleDays
+//   - redundant: the same person has an equal or higher role on the same
+//     workspace another way
+//   - many-owners: one of more than maxOwners owners of a workspace
+//   - external: the email's domain isn't in (or under) allowedDomains
+func flagGrants(grants []accessGrant, staleDays, maxOwners int, allowedDomains []string) {
+	cutoff := time.Now().AddDate(0, 0, -staleDays)
+	byPrincipal := map[string][]int{} // workspace|email -> indexes of its grants
+	owners := map[string]map[string]bool{}
+	for i, g := range grants {
+		if g.Scope != "workspace" {
+			continue
+		}
+		key := g.Resource + "|" + g.Email
+		byPrincipal[key] = append(byPrincipal[key], i)
+		if g.Role == "OWNER" {
+			if owners[g.Resource] == nil {
+				owners[g.Resource] = map[string]bool{}
+			}
+			owners[g.Resource][g.Email] = true
+		}
+	}
+	for i := range grants {
+		g := &grants[i]
+		if g.Scope == "workspace" {
+			if t, err := time.Parse(time.RFC3339, g.LastActivity); err == nil && t.Before(cutoff) {
+				g.Flags = append(g.Flags, "stale")
+			}
+			for _, j := range byPrincipal[g.Resource+"|"+g.Email] {
+				other := grants[j]
+				if j != i && (roleRank[other.Role] > roleRank[g.Role] || (other.Role == g.Role && other.Via == "direct" && g.Via != "direct")) {
+					g.Flags = append(g.Flags, "redundant")
+					break
+				}
+			}
+			if g.Role == "OWNER" && len(owners[g.Resource]) > maxOwners {
+				g.Flags = append(g.Flags, "many-owners")
+			}
+		}
+		if len(allowedDomains) > 0 {
+			domain := g.Email[strings.LastIndex(g.Email, "@")+1:]
+			external := true
+			for _, d := range allowedDomains {
+				d = strings.ToLower(strings.TrimPrefix(d, "@"))
+				if domain == d || strings.HasSuffix(domain, "."+d) {
+					external = false
+				}
+			}
+			if external {
+				g.Flags = append(g.Flags, "external")
+			}
+		}
+	}
+}
+
+// handleAccessReview implements access_review.
+func handleAccessReview(ctx context.Context, args map[string]interface{}) (string, error) {
+	email := strings.ToLower(str(args["email"]))
+	stringList := func(key string) []string {
+		var out []string
+		if list, ok := args[key].([]interface{}); ok {
+			for _, v := range list {
+				if s := str(v); s != "" {
+					out = append(out, s)
+				}
+			}
+		}
+		return out
+	}
+	intArg := func(key string, def int) int {
+		if v, ok := args[key].(float64); ok && v > 0 {
+			return int(v)
+		}
+		return def
+	}
+	format, err := checkOneOf("format", firstNonEmpty(str(args["format"]), "json"), []string{"json", "csv"})
+	if err != nil {
+		return "", err
+	}
+
+	var warnings []string
+	workspaces, err := reviewWorkspaces(ctx, stringList("workspaceIds"), intArg("maxWorkspaces", 100))
+	if err != nil {
+		return "", err
+	}
+	groups := loadAccessGroups(ctx, stringList("groupIds"), &warnings)
+
+	var grants []accessGrant
+	for _, ag := range groups {
+		for member, roles := range ag.Members {
+			for _, role := range roles {
+				grants = append(grants, accessGrant{Scope: "group", Resource: ag.Name, Email: member, Role: role, Via: "direct"})
+			}
+		}
+	}
+	for _, ws := range workspaces {
+		id := firstNonEmpty(str(ws["userFacingId"]), str(ws["id"]))
+		lastActivity := firstNonEmpty(str(ws["lastUpdatedDate"]), str(ws["createdDate"]))
+		roles, err := getWorkspaceRoles(ctx, fmt.Sprintf("%s/api/workspaces/v1/%s", workspaceBaseURL, str(ws["id"])))
+		if err != nil {
+			// Only owners can read a workspace's role bindings.
+			warnings = append(warnings, fmt.Sprintf("skipped workspace %s: %v", id, err))
+			continue
+		}
+		for principal, rs := range roles {
+			for role := range rs {
+				g := accessGrant{Scope: "workspace", Resource: id, ResourceName: str(ws["displayName"]), Email: principal, Role: role, Via: "direct", LastActivity: lastActivity}
+				grants = append(grants, g)
+				if ag, ok := groups[principal]; ok {
+					for member := range ag.Members {
+						g.Email, g.Via = member, "group:"+ag.Name
+						grants = append(grants, g)
+					}
+				}
+			}
+		}
+	}
+	if org, pod := str(args["organizationId"]), str(args["podId"]); org != "" && pod != "" {
+		var bindings []interface{}
+		if err := wbJSON(ctx, []string{"pod", "role", "list", "--organization=" + org, "--pod=" + pod, "--format=json"}, &bindings); err != nil {
+			warnings = append(warnings, fmt.Sprintf("could not list pod roles: %v", err))
+		}
+		for _, b := range bindings {
+			principal, roles := principalRoles(b)
+			for _, role := range roles {
+				grants = append(grants, accessGrant{Scope: "pod", Resource: org + "/" + pod, Email: principal, Role: role, Via: "direct"})
+			}
+		}
+	}
+
+	flagGrants(grants, intArg("staleDays", 90), intArg("maxOwners", 3), stringList("allowedDomains"))
+
+	if email != "" {
+		kept := grants[:0]
+		for _, g := range grants {
+			if g.Email == email {
+				kept = append(kept, g)
+			}
+		}
+		grants = kept
+	}
+	if onlyFlagged, _ := args["onlyFlagged"].(bool); onlyFlagged {
+		kept := grants[:0]
+		for _, g := range grants {
+			if len(g.Flags) > 0 {
+				kept = append(kept, g)
+			}
+		}
+		grants = kept
+	}
+	sort.SliceStable(grants, func(i, j int) bool {
+		a, b := grants[i], grants[j]
+		if a.Scope != b.Scope {
+			return a.Scope < b.Scope
+		}
+		if a.Resource != b.Resource {
+			return a.Resource < b.Resource
+		}
+		if a.Email != b.Email {
+			return a.Email < b.Email
+		}
+		return a.Via < b.Via
+	})
+
+	byFlag := map[string]int{}
+	flagged := 0
+	for _, g := range grants {
+		if len(g.Flags) > 0 {
+			flagged++
+		}
+		for _, f := range g.Flags {
+			byFlag[f]++
+		}
+	}
+	if grants == nil {
+		grants = []accessGrant{}
+	}
+	result := map[string]interface{}{
+		"generatedAt": time.Now().UTC().Format(time.RFC3339),
+		"summary": map[string]interface{}{
+			"workspaces": len(workspaces),
+			"groups":     len(groups),
+			"grants":     len(grants),
+			"flagged":    flagged,
+			"byFlag":     byFlag,
+		},
+		"grants": grants,
+	}
+	if format == "csv" {
+		delete(result, "grants")
+		result["csv"] = accessReviewCSV(grants)
+	}
+	if email != "" {
+		result["email"] = email
+	}
+	if len(warnings) > 0 {
+		result["warnings"] = warnings
+	}
+	data, err := json.MarshalIndent(result, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal result: %w", err)
+	}
+	return string(data), nil
+}
+
+// accessReviewCSV renders grants as CSV, one row per grant.
+func accessReviewCSV(grants []accessGrant) string {
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	w.Write([]string{"scope", "resource", "resource_name", "email", "role", "via", "last_activity", "flags"})
+	for _, g := range grants {
+		row := []string{g.Scope, g.Resource, g.ResourceName, g.Email, g.Role, g.Via, g.LastActivity, strings.Join(g.Flags, ";")}
+		for i, cell := range row {
+			row[i] = csvCell(cell)
+		}
+		w.Write(row)
+	}
+	w.Flush()
+	return buf.String()
+}
+
+// csvCell keeps spreadsheets from evaluating a cell as a formula: values
+// such as display names are user-controlled, so any starting with =, +, -, @,
+// tab or carriage return get a leading single quote.
+func csvCell(s string) string {
+	if s != "" && strings.ContainsRune("=+-@\t\r", rune(s[0])) {
+		return "'" + s
+	}
+	return s
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// groupCount is one row of a cohort count, keyed by the display values of the
+// group-by attributes ("" when ungrouped).
+type groupCount struct {
+	Key   string
+	Count int64
+}
+
+// cohortCounts calls the cohort counts endpoint, optionally grouped by
+// primary-entity attributes.
+func cohortCounts(ctx context.Context, studyId, cohortId string, groupBy []string) ([]groupCount, error) {
+	if groupBy == nil {
+		groupBy = []string{}
+	}
+	body := map[string]interface{}{"groupByAttributes": groupBy}
+	respBody, err := makeAPIRequest(ctx, "POST", fmt.Sprintf("%s/v2/studies/%s/cohorts/%s/counts", dataExplorerURL, studyId, cohortId), body)
+	if err != nil {
+		return nil, err
+	}
+	var raw struct {
+		InstanceCounts []struct {
+			Count      int64 `json:"count"`
+			Attributes map[string]struct {
+				Value struct {
+					ValueUnion map[string]interface{} `json:"valueUnion"`
+				} `json:"value"`
+				Display string `json:"display"`
+			} `json:"attributes"`
+		} `json:"instanceCounts"`
+	}
+	if err := json.Unmarshal(respBody, &raw); err != nil {
+		return nil, fmt.Errorf("failed to parse counts: %w", err)
+	}
+	var out []groupCount
+	for _, ic := range raw.InstanceCounts {
+		var parts []string
+		for _, attr := range groupBy {
+			v := ic.Attributes[attr]
+			display := v.Display
+			if display == "" {
+				for _, val := range v.Value.ValueUnion {
+					if val != nil {
+						display = fmt.Sprint(val)
+					}
+				}
+			}
+			parts = append(parts, firstNonEmpty(display, "(none)"))
+		}
+		out = append(out, groupCount{Key: strings.Join(parts, " / "), Count: ic.Count})
+	}
+	return out, nil
+}
+
+// attritionStep is one row of the funnel: the count after applying the first
+// N sections.
+type attritionStep struct {
+	Label  string
+	Count  int64
+	Groups map[string]int64
+}
+
+// handleCohortAttrition implements cohort_attrition. Sections are applied one
+// at a time to a scratch cohort in the same study, which is deleted afterwards,
+// so the source cohort is never modified.
+func handleCohortAttrition(ctx context.Context, args map[string]interface{}) (string, error) {
+	studyId, err := requireString(args, "studyId")
+	if err != nil {
+		return "", err
+	}
+	sections, _ := args["criteriaGroupSections"].([]interface{})
+	underlayName, _ := args["underlayName"].(string)
+	if cohortId, ok := args["cohortId"].(string); ok && cohortId != "" {
+		respBody, err := makeAPIRequest(ctx, "GET", fmt.Sprintf("%s/v2/studies/%s/cohorts/%s", dataExplorerURL, studyId, cohortId), nil)
+		if err != nil {
+			return "", fmt.Errorf("failed to load cohort: %w", err)
+		}
+		var cohort struct {
+			UnderlayName          string        `json:"underlayName"`
+			CriteriaGroupSections []interface{} `json:"criteriaGroupSections"`
+		}
+		if err := json.Unmarshal(respBody, &cohort); err != nil {
+			return "", fmt.Errorf("failed to parse cohort: %w", err)
+		}
+		underlayName = cohort.UnderlayName
+		if sections == nil {
+			sections = cohort.CriteriaGroupSections
+		}
+	}
+	if underlayName == "" {
+		return "", fmt.Errorf("pass cohortId, or underlayName with criteriaGroupSections")
+	}
+	if len(sections) == 0 {
+		return "", fmt.Errorf("no criteria sections to apply")
+	}
+	var groupBy []string
+	if attrs, ok := args["groupByAttributes"].([]interface{}); ok {
+		for _, a := range attrs {
+			if s, ok := a.(string); ok {
+				groupBy = append(groupBy, s)
+			}
+		}
+	}
+
+	createResp, err := makeAPIRequest(ctx, "POST", fmt.Sprintf("%s/v2/studies/%s/cohorts", dataExplorerURL, studyId), map[string]interface{}{
+		"underlayName": underlayName,
+		"displayName":  "wb-mcp attrition (temporary)",
+		"description":  "Scratch cohort for cohort_attrition; safe to delete.",
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to create scratch cohort: %w", err)
+	}
+	var scratch struct {
+		ID string `json:"id"`
+	}
+	if err := json.Unmarshal(createResp, &scratch); err != nil || scratch.ID == "" {
+		return "", fmt.Errorf("failed to parse scratch cohort: %s", string(createResp))
+	}
+	defer func() {
+		// Delete it even if the call was cancelled or timed out, or it leaks.
+		if _, err := makeAPIRequest(context.WithoutCancel(ctx), "DELETE", fmt.Sprintf("%s/v2/studies/%s/cohorts/%s", dataExplorerURL, studyId, scratch.ID), nil); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: failed to delete scratch cohort %s: %v\n", scratch.ID, err)
+		}
+	}()
+
+	ids, lines := describeSections(sections)
+	var steps []attritionStep
+	for k := 0; k <= len(sections); k++ {
+		label := "All participants"
+		if k > 0 {
+			section, _ := sections[k-1].(map[string]interface{})
+			name, _ := section["displayName"].(string)
+			label = truncate(firstNonEmpty(name, lines[ids[k-1]]), 60)
+			if _, err := makeAPIRequest(ctx, "PATCH", fmt.Sprintf("%s/v2/studies/%s/cohorts/%s", dataExplorerURL, studyId, scratch.ID), map[string]interface{}{
+				"criteriaGroupSections": sections[:k],
+			}); err != nil {
+				return "", fmt.Errorf("failed to apply section %d (%s): %w", k, label, err)
+			}
+		}
+		counts, err := cohortCounts(ctx, studyId, scratch.ID, groupBy)
+		if err != nil {
+			return "", fmt.Errorf("failed to count after section %d (%s): %w", k, label, err)
+		}
+		step := attritionStep{Label: label, Groups: map[string]int64{}}
+		for _, c := range counts {
+			step.Count += c.Count
+			step.Groups[c.Key] += c.Count
+		}
+		steps = append(steps, step)
+	}
+	return formatAttrition(steps, groupBy), nil
+}
+
+// formatAttrition renders the funnel as a text table: count after each step,
+// participants removed, and the share of the previous step and of the start.
+func formatAttrition(steps []attritionStep, groupBy []string) string {
+	var b strings.Builder
+	total := steps[0].Count
+	fmt.Fprintf(&b, "%-4s %-60s %10s %10s %9s %9s\n", "Step", "Criteria", "Count", "Removed", "% prev", "% total")
+	for i, s := range steps {
+		removed, prevPct := "", ""
+		if i > 0 {
+			prev := steps[i-1].Count
+			removed = fmt.Sprint(prev - s.Count)
+			prevPct = percent(s.Count, prev)
+		}
+		fmt.Fprintf(&b, "%-4d %-60s %10d %10s %9s %9s\n", i, s.Label, s.Count, removed, prevPct, percent(s.Count, total))
+	}
+	if len(groupBy) == 0 {
+		return b.String()
+	}
+
+	keySet := map[string]bool{}
+	for _, s := range steps {
+		for k := range s.Groups {
+			keySet[k] = true
+		}
+	}
+	var keys []string
+	for k := range keySet {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	fmt.Fprintf(&b, "\nBy %s (count, %% of the group at step 0):\n", strings.Join(groupBy, " / "))
+	fmt.Fprintf(&b, "%-4s", "Step")
+	for _, k := range keys {
+		fmt.Fprintf(&b, " %22s", truncate(k, 19))
+	}
+	b.WriteString("\n")
+	for i, s := range steps {
+		fmt.Fprintf(&b, "%-4d", i)
+		for _, k := range keys {
+			fmt.Fprintf(&b, " %22s", fmt.Sprintf("%d (%s)", s.Groups[k], percent(s.Groups[k], steps[0].Groups[k])))
+		}
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
+func percent(n, of int64) string {
+	if of == 0 {
+		return "-"
+	}
+	return fmt.Sprintf("%.1f%%", float64(n)*100/float64(of))
+}
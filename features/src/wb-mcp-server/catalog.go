@@ -0,0 +1,444 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// catalogTTL bounds how long the persisted catalog index is reused before it
+// is rebuilt from the workspace and Data Explorer APIs.
+const catalogTTL = 24 * time.Hour
+
+// catalogFieldWeights ranks where a term matched: a hit in a collection's
+// name or tags says more than a hit in one of its hundreds of attributes.
+var catalogFieldWeights = map[string]float64{
+	"name":        3,
+	"tags":        2,
+	"description": 1,
+	"properties":  1,
+	"entities":    1.5,
+	"selectors":   1.5,
+	"attributes":  0.5,
+}
+
+// catalogStopWords are dropped from queries and documents so questions like
+// "which collection has lab measurements" rank on the content words.
+var catalogStopWords = map[string]bool{
+	"a": true, "about": true, "an": true, "and": true, "any": true, "are": true,
+	"collection": true, "do": true, "does": true, "find": true, "for": true,
+	"has": true, "have": true, "i": true, "in": true, "is": true,
+	"me": true, "of": true, "on": true, "or": true, "show": true, "that": true,
+	"the": true, "to": true, "what": true, "which": true, "with": true,
+}
+
+// catalogDoc is one data collection as indexed. Fields hold the searchable
+// text by field name; Entities, Selectors and Attributes keep the individual
+// names so matches can be reported.
+type catalogDoc struct {
+	ID               string            `json:"id"`
+	UUID             string            `json:"uuid"`
+	Name             string            `json:"name"`
+	ShortDescription string            `json:"shortDescription,omitempty"`
+	UnderlayName     string            `json:"underlayName,omitempty"`
+	UnderlayError    string            `json:"underlayError,omitempty"`
+	Fields           map[string]string `json:"fields"`
+	Entities         []string          `json:"entities,omitempty"`
+	Selectors        []string          `json:"selectors,omitempty"`
+	Attributes       []string          `json:"attributes,omitempty"`
+}
+
+// catalogIndex is the persisted catalog plus the in-memory inverted index
+// built from it on load. BaseURL is the workspace manager it was built from,
+// so switching environments doesn't serve another environment's catalog.
+type catalogIndex struct {
+	BuiltAt time.Time    `json:"builtAt"`
+	BaseURL string       `json:"baseUrl"`
+	Docs    []catalogDoc `json:"docs"`
+
+	postings map[string][]catalogPosting
+	lengths  []float64
+	avgLen   float64
+}
+
+// catalogPosting is the weighted frequency of a term in one doc, with the
+// fields it occurred in.
+type catalogPosting struct {
+	Doc    int
+	Weight float64
+	Fields map[string]bool
+}
+
+var (
+	catalogMu     sync.Mutex
+	catalogLoaded *catalogIndex
+)
+
+// loadCatalog returns the catalog index, reading catalog.json from the state
+// directory or rebuilding it when missing, stale, built for another
+// environment, or refresh is set.
+func loadCatalog(ctx context.Context, refresh bool) (*catalogIndex, error) {
+	catalogMu.Lock()
+	defer catalogMu.Unlock()
+	if !refresh && catalogLoaded != nil && catalogLoaded.BaseURL == workspaceBaseURL && time.Since(catalogLoaded.BuiltAt) < catalogTTL {
+		return catalogLoaded, nil
+	}
+	path, err := statePath("catalog.json")
+	if err != nil {
+		return nil, err
+	}
+	if !refresh {
+		var idx catalogIndex
+		if err := readStateFile(path, &idx); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: ignoring catalog index: %v\n", err)
+		} else if len(idx.Docs) > 0 && idx.BaseURL == workspaceBaseURL && time.Since(idx.BuiltAt) < catalogTTL {
+			idx.build()
+			catalogLoaded = &idx
+			return catalogLoaded, nil
+		}
+	}
+
+	idx, err := buildCatalog(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err := writeStateFile(path, idx); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: failed to save catalog index: %v\n", err)
+	}
+	idx.build()
+	catalogLoaded = idx
+	return catalogLoaded, nil
+}
+
+// buildCatalog lists every data collection the user can read and indexes its
+// metadata and, when it has one, its underlay's entities and attributes.
+func buildCatalog(ctx context.Context) (*catalogIndex, error) {
+	ctx, span := startSpan(ctx, "catalog.build", spanKindInternal)
+	idx := &catalogIndex{BuiltAt: time.Now().UTC(), BaseURL: workspaceBaseURL}
+	const pageSize = 100
+	for offset := 0; ; offset += pageSize {
+		respBody, err := makeAPIRequest(ctx, "POST", workspaceBaseURL+"/api/workspaces/v2/filtered", map[string]interface{}{
+			"limit":  pageSize,
+			"offset": offset,
+			"properties": []map[string]string{
+				{"key": "terra-type", "value": "data-collection"},
+			},
+		})
+		if err != nil {
+			span.end(err)
+			return nil, fmt.Errorf("failed to list data collections: %w", err)
+		}
+		var page struct {
+			Workspaces []map[string]interface{} `json:"workspaces"`
+		}
+		if err := json.Unmarshal(respBody, &page); err != nil {
+			span.end(err)
+			return nil, fmt.Errorf("failed to parse response: %w", err)
+		}
+		for _, ws := range page.Workspaces {
+			idx.Docs = append(idx.Docs, catalogDocument(ws))
+		}
+		if len(page.Workspaces) < pageSize {
+			break
+		}
+	}
+
+	for i := range idx.Docs {
+		doc := &idx.Docs[i]
+		if doc.UnderlayName == "" {
+			continue
+		}
+		schema, err := loadUnderlaySchema(ctx, doc.UnderlayName)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: catalog: %v\n", err)
+			doc.UnderlayError = err.Error()
+			continue
+		}
+		for _, name := range schema.entityNames() {
+			doc.Entities = append(doc.Entities, name)
+			for _, attr := range schema.Entities[name].attributeNames() {
+				doc.Attributes = append(doc.Attributes, name+"."+attr)
+			}
+		}
+		for _, sel := range schema.Selectors {
+			doc.Selectors = append(doc.Selectors, firstNonEmpty(sel.DisplayName, sel.Name))
+		}
+		doc.Fields["entities"] = strings.Join(doc.Entities, " ")
+		doc.Fields["selectors"] = strings.Join(doc.Selectors, " ")
+		doc.Fields["attributes"] = strings.Join(doc.Attributes, " ")
+	}
+	span.setAttr("catalog.collections", len(idx.Docs))
+	span.end(nil)
+	return idx, nil
+}
+
+// catalogDocument maps a data collection workspace to its indexed fields.
+func catalogDocument(ws map[string]interface{}) catalogDoc {
+	uuid, _ := ws["id"].(string)
+	userFacingId, _ := ws["userFacingId"].(string)
+	name, _ := ws["displayName"].(string)
+	desc, _ := ws["description"].(string)
+	props := workspaceProperties(ws)
+
+	var other []string
+	for k, v := range props {
+		switch k {
+		case "terra-type", "terra-workspace-short-description", "terra-data-modality-tags",
+			"terra-therapeutic-tags", "terra-dc-data-model", "terra-dx-underlay-name":
+			continue
+		}
+		other = append(other, v)
+	}
+	sort.Strings(other)
+	return catalogDoc{
+		ID:               userFacingId,
+		UUID:             uuid,
+		Name:             firstNonEmpty(name, userFacingId),
+		ShortDescription: props["terra-workspace-short-description"],
+		UnderlayName:     props["terra-dx-underlay-name"],
+		Fields: map[string]string{
+			"name":        firstNonEmpty(name, userFacingId),
+			"description": props["terra-workspace-short-description"] + " " + desc,
+			"tags": strings.Join([]string{props["terra-data-modality-tags"], props["terra-therapeutic-tags"],
+				props["terra-dc-data-model"], props["terra-dx-underlay-name"]}, " "),
+			"properties": strings.Join(other, " "),
+		},
+	}
+}
+
+// workspaceProperties flattens a workspace's properties array into a map.
+func workspaceProperties(ws map[string]interface{}) map[string]string {
+	props := make(map[string]string)
+	if propsArray, ok := ws["properties"].([]interface{}); ok {
+		for _, p := range propsArray {
+			if prop, ok := p.(map[string]interface{}); ok {
+				k, _ := prop["key"].(string)
+				v, _ := prop["value"].(string)
+				props[k] = v
+			}
+		}
+	}
+	return props
+}
+
+// build computes the inverted index from Docs.
+func (idx *catalogIndex) build() {
+	idx.postings = map[string][]catalogPosting{}
+	idx.lengths = make([]float64, len(idx.Docs))
+	var total float64
+	for i, doc := range idx.Docs {
+		terms := map[string]*catalogPosting{}
+		for field, text := range doc.Fields {
+			weight := catalogFieldWeights[field]
+			for _, term := range catalogTokens(text) {
+				p := terms[term]
+				if p == nil {
+					p = &catalogPosting{Doc: i, Fields: map[string]bool{}}
+					terms[term] = p
+				}
+				p.Weight += weight
+				p.Fields[field] = true
+				idx.lengths[i] += weight
+			}
+		}
+		for term, p := range terms {
+			idx.postings[term] = append(idx.postings[term], *p)
+		}
+		total += idx.lengths[i]
+	}
+	if len(idx.Docs) > 0 {
+		idx.avgLen = total / float64(len(idx.Docs))
+	}
+}
+
+// catalogResult is one ranked collection with the fields and names that
+// matched the query.
+type catalogResult struct {
+	ID               string              `json:"id"`
+	UUID             string              `json:"uuid"`
+	Name             string              `json:"name"`
+	Score            float64             `json:"score"`
+	MatchedTerms     []string            `json:"matchedTerms"`
+	MatchedFields    []string            `json:"matchedFields"`
+	Matches          map[string][]string `json:"matches,omitempty"`
+	ShortDescription string              `json:"shortDescription,omitempty"`
+	UnderlayName     string              `json:"underlayName,omitempty"`
+	WorkbenchURL     string              `json:"workbenchUrl"`
+}
+
+// search ranks docs with BM25 over field-weighted term frequencies.
+func (idx *catalogIndex) search(query string, limit int) []catalogResult {
+	const k1, b = 1.2, 0.75
+	n := float64(len(idx.Docs))
+	scores := map[int]float64{}
+	terms := map[int][]string{}
+	fields := map[int]map[string]bool{}
+	seen := map[string]bool{}
+	for _, term := range catalogTokens(query) {
+		if seen[term] {
+			continue
+		}
+		seen[term] = true
+		postings := idx.postings[term]
+		if len(postings) == 0 {
+			continue
+		}
+		df := float64(len(postings))
+		idf := math.Log(1 + (n-df+0.5)/(df+0.5))
+		for _, p := range postings {
+			norm := 1 - b + b*idx.lengths[p.Doc]/idx.avgLen
+			scores[p.Doc] += idf * p.Weight * (k1 + 1) / (p.Weight + k1*norm)
+			terms[p.Doc] = append(terms[p.Doc], term)
+			if fields[p.Doc] == nil {
+				fields[p.Doc] = map[string]bool{}
+			}
+			for f := range p.Fields {
+				fields[p.Doc][f] = true
+			}
+		}
+	}
+
+	var ranked []int
+	for i := range scores {
+		ranked = append(ranked, i)
+	}
+	sort.Slice(ranked, func(a, c int) bool {
+		if scores[ranked[a]] != scores[ranked[c]] {
+			return scores[ranked[a]] > scores[ranked[c]]
+		}
+		return idx.Docs[ranked[a]].Name < idx.Docs[ranked[c]].Name
+	})
+	if len(ranked) > limit {
+		ranked = ranked[:limit]
+	}
+
+	results := []catalogResult{}
+	for _, i := range ranked {
+		doc := idx.Docs[i]
+		matchedTerms := map[string]bool{}
+		for _, t := range terms[i] {
+			matchedTerms[t] = true
+		}
+		r := catalogResult{
+			ID:               doc.ID,
+			UUID:             doc.UUID,
+			Name:             doc.Name,
+			Score:            math.Round(scores[i]*1000) / 1000,
+			MatchedTerms:     terms[i],
+			ShortDescription: doc.ShortDescription,
+			UnderlayName:     doc.UnderlayName,
+			WorkbenchURL:     fmt.Sprintf("%s/data-collections/%s", workbenchUIURL, doc.ID),
+		}
+		for f := range fields[i] {
+			r.MatchedFields = append(r.MatchedFields, f)
+		}
+		sort.Strings(r.MatchedFields)
+		for field, names := range map[string][]string{"entities": doc.Entities, "selectors": doc.Selectors, "attributes": doc.Attributes} {
+			if hits := catalogMatchingNames(names, matchedTerms, 10); len(hits) > 0 {
+				if r.Matches == nil {
+					r.Matches = map[string][]string{}
+				}
+				r.Matches[field] = hits
+			}
+		}
+		results = append(results, r)
+	}
+	return results
+}
+
+// catalogMatchingNames returns up to max names containing one of terms.
+func catalogMatchingNames(names []string, terms map[string]bool, max int) []string {
+	var hits []string
+	for _, name := range names {
+		for _, t := range catalogTokens(name) {
+			if terms[t] {
+				hits = append(hits, name)
+				break
+			}
+		}
+		if len(hits) == max {
+			break
+		}
+	}
+	return hits
+}
+
+// catalogTokens lowercases and splits text into terms. Words are also split at
+// camelCase and letter/digit boundaries so "measurementOccurrence" matches
+// "measurement", and a light plural stem makes "labs" match "lab".
+func catalogTokens(text string) []string {
+	var out []string
+	emit := func(word string) {
+		w := strings.ToLower(word)
+		if len(w) > 3 && strings.HasSuffix(w, "s") && !strings.HasSuffix(w, "ss") {
+			w = strings.TrimSuffix(w, "s")
+		}
+		if w != "" && !catalogStopWords[w] {
+			out = append(out, w)
+		}
+	}
+	for _, word := range strings.FieldsFunc(text, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}) {
+		emit(word)
+		start, parts := 0, 0
+		runes := []rune(word)
+		for i := 1; i < len(runes); i++ {
+			prev, r := runes[i-1], runes[i]
+			if unicode.IsLower(prev) && unicode.IsUpper(r) || unicode.IsLetter(prev) != unicode.IsLetter(r) {
+				emit(string(runes[start:i]))
+				start = i
+				parts++
+			}
+		}
+		if parts > 0 {
+			emit(string(runes[start:]))
+		}
+	}
+	return out
+}
+
+// handleCatalogSearch implements catalog_search.
+func handleCatalogSearch(ctx context.Context, args map[string]interface{}) (string, error) {
+	query, err := requireString(args, "query")
+	if err != nil {
+		return "", err
+	}
+	limit := 10
+	if l, ok := args["limit"].(float64); ok && l > 0 {
+		limit = int(l)
+	}
+	refresh, _ := args["refresh"].(bool)
+	idx, err := loadCatalog(ctx, refresh)
+	if err != nil {
+		return "", err
+	}
+
+	result := map[string]interface{}{
+		"query":              query,
+		"results":            idx.search(query, limit),
+		"indexedCollections": len(idx.Docs),
+		"indexBuiltAt":       idx.BuiltAt.Format(time.RFC3339),
+	}
+	var skipped []string
+	for _, doc := range idx.Docs {
+		if doc.UnderlayError != "" {
+			skipped = append(skipped, doc.Name)
+		}
+	}
+	if len(skipped) > 0 {
+		result["schemasNotIndexed"] = skipped
+	}
+	resultBytes, err := json.MarshalIndent(result, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal result: %w", err)
+	}
+	return string(resultBytes), nil
+}
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCatalogTokens(t *testing.T) {
+	tests := []struct {
+		text string
+		want []string
+	}{
+		{"Lab results", []string{"lab", "result"}},
+		{"measurementOccurrence", []string{"measurementoccurrence", "measurement", "occurrence"}},
+		{"icd10cm", []string{"icd10cm", "icd", "10", "cm"}},
+		{"T2DM cohort", []string{"t2dm", "t", "2", "dm", "cohort"}},
+		{"2024 class", []string{"2024", "class"}},
+		{"the data of AoU", []string{"data", "aou", "ao", "u"}},
+	}
+	for _, tt := range tests {
+		if got := catalogTokens(tt.text); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("catalogTokens(%q) = %q, want %q", tt.text, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+)
+
+// handleCohortCreateInWorkspace implements cohort_create_in_workspace:
+//  1. create a study and cohort in Data Explorer
+//  2. apply criteriaJson, if given
+//  3. save the cohort to the workspace as a controlled resource
+//
+// Inputs are checked and the workspace resolved before anything is created.
+// If step 2 or 3 fails, the study and cohort from step 1 are deleted again.
+func handleCohortCreateInWorkspace(ctx context.Context, args map[string]interface{}) (string, error) {
+	vals, err := requireStrings(args, "workspaceId", "underlayId", "underlayName", "name")
+	if err != nil {
+		return "", err
+	}
+	// underlayId is kept for validation but not used.
+	workspaceId, underlayName, name := vals[0], vals[2], vals[3]
+	displayName := name
+	if dn, ok := args["displayName"].(string); ok {
+		displayName = dn
+	}
+	description, _ := args["description"].(string)
+
+	var criteria interface{}
+	if criteriaJson, ok := args["criteriaJson"].(string); ok && criteriaJson != "" {
+		if err := json.Unmarshal([]byte(criteriaJson), &criteria); err != nil {
+			return "", fmt.Errorf("invalid criteriaJson: %w", err)
+		}
+	}
+	workspaceUuid, err := resolveWorkspaceId(ctx, workspaceId)
+	if err != nil {
+		return "", err
+	}
+
+	// Step 1: Create cohort in Data Explorer
+	createBody := map[string]interface{}{
+		"studyCreateInfo": map[string]interface{}{
+			"displayName": displayName + " Study",
+		},
+		"cohortCreateInfo": map[string]interface{}{
+			"underlayName": underlayName,
+			"displayName":  displayName,
+			"description":  description,
+		},
+	}
+	createResp, err := makeAPIRequest(ctx, "POST", dataExplorerURL+"/v2/createCohortInStudy", createBody)
+	if err != nil {
+		return "", fmt.Errorf("Step 1 failed (create cohort): %w", err)
+	}
+	var created struct {
+		Study  struct{ ID string } `json:"study"`
+		Cohort struct{ ID string } `json:"cohort"`
+	}
+	if err := json.Unmarshal(createResp, &created); err != nil || created.Study.ID == "" || created.Cohort.ID == "" {
+		return "", fmt.Errorf("Step 1 failed (create cohort): unexpected response: %s", truncate(string(createResp), 300))
+	}
+	studyId, cohortId := created.Study.ID, created.Cohort.ID
+
+	sg := &saga{}
+	sg.undo("delete study "+studyId, func(ctx context.Context) error {
+		_, err := makeAPIRequest(ctx, "DELETE", fmt.Sprintf("%s/v2/studies/%s", dataExplorerURL, studyId), nil)
+		return err
+	})
+	sg.undo(fmt.Sprintf("delete cohort %s in study %s", cohortId, studyId), func(ctx context.Context) error {
+		_, err := makeAPIRequest(ctx, "DELETE", fmt.Sprintf("%s/v2/studies/%s/cohorts/%s", dataExplorerURL, studyId, cohortId), nil)
+		return err
+	})
+
+	// Step 2: Update criteria if provided
+	if criteria != nil {
+		if _, err := makeAPIRequest(ctx, "PATCH", fmt.Sprintf("%s/v2/studies/%s/cohorts/%s", dataExplorerURL, studyId, cohortId), criteria); err != nil {
+			return "", sg.fail(ctx, fmt.Errorf("Step 2 failed (update criteria): %w", err))
+		}
+	}
+
+	// Step 3: Save cohort to workspace
+	saveBody := map[string]interface{}{
+		"common": map[string]interface{}{
+			"displayName":         displayName,
+			"description":         description,
+			"accessScope":         "SHARED_ACCESS",
+			"managedBy":           "USER",
+			"cloningInstructions": "COPY_RESOURCE",
+		},
+		"dataExplorerCohort": map[string]interface{}{
+			"studyId":  studyId,
+			"cohortId": cohortId,
+		},
+	}
+	if folderId, ok := args["folderId"].(string); ok {
+		saveBody["common"].(map[string]interface{})["folderId"] = folderId
+	}
+	saveUrl := fmt.Sprintf("%s/api/workspaces/v1/%s/resources/controlled/data-explorer/cohort/save", workspaceBaseURL, workspaceUuid)
+	respBody, err := makeAPIRequest(ctx, "POST", saveUrl, saveBody)
+	if err != nil {
+		return "", sg.fail(ctx, fmt.Errorf("Step 3 failed (save to workspace): %w", err))
+	}
+
+	// Add studyId/cohortId at top level for easy extraction
+	var workspaceResp map[string]interface{}
+	if err := json.Unmarshal(respBody, &workspaceResp); err != nil {
+		return string(respBody), nil
+	}
+	workspaceResp["studyId"] = studyId
+	workspaceResp["cohortId"] = cohortId
+	modifiedResp, err := json.Marshal(workspaceResp)
+	if err != nil {
+		return string(respBody), nil
+	}
+	return string(modifiedResp), nil
+}
@@ -0,0 +1,346 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Cohort versions are snapshots of a cohort's criteria and participant count
+// taken just before each change made through this server, kept per cohort in
+// the state directory so an agent's edits can be reviewed and undone.
+
+// cohortVersion is one snapshot. Count is nil if counting failed.
+type cohortVersion struct {
+	Version               int           `json:"version"`
+	Time                  time.Time     `json:"time"`
+	Reason                string        `json:"reason"`
+	DisplayName           string        `json:"displayName"`
+	Description           string        `json:"description,omitempty"`
+	CriteriaGroupSections []interface{} `json:"criteriaGroupSections"`
+	Count                 *int64        `json:"count"`
+}
+
+type cohortHistory struct {
+	StudyID  string          `json:"studyId"`
+	CohortID string          `json:"cohortId"`
+	Versions []cohortVersion `json:"versions"`
+}
+
+// cohortHistoryMu serializes read-modify-write of history files.
+var cohortHistoryMu sync.Mutex
+
+func cohortHistoryPath(studyId, cohortId string) (string, error) {
+	for _, id := range []string{studyId, cohortId} {
+		if id == "" || id == "." || id == ".." || strings.ContainsAny(id, "/\\") {
+			return "", fmt.Errorf("invalid study or cohort ID %q", id)
+		}
+	}
+	return statePath("cohorts", studyId, cohortId+".json")
+}
+
+func loadCohortHistory(studyId, cohortId string) (*cohortHistory, error) {
+	path, err := cohortHistoryPath(studyId, cohortId)
+	if err != nil {
+		return nil, err
+	}
+	h := &cohortHistory{StudyID: studyId, CohortID: cohortId}
+	if err := readStateFile(path, h); err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+// fetchCohortState reads a cohort's current criteria and participant count.
+func fetchCohortState(ctx context.Context, studyId, cohortId string) (cohortVersion, error) {
+	var v cohortVersion
+	respBody, err := makeAPIRequest(ctx, "GET", fmt.Sprintf("%s/v2/studies/%s/cohorts/%s", dataExplorerURL, studyId, cohortId), nil)
+	if err != nil {
+		return v, err
+	}
+	if err := json.Unmarshal(respBody, &v); err != nil {
+		return v, fmt.Errorf("failed to parse cohort: %w", err)
+	}
+	if count, err := countCohort(ctx, studyId, cohortId); err == nil {
+		v.Count = &count
+	} else {
+		fmt.Fprintf(os.Stderr, "Warning: failed to count cohort %s: %v\n", cohortId, err)
+	}
+	return v, nil
+}
+
+// countCohort returns the number of primary-entity instances in a cohort.
+func countCohort(ctx context.Context, studyId, cohortId string) (int64, error) {
+	counts, err := cohortCounts(ctx, studyId, cohortId, nil)
+	if err != nil {
+		return 0, err
+	}
+	var total int64
+	for _, c := range counts {
+		total += c.Count
+	}
+	return total, nil
+}
+
+// updateCohort snapshots a cohort and then PATCHes it with body. A failed
+// snapshot is logged but does not block the update. Dry runs take no
+// snapshot, since the cohort doesn't change.
+func updateCohort(ctx context.Context, studyId, cohortId string, body map[string]interface{}, reason string) ([]byte, error) {
+	if dryRunFrom(ctx) == nil {
+		if err := snapshotCohort(ctx, studyId, cohortId, reason); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: failed to snapshot cohort %s: %v\n", cohortId, err)
+		}
+	}
+	return makeAPIRequest(ctx, "PATCH", fmt.Sprintf("%s/v2/studies/%s/cohorts/%s", dataExplorerURL, studyId, cohortId), body)
+}
+
+func snapshotCohort(ctx context.Context, studyId, cohortId, reason string) error {
+	v, err := fetchCohortState(ctx, studyId, cohortId)
+	if err != nil {
+		return err
+	}
+	cohortHistoryMu.Lock()
+	defer cohortHistoryMu.Unlock()
+	h, err := loadCohortHistory(studyId, cohortId)
+	if err != nil {
+		return err
+	}
+	v.Version = len(h.Versions) + 1
+	v.Time = time.Now().UTC()
+	v.Reason = reason
+	h.Versions = append(h.Versions, v)
+	path, err := cohortHistoryPath(studyId, cohortId)
+	if err != nil {
+		return err
+	}
+	return writeStateFile(path, h)
+}
+
+// resolveCohortVersion returns the snapshot named by ref: a version number,
+// "vN", or "current" for the cohort's live state.
+func resolveCohortVersion(ctx context.Context, h *cohortHistory, ref string) (cohortVersion, string, error) {
+	if ref == "" || ref == "current" {
+		v, err := fetchCohortState(ctx, h.StudyID, h.CohortID)
+		return v, "current", err
+	}
+	n, err := strconv.Atoi(strings.TrimPrefix(ref, "v"))
+	if err != nil || n < 1 || n > len(h.Versions) {
+		if len(h.Versions) == 0 {
+			return cohortVersion{}, "", fmt.Errorf("cohort %s has no saved versions yet; versions are recorded by cohort_update_criteria", h.CohortID)
+		}
+		return cohortVersion{}, "", fmt.Errorf("unknown version %q (valid: 1-%d or current)", ref, len(h.Versions))
+	}
+	return h.Versions[n-1], fmt.Sprintf("v%d", n), nil
+}
+
+// describeSections renders each section as one line keyed by section ID, e.g.
+// "exclude: drugs {...} OR conditions {...}".
+func describeSections(sections []interface{}) (ids []string, lines map[string]string) {
+	lines = map[string]string{}
+	for i, s := range sections {
+		section, _ := s.(map[string]interface{})
+		id, _ := section["id"].(string)
+		if id == "" {
+			id = fmt.Sprintf("#%d", i+1)
+		}
+		var parts []string
+		for _, g := range asSlice(section["criteriaGroups"]) {
+			group, _ := g.(map[string]interface{})
+			var crits []string
+			for _, c := range asSlice(group["criteria"]) {
+				crit, _ := c.(map[string]interface{})
+				crits = append(crits, fmt.Sprintf("%v %v", crit["selectorOrModifierName"], crit["selectionData"]))
+			}
+			desc := strings.Join(crits, " AND ")
+			if disabled, _ := group["disabled"].(bool); disabled {
+				desc += " (disabled)"
+			}
+			parts = append(parts, desc)
+		}
+		operator, _ := section["operator"].(string)
+		if operator == "" {
+			operator = "OR"
+		}
+		verb := "include"
+		if excluded, _ := section["excluded"].(bool); excluded {
+			verb = "exclude"
+		}
+		line := verb + ": " + strings.Join(parts, " "+operator+" ")
+		if disabled, _ := section["disabled"].(bool); disabled {
+			line += " (disabled)"
+		}
+		ids = append(ids, id)
+		lines[id] = line
+	}
+	return ids, lines
+}
+
+func asSlice(v interface{}) []interface{} {
+	s, _ := v.([]interface{})
+	return s
+}
+
+// handleCohortHistory implements cohort_history.
+func handleCohortHistory(ctx context.Context, args map[string]interface{}) (string, error) {
+	vals, err := requireStrings(args, "studyId", "cohortId")
+	if err != nil {
+		return "", err
+	}
+	cohortHistoryMu.Lock()
+	h, err := loadCohortHistory(vals[0], vals[1])
+	cohortHistoryMu.Unlock()
+	if err != nil {
+		return "", err
+	}
+	type entry struct {
+		Version  string   `json:"version"`
+		Time     string   `json:"time,omitempty"`
+		Reason   string   `json:"reason,omitempty"`
+		Name     string   `json:"displayName"`
+		Count    *int64   `json:"count"`
+		Sections []string `json:"sections"`
+	}
+	sectionLines := func(v cohortVersion) []string {
+		ids, lines := describeSections(v.CriteriaGroupSections)
+		out := make([]string, len(ids))
+		for i, id := range ids {
+			out[i] = id + " " + lines[id]
+		}
+		return out
+	}
+	var entries []entry
+	for _, v := range h.Versions {
+		entries = append(entries, entry{fmt.Sprintf("v%d", v.Version), v.Time.Format(time.RFC3339), v.Reason, v.DisplayName, v.Count, sectionLines(v)})
+	}
+	current, err := fetchCohortState(ctx, h.StudyID, h.CohortID)
+	if err != nil {
+		return "", err
+	}
+	entries = append(entries, entry{Version: "current", Name: current.DisplayName, Count: current.Count, Sections: sectionLines(current)})
+
+	outputBytes, err := json.MarshalIndent(map[string]interface{}{
+		"studyId":  h.StudyID,
+		"cohortId": h.CohortID,
+		"versions": entries,
+	}, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal result: %w", err)
+	}
+	return string(outputBytes), nil
+}
+
+// handleCohortDiff implements cohort_diff: sections added, removed or changed
+// between two versions, and how the count moved.
+func handleCohortDiff(ctx context.Context, args map[string]interface{}) (string, error) {
+	vals, err := requireStrings(args, "studyId", "cohortId")
+	if err != nil {
+		return "", err
+	}
+	cohortHistoryMu.Lock()
+	h, err := loadCohortHistory(vals[0], vals[1])
+	cohortHistoryMu.Unlock()
+	if err != nil {
+		return "", err
+	}
+	fromRef, _ := args["from"].(string)
+	toRef, _ := args["to"].(string)
+	if fromRef == "" {
+		fromRef = strconv.Itoa(len(h.Versions))
+	}
+	from, fromName, err := resolveCohortVersion(ctx, h, fromRef)
+	if err != nil {
+		return "", err
+	}
+	to, toName, err := resolveCohortVersion(ctx, h, toRef)
+	if err != nil {
+		return "", err
+	}
+
+	fromIDs, fromLines := describeSections(from.CriteriaGroupSections)
+	toIDs, toLines := describeSections(to.CriteriaGroupSections)
+	var changes []string
+	for _, id := range fromIDs {
+		if _, ok := toLines[id]; !ok {
+			changes = append(changes, fmt.Sprintf("- removed %s: %s", id, fromLines[id]))
+		} else if fromLines[id] != toLines[id] {
+			changes = append(changes, fmt.Sprintf("~ changed %s:\n    was: %s\n    now: %s", id, fromLines[id], toLines[id]))
+		}
+	}
+	for _, id := range toIDs {
+		if _, ok := fromLines[id]; !ok {
+			changes = append(changes, fmt.Sprintf("+ added %s: %s", id, toLines[id]))
+		}
+	}
+	if from.DisplayName != to.DisplayName {
+		changes = append(changes, fmt.Sprintf("~ displayName: %q -> %q", from.DisplayName, to.DisplayName))
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "Cohort %s: %s -> %s\n", h.CohortID, fromName, toName)
+	if len(changes) == 0 {
+		b.WriteString("Criteria unchanged.\n")
+	} else {
+		b.WriteString(strings.Join(changes, "\n") + "\n")
+	}
+	b.WriteString("Count: " + describeCountChange(from.Count, to.Count))
+	return b.String(), nil
+}
+
+func describeCountChange(from, to *int64) string {
+	if from == nil || to == nil {
+		return fmt.Sprintf("%s -> %s", formatCount(from), formatCount(to))
+	}
+	delta := *to - *from
+	s := fmt.Sprintf("%d -> %d (%+d", *from, *to, delta)
+	if *from != 0 {
+		s += fmt.Sprintf(", %+.1f%%", float64(delta)*100/float64(*from))
+	}
+	return s + ")"
+}
+
+func formatCount(n *int64) string {
+	if n == nil {
+		return "unknown"
+	}
+	return strconv.FormatInt(*n, 10)
+}
+
+// handleCohortRevert implements cohort_revert. The revert is itself recorded
+// as a version, so it can be undone the same way.
+func handleCohortRevert(ctx context.Context, args map[string]interface{}) (string, error) {
+	vals, err := requireStrings(args, "studyId", "cohortId", "version")
+	if err != nil {
+		return "", err
+	}
+	cohortHistoryMu.Lock()
+	h, err := loadCohortHistory(vals[0], vals[1])
+	cohortHistoryMu.Unlock()
+	if err != nil {
+		return "", err
+	}
+	if vals[2] == "current" {
+		return "", fmt.Errorf("version must be a saved version number, not current")
+	}
+	target, name, err := resolveCohortVersion(ctx, h, vals[2])
+	if err != nil {
+		return "", err
+	}
+	body := map[string]interface{}{
+		"criteriaGroupSections": target.CriteriaGroupSections,
+		"displayName":           target.DisplayName,
+		"description":           target.Description,
+	}
+	if _, err := updateCohort(ctx, h.StudyID, h.CohortID, body, "before revert to "+name); err != nil {
+		return "", err
+	}
+	output := fmt.Sprintf("Reverted cohort %s to %s.", h.CohortID, name)
+	if count, err := countCohort(ctx, h.StudyID, h.CohortID); err == nil {
+		output += fmt.Sprintf("\nCount: %d now, %s when %s was saved.", count, formatCount(target.Count), name)
+	}
+	return output, nil
+}
@@ -0,0 +1,299 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// conceptExpandMax bounds how many matches get their children or descendants
+// listed, since each expansion is a separate instance query.
+const conceptExpandMax = 5
+
+// conceptRef is a concept ID and name, as used in paths and expansions.
+type conceptRef struct {
+	ID          int64  `json:"id"`
+	Name        string `json:"name"`
+	Count       *int64 `json:"count,omitempty"`
+	RollupCount *int64 `json:"rollupCount,omitempty"`
+}
+
+// conceptMatch is one concept_search result.
+type conceptMatch struct {
+	conceptRef
+	IsRoot          bool                   `json:"isRoot,omitempty"`
+	NumChildren     *int64                 `json:"numChildren,omitempty"`
+	Path            string                 `json:"path,omitempty"`
+	Ancestors       []conceptRef           `json:"ancestors,omitempty"`
+	Children        []conceptRef           `json:"children,omitempty"`
+	Descendants     []conceptRef           `json:"descendants,omitempty"`
+	HierarchyFilter map[string]interface{} `json:"hierarchyFilter,omitempty"`
+
+	ancestorIDs []int64
+}
+
+// conceptQuery holds what every instance query in one concept_search shares.
+type conceptQuery struct {
+	ctx       context.Context
+	schema    *underlaySchema
+	entity    *underlayEntity
+	hierarchy string
+}
+
+// list runs an instance query with the id and name attributes plus, when the
+// entity has a hierarchy, its path and child count and the primary entity
+// counts (direct and rolled up over descendants).
+func (q *conceptQuery) list(filter map[string]interface{}, limit int) ([]conceptMatch, error) {
+	body := map[string]interface{}{
+		"includeAttributes": []string{q.entity.IDAttribute, "name"},
+		"filter":            filter,
+		"limit":             limit,
+	}
+	if _, ok := q.entity.attribute("name"); !ok {
+		body["includeAttributes"] = []string{q.entity.IDAttribute}
+	}
+	related := []map[string]interface{}{{"relatedEntity": q.schema.PrimaryEntity}}
+	if q.hierarchy != "" {
+		body["includeHierarchyFields"] = map[string]interface{}{
+			"hierarchies": []string{q.hierarchy},
+			"fields":      []string{"PATH", "NUM_CHILDREN", "IS_ROOT", "IS_MEMBER"},
+		}
+		related = append(related, map[string]interface{}{"relatedEntity": q.schema.PrimaryEntity, "hierarchies": []string{q.hierarchy}})
+	}
+	if q.entity.Name != q.schema.PrimaryEntity {
+		body["includeRelationshipFields"] = related
+	}
+	raw, err := listInstances(q.ctx, q.schema.Name, q.entity.Name, body)
+	if err != nil {
+		return nil, err
+	}
+
+	var out []conceptMatch
+	for _, inst := range raw {
+		row := inst.instance()
+		m := conceptMatch{conceptRef: conceptRef{ID: row.ID, Name: row.Name}}
+		if id, ok := inst.Attributes[q.entity.IDAttribute]; ok {
+			m.ID = literalInt64(id.Value.ValueUnion["int64Val"])
+		}
+		if m.Name == "" || m.Name == "<nil>" {
+			m.Name = strconv.FormatInt(m.ID, 10)
+		}
+		for _, hf := range inst.HierarchyFields {
+			if hf.Hierarchy != "" && hf.Hierarchy != q.hierarchy {
+				continue
+			}
+			m.IsRoot = hf.IsRoot
+			n := hf.NumChildren
+			m.NumChildren = &n
+			if hf.Path != nil && *hf.Path != "" {
+				// PATH lists ancestor IDs from the parent up to the root.
+				for _, p := range strings.Split(*hf.Path, ".") {
+					if id, err := strconv.ParseInt(p, 10, 64); err == nil {
+						m.ancestorIDs = append(m.ancestorIDs, id)
+					}
+				}
+			}
+		}
+		for _, rf := range inst.RelationshipFields {
+			if rf.RelatedEntity != q.schema.PrimaryEntity {
+				continue
+			}
+			c := rf.Count
+			if rf.Hierarchy == "" {
+				m.Count = &c
+			} else if rf.Hierarchy == q.hierarchy {
+				m.RollupCount = &c
+			}
+		}
+		if q.hierarchy != "" {
+			m.HierarchyFilter = hierarchyFilter(q.hierarchy, "DESCENDANT_OF_INCLUSIVE", []interface{}{conceptLiteral(m.ID)})
+		}
+		out = append(out, m)
+	}
+	return out, nil
+}
+
+func conceptLiteral(id int64) map[string]interface{} {
+	return map[string]interface{}{
+		"dataType":   "INT64",
+		"valueUnion": map[string]interface{}{"int64Val": strconv.FormatInt(id, 10)},
+	}
+}
+
+// idFilter matches instances by ID.
+func (q *conceptQuery) idFilter(ids []int64) map[string]interface{} {
+	var values []interface{}
+	for _, id := range ids {
+		values = append(values, conceptLiteral(id))
+	}
+	return map[string]interface{}{
+		"filterType": "ATTRIBUTE",
+		"filterUnion": map[string]interface{}{"attributeFilter": map[string]interface{}{
+			"attribute": q.entity.IDAttribute,
+			"operator":  "IN",
+			"values":    values,
+		}},
+	}
+}
+
+// resolveAncestors names every ancestor on the matches' paths with a single
+// ID lookup and fills in Ancestors (root first) and the display Path.
+func (q *conceptQuery) resolveAncestors(matches []conceptMatch) error {
+	seen := map[int64]bool{}
+	var ids []int64
+	for _, m := range matches {
+		for _, id := range m.ancestorIDs {
+			if !seen[id] {
+				seen[id] = true
+				ids = append(ids, id)
+			}
+		}
+	}
+	names := map[int64]string{}
+	if len(ids) > 0 {
+		rows, err := q.list(q.idFilter(ids), len(ids))
+		if err != nil {
+			return err
+		}
+		for _, r := range rows {
+			names[r.ID] = r.Name
+		}
+	}
+	for i := range matches {
+		m := &matches[i]
+		var parts []string
+		for j := len(m.ancestorIDs) - 1; j >= 0; j-- {
+			id := m.ancestorIDs[j]
+			name := firstNonEmpty(names[id], strconv.FormatInt(id, 10))
+			m.Ancestors = append(m.Ancestors, conceptRef{ID: id, Name: name})
+			parts = append(parts, name)
+		}
+		if m.NumChildren != nil {
+			m.Path = strings.Join(append(parts, m.Name), " → ")
+		}
+	}
+	return nil
+}
+
+// handleConceptSearch implements concept_search: text (or ID) lookup of
+// hierarchy concepts with their paths, counts and optional expansion.
+func handleConceptSearch(ctx context.Context, args map[string]interface{}) (string, error) {
+	vals, err := requireStrings(args, "underlayName", "entity")
+	if err != nil {
+		return "", err
+	}
+	schema, err := loadUnderlaySchema(ctx, vals[0])
+	if err != nil {
+		return "", err
+	}
+	entity := schema.Entities[vals[1]]
+	if entity == nil {
+		return "", unknownName("entity", vals[1], "underlay "+schema.Name, schema.entityNames())
+	}
+	q := &conceptQuery{ctx: ctx, schema: schema, entity: entity}
+	if h, ok := args["hierarchy"].(string); ok && h != "" {
+		if err := lookupHierarchy(entity, h); err != nil {
+			return "", err
+		}
+		q.hierarchy = h
+	} else if entity.hasHierarchy() {
+		q.hierarchy = entity.Hierarchies[0].Name
+	}
+	expand := "none"
+	if e, ok := args["expand"].(string); ok && e != "" {
+		if expand, err = checkOneOf("expand", e, []string{"none", "children", "descendants"}); err != nil {
+			return "", err
+		}
+	}
+	if expand != "none" && q.hierarchy == "" {
+		return "", fmt.Errorf("entity %s has no hierarchy to expand", entity.Name)
+	}
+	limit := 10
+	if l, ok := args["limit"].(float64); ok && l > 0 {
+		limit = int(l)
+	}
+	if limit > 100 {
+		limit = 100
+	}
+
+	var filter map[string]interface{}
+	text, _ := args["text"].(string)
+	if id, ok := args["conceptId"]; ok && id != nil {
+		n, err := literalValue("INT64", id)
+		if err != nil {
+			return "", fmt.Errorf("conceptId: %w", err)
+		}
+		parsed, _ := strconv.ParseInt(n.(string), 10, 64)
+		filter = q.idFilter([]int64{parsed})
+	} else if strings.TrimSpace(text) != "" {
+		filter = textFilter(text)
+	} else {
+		return "", fmt.Errorf("missing required parameter: text (or conceptId)")
+	}
+
+	matches, err := q.list(filter, limit)
+	if err != nil {
+		return "", err
+	}
+	if q.hierarchy != "" {
+		if err := q.resolveAncestors(matches); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: failed to resolve concept paths: %v\n", err)
+		}
+	}
+	if expand != "none" {
+		operator := "CHILD_OF"
+		if expand == "descendants" {
+			operator = "DESCENDANT_OF_INCLUSIVE"
+		}
+		for i := range matches {
+			if i == conceptExpandMax {
+				break
+			}
+			m := &matches[i]
+			if m.NumChildren != nil && *m.NumChildren == 0 {
+				continue
+			}
+			related, err := q.list(hierarchyFilter(q.hierarchy, operator, []interface{}{conceptLiteral(m.ID)}), limit)
+			if err != nil {
+				return "", fmt.Errorf("failed to expand %s (%d): %w", m.Name, m.ID, err)
+			}
+			var refs []conceptRef
+			for _, r := range related {
+				if r.ID != m.ID {
+					refs = append(refs, r.conceptRef)
+				}
+			}
+			if expand == "children" {
+				m.Children = refs
+			} else {
+				m.Descendants = refs
+			}
+		}
+	}
+
+	ids := []int64{}
+	for _, m := range matches {
+		ids = append(ids, m.ID)
+	}
+	if matches == nil {
+		matches = []conceptMatch{}
+	}
+	result := map[string]interface{}{
+		"underlayName": schema.Name,
+		"entity":       entity.Name,
+		"conceptIds":   ids,
+		"matches":      matches,
+	}
+	if q.hierarchy != "" {
+		result["hierarchy"] = q.hierarchy
+		result["usage"] = fmt.Sprintf("Each match's hierarchyFilter selects the concept and its descendants; pass it as a filter on %s (e.g. data_sample_instances), or use filter_build_hierarchy with hierarchy=%q, operator=DESCENDANT_OF_INCLUSIVE and the concept ID. In cohort_compile_criteria, write: has %s in hierarchy <id>.", entity.Name, q.hierarchy, entity.Name)
+	}
+	resultBytes, err := json.MarshalIndent(result, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal result: %w", err)
+	}
+	return string(resultBytes), nil
+}
@@ -0,0 +1,307 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+)
+
+// defaultConfigPaths are searched in order when no -config flag or
+// WB_MCP_CONFIG variable is given. A missing file at these paths is not an error.
+var defaultConfigPaths = []string{
+	"$HOME/.config/wb-mcp-server/config.json",
+	"/opt/wb-mcp-server/config.json",
+}
+
+// endpointConfig is the set of service URLs for one environment. Empty fields
+// are derived from WorkspaceManagerURL.
+type endpointConfig struct {
+	WorkspaceManagerURL string `json:"workspaceManagerUrl,omitempty"`
+	DataExplorerURL     string `json:"dataExplorerUrl,omitempty"`
+	UIURL               string `json:"uiUrl,omitempty"`
+}
+
+// configFile is the on-disk config format:
+//
+//	{
+//	  "environment": "staging",
+//	  "environments": {
+//	    "staging": {"workspaceManagerUrl": "https://.../api/wsm", "dataExplorerUrl": "https://.../api/de"}
+//	  }
+//	}
+type configFile struct {
+	Environment  string                    `json:"environment,omitempty"`
+	Environments map[string]endpointConfig `json:"environments,omitempty"`
+}
+
+// configOptions carries the command-line flags that affect configuration.
+type configOptions struct {
+	ConfigPath  string
+	Environment string
+	WSMURL      string
+	DEURL       string
+	UIURL       string
+}
+
+// builtinEnvironments are always available by name, even without a config file.
+var builtinEnvironments = map[string]endpointConfig{
+	"prod": {
+		WorkspaceManagerURL: "https://workbench.verily.com/api/wsm",
+		DataExplorerURL:     "https://workbench.verily.com/api/de",
+		UIURL:               "https://workbench.verily.com",
+	},
+}
+
+var (
+	workbenchUIURL    string
+	activeEnvironment string            // "" when endpoints come from wb status
+	configFilePath    string            // config file actually loaded, if any
+	endpointSources   map[string]string // endpoint name → where its value came from
+	environments      map[string]endpointConfig
+)
+
+// initializeConfig resolves service endpoints. Later sources win:
+//
+//  1. Built-in production defaults.
+//  2. The named environment (flag -env, WB_MCP_ENV, or "environment" in the
+//     config file), or — when no environment is named — the wb CLI's server
+//     from `wb status`.
+//  3. WB_MCP_WSM_URL / WB_MCP_DE_URL / WB_MCP_UI_URL environment variables.
+//  4. -wsm-url / -de-url / -ui-url flags.
+//
+// Data Explorer and UI URLs that no source sets are then derived from the
+// Workspace Manager URL. The result is validated; an unknown environment, an
+// underivable or malformed URL is fatal.
+func initializeConfig(opts configOptions) error {
+	ctx, span := startSpan(context.Background(), "startup", spanKindInternal)
+	defer span.end(nil)
+	prod := builtinEnvironments["prod"]
+	workspaceBaseURL = prod.WorkspaceManagerURL
+	dataExplorerURL = prod.DataExplorerURL
+	workbenchUIURL = prod.UIURL
+	endpointSources = map[string]string{
+		"workspaceManagerUrl": "default",
+		"dataExplorerUrl":     "default",
+		"uiUrl":               "default",
+	}
+
+	file, path, err := loadConfigFile(opts.ConfigPath)
+	if err != nil {
+		return err
+	}
+	configFilePath = path
+
+	environments = map[string]endpointConfig{}
+	for name, env := range builtinEnvironments {
+		environments[name] = env
+	}
+	for name, env := range file.Environments {
+		environments[name] = env
+	}
+
+	activeEnvironment = firstNonEmpty(opts.Environment, os.Getenv("WB_MCP_ENV"), file.Environment)
+	if activeEnvironment != "" {
+		env, ok := environments[activeEnvironment]
+		if !ok {
+			return fmt.Errorf("unknown environment %q (known: %s)", activeEnvironment, strings.Join(environmentNames(), ", "))
+		}
+		applyEndpoints(env, "environment "+activeEnvironment)
+	} else {
+		applyWbStatusEndpoints(ctx)
+	}
+
+	applyEndpoints(endpointConfig{
+		WorkspaceManagerURL: os.Getenv("WB_MCP_WSM_URL"),
+		DataExplorerURL:     os.Getenv("WB_MCP_DE_URL"),
+		UIURL:               os.Getenv("WB_MCP_UI_URL"),
+	}, "environment variable")
+	applyEndpoints(endpointConfig{
+		WorkspaceManagerURL: opts.WSMURL,
+		DataExplorerURL:     opts.DEURL,
+		UIURL:               opts.UIURL,
+	}, "flag")
+	if err := deriveEndpoints(); err != nil {
+		return err
+	}
+
+	for _, ep := range []struct{ name, url string }{
+		{"workspaceManagerUrl", workspaceBaseURL},
+		{"dataExplorerUrl", dataExplorerURL},
+		{"uiUrl", workbenchUIURL},
+	} {
+		if err := validateEndpointURL(ep.url); err != nil {
+			return fmt.Errorf("invalid %s %q (from %s): %w", ep.name, ep.url, endpointSources[ep.name], err)
+		}
+	}
+
+	// Best-effort workspace UUID cache at startup. If this fails (e.g. auth not
+	// ready yet), getCurrentWorkspaceUUID(ctx) will retry lazily at call time.
+	if _, startupErr := getCurrentWorkspaceUUID(ctx); startupErr != nil {
+		fmt.Fprintf(os.Stderr, "Warning: could not resolve workspace UUID at startup (will retry on first use): %v\n", startupErr)
+	}
+
+	envLabel := activeEnvironment
+	if envLabel == "" {
+		envLabel = "wb-cli"
+	}
+	fmt.Fprintf(os.Stderr, "Initialized (%s) - Workspace: %s, DataExplorer: %s\n", envLabel, workspaceBaseURL, dataExplorerURL)
+	return nil
+}
+
+// loadConfigFile reads the config file named by the flag, WB_MCP_CONFIG, or
+// the first default path that exists. Only an explicitly named file must exist.
+func loadConfigFile(flagPath string) (configFile, string, error) {
+	var cfg configFile
+	explicit := firstNonEmpty(flagPath, os.Getenv("WB_MCP_CONFIG"))
+	candidates := []string{explicit}
+	if explicit == "" {
+		candidates = nil
+		for _, p := range defaultConfigPaths {
+			candidates = append(candidates, os.ExpandEnv(p))
+		}
+	}
+	for _, p := range candidates {
+		data, err := os.ReadFile(p)
+		if err != nil {
+			if os.IsNotExist(err) && explicit == "" {
+				continue
+			}
+			return cfg, "", fmt.Errorf("failed to read config file: %w", err)
+		}
+		if err := json.Unmarshal(data, &cfg); err != nil {
+			return cfg, "", fmt.Errorf("failed to parse config file %s: %w", p, err)
+		}
+		return cfg, p, nil
+	}
+	return cfg, "", nil
+}
+
+// applyWbStatusEndpoints takes the Workspace Manager URL from `wb status`, so
+// the server follows whatever server the wb CLI is pointed at.
+func applyWbStatusEndpoints(ctx context.Context) {
+	cmd := exec.CommandContext(ctx, "wb", "status", "--format=json")
+	output, err := runCommand(ctx, cmd)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: wb status failed, using default URLs: %v\n", err)
+		return
+	}
+	var status map[string]interface{}
+	if err := json.Unmarshal(output, &status); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: failed to parse wb status JSON, using default URLs: %v\n", err)
+		return
+	}
+	server, ok := status["server"].(map[string]interface{})
+	if !ok {
+		fmt.Fprintf(os.Stderr, "Warning: server info not found in wb status, using default URLs\n")
+		return
+	}
+	if wsURL, ok := server["workspaceManagerUri"].(string); ok && wsURL != "" {
+		applyEndpoints(endpointConfig{WorkspaceManagerURL: wsURL}, "wb status")
+	}
+}
+
+// applyEndpoints overlays the non-empty fields of env.
+func applyEndpoints(env endpointConfig, source string) {
+	if env.WorkspaceManagerURL != "" {
+		workspaceBaseURL = strings.TrimRight(env.WorkspaceManagerURL, "/")
+		endpointSources["workspaceManagerUrl"] = source
+	}
+	if env.DataExplorerURL != "" {
+		dataExplorerURL = strings.TrimRight(env.DataExplorerURL, "/")
+		endpointSources["dataExplorerUrl"] = source
+	}
+	if env.UIURL != "" {
+		workbenchUIURL = strings.TrimRight(env.UIURL, "/")
+		endpointSources["uiUrl"] = source
+	}
+}
+
+// deriveEndpoints fills in the Data Explorer and UI URLs from a non-default
+// Workspace Manager URL when no source set them. A URL set at any level is
+// kept.
+func deriveEndpoints() error {
+	if endpointSources["workspaceManagerUrl"] == "default" {
+		return nil
+	}
+	for _, ep := range []struct {
+		name, replacement string
+		url               *string
+	}{
+		{"dataExplorerUrl", "/api/de", &dataExplorerURL},
+		{"uiUrl", "", &workbenchUIURL},
+	} {
+		if endpointSources[ep.name] != "default" {
+			continue
+		}
+		if !strings.Contains(workspaceBaseURL, "/api/wsm") {
+			return fmt.Errorf("cannot derive %s from workspaceManagerUrl %q (no /api/wsm in it); set it explicitly", ep.name, workspaceBaseURL)
+		}
+		*ep.url = strings.Replace(workspaceBaseURL, "/api/wsm", ep.replacement, 1)
+		endpointSources[ep.name] = "derived from workspaceManagerUrl"
+	}
+	return nil
+}
+
+// validateEndpointURL requires an absolute http(s) URL with a host.
+func validateEndpointURL(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("scheme must be http or https")
+	}
+	if u.Host == "" {
+		return fmt.Errorf("missing host")
+	}
+	return nil
+}
+
+func environmentNames() []string {
+	names := make([]string, 0, len(environments))
+	for name := range environments {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func firstNonEmpty(vals ...string) string {
+	for _, v := range vals {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// handleServerConfig implements the server_config tool.
+func handleServerConfig() (string, error) {
+	env := activeEnvironment
+	if env == "" {
+		env = "(none - following wb CLI server)"
+	}
+	result := map[string]interface{}{
+		"environment": env,
+		"endpoints": map[string]interface{}{
+			"workspaceManagerUrl": workspaceBaseURL,
+			"dataExplorerUrl":     dataExplorerURL,
+			"uiUrl":               workbenchUIURL,
+		},
+		"sources":               endpointSources,
+		"availableEnvironments": environmentNames(),
+	}
+	if configFilePath != "" {
+		result["configFile"] = configFilePath
+	}
+	resultBytes, err := json.MarshalIndent(result, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal result: %w", err)
+	}
+	return string(resultBytes), nil
+}
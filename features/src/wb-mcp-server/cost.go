@@ -0,0 +1,397 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// workspace_cost_summary estimates what a workspace's apps, notebooks and
+// clusters cost to keep running. Prices are on-demand list prices for us-central1 and cover vCPUs,
+// memory and GPUs only (no disks, network, Dataproc fees, discounts or
+// BigQuery); they are meant for spotting what to stop, not for billing.
+// prices.json in the state directory overrides them:
+//
+//	{"n1-standard-4": 0.19, "nvidia-tesla-t4": 0.35}
+
+// machineFamilies prices predefined and custom machine types per vCPU-hour
+// and GB-hour; memPerCPU gives the GB per vCPU of each predefined class.
+var machineFamilies = map[string]struct {
+	cpu, mem  float64
+	memPerCPU map[string]float64
+}{
+	"n1":  {0.031611, 0.004237, map[string]float64{"standard": 3.75, "highmem": 6.5, "highcpu": 0.9}},
+	"n2":  {0.031611, 0.004237, map[string]float64{"standard": 4, "highmem": 8, "highcpu": 1}},
+	"n2d": {0.027502, 0.003686, map[string]float64{"standard": 4, "highmem": 8, "highcpu": 1}},
+	"e2":  {0.021811, 0.002923, map[string]float64{"standard": 4, "highmem": 8, "highcpu": 1}},
+	"c2":  {0.03398, 0.00455, map[string]float64{"standard": 4}},
+}
+
+// fixedPrices are hourly prices for shared-core machines and GPUs.
+var fixedPrices = map[string]float64{
+	"e2-micro":          0.008376,
+	"e2-small":          0.016751,
+	"e2-medium":         0.033503,
+	"f1-micro":          0.0076,
+	"g1-small":          0.0257,
+	"nvidia-tesla-k80":  0.45,
+	"nvidia-tesla-p4":   0.60,
+	"nvidia-tesla-t4":   0.35,
+	"nvidia-tesla-p100": 1.46,
+	"nvidia-tesla-v100": 2.48,
+	"nvidia-tesla-a100": 2.934,
+	"nvidia-l4":         0.56,
+}
+
+// typeName strips the URL prefix the CLI sometimes reports machine and
+// accelerator types with.
+func typeName(s string) string {
+	if s == "" {
+		return ""
+	}
+	return strings.ToLower(path.Base(s))
+}
+
+// hourlyPrice returns the hourly price of a machine or GPU type.
+func hourlyPrice(name string, overrides map[string]float64) (float64, bool) {
+	name = typeName(name)
+	if p, ok := overrides[name]; ok {
+		return p, true
+	}
+	if p, ok := fixedPrices[name]; ok {
+		return p, true
+	}
+	parts := strings.Split(name, "-")
+	if parts[0] == "custom" { // N1 custom types have no family prefix
+		parts = append([]string{"n1"}, parts...)
+	}
+	fam, ok := machineFamilies[parts[0]]
+	if !ok || len(parts) < 3 {
+		return 0, false
+	}
+	cpus, err := strconv.ParseFloat(parts[2], 64)
+	if err != nil {
+		return 0, false
+	}
+	if parts[1] == "custom" && len(parts) == 4 {
+		memMB, err := strconv.ParseFloat(parts[3], 64)
+		if err != nil {
+			return 0, false
+		}
+		return cpus*fam.cpu + memMB/1024*fam.mem, true
+	}
+	perCPU, ok := fam.memPerCPU[parts[1]]
+	if !ok || len(parts) != 3 {
+		return 0, false
+	}
+	return cpus*fam.cpu + cpus*perCPU*fam.mem, true
+}
+
+// findValue returns the first non-nil field named one of keys (lowercase),
+// searching breadth-first like findField.
+func findValue(doc interface{}, keys ...string) interface{} {
+	queue := []interface{}{doc}
+	for len(queue) > 0 {
+		m, ok := queue[0].(map[string]interface{})
+		queue = queue[1:]
+		if !ok {
+			continue
+		}
+		for _, key := range keys {
+			for k, v := range m {
+				if strings.ToLower(k) == key && v != nil {
+					return v
+				}
+			}
+		}
+		for _, v := range m {
+			queue = append(queue, v)
+		}
+	}
+	return nil
+}
+
+// findNumber is findField for numeric fields, which the CLI may report as
+// strings.
+func findNumber(doc interface{}, keys ...string) float64 {
+	switch n := findValue(doc, keys...).(type) {
+	case float64:
+		return n
+	case string:
+		f, _ := strconv.ParseFloat(n, 64)
+		return f
+	}
+	return 0
+}
+
+// costItem is one app, notebook or cluster in the summary.
+type costItem struct {
+	ID           string  `json:"id"`
+	Kind         string  `json:"kind"`
+	Name         string  `json:"name,omitempty"`
+	Type         string  `json:"type,omitempty"`
+	Status       string  `json:"status"`
+	MachineType  string  `json:"machineType,omitempty"`
+	Workers      int     `json:"workers,omitempty"`
+	WorkerType   string  `json:"workerMachineType,omitempty"`
+	Accelerators string  `json:"accelerators,omitempty"`
+	RunningSince string  `json:"runningSince,omitempty"`
+	UptimeHours  float64 `json:"uptimeHours,omitempty"`
+	LastActivity string  `json:"lastActivity,omitempty"`
+	Activity     string  `json:"activity,omitempty"` // "unknown" when none is reported
+	HourlyCost   float64 `json:"hourlyCostUsd"`
+	CostSoFar    float64 `json:"costSinceStartUsd,omitempty"`
+	Idle         bool    `json:"idle,omitempty"`
+	IdleReason   string  `json:"idleReason,omitempty"`
+	Suggestion   string  `json:"suggestion,omitempty"`
+}
+
+// costKind maps a resource type from resource list to the kind of compute
+// it is, or "" for resources that don't run machines.
+func costKind(resourceType string) string {
+	t := strings.ToUpper(resourceType)
+	switch {
+	case strings.Contains(t, "NOTEBOOK"), strings.Contains(t, "GCE_INSTANCE"), strings.Contains(t, "SAGEMAKER"):
+		return "notebook"
+	case strings.Contains(t, "CLUSTER"), strings.Contains(t, "DATAPROC"):
+		return "cluster"
+	}
+	return ""
+}
+
+// costStopTool is the tool and argument that stops each kind.
+var costStopTool = map[string]string{
+	"app":      "app_stop appId",
+	"notebook": "notebook_stop notebookId",
+	"cluster":  "cluster_stop clusterId",
+}
+
+func roundTo(v float64, places int) float64 {
+	p := math.Pow(10, float64(places))
+	return math.Round(v*p) / p
+}
+
+// costTime parses the timestamp formats the CLI reports.
+func costTime(s string) (time.Time, bool) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, true
+	}
+	if ms, err := strconv.ParseInt(s, 10, 64); err == nil && ms > 1e12 {
+		return time.UnixMilli(ms), true
+	}
+	return time.Time{}, false
+}
+
+// handleWorkspaceCostSummary implements workspace_cost_summary.
+func handleWorkspaceCostSummary(ctx context.Context, sess *session, args map[string]interface{}) (string, error) {
+	idleHours := 2.0
+	if v, ok := args["idleHours"].(float64); ok && v > 0 {
+		idleHours = v
+	}
+	wbArgs := func(a ...string) []string {
+		if id := str(args["workspaceId"]); id != "" {
+			return append(a, "--workspace="+id)
+		}
+		return sess.wbArgs(a...)
+	}
+
+	var warnings []string
+	overrides := map[string]float64{}
+	priceSource := "built-in us-central1 on-demand list prices"
+	if p, err := statePath("prices.json"); err == nil {
+		var raw map[string]float64
+		if err := readStateFile(p, &raw); err != nil {
+			warnings = append(warnings, err.Error())
+		} else if len(raw) > 0 {
+			for k, v := range raw {
+				overrides[strings.ToLower(k)] = v
+			}
+			priceSource += ", overridden by " + p
+		}
+	}
+
+	type source struct {
+		kind string
+		doc  map[string]interface{}
+	}
+	var apps []map[string]interface{}
+	if err := wbJSON(ctx, wbArgs("app", "list", "--format=json"), &apps); err != nil {
+		return "", fmt.Errorf("failed to list apps: %w", err)
+	}
+	var sources []source
+	seen := map[string]bool{}
+	for _, app := range apps {
+		sources = append(sources, source{"app", app})
+		seen[findField(app, "id", "resourceid", "name")] = true
+	}
+	// Notebooks and clusters are workspace resources that app list may not
+	// include.
+	var resources []map[string]interface{}
+	if err := wbJSON(ctx, wbArgs("resource", "list", "--format=json"), &resources); err != nil {
+		warnings = append(warnings, fmt.Sprintf("could not list notebooks and clusters: %v", err))
+	}
+	for _, res := range resources {
+		kind := costKind(findField(res, "resourcetype", "type"))
+		id := findField(res, "id", "resourceid", "name")
+		if kind == "" || seen[id] {
+			continue
+		}
+		seen[id] = true
+		sources = append(sources, source{kind, res})
+	}
+
+	now := time.Now()
+	var items []costItem
+	var hourly, idleHourly float64
+	running := 0
+	for _, src := range sources {
+		docs := []interface{}{src.doc}
+		get := func(keys ...string) string {
+			for _, d := range docs {
+				if s := findField(d, keys...); s != "" {
+					return s
+				}
+			}
+			return ""
+		}
+		num := func(keys ...string) float64 {
+			for _, d := range docs {
+				if n := findNumber(d, keys...); n != 0 {
+					return n
+				}
+			}
+			return 0
+		}
+
+		it := costItem{
+			ID:     get("id", "resourceid", "name"),
+			Kind:   src.kind,
+			Name:   get("displayname", "name"),
+			Type:   get("resourcetype", "apptype"),
+			Status: strings.ToUpper(findStatus(src.doc)),
+		}
+		isRunning := it.Status == "RUNNING" || it.Status == "ACTIVE" || it.Status == "READY"
+		if isRunning && get("machinetype", "mastermachinetype", "managermachinetype") == "" {
+			// The list output doesn't always include cloud details; describe
+			// has them.
+			var desc interface{}
+			if err := wbJSON(ctx, wbArgs("resource", "describe", "--id="+it.ID, "--format=json"), &desc); err != nil {
+				warnings = append(warnings, fmt.Sprintf("could not describe %s: %v", it.ID, err))
+			} else {
+				docs = append(docs, desc)
+			}
+		}
+		it.MachineType = typeName(get("machinetype", "managermachinetype", "mastermachinetype"))
+		it.Workers = int(num("numworkers", "workercount")) + int(num("numsecondaryworkers", "secondaryworkercount"))
+		if it.Workers > 0 {
+			it.WorkerType = firstNonEmpty(typeName(get("workermachinetype")), it.MachineType)
+		}
+		gpu, gpus := typeName(get("acceleratortype")), num("acceleratorcount")
+		for _, d := range docs {
+			// GCE reports acceleratorConfig: {type, coreCount}.
+			if cfg := findValue(d, "acceleratorconfig", "accelerator"); cfg != nil && gpu == "" {
+				gpu, gpus = typeName(findField(cfg, "type", "acceleratortype")), findNumber(cfg, "corecount", "count", "acceleratorcount")
+			}
+		}
+		if gpu != "" {
+			gpus = math.Max(gpus, 1)
+			it.Accelerators = fmt.Sprintf("%g x %s", gpus, gpu)
+		}
+		it.LastActivity = get("lastactivity", "lastactivitytime", "lastactivitytimestamp", "lastaccessed")
+
+		if !isRunning {
+			items = append(items, it)
+			continue
+		}
+		running++
+
+		price := func(name string, count float64) {
+			if p, ok := hourlyPrice(name, overrides); ok {
+				it.HourlyCost += p * count
+			} else {
+				warnings = append(warnings, fmt.Sprintf("no price for %s (%s); add it to prices.json", name, it.ID))
+			}
+		}
+		if it.MachineType != "" {
+			price(it.MachineType, 1)
+		} else {
+			warnings = append(warnings, fmt.Sprintf("no machine type reported for %s; its cost is left out", it.ID))
+		}
+		if it.Workers > 0 {
+			price(it.WorkerType, float64(it.Workers))
+		}
+		if gpu != "" {
+			price(gpu, gpus)
+		}
+
+		// Only a reported start time counts: creation and update dates say
+		// nothing about how long the machine has been up.
+		var uptime time.Duration
+		if t, ok := costTime(get("laststarttimestamp", "laststarttime", "starttime")); ok {
+			it.RunningSince = t.UTC().Format(time.RFC3339)
+			uptime = now.Sub(t)
+			it.UptimeHours = roundTo(uptime.Hours(), 1)
+			it.CostSoFar = roundTo(it.HourlyCost*uptime.Hours(), 2)
+		} else {
+			warnings = append(warnings, fmt.Sprintf("no start time reported for %s; its cost since start and uptime are left out", it.ID))
+		}
+		// Uptime alone doesn't make a machine idle: a long job may be running
+		// on it. Without reported activity the item is only marked unknown.
+		if t, ok := costTime(it.LastActivity); ok {
+			if quiet := now.Sub(t); quiet.Hours() >= idleHours {
+				it.Idle, it.IdleReason = true, fmt.Sprintf("no activity for %.1fh", quiet.Hours())
+			}
+		} else {
+			it.Activity = "unknown"
+		}
+		if it.Idle {
+			it.Suggestion = fmt.Sprintf("%s=%s", costStopTool[it.Kind], it.ID)
+			idleHourly += it.HourlyCost
+		}
+		hourly += it.HourlyCost
+		it.HourlyCost = roundTo(it.HourlyCost, 4)
+		items = append(items, it)
+	}
+
+	sort.SliceStable(items, func(i, j int) bool {
+		if items[i].HourlyCost != items[j].HourlyCost {
+			return items[i].HourlyCost > items[j].HourlyCost
+		}
+		return items[i].ID < items[j].ID
+	})
+
+	result := map[string]interface{}{
+		"generatedAt": now.UTC().Format(time.RFC3339),
+		"currency":    "USD",
+		"priceSource": priceSource,
+		"idleHours":   idleHours,
+		"totals": map[string]interface{}{
+			"resources":         len(items),
+			"running":           running,
+			"hourlyCostUsd":     roundTo(hourly, 2),
+			"dailyCostUsd":      roundTo(hourly*24, 2),
+			"monthlyCostUsd":    roundTo(hourly*730, 2),
+			"idleHourlyCostUsd": roundTo(idleHourly, 2),
+		},
+		"resources": items,
+	}
+	if id := str(args["workspaceId"]); id != "" {
+		result["workspaceId"] = id
+	} else if id, _ := sess.currentWorkspace(); id != "" {
+		result["workspaceId"] = id
+	}
+	if len(warnings) > 0 {
+		result["warnings"] = warnings
+	}
+	out, err := json.MarshalIndent(result, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	return string(out), nil
+}
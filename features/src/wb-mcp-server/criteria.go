@@ -0,0 +1,744 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Cohort criteria DSL
+//
+// cohort_compile_criteria turns a compact expression such as
+//
+//	person.age >= 18 AND has condition in hierarchy "Type 2 diabetes mellitus"
+//
+// into Data Explorer criteriaGroupSections. The expression is an AND of
+// clauses; each clause becomes one section, optionally negated with NOT
+// (an excluded section) and made of one or more OR'd terms (one criteria
+// group each):
+//
+//	criteria := clause { AND clause }
+//	clause   := [NOT] ( term | "(" term { OR term } ")" )
+//	term     := attr op literal
+//	          | attr BETWEEN number AND number
+//	          | attr IN "(" literal { "," literal } ")"
+//	          | HAS name [ IN [HIERARCHY] ] concepts
+//	attr     := [ entity "." ] attribute
+//	op       := "=" | "<" | "<=" | ">" | ">="
+//	concepts := concept | "(" concept { "," concept } ")"
+//	concept  := number | "quoted name"
+//
+// Attribute terms use the primary entity's "attribute" selector; HAS terms use
+// the "entityGroup" selector named by (or drawing from the entity named by)
+// name. Keywords are case-insensitive.
+
+type criteriaTokenKind int
+
+const (
+	tokEOF criteriaTokenKind = iota
+	tokIdent
+	tokNumber
+	tokString
+	tokOp
+	tokLParen
+	tokRParen
+	tokComma
+	tokDot
+)
+
+type criteriaToken struct {
+	kind criteriaTokenKind
+	text string
+	pos  int // byte offset in the source
+}
+
+func (t criteriaToken) is(keyword string) bool {
+	return t.kind == tokIdent && strings.EqualFold(t.text, keyword)
+}
+
+func (t criteriaToken) describe() string {
+	if t.kind == tokEOF {
+		return "end of input"
+	}
+	return fmt.Sprintf("%q", t.text)
+}
+
+func lexCriteria(src string) ([]criteriaToken, error) {
+	var toks []criteriaToken
+	for i := 0; i < len(src); {
+		c := rune(src[i])
+		switch {
+		case unicode.IsSpace(c):
+			i++
+		case c == '(':
+			toks = append(toks, criteriaToken{tokLParen, "(", i})
+			i++
+		case c == ')':
+			toks = append(toks, criteriaToken{tokRParen, ")", i})
+			i++
+		case c == ',':
+			toks = append(toks, criteriaToken{tokComma, ",", i})
+			i++
+		case c == '.':
+			toks = append(toks, criteriaToken{tokDot, ".", i})
+			i++
+		case c == '<' || c == '>' || c == '=':
+			op := string(c)
+			if (c == '<' || c == '>') && i+1 < len(src) && src[i+1] == '=' {
+				op += "="
+			}
+			toks = append(toks, criteriaToken{tokOp, op, i})
+			i += len(op)
+		case c == '!':
+			return nil, fmt.Errorf("at position %d: != is not supported; use NOT (attr = value) as a separate clause", i+1)
+		case c == '"' || c == '\'':
+			end := strings.IndexByte(src[i+1:], byte(c))
+			if end < 0 {
+				return nil, fmt.Errorf("at position %d: unterminated string", i+1)
+			}
+			toks = append(toks, criteriaToken{tokString, src[i+1 : i+1+end], i})
+			i += end + 2
+		case c == '-' || unicode.IsDigit(c):
+			j := i + 1
+			for j < len(src) && (unicode.IsDigit(rune(src[j])) || src[j] == '.') {
+				j++
+			}
+			if _, err := strconv.ParseFloat(src[i:j], 64); err != nil {
+				return nil, fmt.Errorf("at position %d: invalid number %q", i+1, src[i:j])
+			}
+			toks = append(toks, criteriaToken{tokNumber, src[i:j], i})
+			i = j
+		case c == '_' || unicode.IsLetter(c):
+			j := i + 1
+			for j < len(src) && (src[j] == '_' || unicode.IsLetter(rune(src[j])) || unicode.IsDigit(rune(src[j]))) {
+				j++
+			}
+			toks = append(toks, criteriaToken{tokIdent, src[i:j], i})
+			i = j
+		default:
+			return nil, fmt.Errorf("at position %d: unexpected character %q", i+1, c)
+		}
+	}
+	return append(toks, criteriaToken{kind: tokEOF, pos: len(src)}), nil
+}
+
+// criteriaClause is one AND'd clause: a section in the compiled cohort.
+type criteriaClause struct {
+	excluded bool
+	terms    []*criteriaTerm
+	text     string
+}
+
+// criteriaTerm is one OR'd term: a criteria group in the compiled cohort.
+type criteriaTerm struct {
+	text string
+
+	// Attribute comparison.
+	entity, attribute string
+	op                string // =, <, <=, >, >=, BETWEEN, IN
+	values            []criteriaToken
+
+	// HAS term.
+	has       bool
+	target    string
+	hierarchy bool
+}
+
+type criteriaParser struct {
+	src  string
+	toks []criteriaToken
+	i    int
+}
+
+func parseCriteria(src string) ([]*criteriaClause, error) {
+	toks, err := lexCriteria(src)
+	if err != nil {
+		return nil, err
+	}
+	p := &criteriaParser{src: src, toks: toks}
+	var clauses []*criteriaClause
+	for {
+		clause, err := p.clause()
+		if err != nil {
+			return nil, err
+		}
+		clauses = append(clauses, clause)
+		if !p.accept("AND") {
+			break
+		}
+	}
+	if t := p.peek(); t.kind != tokEOF {
+		if t.is("OR") {
+			return nil, p.errorf(t, "mixing AND and OR requires parentheses around each OR group, e.g. age >= 18 AND (has condition 201826 OR has condition 4329847)")
+		}
+		return nil, p.errorf(t, "expected AND or end of input, got %s", t.describe())
+	}
+	return clauses, nil
+}
+
+func (p *criteriaParser) peek() criteriaToken { return p.toks[p.i] }
+
+func (p *criteriaParser) next() criteriaToken {
+	t := p.toks[p.i]
+	if t.kind != tokEOF {
+		p.i++
+	}
+	return t
+}
+
+func (p *criteriaParser) accept(keyword string) bool {
+	if p.peek().is(keyword) {
+		p.i++
+		return true
+	}
+	return false
+}
+
+func (p *criteriaParser) errorf(t criteriaToken, format string, args ...interface{}) error {
+	return fmt.Errorf("at position %d: %s", t.pos+1, fmt.Sprintf(format, args...))
+}
+
+// textFrom returns the source text from token start up to the current token.
+func (p *criteriaParser) textFrom(start criteriaToken) string {
+	end := len(p.src)
+	if p.i > 0 {
+		last := p.toks[p.i-1]
+		end = last.pos + len(last.text)
+		if last.kind == tokString {
+			end += 2
+		}
+	}
+	return strings.TrimSpace(p.src[start.pos:end])
+}
+
+func (p *criteriaParser) clause() (*criteriaClause, error) {
+	start := p.peek()
+	c := &criteriaClause{excluded: p.accept("NOT")}
+	if p.peek().kind == tokLParen {
+		p.next()
+		for {
+			term, err := p.term()
+			if err != nil {
+				return nil, err
+			}
+			c.terms = append(c.terms, term)
+			if !p.accept("OR") {
+				break
+			}
+		}
+		if t := p.next(); t.kind != tokRParen {
+			if t.is("AND") {
+				return nil, p.errorf(t, "AND is not allowed inside parentheses; criteria must be an AND of (OR groups)")
+			}
+			return nil, p.errorf(t, "expected OR or ), got %s", t.describe())
+		}
+	} else {
+		term, err := p.term()
+		if err != nil {
+			return nil, err
+		}
+		c.terms = append(c.terms, term)
+		if t := p.peek(); t.is("OR") {
+			return nil, p.errorf(t, "wrap OR'd terms in parentheses, e.g. (has condition 201826 OR has condition 4329847)")
+		}
+	}
+	c.text = p.textFrom(start)
+	return c, nil
+}
+
+func (p *criteriaParser) term() (*criteriaTerm, error) {
+	start := p.peek()
+	switch {
+	case start.kind == tokLParen:
+		return nil, p.errorf(start, "nested parentheses are not supported; criteria must be an AND of (OR groups)")
+	case start.is("NOT"):
+		return nil, p.errorf(start, "NOT applies to a whole clause, e.g. NOT (has condition 201826 OR has condition 4329847)")
+	case start.is("HAS"):
+		p.next()
+		t := &criteriaTerm{has: true}
+		name := p.next()
+		if name.kind != tokIdent {
+			return nil, p.errorf(name, "expected a selector or entity name after HAS, got %s", name.describe())
+		}
+		t.target = name.text
+		if p.accept("IN") {
+			t.hierarchy = p.accept("HIERARCHY")
+		}
+		values, err := p.valueList(true)
+		if err != nil {
+			return nil, err
+		}
+		for _, v := range values {
+			if v.kind != tokNumber && v.kind != tokString {
+				return nil, p.errorf(v, "expected a concept ID or quoted concept name, got %s", v.describe())
+			}
+		}
+		t.values = values
+		t.text = p.textFrom(start)
+		return t, nil
+	}
+
+	name := p.next()
+	if name.kind != tokIdent {
+		return nil, p.errorf(name, "expected an attribute or HAS, got %s", name.describe())
+	}
+	t := &criteriaTerm{attribute: name.text}
+	if p.peek().kind == tokDot {
+		p.next()
+		attr := p.next()
+		if attr.kind != tokIdent {
+			return nil, p.errorf(attr, "expected an attribute name after %s., got %s", name.text, attr.describe())
+		}
+		t.entity, t.attribute = name.text, attr.text
+	}
+
+	switch op := p.next(); {
+	case op.is("BETWEEN"):
+		t.op = "BETWEEN"
+		lo := p.next()
+		if !p.accept("AND") {
+			return nil, p.errorf(p.peek(), "expected AND in BETWEEN, got %s", p.peek().describe())
+		}
+		hi := p.next()
+		for _, v := range []criteriaToken{lo, hi} {
+			if v.kind != tokNumber {
+				return nil, p.errorf(v, "BETWEEN bounds must be numbers, got %s", v.describe())
+			}
+		}
+		t.values = []criteriaToken{lo, hi}
+	case op.is("IN"):
+		t.op = "IN"
+		if p.peek().kind != tokLParen {
+			return nil, p.errorf(p.peek(), "expected ( after IN")
+		}
+		values, err := p.valueList(false)
+		if err != nil {
+			return nil, err
+		}
+		t.values = values
+	case op.kind == tokOp:
+		t.op = op.text
+		v := p.next()
+		if v.kind != tokNumber && v.kind != tokString && !v.is("TRUE") && !v.is("FALSE") {
+			return nil, p.errorf(v, "expected a value after %s, got %s", op.text, v.describe())
+		}
+		t.values = []criteriaToken{v}
+	default:
+		return nil, p.errorf(op, "expected an operator (=, <, <=, >, >=, BETWEEN, IN) after %s, got %s", t.attribute, op.describe())
+	}
+	t.text = p.textFrom(start)
+	return t, nil
+}
+
+// valueList parses a parenthesized, comma-separated list of literals, or a
+// single bare literal when allowBare is set.
+func (p *criteriaParser) valueList(allowBare bool) ([]criteriaToken, error) {
+	if p.peek().kind != tokLParen {
+		if !allowBare {
+			return nil, p.errorf(p.peek(), "expected (")
+		}
+		return []criteriaToken{p.next()}, nil
+	}
+	p.next()
+	var values []criteriaToken
+	for {
+		v := p.next()
+		if v.kind != tokNumber && v.kind != tokString && !v.is("TRUE") && !v.is("FALSE") {
+			return nil, p.errorf(v, "expected a value, got %s", v.describe())
+		}
+		values = append(values, v)
+		if t := p.next(); t.kind == tokRParen {
+			return values, nil
+		} else if t.kind != tokComma {
+			return nil, p.errorf(t, "expected , or ), got %s", t.describe())
+		}
+	}
+}
+
+// criteriaCompiler resolves parsed terms against an underlay schema. Hints are
+// fetched lazily, only when a term needs them.
+type criteriaCompiler struct {
+	ctx    context.Context
+	schema *underlaySchema
+	hints  map[string]attributeHint
+	errs   []string
+}
+
+// compileCriteria parses src and compiles it against the underlay schema into
+// criteriaGroupSections plus a one-line summary per section. All validation
+// errors are reported together.
+func compileCriteria(ctx context.Context, schema *underlaySchema, src string) ([]interface{}, []string, error) {
+	clauses, err := parseCriteria(src)
+	if err != nil {
+		return nil, nil, err
+	}
+	c := &criteriaCompiler{ctx: ctx, schema: schema}
+	var sections []interface{}
+	var summary []string
+	for si, clause := range clauses {
+		var groups []interface{}
+		var parts []string
+		for gi, term := range clause.terms {
+			criterion, desc, ok := c.compileTerm(term)
+			if !ok {
+				continue
+			}
+			criterion["id"] = fmt.Sprintf("crit-%d-%d", si+1, gi+1)
+			groups = append(groups, map[string]interface{}{
+				"id":       fmt.Sprintf("group-%d-%d", si+1, gi+1),
+				"disabled": false,
+				"criteria": []interface{}{criterion},
+			})
+			parts = append(parts, desc)
+		}
+		operator := "AND"
+		if len(clause.terms) > 1 {
+			operator = "OR"
+		}
+		sections = append(sections, map[string]interface{}{
+			"id":                          fmt.Sprintf("section-%d", si+1),
+			"displayName":                 clause.text,
+			"disabled":                    false,
+			"operator":                    operator,
+			"excluded":                    clause.excluded,
+			"firstBlockReducingOperator":  "ANY",
+			"secondBlockReducingOperator": "ANY",
+			"secondBlockCriteriaGroups":   []interface{}{},
+			"criteriaGroups":              groups,
+		})
+		verb := "include"
+		if clause.excluded {
+			verb = "exclude"
+		}
+		summary = append(summary, fmt.Sprintf("section-%d (%s): %s", si+1, verb, strings.Join(parts, " OR ")))
+	}
+	if len(c.errs) > 0 {
+		return nil, nil, fmt.Errorf("criteria do not match underlay %s:\n- %s", schema.Name, strings.Join(c.errs, "\n- "))
+	}
+	return sections, summary, nil
+}
+
+func (c *criteriaCompiler) fail(term *criteriaTerm, format string, args ...interface{}) (map[string]interface{}, string, bool) {
+	c.errs = append(c.errs, fmt.Sprintf("%s: %s", term.text, fmt.Sprintf(format, args...)))
+	return nil, "", false
+}
+
+func (c *criteriaCompiler) compileTerm(term *criteriaTerm) (map[string]interface{}, string, bool) {
+	if term.has {
+		return c.compileHas(term)
+	}
+	return c.compileAttribute(term)
+}
+
+func newCriterion(sel *criteriaSelector, selectionData interface{}) map[string]interface{} {
+	data, _ := json.Marshal(selectionData)
+	return map[string]interface{}{
+		"pluginName":             sel.Plugin,
+		"selectorOrModifierName": sel.Name,
+		"selectionData":          string(data),
+		"uiConfig":               sel.PluginConfig,
+		"pluginVersion":          0,
+		"tags":                   map[string]interface{}{},
+		"enabled":                true,
+	}
+}
+
+// compileAttribute compiles a comparison on a primary-entity attribute into an
+// "attribute" plugin criterion: =/IN select values, the rest select a range.
+func (c *criteriaCompiler) compileAttribute(term *criteriaTerm) (map[string]interface{}, string, bool) {
+	primary := c.schema.PrimaryEntity
+	if term.entity != "" && term.entity != primary {
+		return c.fail(term, "attribute criteria apply to the primary entity %q; use HAS %s ... to select %s instances", primary, term.entity, term.entity)
+	}
+	entity := c.schema.Entities[primary]
+	if entity == nil {
+		return c.fail(term, "primary entity %q not found in underlay", primary)
+	}
+	attr, ok := entity.attribute(term.attribute)
+	if !ok {
+		return c.fail(term, "%v", unknownName("attribute", term.attribute, "entity "+primary, entity.attributeNames()))
+	}
+	var sel *criteriaSelector
+	var attrSelectors []string
+	for _, s := range c.schema.Selectors {
+		if s.Plugin != "attribute" {
+			continue
+		}
+		attrSelectors = append(attrSelectors, s.Name)
+		if name, _ := s.config["attribute"].(string); name == attr.Name {
+			sel = s
+			break
+		}
+	}
+	if sel == nil {
+		return c.fail(term, "no criteria selector filters on %s.%s (attribute selectors: %s)", primary, attr.Name, strings.Join(attrSelectors, ", "))
+	}
+
+	switch term.op {
+	case "=", "IN":
+		var selected []interface{}
+		var names []string
+		for _, v := range term.values {
+			value, name, err := c.attributeValue(attr, v)
+			if err != nil {
+				return c.fail(term, "%v", err)
+			}
+			selected = append(selected, map[string]interface{}{"value": value, "name": name})
+			names = append(names, name)
+		}
+		return newCriterion(sel, map[string]interface{}{"selected": selected}),
+			fmt.Sprintf("%s in {%s} [selector %s]", attr.Name, strings.Join(names, ", "), sel.Name), true
+	}
+
+	if attr.DataType != "INT64" && attr.DataType != "DOUBLE" {
+		return c.fail(term, "%s is %s; range comparisons need an INT64 or DOUBLE attribute", attr.Name, attr.DataType)
+	}
+	nums := make([]float64, len(term.values))
+	for i, v := range term.values {
+		if v.kind != tokNumber {
+			return c.fail(term, "%s %s needs a number, got %s", attr.Name, term.op, v.describe())
+		}
+		nums[i], _ = strconv.ParseFloat(v.text, 64)
+	}
+	lo, hi := math.Inf(-1), math.Inf(1)
+	switch term.op {
+	case "BETWEEN":
+		lo, hi = nums[0], nums[1]
+	case ">=":
+		lo = nums[0]
+	case "<=":
+		hi = nums[0]
+	case ">", "<":
+		if attr.DataType != "INT64" {
+			return c.fail(term, "strict %s is only supported for INT64 attributes; use %s=", term.op, term.op)
+		}
+		// The nearest integer past the bound: age > 17.5 is age >= 18.
+		if term.op == ">" {
+			lo = math.Floor(nums[0]) + 1
+		} else {
+			hi = math.Ceil(nums[0]) - 1
+		}
+	}
+	// Data Explorer ranges need both ends; take open ends from the hints.
+	if math.IsInf(lo, 0) || math.IsInf(hi, 0) {
+		hint, err := c.hint(attr.Name)
+		if err != nil || hint.Min == nil || hint.Max == nil {
+			return c.fail(term, "could not look up the range of %s; use BETWEEN with both bounds", attr.Name)
+		}
+		if math.IsInf(lo, 0) {
+			lo = *hint.Min
+		}
+		if math.IsInf(hi, 0) {
+			hi = *hint.Max
+		}
+	}
+	if lo > hi {
+		return c.fail(term, "empty range: %g > %g", lo, hi)
+	}
+	return newCriterion(sel, map[string]interface{}{"dataRanges": []interface{}{map[string]interface{}{"min": lo, "max": hi}}}),
+		fmt.Sprintf("%s between %g and %g [selector %s]", attr.Name, lo, hi, sel.Name), true
+}
+
+// attributeValue converts a literal to a selection value for attr. Quoted
+// names of enum values (e.g. gender = "Female") resolve through the hints.
+func (c *criteriaCompiler) attributeValue(attr underlayAttribute, v criteriaToken) (map[string]interface{}, string, error) {
+	if v.kind == tokString {
+		if hint, err := c.hint(attr.Name); err == nil && len(hint.Enum) > 0 {
+			var known []string
+			for _, e := range hint.Enum {
+				if strings.EqualFold(e.Display, v.text) {
+					return e.Value, e.Display, nil
+				}
+				known = append(known, e.Display)
+			}
+			return nil, "", unknownName("value", v.text, "attribute "+attr.Name, known)
+		}
+	}
+	switch attr.DataType {
+	case "STRING":
+		return map[string]interface{}{"stringVal": v.text}, v.text, nil
+	case "INT64":
+		n, err := strconv.ParseInt(v.text, 10, 64)
+		if v.kind != tokNumber || err != nil {
+			return nil, "", fmt.Errorf("%s is INT64 but %s is not an integer", attr.Name, v.describe())
+		}
+		return map[string]interface{}{"int64Val": n}, v.text, nil
+	case "DOUBLE":
+		n, err := strconv.ParseFloat(v.text, 64)
+		if v.kind != tokNumber || err != nil {
+			return nil, "", fmt.Errorf("%s is DOUBLE but %s is not a number", attr.Name, v.describe())
+		}
+		return map[string]interface{}{"doubleVal": n}, v.text, nil
+	case "BOOLEAN":
+		if !v.is("TRUE") && !v.is("FALSE") {
+			return nil, "", fmt.Errorf("%s is BOOLEAN; use true or false", attr.Name)
+		}
+		return map[string]interface{}{"boolVal": v.is("TRUE")}, strings.ToLower(v.text), nil
+	}
+	return nil, "", fmt.Errorf("%s has type %s, which the attribute selector does not support", attr.Name, attr.DataType)
+}
+
+func (c *criteriaCompiler) hint(attribute string) (attributeHint, error) {
+	if c.hints == nil {
+		hints, err := loadAttributeHints(c.ctx, c.schema.Name, c.schema.PrimaryEntity)
+		if err != nil {
+			return attributeHint{}, err
+		}
+		c.hints = hints
+	}
+	return c.hints[attribute], nil
+}
+
+// compileHas compiles HAS <name> into an "entityGroup" plugin criterion. name
+// is a selector name (e.g. "conditions") or the entity it selects (e.g.
+// "condition").
+func (c *criteriaCompiler) compileHas(term *criteriaTerm) (map[string]interface{}, string, bool) {
+	var sel *criteriaSelector
+	var groupID string
+	var entityGroupSelectors []string
+	for _, s := range c.schema.Selectors {
+		if s.matchesName(term.target) {
+			sel = s
+			break
+		}
+	}
+	for _, s := range c.schema.Selectors {
+		if s.Plugin != "entityGroup" {
+			continue
+		}
+		entityGroupSelectors = append(entityGroupSelectors, s.Name)
+		for _, id := range s.classificationGroups() {
+			g := c.schema.EntityGroups[id]
+			if g == nil || normalizeName(g.criteriaEntity()) != normalizeName(term.target) {
+				continue
+			}
+			if sel == nil || sel == s {
+				sel, groupID = s, id
+			}
+		}
+		if groupID != "" {
+			break
+		}
+	}
+	if sel == nil {
+		return c.fail(term, "%v", unknownName("criteria selector or entity", term.target, "underlay "+c.schema.Name, entityGroupSelectors))
+	}
+	if sel.Plugin != "entityGroup" {
+		return c.fail(term, "selector %s uses the %s plugin, which the DSL does not compile; pass criteriaGroupSections JSON instead", sel.Name, sel.Plugin)
+	}
+	if groupID == "" {
+		groups := sel.classificationGroups()
+		if len(groups) == 0 {
+			return c.fail(term, "selector %s has no classificationEntityGroups", sel.Name)
+		}
+		groupID = groups[0]
+	}
+	entityName := term.target
+	if g := c.schema.EntityGroups[groupID]; g != nil {
+		entityName = g.criteriaEntity()
+	}
+	var hierarchy string
+	if term.hierarchy {
+		e := c.schema.Entities[entityName]
+		if e == nil || !e.hasHierarchy() {
+			return c.fail(term, "%s has no hierarchy; drop IN HIERARCHY", entityName)
+		}
+		hierarchy = e.Hierarchies[0].Name
+	}
+
+	var selected []interface{}
+	var names []string
+	var ids []interface{}
+	for _, v := range term.values {
+		id, name, err := c.resolveConcept(entityName, v)
+		if err != nil {
+			return c.fail(term, "%v", err)
+		}
+		selected = append(selected, map[string]interface{}{
+			"key":         map[string]interface{}{"int64Key": id},
+			"name":        name,
+			"entityGroup": groupID,
+		})
+		ids = append(ids, conceptLiteral(id))
+		if name == strconv.FormatInt(id, 10) {
+			names = append(names, name)
+		} else {
+			names = append(names, fmt.Sprintf("%s (%d)", name, id))
+		}
+	}
+	if hierarchy == "" {
+		return newCriterion(sel, map[string]interface{}{"selected": selected}),
+			fmt.Sprintf("has %s %s [selector %s]", entityName, strings.Join(names, ", "), sel.Name), true
+	}
+	// IN HIERARCHY also selects every descendant of the concepts.
+	return newCriterion(sel, map[string]interface{}{
+			"selected":        selected,
+			"hierarchyFilter": hierarchyFilter(hierarchy, "DESCENDANT_OF_INCLUSIVE", ids),
+		}),
+		fmt.Sprintf("has %s %s or a descendant in hierarchy %s [selector %s]", entityName, strings.Join(names, ", "), hierarchy, sel.Name), true
+}
+
+// resolveConcept turns a concept ID or exact concept name into an ID and
+// display name. Ambiguous or unknown names are errors listing candidates.
+func (c *criteriaCompiler) resolveConcept(entityName string, v criteriaToken) (int64, string, error) {
+	if v.kind == tokNumber {
+		id, err := strconv.ParseInt(v.text, 10, 64)
+		if err != nil {
+			return 0, "", fmt.Errorf("concept ID %s is not an integer", v.text)
+		}
+		return id, v.text, nil
+	}
+	matches, err := searchInstances(c.ctx, c.schema.Name, entityName, v.text, 20)
+	if err != nil {
+		return 0, "", fmt.Errorf("could not look up %s %q (%v); use its concept ID instead", entityName, v.text, err)
+	}
+	var exact []underlayInstance
+	var candidates []string
+	for _, m := range matches {
+		if strings.EqualFold(m.Name, v.text) {
+			exact = append(exact, m)
+		}
+		candidates = append(candidates, fmt.Sprintf("%s (%d)", m.Name, m.ID))
+	}
+	switch {
+	case len(exact) == 1:
+		return exact[0].ID, exact[0].Name, nil
+	case len(exact) > 1:
+		return 0, "", fmt.Errorf("%q matches several %s concepts; use an ID: %s", v.text, entityName, strings.Join(candidates, ", "))
+	case len(candidates) > 0:
+		return 0, "", fmt.Errorf("no %s named exactly %q; did you mean: %s", entityName, v.text, strings.Join(candidates, ", "))
+	}
+	return 0, "", fmt.Errorf("no %s matches %q", entityName, v.text)
+}
+
+// handleCohortCompileCriteria implements the cohort_compile_criteria tool.
+func handleCohortCompileCriteria(ctx context.Context, args map[string]interface{}) (string, error) {
+	vals, err := requireStrings(args, "underlayName", "criteria")
+	if err != nil {
+		return "", err
+	}
+	underlayName, src := vals[0], vals[1]
+	schema, err := loadUnderlaySchema(ctx, underlayName)
+	if err != nil {
+		return "", err
+	}
+	sections, summary, err := compileCriteria(ctx, schema, src)
+	if err != nil {
+		return "", err
+	}
+	criteriaJSON, _ := json.Marshal(map[string]interface{}{"criteriaGroupSections": sections})
+	result := map[string]interface{}{
+		"summary":               summary,
+		"criteriaGroupSections": sections,
+		"criteriaJson":          string(criteriaJSON),
+	}
+	resultBytes, err := json.MarshalIndent(result, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal result: %w", err)
+	}
+	return string(resultBytes), nil
+}
@@ -0,0 +1,276 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+// formatClauses renders parsed criteria compactly: clauses joined by " & ",
+// OR'd terms by " | ", excluded clauses as !(...).
+func formatClauses(clauses []*criteriaClause) string {
+	var parts []string
+	for _, c := range clauses {
+		var terms []string
+		for _, t := range c.terms {
+			var values []string
+			for _, v := range t.values {
+				values = append(values, v.text)
+			}
+			switch {
+			case t.has && t.hierarchy:
+				terms = append(terms, "has "+t.target+" in hierarchy "+strings.Join(values, ","))
+			case t.has:
+				terms = append(terms, "has "+t.target+" "+strings.Join(values, ","))
+			case t.entity != "":
+				terms = append(terms, t.entity+"."+t.attribute+" "+t.op+" "+strings.Join(values, ","))
+			default:
+				terms = append(terms, t.attribute+" "+t.op+" "+strings.Join(values, ","))
+			}
+		}
+		s := strings.Join(terms, " | ")
+		if c.excluded {
+			s = "!(" + s + ")"
+		}
+		parts = append(parts, s)
+	}
+	return strings.Join(parts, " & ")
+}
+
+func TestParseCriteria(t *testing.T) {
+	tests := []struct {
+		src  string
+		want string
+	}{
+		{`age >= 18`, `age >= 18`},
+		{`person.age < 65.5`, `person.age < 65.5`},
+		{`gender = "Female"`, `gender = Female`},
+		{`deceased = false`, `deceased = false`},
+		{`year_of_birth > -5`, `year_of_birth > -5`},
+		{`age >= 18 AND age <= 65`, `age >= 18 & age <= 65`},
+		// The AND inside BETWEEN doesn't start a new clause.
+		{`age BETWEEN 18 AND 65 AND gender = 'F'`, `age BETWEEN 18,65 & gender = F`},
+		{`race IN ("Asian", "White")`, `race IN Asian,White`},
+		{`has condition 201826`, `has condition 201826`},
+		{`has condition in hierarchy "Type 2 diabetes mellitus"`, `has condition in hierarchy Type 2 diabetes mellitus`},
+		{`has condition in (201826, 4329847)`, `has condition 201826,4329847`},
+		// OR binds inside parentheses only; AND separates clauses.
+		{`age >= 18 AND (has condition 201826 OR has condition 4329847)`, `age >= 18 & has condition 201826 | has condition 4329847`},
+		{`(a = 1 OR b = 2) AND (c = 3 OR d = 4)`, `a = 1 | b = 2 & c = 3 | d = 4`},
+		// NOT applies to the whole clause that follows it.
+		{`NOT has drug 1 AND age > 1`, `!(has drug 1) & age > 1`},
+		{`age > 1 AND NOT (has drug 1 OR has drug 2)`, `age > 1 & !(has drug 1 | has drug 2)`},
+		{`not Has condition 1 and AGE between 1 and 2`, `!(has condition 1) & AGE BETWEEN 1,2`},
+	}
+	for _, tt := range tests {
+		clauses, err := parseCriteria(tt.src)
+		if err != nil {
+			t.Errorf("parseCriteria(%q): %v", tt.src, err)
+			continue
+		}
+		if got := formatClauses(clauses); got != tt.want {
+			t.Errorf("parseCriteria(%q) = %s, want %s", tt.src, got, tt.want)
+		}
+	}
+}
+
+func TestParseCriteriaText(t *testing.T) {
+	clauses, err := parseCriteria(`age >= 18 AND NOT (has condition "a b" OR has drug 2)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(clauses) != 2 {
+		t.Fatalf("got %d clauses, want 2", len(clauses))
+	}
+	if got, want := clauses[1].text, `NOT (has condition "a b" OR has drug 2)`; got != want {
+		t.Errorf("clause text = %q, want %q", got, want)
+	}
+	if got, want := clauses[1].terms[0].text, `has condition "a b"`; got != want {
+		t.Errorf("term text = %q, want %q", got, want)
+	}
+}
+
+func TestParseCriteriaErrors(t *testing.T) {
+	tests := []struct {
+		src  string
+		want string
+	}{
+		{`age >= 18 AND has condition 1 OR has condition 2`, "wrap OR'd terms in parentheses"},
+		{`has condition 1 OR has condition 2`, "wrap OR'd terms in parentheses"},
+		{`(age > 1 AND age < 2)`, "AND is not allowed inside parentheses"},
+		{`((age > 1))`, "nested parentheses are not supported"},
+		{`(NOT age > 1)`, "NOT applies to a whole clause"},
+		{`age != 3`, "!= is not supported"},
+		{`gender = "F`, "unterminated string"},
+		{`age >= 1.2.3`, "invalid number"},
+		{`age BETWEEN 1 OR 2`, "expected AND in BETWEEN"},
+		{`age BETWEEN "a" AND 2`, "BETWEEN bounds must be numbers"},
+		{`race IN "Asian"`, "expected ( after IN"},
+		{`race IN ("Asian" "White")`, "expected , or )"},
+		{`age 18`, "expected an operator"},
+		{`age >=`, "expected a value after >=, got end of input"},
+		{`has 201826`, "expected a selector or entity name after HAS"},
+		{`age > 1 AND`, "at position 12"},
+		{`age > 1 age`, "expected AND or end of input"},
+		{`age > 1 # x`, "unexpected character"},
+	}
+	for _, tt := range tests {
+		_, err := parseCriteria(tt.src)
+		if err == nil {
+			t.Errorf("parseCriteria(%q) succeeded, want error containing %q", tt.src, tt.want)
+			continue
+		}
+		if !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("parseCriteria(%q) error = %q, want it to contain %q", tt.src, err, tt.want)
+		}
+	}
+}
+
+func floatPtr(v float64) *float64 { return &v }
+
+// testUnderlay is a small schema with attribute and entityGroup selectors.
+func testUnderlay() *underlaySchema {
+	return &underlaySchema{
+		Name:          "test",
+		PrimaryEntity: "person",
+		Entities: map[string]*underlayEntity{
+			"person": {Name: "person", Attributes: []underlayAttribute{
+				{Name: "age", DataType: "INT64"},
+				{Name: "bmi", DataType: "DOUBLE"},
+				{Name: "gender", DataType: "INT64"},
+				{Name: "name", DataType: "STRING"},
+				{Name: "height", DataType: "DOUBLE"},
+			}},
+			"condition": {Name: "condition", Hierarchies: []struct {
+				Name string `json:"name"`
+			}{{Name: "default"}}},
+			"drug": {Name: "drug"},
+		},
+		EntityGroups: map[string]*underlayEntityGroup{
+			"conditionPerson": {Name: "conditionPerson", CriteriaEntity: "condition"},
+			"drugPerson":      {Name: "drugPerson", CriteriaEntity: "drug"},
+		},
+		Selectors: []*criteriaSelector{
+			{Name: "age", Plugin: "attribute", config: map[string]interface{}{"attribute": "age"}},
+			{Name: "bmi", Plugin: "attribute", config: map[string]interface{}{"attribute": "bmi"}},
+			{Name: "gender", Plugin: "attribute", config: map[string]interface{}{"attribute": "gender"}},
+			{Name: "name", Plugin: "attribute", config: map[string]interface{}{"attribute": "name"}},
+			{Name: "conditions", Plugin: "entityGroup", config: map[string]interface{}{
+				"classificationEntityGroups": []interface{}{map[string]interface{}{"id": "conditionPerson"}}}},
+			{Name: "drugs", Plugin: "entityGroup", config: map[string]interface{}{
+				"classificationEntityGroups": []interface{}{map[string]interface{}{"id": "drugPerson"}}}},
+			{Name: "survey", Plugin: "survey"},
+		},
+	}
+}
+
+func TestCompileTerm(t *testing.T) {
+	tests := []struct {
+		src     string
+		want    string
+		wantErr string
+	}{
+		{src: `age BETWEEN 18 AND 65`, want: `age between 18 and 65 [selector age]`},
+		// Open ends come from the hints.
+		{src: `age >= 18`, want: `age between 18 and 120 [selector age]`},
+		{src: `age <= 30`, want: `age between 0 and 30 [selector age]`},
+		// Strict bounds move to the nearest integer past them.
+		{src: `age > 17`, want: `age between 18 and 120 [selector age]`},
+		{src: `age > 17.5`, want: `age between 18 and 120 [selector age]`},
+		{src: `age < 65.5`, want: `age between 0 and 65 [selector age]`},
+		{src: `age < -2.5`, wantErr: "empty range: 0 > -3"},
+		{src: `bmi >= 30.5`, want: `bmi between 30.5 and 80 [selector bmi]`},
+		{src: `gender = "female"`, want: `gender in {Female} [selector gender]`},
+		{src: `gender IN (8507, 8532)`, want: `gender in {8507, 8532} [selector gender]`},
+		{src: `has condition 201826`, want: `has condition 201826 [selector conditions]`},
+		{src: `has conditions in (1, 2)`, want: `has condition 1, 2 [selector conditions]`},
+		{src: `has condition in hierarchy 201826`, want: `has condition 201826 or a descendant in hierarchy default [selector conditions]`},
+		{src: `bmi > 30`, wantErr: "strict > is only supported for INT64 attributes"},
+		{src: `name >= 3`, wantErr: "name is STRING; range comparisons need an INT64 or DOUBLE attribute"},
+		{src: `age >= "x"`, wantErr: `age >= needs a number, got "x"`},
+		{src: `gender = "other"`, wantErr: `unknown value "other"`},
+		{src: `age = 1.5`, wantErr: `age is INT64 but "1.5" is not an integer`},
+		{src: `weight = 3`, wantErr: `unknown attribute "weight"`},
+		{src: `condition.age = 3`, wantErr: `attribute criteria apply to the primary entity "person"`},
+		{src: `has drug in hierarchy 1`, wantErr: "drug has no hierarchy; drop IN HIERARCHY"},
+		{src: `has survey 1`, wantErr: "selector survey uses the survey plugin"},
+		{src: `height >= 1`, wantErr: "no criteria selector filters on person.height"},
+		{src: `has procedure 1`, wantErr: `unknown criteria selector or entity "procedure"`},
+	}
+	for _, tt := range tests {
+		clauses, err := parseCriteria(tt.src)
+		if err != nil {
+			t.Fatalf("parseCriteria(%q): %v", tt.src, err)
+		}
+		c := &criteriaCompiler{ctx: context.Background(), schema: testUnderlay(), hints: map[string]attributeHint{
+			"age": {Min: floatPtr(0), Max: floatPtr(120)},
+			"bmi": {Min: floatPtr(10), Max: floatPtr(80)},
+			"gender": {Enum: []enumHintValue{
+				{Value: map[string]interface{}{"int64Val": float64(8532)}, Display: "Female"},
+				{Value: map[string]interface{}{"int64Val": float64(8507)}, Display: "Male"},
+			}},
+		}}
+		_, desc, ok := c.compileTerm(clauses[0].terms[0])
+		if tt.wantErr != "" {
+			if ok || len(c.errs) != 1 || !strings.Contains(c.errs[0], tt.wantErr) {
+				t.Errorf("compile %q: errors %q, want %q", tt.src, c.errs, tt.wantErr)
+			}
+			continue
+		}
+		if !ok {
+			t.Errorf("compile %q: %v", tt.src, c.errs)
+		} else if desc != tt.want {
+			t.Errorf("compile %q = %q, want %q", tt.src, desc, tt.want)
+		}
+	}
+}
+
+func TestCompileCriteriaSelectionData(t *testing.T) {
+	schema := testUnderlay()
+	sections, _, err := compileCriteria(context.Background(), schema, `age BETWEEN 18 AND 65 AND NOT (has condition in hierarchy 201826 OR has drug 5)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sections) != 2 {
+		t.Fatalf("got %d sections, want 2", len(sections))
+	}
+	data := func(section, group int) map[string]interface{} {
+		groups := sections[section].(map[string]interface{})["criteriaGroups"].([]interface{})
+		criterion := groups[group].(map[string]interface{})["criteria"].([]interface{})[0].(map[string]interface{})
+		var v map[string]interface{}
+		if err := json.Unmarshal([]byte(criterion["selectionData"].(string)), &v); err != nil {
+			t.Fatal(err)
+		}
+		return v
+	}
+	if got, want := data(0, 0), `{"dataRanges":[{"max":65,"min":18}]}`; mustJSON(t, got) != want {
+		t.Errorf("range selection = %s, want %s", mustJSON(t, got), want)
+	}
+	second := sections[1].(map[string]interface{})
+	if second["excluded"] != true || second["operator"] != "OR" {
+		t.Errorf("second section = excluded %v, operator %v; want an excluded OR section", second["excluded"], second["operator"])
+	}
+	hier := data(1, 0)
+	want := `{"filterType":"HIERARCHY","filterUnion":{"hierarchyFilter":{"hierarchy":"default","operator":"DESCENDANT_OF_INCLUSIVE","values":[{"dataType":"INT64","valueUnion":{"int64Val":"201826"}}]}}}`
+	if got := mustJSON(t, hier["hierarchyFilter"]); got != want {
+		t.Errorf("hierarchyFilter = %s, want %s", got, want)
+	}
+	if _, ok := data(1, 1)["hierarchyFilter"]; ok {
+		t.Error("an exact match has a hierarchyFilter")
+	}
+
+	_, _, err = compileCriteria(context.Background(), schema, `weight > 1 AND has procedure 1`)
+	if err == nil || !strings.Contains(err.Error(), "criteria do not match underlay test:\n- weight > 1: ") || !strings.Contains(err.Error(), "\n- has procedure 1: ") {
+		t.Errorf("got %v, want both errors listed", err)
+	}
+}
+
+func mustJSON(t *testing.T, v interface{}) string {
+	t.Helper()
+	b, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Dry runs execute a tool with reads going through as usual and every
+// mutating subprocess or HTTP request recorded instead of issued. The
+// recorder travels in the context, so the choke points (runCommand and
+// makeAPIRequest) cover remote changes without per-tool code. Changes the
+// server makes itself - local files, state-directory records, the session's
+// workspace - don't pass through them, so those tools check dryRunFrom and
+// record or skip the change.
+
+// dryRunDefault makes every call a dry run unless it passes dryRun=false
+// (set with -dry-run or WB_MCP_DRY_RUN).
+var dryRunDefault bool
+
+type dryRunKey struct{}
+
+// dryRunAction is one command or request that would have been issued.
+type dryRunAction struct {
+	Argv   []string    `json:"argv,omitempty"`
+	Method string      `json:"method,omitempty"`
+	URL    string      `json:"url,omitempty"`
+	Body   interface{} `json:"body,omitempty"`
+}
+
+type dryRunRecorder struct {
+	mu      sync.Mutex
+	actions []dryRunAction
+}
+
+func (r *dryRunRecorder) record(a dryRunAction) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.actions = append(r.actions, a)
+}
+
+func dryRunFrom(ctx context.Context) *dryRunRecorder {
+	r, _ := ctx.Value(dryRunKey{}).(*dryRunRecorder)
+	return r
+}
+
+// initDryRun sets the server-wide default and advertises the dryRun argument
+// on every tool.
+func initDryRun(enabled bool) {
+	env, _ := strconv.ParseBool(os.Getenv("WB_MCP_DRY_RUN"))
+	dryRunDefault = enabled || env
+	for i := range wbTools {
+		if wbTools[i].InputSchema.Properties == nil {
+			wbTools[i].InputSchema.Properties = map[string]interface{}{}
+		}
+		wbTools[i].InputSchema.Properties["dryRun"] = map[string]interface{}{
+			"type":        "boolean",
+			"description": "Show the wb/aws commands and HTTP requests that would make changes, without running them",
+		}
+	}
+	if dryRunDefault {
+		log.Println("Dry-run mode: no changes will be made unless a call passes dryRun=false")
+	}
+}
+
+// readOnlyVerbs are subcommands that only read, for wb (including its gsutil
+// and bq passthroughs) and the aws CLI.
+var readOnlyVerbs = map[string]bool{
+	"status": true, "list": true, "describe": true, "tree": true, "list-users": true,
+	"print-access-token": true, "resolve": true, "credentials": true, "check-access": true,
+	"ls": true, "cat": true, "du": true, "stat": true, "show": true, "head": true,
+	// Only writes the local AWS CLI config that later reads depend on.
+	"configure-aws": true,
+}
+
+// isReadOnlyCommand reports whether argv (program first) only reads. For wb
+// the verb is found among the first three non-flag words, e.g. "resource
+// describe" or "workflow job describe". For aws and wb's gsutil and bq
+// passthroughs only the operation right after the service counts, so an
+// argument that happens to be named "list" doesn't make a copy read-only;
+// aws get-*/list-*/describe-*/head-* operations also count.
+func isReadOnlyCommand(argv []string) bool {
+	program := filepath.Base(argv[0])
+	if program != "wb" && program != "aws" {
+		return false // psql and other tools may write
+	}
+	var words []string
+	for _, arg := range argv[1:] {
+		if !strings.HasPrefix(arg, "-") {
+			words = append(words, arg)
+		}
+	}
+	if len(words) == 0 {
+		return false
+	}
+	if program == "aws" || words[0] == "gsutil" || words[0] == "bq" {
+		if len(words) < 2 {
+			return false
+		}
+		op := words[1]
+		if readOnlyVerbs[op] {
+			return true
+		}
+		for _, prefix := range []string{"get-", "list-", "describe-", "head-"} {
+			if program == "aws" && strings.HasPrefix(op, prefix) {
+				return true
+			}
+		}
+		return false
+	}
+	for i, word := range words {
+		if i == 3 {
+			break
+		}
+		if readOnlyVerbs[word] {
+			return true
+		}
+	}
+	return false
+}
+
+// readOnlyPostSuffixes are POST endpoints that query rather than change state.
+var readOnlyPostSuffixes = []string{"/filtered", "/hints", "/instances", "/counts", "/describeExport", "/previewExport"}
+
+func isReadOnlyRequest(method, url string) bool {
+	if method == "GET" || method == "HEAD" {
+		return true
+	}
+	if method != "POST" {
+		return false
+	}
+	path := strings.SplitN(url, "?", 2)[0]
+	for _, suffix := range readOnlyPostSuffixes {
+		if strings.HasSuffix(path, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// dryRunCommand records argv if ctx is a dry run and argv would make changes.
+func dryRunCommand(ctx context.Context, argv []string) bool {
+	r := dryRunFrom(ctx)
+	if r == nil || isReadOnlyCommand(argv) {
+		return false
+	}
+	redacted := make([]string, len(argv))
+	for i, arg := range argv {
+		redacted[i] = redactCommandLine([]string{arg})
+	}
+	r.record(dryRunAction{Argv: redacted})
+	return true
+}
+
+// dryRunResponse stands in for the response to a recorded request. It has
+// just enough shape for multi-step tools (e.g. create a cohort, then save it
+// to a workspace) to carry on, with IDs marked as not yet assigned.
+var dryRunResponse = []byte(`{"id":"<new-id>","study":{"id":"<new-study-id>"},"cohort":{"id":"<new-cohort-id>"}}`)
+
+// dryRunRequest records the request if ctx is a dry run and it would make
+// changes.
+func dryRunRequest(ctx context.Context, method, url string, body interface{}) bool {
+	r := dryRunFrom(ctx)
+	if r == nil || isReadOnlyRequest(method, url) {
+		return false
+	}
+	r.record(dryRunAction{Method: method, URL: url, Body: body})
+	return true
+}
+
+// isDryRun reports whether a call should be a dry run: its dryRun argument,
+// else the server default. Calls already inside a dry run are not wrapped
+// again.
+func isDryRun(ctx context.Context, args map[string]interface{}) bool {
+	if dryRunFrom(ctx) != nil {
+		return false
+	}
+	if v, ok := args["dryRun"].(bool); ok {
+		return v
+	}
+	return dryRunDefault
+}
+
+// handleDryRun runs the tool under a recorder and reports what it would have
+// done. Later steps of multi-step tools may fail because recorded calls return
+// no real result; the report says where the tool stopped.
+func handleDryRun(ctx context.Context, sess *session, params CallToolParams) CallToolResult {
+	rec := &dryRunRecorder{}
+	ctx = context.WithValue(ctx, dryRunKey{}, rec)
+	args := make(map[string]interface{}, len(params.Arguments))
+	for k, v := range params.Arguments {
+		if k != "async" {
+			args[k] = v // run inline, so the recorder sees every call
+		}
+	}
+	params.Arguments = args
+	res := handleCallTool(ctx, sess, params)
+
+	var b strings.Builder
+	if len(rec.actions) == 0 {
+		b.WriteString("Dry run: this call makes no changes. Its output:\n\n")
+		for _, c := range res.Content {
+			b.WriteString(c.Text)
+		}
+		return CallToolResult{Content: []ContentItem{{Type: "text", Text: b.String()}}, StructuredContent: res.StructuredContent, IsError: res.IsError}
+	}
+	fmt.Fprintf(&b, "Dry run: nothing was changed. %s would issue %d call(s):\n", params.Name, len(rec.actions))
+	for i, a := range rec.actions {
+		if a.Argv != nil {
+			fmt.Fprintf(&b, "%d. %s\n", i+1, shellJoin(a.Argv))
+			continue
+		}
+		fmt.Fprintf(&b, "%d. %s %s\n", i+1, a.Method, a.URL)
+		if a.Body != nil {
+			var body bytes.Buffer
+			enc := json.NewEncoder(&body)
+			enc.SetEscapeHTML(false)
+			enc.SetIndent("   ", "  ")
+			enc.Encode(a.Body)
+			fmt.Fprintf(&b, "   %s", body.String())
+		}
+	}
+	if res.IsError {
+		text := ""
+		for _, c := range res.Content {
+			text += c.Text
+		}
+		fmt.Fprintf(&b, "\nThe tool stopped early (%s). Later calls depend on the results of the ones above, so the real run may issue more.\n", truncate(strings.TrimSpace(text), 300))
+	}
+	return CallToolResult{Content: []ContentItem{{Type: "text", Text: b.String()}}}
+}
+
+// shellJoin quotes args that need it so the line can be pasted into a shell.
+func shellJoin(args []string) string {
+	quoted := make([]string, len(args))
+	for i, a := range args {
+		if a == "" || strings.ContainsAny(a, " \t\n'\"$`\\*?[]{}()<>|&;#~") {
+			a = "'" + strings.ReplaceAll(a, "'", `'\''`) + "'"
+		}
+		quoted[i] = a
+	}
+	return strings.Join(quoted, " ")
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestIsReadOnlyCommand(t *testing.T) {
+	tests := []struct {
+		argv string
+		want bool
+	}{
+		{"wb workspace list --format=json", true},
+		{"wb resource describe --id=x --format=json", true},
+		{"wb workflow job describe --job=j", true},
+		{"wb workspace list-users", true},
+		{"wb auth print-access-token", true},
+		{"wb workspace configure-aws", true},
+		{"wb gsutil ls gs://b/", true},
+		{"wb gsutil --workspace=ws cat -r 0-10 gs://b/x", true},
+		{"wb gsutil -m cp gs://b/x /tmp/x", false},
+		{"wb gsutil cp list gs://b/list", false},
+		{"wb bq show ds.t", true},
+		{"wb bq query SELECT", false},
+		{"wb workspace create --id=list", false},
+		{"wb resource create gcs-bucket --id=b", false},
+		{"wb app stop --app=a", false},
+		{"wb resource delete --id=x", false},
+		{"wb a b c list", false}, // only the first three words
+		{"wb --format=json", false},
+		{"/usr/local/bin/wb status", true},
+		{"aws s3 ls s3://b/", true},
+		{"aws s3api get-object --bucket=b --key=k /tmp/k", true},
+		{"aws sts get-caller-identity", true},
+		{"aws s3 cp list s3://b/list", false},
+		{"aws s3api put-object --bucket=b", false},
+		{"aws s3", false},
+		{"psql -c select", false},
+		{"gsutil ls", false},
+	}
+	for _, tt := range tests {
+		if got := isReadOnlyCommand(strings.Fields(tt.argv)); got != tt.want {
+			t.Errorf("isReadOnlyCommand(%q) = %v, want %v", tt.argv, got, tt.want)
+		}
+	}
+}
+
+func TestIsReadOnlyRequest(t *testing.T) {
+	tests := []struct {
+		method, url string
+		want        bool
+	}{
+		{"GET", "https://x/api/v1/underlays", true},
+		{"HEAD", "https://x/api/v1/underlays", true},
+		{"POST", "https://x/api/v1/underlays/u/entities/person/instances", true},
+		{"POST", "https://x/api/v1/underlays/u/entities/person/counts?x=1", true},
+		{"POST", "https://x/api/v1/studies/s/cohorts/c/describeExport", true},
+		{"POST", "https://x/api/v1/studies/s/cohorts", false},
+		{"POST", "https://x/api/v1/studies?next=/counts", false},
+		{"PATCH", "https://x/api/v1/studies/s/cohorts/c", false},
+		{"DELETE", "https://x/api/v1/studies/s/cohorts/c", false},
+	}
+	for _, tt := range tests {
+		if got := isReadOnlyRequest(tt.method, tt.url); got != tt.want {
+			t.Errorf("isReadOnlyRequest(%q, %q) = %v, want %v", tt.method, tt.url, got, tt.want)
+		}
+	}
+}
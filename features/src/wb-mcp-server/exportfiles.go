@@ -0,0 +1,561 @@
+package main
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/binary"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// exportPollInterval bounds how often export download links are retried while
+// Data Explorer is still writing the files.
+const exportPollInterval = 5 * time.Second
+
+// exportIdleTimeout is how long a download may go without receiving any data
+// before it is abandoned.
+var exportIdleTimeout = 60 * time.Second
+
+// downloadClient fetches export files. Unlike httpClient it has no overall
+// timeout, since large exports can take minutes to stream; the response header
+// timeout only covers the wait for headers, so fetchToFile also aborts a body
+// that stops arriving for exportIdleTimeout.
+var downloadClient = &http.Client{
+	Transport: &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		ResponseHeaderTimeout: 60 * time.Second,
+	},
+}
+
+// exportedFile describes one downloaded export file in the tool result.
+type exportedFile struct {
+	Name   string `json:"name"`
+	Path   string `json:"path,omitempty"`
+	URI    string `json:"uri,omitempty"`
+	Format string `json:"format"`
+	Bytes  int64  `json:"bytes"`
+	Rows   *int64 `json:"rows,omitempty"`
+}
+
+// exportLink is a download link from an export result.
+type exportLink struct {
+	DisplayName string `json:"displayName"`
+	URL         string `json:"url"`
+	Error       string `json:"error"`
+}
+
+type exportResult struct {
+	Status string       `json:"status"`
+	Error  string       `json:"error"`
+	Links  []exportLink `json:"links"`
+}
+
+// parseExportResults accepts either a bare array of export results or an
+// object wrapping one.
+func parseExportResults(respBody []byte) ([]exportResult, error) {
+	var results []exportResult
+	if err := json.Unmarshal(respBody, &results); err == nil {
+		return results, nil
+	}
+	var wrapped map[string]json.RawMessage
+	if err := json.Unmarshal(respBody, &wrapped); err != nil {
+		return nil, fmt.Errorf("failed to parse export response: %w", err)
+	}
+	for _, key := range []string{"exportResults", "results"} {
+		if raw, ok := wrapped[key]; ok {
+			if err := json.Unmarshal(raw, &results); err != nil {
+				return nil, fmt.Errorf("failed to parse export response: %w", err)
+			}
+			return results, nil
+		}
+	}
+	var single exportResult
+	if err := json.Unmarshal(respBody, &single); err != nil || single.Status == "" {
+		return nil, fmt.Errorf("unrecognized export response: %s", truncate(string(respBody), 500))
+	}
+	return []exportResult{single}, nil
+}
+
+// exportFileFormat classifies a link by file extension.
+func exportFileFormat(name string) string {
+	lower := strings.ToLower(name)
+	switch {
+	case strings.HasSuffix(lower, ".parquet"):
+		return "parquet"
+	case strings.HasSuffix(lower, ".csv.gz"), strings.HasSuffix(lower, ".tsv.gz"):
+		return "csv.gz"
+	case strings.HasSuffix(lower, ".csv"), strings.HasSuffix(lower, ".tsv"):
+		return "csv"
+	}
+	return strings.TrimPrefix(path.Ext(lower), ".")
+}
+
+// exportLinkName returns a local file name for a link: the last element of its
+// URL path, falling back to the display name.
+func exportLinkName(link exportLink) string {
+	if u, err := url.Parse(link.URL); err == nil {
+		if base := path.Base(u.Path); base != "/" && base != "." && base != ".." {
+			return base
+		}
+	}
+	name := strings.NewReplacer("/", "_", "\\", "_", " ", "_").Replace(link.DisplayName)
+	if name == "" || name == "." || name == ".." {
+		name = "export"
+	}
+	return name
+}
+
+// downloadExportLink streams a signed export URL to dest, retrying while the
+// file is not there yet (404/403) until deadline.
+func downloadExportLink(ctx context.Context, rawURL, dest string, deadline time.Time) (int64, error) {
+	for {
+		n, status, err := fetchToFile(ctx, rawURL, dest)
+		if err == nil {
+			return n, nil
+		}
+		if (status != http.StatusNotFound && status != http.StatusForbidden) || time.Now().Add(exportPollInterval).After(deadline) {
+			return 0, err
+		}
+		time.Sleep(exportPollInterval)
+	}
+}
+
+func fetchToFile(ctx context.Context, rawURL, dest string) (n int64, status int, err error) {
+	ctx, span := startSpan(ctx, "GET", spanKindClient)
+	span.setAttr("http.request.method", "GET")
+	// Drop the query: signed URLs carry credentials there.
+	span.setAttr("url.full", strings.SplitN(rawURL, "?", 2)[0])
+	defer func() { span.end(err) }()
+
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", rawURL, nil)
+	if err != nil {
+		return 0, 0, err
+	}
+	if span != nil {
+		req.Header.Set("traceparent", span.traceparent())
+	}
+	// Links under Data Explorer itself need the user's token; signed bucket
+	// URLs must not get it.
+	if strings.HasPrefix(rawURL, dataExplorerURL) {
+		token, err := getToken(ctx)
+		if err != nil {
+			return 0, 0, err
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := downloadClient.Do(req)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer resp.Body.Close()
+	span.setAttr("http.response.status_code", resp.StatusCode)
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return 0, resp.StatusCode, fmt.Errorf("download failed (%d): %s", resp.StatusCode, string(body))
+	}
+	f, err := os.OpenFile(dest, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return 0, resp.StatusCode, err
+	}
+	stalled := fmt.Errorf("download stalled: no data received for %s", exportIdleTimeout)
+	idle := time.AfterFunc(exportIdleTimeout, func() { cancel(stalled) })
+	defer idle.Stop()
+	n, err = io.Copy(f, &idleReader{r: resp.Body, timer: idle, timeout: exportIdleTimeout})
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil && context.Cause(ctx) == stalled {
+		err = stalled
+	}
+	return n, resp.StatusCode, err
+}
+
+// idleReader restarts timer whenever a read returns data.
+type idleReader struct {
+	r       io.Reader
+	timer   *time.Timer
+	timeout time.Duration
+}
+
+func (r *idleReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	if n > 0 {
+		r.timer.Reset(r.timeout)
+	}
+	return n, err
+}
+
+// countExportRows returns the number of data rows in a downloaded file, or
+// nil for formats it can't read.
+func countExportRows(file, format string) (*int64, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var r io.Reader = f
+	switch format {
+	case "parquet":
+		n, err := parquetNumRows(f)
+		if err != nil {
+			return nil, err
+		}
+		return &n, nil
+	case "csv.gz":
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return nil, err
+		}
+		defer gz.Close()
+		r = gz
+	case "csv":
+	default:
+		return nil, nil
+	}
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	cr.LazyQuotes = true
+	if strings.HasSuffix(strings.ToLower(file), ".tsv") || strings.HasSuffix(strings.ToLower(file), ".tsv.gz") {
+		cr.Comma = '\t'
+	}
+	var rows int64 = -1 // header
+	for {
+		_, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		rows++
+	}
+	if rows < 0 {
+		rows = 0
+	}
+	return &rows, nil
+}
+
+// parquetNumRows reads num_rows from a Parquet file's footer.
+func parquetNumRows(f *os.File) (int64, error) {
+	info, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+	if info.Size() < 12 {
+		return 0, fmt.Errorf("not a parquet file")
+	}
+	tail := make([]byte, 8)
+	if _, err := f.ReadAt(tail, info.Size()-8); err != nil {
+		return 0, err
+	}
+	if string(tail[4:]) != "PAR1" {
+		return 0, fmt.Errorf("not a parquet file")
+	}
+	footerLen := int64(binary.LittleEndian.Uint32(tail[:4]))
+	if footerLen > info.Size()-12 {
+		return 0, fmt.Errorf("corrupt parquet footer")
+	}
+	footer := make([]byte, footerLen)
+	if _, err := f.ReadAt(footer, info.Size()-8-footerLen); err != nil {
+		return 0, err
+	}
+	return thriftFileMetaDataNumRows(footer)
+}
+
+// thriftFileMetaDataNumRows decodes field 3 (num_rows) of a Thrift
+// compact-encoded FileMetaData, skipping every other field.
+func thriftFileMetaDataNumRows(b []byte) (int64, error) {
+	d := &thriftDecoder{b: b}
+	var lastID int16
+	for {
+		header, err := d.byte()
+		if err != nil {
+			return 0, err
+		}
+		if header == 0 {
+			return 0, fmt.Errorf("parquet footer has no num_rows")
+		}
+		typ := header & 0x0f
+		if delta := int16(header >> 4); delta != 0 {
+			lastID += delta
+		} else {
+			v, err := d.varint()
+			if err != nil {
+				return 0, err
+			}
+			lastID = int16(zigzag(v))
+		}
+		if lastID == 3 && typ == 6 {
+			v, err := d.varint()
+			return zigzag(v), err
+		}
+		if err := d.skip(typ); err != nil {
+			return 0, err
+		}
+	}
+}
+
+type thriftDecoder struct {
+	b   []byte
+	pos int
+}
+
+func (d *thriftDecoder) byte() (byte, error) {
+	if d.pos >= len(d.b) {
+		return 0, io.ErrUnexpectedEOF
+	}
+	d.pos++
+	return d.b[d.pos-1], nil
+}
+
+func (d *thriftDecoder) varint() (uint64, error) {
+	if d.pos >= len(d.b) {
+		return 0, io.ErrUnexpectedEOF
+	}
+	v, n := binary.Uvarint(d.b[d.pos:])
+	if n <= 0 {
+		return 0, io.ErrUnexpectedEOF
+	}
+	d.pos += n
+	return v, nil
+}
+
+func zigzag(v uint64) int64 {
+	return int64(v>>1) ^ -int64(v&1)
+}
+
+// skip advances past one value of the given compact-protocol type.
+func (d *thriftDecoder) skip(typ byte) error {
+	switch typ {
+	case 1, 2: // bool, encoded in the field header
+		return nil
+	case 3:
+		_, err := d.byte()
+		return err
+	case 4, 5, 6:
+		_, err := d.varint()
+		return err
+	case 7:
+		d.pos += 8
+	case 8:
+		n, err := d.varint()
+		if err != nil {
+			return err
+		}
+		if n > uint64(len(d.b)-d.pos) {
+			return io.ErrUnexpectedEOF
+		}
+		d.pos += int(n)
+	case 9, 10:
+		header, err := d.byte()
+		if err != nil {
+			return err
+		}
+		size, elem := uint64(header>>4), header&0x0f
+		if size == 15 {
+			if size, err = d.varint(); err != nil {
+				return err
+			}
+		}
+		// Every element takes at least one byte.
+		if size > uint64(len(d.b)-d.pos) {
+			return io.ErrUnexpectedEOF
+		}
+		for i := uint64(0); i < size; i++ {
+			if elem == 1 || elem == 2 {
+				d.pos++ // bools in collections take a byte each
+			} else if err := d.skip(elem); err != nil {
+				return err
+			}
+		}
+	case 11:
+		size, err := d.varint()
+		if err != nil || size == 0 {
+			return err
+		}
+		kv, err := d.byte()
+		if err != nil {
+			return err
+		}
+		if size > uint64(len(d.b)-d.pos) {
+			return io.ErrUnexpectedEOF
+		}
+		for i := uint64(0); i < size; i++ {
+			if err := d.skip(kv >> 4); err != nil {
+				return err
+			}
+			if err := d.skip(kv & 0x0f); err != nil {
+				return err
+			}
+		}
+	case 12:
+		for {
+			header, err := d.byte()
+			if err != nil {
+				return err
+			}
+			if header == 0 {
+				return nil
+			}
+			if header>>4 == 0 {
+				if _, err := d.varint(); err != nil {
+					return err
+				}
+			}
+			if err := d.skip(header & 0x0f); err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("unknown thrift type %d in parquet footer", typ)
+	}
+	if d.pos > len(d.b) {
+		return io.ErrUnexpectedEOF
+	}
+	return nil
+}
+
+// handleExportCohortToFiles implements export_cohort_to_files: run an export,
+// wait for its files, download them locally and optionally copy them to a GCS
+// or S3 bucket resource.
+func handleExportCohortToFiles(ctx context.Context, sess *session, args map[string]interface{}) (string, error) {
+	vals, err := requireStrings(args, "studyId", "cohortId", "exportModel")
+	if err != nil {
+		return "", err
+	}
+	studyId, cohortId, exportModel := vals[0], vals[1], vals[2]
+	format, _ := args["format"].(string)
+	if format == "" {
+		format = "any"
+	}
+	if format != "any" && format != "csv" && format != "parquet" {
+		return "", fmt.Errorf("format must be csv, parquet or any")
+	}
+	timeout := 10 * time.Minute
+	if t, ok := args["timeoutSeconds"].(float64); ok && t > 0 {
+		timeout = time.Duration(t) * time.Second
+	}
+	resourceName, _ := args["resourceName"].(string)
+	localDir, _ := args["localDir"].(string)
+	switch {
+	case localDir == "" && resourceName != "":
+		tmp, err := os.MkdirTemp("", "wb-export-*")
+		if err != nil {
+			return "", err
+		}
+		defer os.RemoveAll(tmp)
+		localDir = tmp
+	default:
+		// Like the other local file tools, stay under the local root.
+		if localDir, err = localRealPath(firstNonEmpty(localDir, filepath.Join("exports", cohortId))); err != nil {
+			return "", err
+		}
+	}
+	// A dry run records the export request and stops at its placeholder
+	// response, so nothing is downloaded.
+	if dryRunFrom(ctx) == nil {
+		if err := os.MkdirAll(localDir, 0700); err != nil {
+			return "", fmt.Errorf("failed to create %s: %w", localDir, err)
+		}
+	}
+
+	request := map[string]interface{}{
+		"exportModel":        exportModel,
+		"includeAnnotations": false,
+		"compressFiles":      false,
+	}
+	if inputs, ok := args["inputs"].(map[string]interface{}); ok {
+		request["inputs"] = inputs
+	}
+	respBody, err := makeAPIRequest(ctx, "POST", fmt.Sprintf("%s/v2/studies/%s/cohorts/%s/export", dataExplorerURL, studyId, cohortId), map[string]interface{}{
+		"exportRequests": []interface{}{request},
+	})
+	if err != nil {
+		return "", fmt.Errorf("export failed: %w", err)
+	}
+	results, err := parseExportResults(respBody)
+	if err != nil {
+		return "", err
+	}
+
+	deadline := time.Now().Add(timeout)
+	var files []exportedFile
+	var skipped []string
+	for _, result := range results {
+		if result.Status == "FAILED" {
+			return "", fmt.Errorf("export failed: %s", firstNonEmpty(result.Error, "no error message"))
+		}
+		for _, link := range result.Links {
+			if link.URL == "" {
+				if link.Error != "" {
+					skipped = append(skipped, fmt.Sprintf("%s: %s", link.DisplayName, link.Error))
+				}
+				continue
+			}
+			name := exportLinkName(link)
+			fileFormat := exportFileFormat(name)
+			if format != "any" && !strings.HasPrefix(fileFormat, format) {
+				skipped = append(skipped, name+": not "+format)
+				continue
+			}
+			dest := filepath.Join(localDir, name)
+			n, err := downloadExportLink(ctx, link.URL, dest, deadline)
+			if err != nil {
+				return "", fmt.Errorf("failed to download %s: %w", name, err)
+			}
+			file := exportedFile{Name: name, Path: dest, Format: fileFormat, Bytes: n}
+			if rows, err := countExportRows(dest, fileFormat); err != nil {
+				fmt.Fprintf(os.Stderr, "Warning: failed to count rows in %s: %v\n", name, err)
+			} else {
+				file.Rows = rows
+			}
+			files = append(files, file)
+		}
+	}
+	if len(files) == 0 {
+		return "", fmt.Errorf("export produced no downloadable %s files (skipped: %s)", format, strings.Join(skipped, "; "))
+	}
+
+	if resourceName != "" {
+		bucket, cloud, err := bucketURI(ctx, sess, resourceName)
+		if err != nil {
+			return "", err
+		}
+		if prefix, _ := args["path"].(string); prefix != "" {
+			bucket += strings.TrimSuffix(strings.TrimPrefix(prefix, "/"), "/") + "/"
+		}
+		keepLocal := args["localDir"] != nil
+		for i := range files {
+			uri := bucket + files[i].Name
+			if out, err := copyObjects(ctx, sess, cloud, resourceName, files[i].Path, uri, false); err != nil {
+				return "", fmt.Errorf("failed to upload %s: %w\n%s", files[i].Name, err, out)
+			}
+			files[i].URI = uri
+			if !keepLocal {
+				files[i].Path = ""
+			}
+		}
+	}
+
+	result := map[string]interface{}{"files": files}
+	if len(skipped) > 0 {
+		result["skipped"] = skipped
+	}
+	outputBytes, err := json.MarshalIndent(result, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal result: %w", err)
+	}
+	return string(outputBytes), nil
+}
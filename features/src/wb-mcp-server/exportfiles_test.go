@@ -0,0 +1,212 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Thrift compact-protocol helpers for building footers by hand.
+
+func tField(delta int, typ byte) []byte { return []byte{byte(delta<<4) | typ} }
+
+func tVarint(v int64) []byte {
+	return binary.AppendUvarint(nil, uint64((v<<1)^(v>>63)))
+}
+
+func tBinary(s string) []byte {
+	return append(binary.AppendUvarint(nil, uint64(len(s))), s...)
+}
+
+func cat(parts ...[]byte) []byte {
+	var b []byte
+	for _, p := range parts {
+		b = append(b, p...)
+	}
+	return b
+}
+
+func TestThriftFileMetaDataNumRows(t *testing.T) {
+	// A SchemaElement with a name and a required repetition type.
+	schemaElement := cat(tField(4, 8), tBinary("schema"), tField(1, 5), tVarint(0), []byte{0})
+	tests := []struct {
+		name    string
+		footer  []byte
+		want    int64
+		wantErr string
+	}{
+		{
+			name:   "version then num_rows",
+			footer: cat(tField(1, 5), tVarint(1), tField(2, 6), tVarint(1234), []byte{0}),
+			want:   1234,
+		},
+		{
+			name: "schema list before num_rows",
+			footer: cat(
+				tField(1, 5), tVarint(2),
+				tField(1, 9), []byte{2<<4 | 12}, schemaElement, schemaElement,
+				tField(1, 6), tVarint(9000000000),
+				[]byte{0}),
+			want: 9000000000,
+		},
+		{
+			name: "long list header and binary list",
+			footer: cat(
+				tField(2, 9), []byte{15<<4 | 8}, binary.AppendUvarint(nil, 16),
+				cat(tBinary("a"), tBinary("bb"), tBinary("c"), tBinary("d"), tBinary("e"), tBinary("f"), tBinary("g"), tBinary("h"),
+					tBinary("i"), tBinary("j"), tBinary("k"), tBinary("l"), tBinary("m"), tBinary("n"), tBinary("o"), tBinary("p")),
+				tField(1, 6), tVarint(7),
+				[]byte{0}),
+			want: 7,
+		},
+		{
+			name: "bools and doubles are skipped",
+			footer: cat(
+				tField(1, 1),
+				tField(1, 7), make([]byte, 8),
+				tField(1, 6), tVarint(5), // field 3 again after the skips
+				[]byte{0}),
+			want: 5,
+		},
+		{
+			name: "long-form headers, maps and nested structs",
+			footer: cat(
+				// Long-form header: type i32, field id 10.
+				[]byte{5}, tVarint(10), tVarint(1),
+				tField(1, 11), binary.AppendUvarint(nil, 1), []byte{8<<4 | 8}, tBinary("k"), tBinary("v"),
+				tField(1, 12), tField(1, 3), []byte{0x7f}, tField(1, 10), []byte{1<<4 | 1, 1}, []byte{0},
+				// Long-form header for field 3.
+				[]byte{6}, tVarint(3), tVarint(42),
+				[]byte{0}),
+			want: 42,
+		},
+		{
+			name:   "negative num_rows decodes as zigzag",
+			footer: cat(tField(3, 6), tVarint(-1), []byte{0}),
+			want:   -1,
+		},
+		{
+			name:    "no num_rows",
+			footer:  cat(tField(1, 5), tVarint(1), []byte{0}),
+			wantErr: "has no num_rows",
+		},
+		{
+			name:    "num_rows with the wrong type",
+			footer:  cat(tField(3, 5), tVarint(1), []byte{0}),
+			wantErr: "has no num_rows",
+		},
+		{
+			name:    "truncated",
+			footer:  cat(tField(1, 5)),
+			wantErr: "unexpected EOF",
+		},
+		{
+			name:    "binary longer than the footer",
+			footer:  cat(tField(1, 8), binary.AppendUvarint(nil, 1<<63), tField(2, 6), tVarint(1)),
+			wantErr: "unexpected EOF",
+		},
+		{
+			name:    "list longer than the footer",
+			footer:  cat(tField(1, 9), []byte{15<<4 | 1}, binary.AppendUvarint(nil, 1<<40)),
+			wantErr: "unexpected EOF",
+		},
+		{
+			name:    "unknown type",
+			footer:  cat(tField(1, 13), []byte{0}),
+			wantErr: "unknown thrift type 13",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := thriftFileMetaDataNumRows(tt.footer)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got (%d, %v), want error containing %q", got, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("got %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParquetNumRows(t *testing.T) {
+	footer := cat(tField(1, 5), tVarint(1), tField(2, 6), tVarint(77), []byte{0})
+	length := binary.LittleEndian.AppendUint32(nil, uint32(len(footer)))
+	tests := []struct {
+		name    string
+		data    []byte
+		want    int64
+		wantErr string
+	}{
+		{"valid", cat([]byte("PAR1"), []byte("column data"), footer, length, []byte("PAR1")), 77, ""},
+		{"too short", []byte("PAR1PAR1"), 0, "not a parquet file"},
+		{"wrong magic", cat([]byte("PAR1"), footer, length, []byte("PAR2")), 0, "not a parquet file"},
+		{"footer length past the start", cat([]byte("PAR1"), footer, binary.LittleEndian.AppendUint32(nil, 1000), []byte("PAR1")), 0, "corrupt parquet footer"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "x.parquet")
+			if err := os.WriteFile(path, tt.data, 0600); err != nil {
+				t.Fatal(err)
+			}
+			f, err := os.Open(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+			got, err := parquetNumRows(f)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got (%d, %v), want error containing %q", got, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("got %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFetchToFileStalled(t *testing.T) {
+	defer func(d time.Duration, u string) { exportIdleTimeout, dataExplorerURL = d, u }(exportIdleTimeout, dataExplorerURL)
+	exportIdleTimeout = 200 * time.Millisecond
+	dataExplorerURL = "http://data-explorer.invalid"
+
+	release := make(chan struct{})
+	defer close(release)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "first chunk")
+		w.(http.Flusher).Flush()
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer srv.Close()
+
+	start := time.Now()
+	_, _, err := fetchToFile(context.Background(), srv.URL+"/x.csv", filepath.Join(t.TempDir(), "x.csv"))
+	if err == nil || !strings.Contains(err.Error(), "download stalled") {
+		t.Fatalf("got %v, want a stalled download error", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("stall detected after %s", elapsed)
+	}
+}
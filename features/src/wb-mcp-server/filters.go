@@ -0,0 +1,587 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Local validation for Data Explorer filters, so mistakes surface as
+// actionable errors before a request is sent rather than as opaque API errors.
+
+var (
+	literalDataTypes     = []string{"BOOLEAN", "INT64", "STRING", "DATE", "TIMESTAMP", "DOUBLE"}
+	attributeOperators   = []string{"EQUALS", "NOT_EQUALS", "LESS_THAN", "GREATER_THAN", "LESS_THAN_OR_EQUAL", "GREATER_THAN_OR_EQUAL", "IN", "NOT_IN", "BETWEEN", "IS_NULL", "IS_NOT_NULL"}
+	hierarchyOperators   = []string{"CHILD_OF", "DESCENDANT_OF_INCLUSIVE", "IS_ROOT", "IS_MEMBER", "IS_LEAF"}
+	booleanOperators     = []string{"AND", "OR", "NOT"}
+	orderingOperators    = map[string]bool{"LESS_THAN": true, "GREATER_THAN": true, "LESS_THAN_OR_EQUAL": true, "GREATER_THAN_OR_EQUAL": true, "BETWEEN": true}
+	literalValueUnionKey = map[string]string{"BOOLEAN": "boolVal", "INT64": "int64Val", "STRING": "stringVal", "DATE": "dateVal", "TIMESTAMP": "timestampVal", "DOUBLE": "doubleVal"}
+)
+
+// suggestNames returns the candidates within a small edit distance of name,
+// closest first, for "did you mean" hints.
+func suggestNames(name string, candidates []string) []string {
+	type scored struct {
+		name string
+		dist int
+	}
+	lower := strings.ToLower(name)
+	limit := len(name)/3 + 1
+	if limit > 3 {
+		limit = 3
+	}
+	var matches []scored
+	for _, c := range candidates {
+		lc := strings.ToLower(c)
+		d := editDistance(lower, lc)
+		if d <= limit || (len(lower) >= 3 && (strings.Contains(lc, lower) || strings.Contains(lower, lc))) {
+			matches = append(matches, scored{c, d})
+		}
+	}
+	sort.SliceStable(matches, func(i, j int) bool { return matches[i].dist < matches[j].dist })
+	var out []string
+	for i, m := range matches {
+		if i == 3 {
+			break
+		}
+		out = append(out, m.name)
+	}
+	return out
+}
+
+// editDistance is the Levenshtein distance between a and b.
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
+
+// unknownName formats an error for a name not found among candidates, with
+// near-miss suggestions or, failing that, the (possibly truncated) valid names.
+func unknownName(kind, name, scope string, candidates []string) error {
+	msg := fmt.Sprintf("unknown %s %q", kind, name)
+	if scope != "" {
+		msg += " in " + scope
+	}
+	if s := suggestNames(name, candidates); len(s) > 0 {
+		return fmt.Errorf("%s; did you mean %s?", msg, quoteJoin(s, " or "))
+	}
+	shown := candidates
+	if len(shown) > 20 {
+		shown = append(shown[:20:20], fmt.Sprintf("... (%d more)", len(candidates)-20))
+	}
+	return fmt.Errorf("%s (valid: %s)", msg, strings.Join(shown, ", "))
+}
+
+func quoteJoin(names []string, sep string) string {
+	quoted := make([]string, len(names))
+	for i, n := range names {
+		quoted[i] = strconv.Quote(n)
+	}
+	return strings.Join(quoted, sep)
+}
+
+// checkOneOf validates an enumerated argument such as an operator, accepting
+// any case.
+func checkOneOf(kind, value string, allowed []string) (string, error) {
+	for _, a := range allowed {
+		if strings.EqualFold(a, value) {
+			return a, nil
+		}
+	}
+	return "", unknownName(kind, value, "", allowed)
+}
+
+// checkAttributeOperator validates an attribute filter operator against the
+// attribute's type and the number of values supplied.
+func checkAttributeOperator(operator, dataType string, numValues int) error {
+	if orderingOperators[operator] && dataType == "BOOLEAN" {
+		return fmt.Errorf("operator %s does not apply to BOOLEAN values; use EQUALS", operator)
+	}
+	switch operator {
+	case "IS_NULL", "IS_NOT_NULL":
+		if numValues > 0 {
+			return fmt.Errorf("operator %s takes no values", operator)
+		}
+	case "BETWEEN":
+		if numValues != 2 {
+			return fmt.Errorf("operator BETWEEN needs exactly 2 values (low, high), got %d", numValues)
+		}
+	case "IN", "NOT_IN":
+		if numValues == 0 {
+			return fmt.Errorf("operator %s needs at least one value in 'values'", operator)
+		}
+	default:
+		if numValues != 1 {
+			return fmt.Errorf("operator %s needs exactly 1 value, got %d", operator, numValues)
+		}
+	}
+	return nil
+}
+
+// schemaArgs loads the schema named by the optional underlayName argument and
+// resolves the entity argument (default: the primary entity). A nil schema
+// means the caller didn't ask for schema validation.
+func schemaArgs(ctx context.Context, args map[string]interface{}) (*underlaySchema, *underlayEntity, error) {
+	underlayName, _ := args["underlayName"].(string)
+	if underlayName == "" {
+		return nil, nil, nil
+	}
+	schema, err := loadUnderlaySchema(ctx, underlayName)
+	if err != nil {
+		return nil, nil, err
+	}
+	entityName, _ := args["entity"].(string)
+	if entityName == "" {
+		entityName = schema.PrimaryEntity
+	}
+	entity := schema.Entities[entityName]
+	if entity == nil {
+		return nil, nil, unknownName("entity", entityName, "underlay "+underlayName, schema.entityNames())
+	}
+	return schema, entity, nil
+}
+
+// lookupAttribute finds an attribute on entity, with suggestions if missing.
+func lookupAttribute(entity *underlayEntity, name string) (underlayAttribute, error) {
+	if attr, ok := entity.attribute(name); ok {
+		return attr, nil
+	}
+	return underlayAttribute{}, unknownName("attribute", name, "entity "+entity.Name, entity.attributeNames())
+}
+
+// lookupHierarchy checks that entity has the named hierarchy.
+func lookupHierarchy(entity *underlayEntity, name string) error {
+	var names []string
+	for _, h := range entity.Hierarchies {
+		if h.Name == name {
+			return nil
+		}
+		names = append(names, h.Name)
+	}
+	if len(names) == 0 {
+		return fmt.Errorf("entity %s has no hierarchies", entity.Name)
+	}
+	return unknownName("hierarchy", name, "entity "+entity.Name, names)
+}
+
+// validateFilter checks a composed filter (as built by the filter_build_*
+// tools) against entity, descending into boolean and relationship subfilters.
+// Problems are appended to errs, prefixed with their path in the filter.
+func validateFilter(schema *underlaySchema, entity *underlayEntity, filter interface{}, path string, errs *[]string) {
+	fail := func(format string, args ...interface{}) {
+		*errs = append(*errs, path+": "+fmt.Sprintf(format, args...))
+	}
+	f, ok := filter.(map[string]interface{})
+	if !ok {
+		fail("filter must be an object")
+		return
+	}
+	union, _ := f["filterUnion"].(map[string]interface{})
+	filterType, _ := f["filterType"].(string)
+	switch filterType {
+	case "ATTRIBUTE":
+		af, _ := union["attributeFilter"].(map[string]interface{})
+		name, _ := af["attribute"].(string)
+		attr, err := lookupAttribute(entity, name)
+		if err != nil {
+			fail("%v", err)
+			return
+		}
+		// Operators are matched case-insensitively; store the canonical
+		// form, since that's what Data Explorer accepts.
+		operator, _ := af["operator"].(string)
+		operator, err = checkOneOf("operator", operator, attributeOperators)
+		if err != nil {
+			fail("%v", err)
+			return
+		}
+		af["operator"] = operator
+		values, _ := af["values"].([]interface{})
+		if err := checkAttributeOperator(operator, attr.DataType, len(values)); err != nil {
+			fail("%v", err)
+		}
+		for i, v := range values {
+			lit, _ := v.(map[string]interface{})
+			dataType, _ := lit["dataType"].(string)
+			if dataType != attr.DataType {
+				fail("values[%d] has dataType %q but %s.%s is %s", i, dataType, entity.Name, attr.Name, attr.DataType)
+				continue
+			}
+			vu, _ := lit["valueUnion"].(map[string]interface{})
+			if _, ok := vu[literalValueUnionKey[dataType]]; !ok {
+				fail("values[%d] has no %s in valueUnion", i, literalValueUnionKey[dataType])
+			}
+		}
+	case "HIERARCHY":
+		hf, _ := union["hierarchyFilter"].(map[string]interface{})
+		name, _ := hf["hierarchy"].(string)
+		if err := lookupHierarchy(entity, name); err != nil {
+			fail("%v", err)
+		}
+		operator, _ := hf["operator"].(string)
+		if operator, err := checkOneOf("operator", operator, hierarchyOperators); err != nil {
+			fail("%v", err)
+		} else {
+			hf["operator"] = operator
+		}
+	case "RELATIONSHIP":
+		rf, _ := union["relationshipFilter"].(map[string]interface{})
+		name, _ := rf["entity"].(string)
+		related := schema.Entities[name]
+		if related == nil {
+			fail("%v", unknownName("entity", name, "underlay "+schema.Name, schema.entityNames()))
+			return
+		}
+		if sub, ok := rf["subfilter"]; ok && sub != nil {
+			validateFilter(schema, related, sub, path+".subfilter", errs)
+		}
+	case "BOOLEAN_LOGIC":
+		bf, _ := union["booleanLogicFilter"].(map[string]interface{})
+		operator, _ := bf["operator"].(string)
+		if canonical, err := checkOneOf("operator", operator, booleanOperators); err != nil {
+			fail("%v", err)
+		} else {
+			operator, bf["operator"] = canonical, canonical
+		}
+		subfilters, _ := bf["subfilters"].([]interface{})
+		if operator == "NOT" && len(subfilters) != 1 {
+			fail("NOT takes exactly one subfilter, got %d", len(subfilters))
+		} else if len(subfilters) == 0 {
+			fail("%s needs at least one subfilter", operator)
+		}
+		for i, sub := range subfilters {
+			validateFilter(schema, entity, sub, fmt.Sprintf("%s.subfilters[%d]", path, i), errs)
+		}
+	case "TEXT":
+		// Free-text search; nothing schema-specific to check.
+	default:
+		fail("%v", unknownName("filterType", filterType, "", []string{"ATTRIBUTE", "HIERARCHY", "RELATIONSHIP", "BOOLEAN_LOGIC", "TEXT"}))
+	}
+}
+
+// checkFilter validates filter against entityName in underlayName and returns
+// all problems as one error.
+func checkFilter(ctx context.Context, underlayName, entityName string, filter interface{}) error {
+	schema, err := loadUnderlaySchema(ctx, underlayName)
+	if err != nil {
+		return err
+	}
+	entity := schema.Entities[entityName]
+	if entity == nil {
+		return unknownName("entity", entityName, "underlay "+underlayName, schema.entityNames())
+	}
+	var errs []string
+	validateFilter(schema, entity, filter, "filter", &errs)
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid filter for %s in underlay %s:\n- %s", entityName, underlayName, strings.Join(errs, "\n- "))
+	}
+	return nil
+}
+
+// cohortUnderlay returns the underlay a Data Explorer cohort was built on.
+func cohortUnderlay(ctx context.Context, studyId, cohortId string) (string, error) {
+	respBody, err := makeAPIRequest(ctx, "GET", fmt.Sprintf("%s/v2/studies/%s/cohorts/%s", dataExplorerURL, studyId, cohortId), nil)
+	if err != nil {
+		return "", err
+	}
+	var cohort struct {
+		UnderlayName string `json:"underlayName"`
+	}
+	if err := json.Unmarshal(respBody, &cohort); err != nil || cohort.UnderlayName == "" {
+		return "", fmt.Errorf("cohort %s has no underlayName", cohortId)
+	}
+	return cohort.UnderlayName, nil
+}
+
+// handleFilterValidate implements the filter_validate tool.
+func handleFilterValidate(ctx context.Context, args map[string]interface{}) (string, error) {
+	vals, err := requireStrings(args, "underlayName", "entity")
+	if err != nil {
+		return "", err
+	}
+	filter, ok := args["filter"].(map[string]interface{})
+	if !ok {
+		return "", fmt.Errorf("missing required parameter: filter")
+	}
+	if err := checkFilter(ctx, vals[0], vals[1], filter); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("Filter is valid for %s in underlay %s.", vals[1], vals[0]), nil
+}
+
+// buildLiteral wraps a tool argument in a Literal of the given dataType.
+func buildLiteral(dataType string, value interface{}) (map[string]interface{}, error) {
+	v, err := literalValue(dataType, value)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{
+		"dataType":   dataType,
+		"valueUnion": map[string]interface{}{literalValueUnionKey[dataType]: v},
+	}, nil
+}
+
+// literalValue converts a tool argument to the valueUnion field for dataType,
+// rejecting values that don't fit the type.
+func literalValue(dataType string, value interface{}) (interface{}, error) {
+	switch dataType {
+	case "BOOLEAN":
+		switch v := value.(type) {
+		case bool:
+			return v, nil
+		case string:
+			if b, err := strconv.ParseBool(v); err == nil {
+				return b, nil
+			}
+		}
+		return nil, fmt.Errorf("value %v is not a BOOLEAN (use true or false)", value)
+	case "INT64":
+		switch v := value.(type) {
+		case float64:
+			if v == math.Trunc(v) {
+				return strconv.FormatInt(int64(v), 10), nil
+			}
+		case string:
+			if _, err := strconv.ParseInt(v, 10, 64); err == nil {
+				return v, nil
+			}
+		}
+		return nil, fmt.Errorf("value %v is not an INT64", value)
+	case "DOUBLE":
+		switch v := value.(type) {
+		case float64:
+			return v, nil
+		case string:
+			if f, err := strconv.ParseFloat(v, 64); err == nil {
+				return f, nil
+			}
+		}
+		return nil, fmt.Errorf("value %v is not a DOUBLE", value)
+	case "STRING":
+		switch value.(type) {
+		case string, float64, bool:
+			return fmt.Sprintf("%v", value), nil
+		}
+		return nil, fmt.Errorf("value %v is not a STRING", value)
+	case "DATE":
+		if s, ok := value.(string); ok {
+			if _, err := time.Parse("2006-01-02", s); err == nil {
+				return s, nil
+			}
+		}
+		return nil, fmt.Errorf("value %v is not a DATE (use YYYY-MM-DD)", value)
+	case "TIMESTAMP":
+		if s, ok := value.(string); ok {
+			for _, layout := range []string{time.RFC3339, "2006-01-02 15:04:05", "2006-01-02T15:04:05"} {
+				if _, err := time.Parse(layout, s); err == nil {
+					return s, nil
+				}
+			}
+		}
+		return nil, fmt.Errorf("value %v is not a TIMESTAMP (use RFC 3339, e.g. 2024-01-31T12:00:00Z)", value)
+	}
+	_, err := checkOneOf("dataType", dataType, literalDataTypes)
+	return nil, err
+}
+
+// marshalFilter formats a built filter as the tool result.
+func marshalFilter(filter map[string]interface{}) (string, error) {
+	outputBytes, err := json.MarshalIndent(filter, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal result: %w", err)
+	}
+	return string(outputBytes), nil
+}
+
+// buildAttributeFilter implements filter_build_attribute. With underlayName it
+// checks the attribute against the entity and takes dataType from the schema.
+func buildAttributeFilter(ctx context.Context, args map[string]interface{}) (string, error) {
+	vals, err := requireStrings(args, "attribute", "operator")
+	if err != nil {
+		return "", err
+	}
+	attribute := vals[0]
+	operator, err := checkOneOf("operator", vals[1], attributeOperators)
+	if err != nil {
+		return "", err
+	}
+	dataType, _ := args["dataType"].(string)
+	_, entity, err := schemaArgs(ctx, args)
+	if err != nil {
+		return "", err
+	}
+	if entity != nil {
+		attr, err := lookupAttribute(entity, attribute)
+		if err != nil {
+			return "", err
+		}
+		if dataType == "" {
+			dataType = attr.DataType
+		} else if !strings.EqualFold(dataType, attr.DataType) {
+			return "", fmt.Errorf("%s.%s is %s, not %s; omit dataType to use the schema's type", entity.Name, attr.Name, attr.DataType, dataType)
+		}
+	}
+	if dataType == "" {
+		return "", fmt.Errorf("missing required parameter: dataType (or pass underlayName to take it from the schema)")
+	}
+	if dataType, err = checkOneOf("dataType", dataType, literalDataTypes); err != nil {
+		return "", err
+	}
+
+	var raw []interface{}
+	if val, ok := args["value"]; ok && val != nil {
+		raw = append(raw, val)
+	}
+	if vs, ok := args["values"].([]interface{}); ok {
+		raw = append(raw, vs...)
+	}
+	if err := checkAttributeOperator(operator, dataType, len(raw)); err != nil {
+		return "", err
+	}
+	attributeFilter := map[string]interface{}{
+		"attribute": attribute,
+		"operator":  operator,
+	}
+	if operator != "IS_NULL" && operator != "IS_NOT_NULL" {
+		values := []interface{}{}
+		for _, v := range raw {
+			literal, err := buildLiteral(dataType, v)
+			if err != nil {
+				return "", fmt.Errorf("%s: %w", attribute, err)
+			}
+			values = append(values, literal)
+		}
+		attributeFilter["values"] = values
+	}
+	return marshalFilter(map[string]interface{}{
+		"filterType":  "ATTRIBUTE",
+		"filterUnion": map[string]interface{}{"attributeFilter": attributeFilter},
+	})
+}
+
+// buildRelationshipFilter implements filter_build_relationship. With
+// underlayName it checks the related entity and validates the subfilter
+// against it.
+func buildRelationshipFilter(ctx context.Context, args map[string]interface{}) (string, error) {
+	relatedEntity, err := requireString(args, "relatedEntity")
+	if err != nil {
+		return "", err
+	}
+	relationshipFilter := map[string]interface{}{"entity": relatedEntity}
+	filter := map[string]interface{}{
+		"filterType":  "RELATIONSHIP",
+		"filterUnion": map[string]interface{}{"relationshipFilter": relationshipFilter},
+	}
+	if subfilter, ok := args["subfilter"].(map[string]interface{}); ok {
+		relationshipFilter["subfilter"] = subfilter
+	}
+	if err := validateBuiltFilter(ctx, args, filter); err != nil {
+		return "", err
+	}
+	return marshalFilter(filter)
+}
+
+// buildBooleanLogicFilter implements filter_build_boolean_logic.
+func buildBooleanLogicFilter(args map[string]interface{}) (string, error) {
+	operator, err := requireString(args, "operator")
+	if err != nil {
+		return "", err
+	}
+	if operator, err = checkOneOf("operator", operator, booleanOperators); err != nil {
+		return "", err
+	}
+	subfilters, ok := args["subfilters"].([]interface{})
+	if !ok || len(subfilters) == 0 {
+		return "", fmt.Errorf("missing required parameter: subfilters")
+	}
+	if operator == "NOT" && len(subfilters) != 1 {
+		return "", fmt.Errorf("NOT takes exactly one subfilter, got %d; wrap several in AND/OR first", len(subfilters))
+	}
+	return marshalFilter(map[string]interface{}{
+		"filterType": "BOOLEAN_LOGIC",
+		"filterUnion": map[string]interface{}{
+			"booleanLogicFilter": map[string]interface{}{
+				"operator":   operator,
+				"subfilters": subfilters,
+			},
+		},
+	})
+}
+
+// buildHierarchyFilter implements filter_build_hierarchy. With underlayName it
+// checks that the entity has the hierarchy.
+func buildHierarchyFilter(ctx context.Context, args map[string]interface{}) (string, error) {
+	vals, err := requireStrings(args, "hierarchy", "operator")
+	if err != nil {
+		return "", err
+	}
+	hierarchy := vals[0]
+	operator, err := checkOneOf("operator", vals[1], hierarchyOperators)
+	if err != nil {
+		return "", err
+	}
+	values, _ := args["values"].([]interface{})
+	switch operator {
+	case "CHILD_OF", "DESCENDANT_OF_INCLUSIVE":
+		if len(values) == 0 {
+			return "", fmt.Errorf("operator %s needs the parent concept ID in 'values'", operator)
+		}
+	default:
+		if len(values) > 0 {
+			return "", fmt.Errorf("operator %s takes no values", operator)
+		}
+	}
+	filter := hierarchyFilter(hierarchy, operator, values)
+	if err := validateBuiltFilter(ctx, args, filter); err != nil {
+		return "", err
+	}
+	return marshalFilter(filter)
+}
+
+func hierarchyFilter(hierarchy, operator string, values []interface{}) map[string]interface{} {
+	hf := map[string]interface{}{
+		"hierarchy": hierarchy,
+		"operator":  operator,
+	}
+	if values != nil {
+		hf["values"] = values
+	}
+	return map[string]interface{}{
+		"filterType":  "HIERARCHY",
+		"filterUnion": map[string]interface{}{"hierarchyFilter": hf},
+	}
+}
+
+// validateBuiltFilter runs validateFilter when the caller passed underlayName.
+func validateBuiltFilter(ctx context.Context, args map[string]interface{}, filter map[string]interface{}) error {
+	schema, entity, err := schemaArgs(ctx, args)
+	if err != nil || schema == nil {
+		return err
+	}
+	var errs []string
+	validateFilter(schema, entity, filter, "filter", &errs)
+	if len(errs) > 0 {
+		return fmt.Errorf("%s", strings.Join(errs, "\n"))
+	}
+	return nil
+}
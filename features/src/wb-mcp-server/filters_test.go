@@ -0,0 +1,53 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateFilterOperatorCase(t *testing.T) {
+	schema := testUnderlay()
+	person := schema.Entities["person"]
+	age := func(operator string, n int) map[string]interface{} {
+		var values []interface{}
+		for i := 0; i < n; i++ {
+			values = append(values, map[string]interface{}{"dataType": "INT64", "valueUnion": map[string]interface{}{"int64Val": "18"}})
+		}
+		return map[string]interface{}{
+			"filterType":  "ATTRIBUTE",
+			"filterUnion": map[string]interface{}{"attributeFilter": map[string]interface{}{"attribute": "age", "operator": operator, "values": values}},
+		}
+	}
+	operatorOf := func(f map[string]interface{}, key string) interface{} {
+		return f["filterUnion"].(map[string]interface{})[key].(map[string]interface{})["operator"]
+	}
+
+	var errs []string
+	validateFilter(schema, person, age("between", 1), "filter", &errs)
+	if len(errs) != 1 || !strings.Contains(errs[0], "BETWEEN needs exactly 2 values") {
+		t.Errorf("lowercase between with one value: errors %q", errs)
+	}
+
+	errs = nil
+	f := age("greater_than_or_equal", 1)
+	validateFilter(schema, person, f, "filter", &errs)
+	if len(errs) != 0 || operatorOf(f, "attributeFilter") != "GREATER_THAN_OR_EQUAL" {
+		t.Errorf("got errors %q and operator %v, want GREATER_THAN_OR_EQUAL", errs, operatorOf(f, "attributeFilter"))
+	}
+
+	errs = nil
+	b := map[string]interface{}{
+		"filterType":  "BOOLEAN_LOGIC",
+		"filterUnion": map[string]interface{}{"booleanLogicFilter": map[string]interface{}{"operator": "not", "subfilters": []interface{}{f, age("in", 1)}}},
+	}
+	validateFilter(schema, person, b, "filter", &errs)
+	if len(errs) != 1 || !strings.Contains(errs[0], "NOT takes exactly one subfilter") || operatorOf(b, "booleanLogicFilter") != "NOT" {
+		t.Errorf("lowercase not with two subfilters: errors %q, operator %v", errs, operatorOf(b, "booleanLogicFilter"))
+	}
+
+	errs = nil
+	validateFilter(schema, person, age("bigger", 1), "filter", &errs)
+	if len(errs) != 1 || !strings.Contains(errs[0], `unknown operator "bigger"`) {
+		t.Errorf("unknown operator: errors %q", errs)
+	}
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// defaultTokenFile is where install.sh writes the per-install bearer token.
+const defaultTokenFile = "/opt/wb-mcp-server/auth-token"
+
+// httpAuthOptions carries the command-line flags for securing HTTP mode.
+type httpAuthOptions struct {
+	TokenFile      string
+	Socket         string
+	AllowedOrigins string // comma-separated, in addition to loopback origins
+	NoAuth         bool   // serve TCP without a token; must be asked for
+}
+
+var (
+	// httpAuthToken is the bearer token required on MCP requests; empty
+	// disables token auth. It is optional with -socket, where file
+	// permissions already restrict who can connect.
+	httpAuthToken string
+	// allowedOrigins are the browser origins allowed to call the server.
+	allowedOrigins = map[string]bool{}
+)
+
+// loadHTTPAuth reads the bearer token and builds the Origin allow-list.
+//
+// The token comes from -token-file, $WB_MCP_TOKEN_FILE or defaultTokenFile,
+// and must exist. Without one the server only starts on a -socket (whose
+// file permissions restrict who can connect) or with -no-auth.
+func loadHTTPAuth(opts httpAuthOptions, port string) error {
+	for _, host := range []string{"127.0.0.1", "localhost", "[::1]"} {
+		allowedOrigins["http://"+host] = true
+		allowedOrigins["http://"+host+":"+port] = true
+	}
+	for _, o := range strings.Split(firstNonEmpty(opts.AllowedOrigins, os.Getenv("WB_MCP_ALLOWED_ORIGINS")), ",") {
+		if o = strings.TrimRight(strings.TrimSpace(o), "/"); o != "" {
+			allowedOrigins[o] = true
+		}
+	}
+
+	explicit := firstNonEmpty(opts.TokenFile, os.Getenv("WB_MCP_TOKEN_FILE"))
+	path := firstNonEmpty(explicit, defaultTokenFile)
+	info, err := os.Stat(path)
+	if err != nil {
+		if os.IsNotExist(err) && explicit == "" {
+			switch {
+			case opts.Socket != "":
+				return nil
+			case opts.NoAuth:
+				log.Printf("Warning: -no-auth given - HTTP requests are NOT authenticated")
+				return nil
+			}
+			return fmt.Errorf("token file %s not found; run install.sh to create it, pass -token-file, or pass -no-auth to serve without authentication", path)
+		}
+		return fmt.Errorf("failed to read token file: %w", err)
+	}
+	if info.Mode().Perm()&0o077 != 0 {
+		log.Printf("Warning: token file %s is accessible by other users (mode %v); run chmod 600", path, info.Mode().Perm())
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read token file: %w", err)
+	}
+	httpAuthToken = strings.TrimSpace(string(data))
+	if httpAuthToken == "" {
+		return fmt.Errorf("token file %s is empty", path)
+	}
+	log.Printf("HTTP bearer-token auth enabled (token file: %s)", path)
+	return nil
+}
+
+// withHTTPAuth guards an MCP handler. It rejects browser requests from
+// origins not on the allow-list (DNS-rebinding protection required by the MCP
+// spec) and, when a token is configured, requests without the bearer token.
+func withHTTPAuth(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if origin := r.Header.Get("Origin"); origin != "" {
+			if !isAllowedOrigin(origin) {
+				http.Error(w, "Origin not allowed", http.StatusForbidden)
+				return
+			}
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+			w.Header().Set("Vary", "Origin")
+		}
+
+		// Browsers send preflights without credentials.
+		if r.Method != http.MethodOptions && httpAuthToken != "" {
+			scheme, got, _ := strings.Cut(r.Header.Get("Authorization"), " ")
+			if !strings.EqualFold(scheme, "Bearer") || subtle.ConstantTimeCompare([]byte(got), []byte(httpAuthToken)) != 1 {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="wb-mcp-server"`)
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+		}
+		next(w, r)
+	}
+}
+
+func isAllowedOrigin(origin string) bool {
+	if allowedOrigins[strings.TrimRight(origin, "/")] {
+		return true
+	}
+	// "null" and other opaque origins never match.
+	u, err := url.Parse(origin)
+	return err == nil && allowedOrigins[u.Scheme+"://"+u.Host]
+}
+
+// listenUnixSocket listens on a Unix domain socket readable only by the
+// current user, replacing any stale socket left by a previous run.
+func listenUnixSocket(path string) (net.Listener, error) {
+	if info, err := os.Lstat(path); err == nil {
+		if info.Mode()&os.ModeSocket == 0 {
+			return nil, fmt.Errorf("%s exists and is not a socket", path)
+		}
+		os.Remove(path)
+	}
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, 0o600); err != nil {
+		listener.Close()
+		return nil, err
+	}
+	return listener, nil
+}
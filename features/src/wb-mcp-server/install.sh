@@ -87,12 +87,12 @@ mkdir -p "${WB_MCP_DIR}"
 # Copy source files to temporary build directory
 BUILD_DIR="${WORKDIR}/wb-mcp-server"
 mkdir -p "${BUILD_DIR}"
-cp "${FEATURE_DIR}/main.go" "${BUILD_DIR}/"
+cp "${FEATURE_DIR}"/*.go "${BUILD_DIR}/"
 cp "${FEATURE_DIR}/go.mod" "${BUILD_DIR}/"
 
 # Build the Go binary
 cd "${BUILD_DIR}"
-go build -o "${WB_MCP_BIN}" main.go
+go build -o "${WB_MCP_BIN}" .
 
 # Make it executable
 chmod +x "${WB_MCP_BIN}"
@@ -161,16 +161,32 @@ EOF
 
 chmod +x "${WB_MCP_DIR}/stop-server.sh"
 
+# Generate a per-install bearer token for the HTTP transport. The server reads
+# it from ${WB_MCP_TOKEN_FILE}; the client configs below send it. Keep an existing
+# token so rebuilding the feature doesn't invalidate already-configured clients.
+# Tracing is paused so the token doesn't end up in build logs.
+readonly WB_MCP_TOKEN_FILE="${WB_MCP_DIR}/auth-token"
+set +o xtrace
+if [[ ! -s "${WB_MCP_TOKEN_FILE}" ]]; then
+    (umask 077 && head -c 32 /dev/urandom | od -An -tx1 | tr -d ' \n' > "${WB_MCP_TOKEN_FILE}")
+fi
+chmod 600 "${WB_MCP_TOKEN_FILE}"
+WB_MCP_AUTH_HEADER="Bearer $(cat "${WB_MCP_TOKEN_FILE}")"
+
 # Create MCP configuration file for easy client setup (HTTP mode)
 cat > "${WB_MCP_DIR}/mcp-config.json" <<EOF
 {
   "mcpServers": {
     "wb": {
-      "url": "http://127.0.0.1:${WB_MCP_PORT}"
+      "url": "http://127.0.0.1:${WB_MCP_PORT}",
+      "headers": {
+        "Authorization": "${WB_MCP_AUTH_HEADER}"
+      }
     }
   }
 }
 EOF
+chmod 600 "${WB_MCP_DIR}/mcp-config.json"
 
 # Make the directory and files accessible to the user
 chown -R "${USERNAME}:" "${WB_MCP_DIR}"
@@ -179,8 +195,8 @@ chown -R "${USERNAME}:" "${WB_MCP_DIR}"
 CLAUDE_SETTINGS="${USER_HOME_DIR}/.claude.json"
 if [[ -f "${CLAUDE_SETTINGS}" ]]; then
     # Merge into existing settings
-    jq --arg url "http://127.0.0.1:${WB_MCP_PORT}" \
-        '.mcpServers.wb = {"type": "http", "url": $url}' \
+    jq --arg url "http://127.0.0.1:${WB_MCP_PORT}" --arg auth "${WB_MCP_AUTH_HEADER}" \
+        '.mcpServers.wb = {"type": "http", "url": $url, "headers": {"Authorization": $auth}}' \
         "${CLAUDE_SETTINGS}" > "${CLAUDE_SETTINGS}.tmp" \
         && mv "${CLAUDE_SETTINGS}.tmp" "${CLAUDE_SETTINGS}"
 else
@@ -189,12 +205,16 @@ else
   "mcpServers": {
     "wb": {
       "type": "http",
-      "url": "http://127.0.0.1:${WB_MCP_PORT}"
+      "url": "http://127.0.0.1:${WB_MCP_PORT}",
+      "headers": {
+        "Authorization": "${WB_MCP_AUTH_HEADER}"
+      }
     }
   }
 }
 CLAUDE_EOF
 fi
+chmod 600 "${CLAUDE_SETTINGS}"
 chown "${USERNAME}:" "${CLAUDE_SETTINGS}"
 echo "Configured Claude Code MCP server in ${CLAUDE_SETTINGS}"
 
@@ -211,6 +231,7 @@ cat > "${CLAUDE_LOCAL_SETTINGS}" <<'PERMS_EOF'
       "mcp__wb__aurora_describe_table",
       "mcp__wb__aurora_resolve_connection",
       "mcp__wb__wb_status",
+      "mcp__wb__workspace_use",
       "mcp__wb__workspace_get",
       "mcp__wb__workspace_list_resources",
       "mcp__wb__workspace_list_data_collections",
@@ -221,18 +242,29 @@ cat > "${CLAUDE_LOCAL_SETTINGS}" <<'PERMS_EOF'
       "mcp__wb__resource_open_console",
       "mcp__wb__s3_list_objects",
       "mcp__wb__s3_read_file",
+      "mcp__wb__local_list",
       "mcp__wb__app_list",
+      "mcp__wb__workspace_cost_summary",
       "mcp__wb__app_get_url",
       "mcp__wb__underlay_list",
       "mcp__wb__underlay_get_schema",
       "mcp__wb__underlay_list_entities",
       "mcp__wb__underlay_get_entity",
       "mcp__wb__underlay_list_criteria_selectors",
+      "mcp__wb__cohort_compile_criteria",
+      "mcp__wb__cohort_history",
+      "mcp__wb__cohort_diff",
+      "mcp__wb__job_status",
+      "mcp__wb__job_list",
+      "mcp__wb__job_wait",
+      "mcp__wb__filter_validate",
+      "mcp__wb__concept_search",
       "mcp__wb__folder_list_tree",
       "mcp__wb__auth_status",
       "mcp__wb__resolve",
       "mcp__wb__server_status",
       "mcp__wb__server_list",
+      "mcp__wb__server_config",
       "mcp__wb__study_list",
       "mcp__wb__study_list_cohorts",
       "mcp__wb__data_query_hints",
@@ -243,16 +275,21 @@ cat > "${CLAUDE_LOCAL_SETTINGS}" <<'PERMS_EOF'
       "mcp__wb__export_preview",
       "mcp__wb__workflow_list",
       "mcp__wb__workflow_describe",
+      "mcp__wb__workflow_validate_inputs",
       "mcp__wb__workflow_job_list",
       "mcp__wb__workflow_job_describe",
+      "mcp__wb__workflow_job_outputs",
+      "mcp__wb__workflow_job_logs",
       "mcp__wb__group_list",
       "mcp__wb__group_describe",
+      "mcp__wb__access_review",
       "mcp__wb__pod_list",
       "mcp__wb__pod_describe",
       "mcp__wb__organization_list",
       "mcp__wb__version",
       "mcp__wb__wb_workspace_list",
-      "mcp__wb__platform_list_data_collections"
+      "mcp__wb__platform_list_data_collections",
+      "mcp__wb__catalog_search"
     ]
   }
 }
@@ -264,8 +301,8 @@ echo "Configured Claude Code read-only MCP permissions in ${CLAUDE_LOCAL_SETTING
 GEMINI_SETTINGS="${USER_HOME_DIR}/.gemini/settings.json"
 mkdir -p "${USER_HOME_DIR}/.gemini"
 if [[ -f "${GEMINI_SETTINGS}" ]]; then
-    jq --arg url "http://127.0.0.1:${WB_MCP_PORT}" \
-        '.mcpServers.wb = {"type": "http", "url": $url}' \
+    jq --arg url "http://127.0.0.1:${WB_MCP_PORT}" --arg auth "${WB_MCP_AUTH_HEADER}" \
+        '.mcpServers.wb = {"type": "http", "url": $url, "headers": {"Authorization": $auth}}' \
         "${GEMINI_SETTINGS}" > "${GEMINI_SETTINGS}.tmp" \
         && mv "${GEMINI_SETTINGS}.tmp" "${GEMINI_SETTINGS}"
 else
@@ -274,12 +311,18 @@ else
   "mcpServers": {
     "wb": {
       "type": "http",
-      "url": "http://127.0.0.1:${WB_MCP_PORT}"
+      "url": "http://127.0.0.1:${WB_MCP_PORT}",
+      "headers": {
+        "Authorization": "${WB_MCP_AUTH_HEADER}"
+      }
     }
   }
 }
 GEMINI_EOF
 fi
+chmod 600 "${GEMINI_SETTINGS}"
+unset WB_MCP_AUTH_HEADER
+set -o xtrace
 chown -R "${USERNAME}:" "${USER_HOME_DIR}/.gemini"
 echo "Configured Gemini CLI MCP server in ${GEMINI_SETTINGS}"
 
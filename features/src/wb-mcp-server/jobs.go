@@ -0,0 +1,467 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Long-running tools can be called with async=true: the call returns a job ID
+// at once, the tool runs in the background, and for operations that finish
+// outside the server (workflow jobs, app and cluster startup) a poller follows
+// their wb status until they settle. The job table is persisted in the state
+// directory, so jobs survive restarts and pollers resume.
+
+const (
+	jobPollInterval = 15 * time.Second
+	maxJobs         = 200 // finished jobs beyond this are pruned, oldest first
+)
+
+const (
+	jobRunning   = "running"
+	jobSucceeded = "succeeded"
+	jobFailed    = "failed"
+)
+
+// jobPoll describes how to follow an operation after its tool returns.
+type jobPoll struct {
+	Kind     string    `json:"kind"` // "workflow" or "resource"
+	Target   string    `json:"target"`
+	Timeout  string    `json:"timeout"`
+	Deadline time.Time `json:"deadline,omitempty"`
+}
+
+type job struct {
+	ID            string                 `json:"id"`
+	Tool          string                 `json:"tool"`
+	Arguments     map[string]interface{} `json:"arguments"`
+	WorkspaceID   string                 `json:"workspaceId,omitempty"`
+	WorkspaceUUID string                 `json:"workspaceUuid,omitempty"`
+	Status        string                 `json:"status"`
+	Progress      string                 `json:"progress,omitempty"`
+	Result        string                 `json:"result,omitempty"`
+	Error         string                 `json:"error,omitempty"`
+	ErrorDetails  *toolError             `json:"errorDetails,omitempty"`
+	Submitted     bool                   `json:"submitted"` // the tool itself has returned
+	Poll          *jobPoll               `json:"poll,omitempty"`
+	Created       time.Time              `json:"created"`
+	Updated       time.Time              `json:"updated"`
+
+	done chan struct{}
+}
+
+// asyncTools lists the tools that accept async=true and how each is followed
+// once the tool call returns. A nil poll means the tool call is the whole job.
+var asyncTools = map[string]func(args map[string]interface{}) *jobPoll{
+	"export_cohort_to_files": func(map[string]interface{}) *jobPoll { return nil },
+	"workflow_job_run": func(args map[string]interface{}) *jobPoll {
+		workspaceId, _ := args["workspaceId"].(string)
+		jobId, _ := args["jobId"].(string)
+		return &jobPoll{Kind: "workflow", Target: workspaceId + "/" + jobId, Timeout: "24h"}
+	},
+	"app_create":    resourcePoll("appId"),
+	"app_start":     resourcePoll("appId"),
+	"cluster_start": resourcePoll("clusterId"),
+}
+
+func resourcePoll(idArg string) func(map[string]interface{}) *jobPoll {
+	return func(args map[string]interface{}) *jobPoll {
+		id, _ := args[idArg].(string)
+		return &jobPoll{Kind: "resource", Target: id, Timeout: "30m"}
+	}
+}
+
+var (
+	jobsMu sync.Mutex
+	jobs   = map[string]*job{}
+)
+
+func jobsPath() (string, error) {
+	return statePath("jobs.json")
+}
+
+// saveJobsLocked writes the job table. Callers hold jobsMu.
+func saveJobsLocked() {
+	path, err := jobsPath()
+	if err == nil {
+		var list []*job
+		for _, j := range jobs {
+			list = append(list, j)
+		}
+		sort.Slice(list, func(a, b int) bool { return list[a].Created.Before(list[b].Created) })
+		err = writeStateFile(path, list)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: failed to save job table: %v\n", err)
+	}
+}
+
+// initJobs loads the persisted job table and resumes polling for jobs that
+// were still running. Jobs whose tool call was interrupted can't be resumed
+// and are marked failed.
+func initJobs() {
+	path, err := jobsPath()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: job table unavailable: %v\n", err)
+		return
+	}
+	var list []*job
+	if err := readStateFile(path, &list); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: failed to load job table: %v\n", err)
+		return
+	}
+	jobsMu.Lock()
+	defer jobsMu.Unlock()
+	for _, j := range list {
+		j.done = make(chan struct{})
+		jobs[j.ID] = j
+		if j.Status != jobRunning {
+			close(j.done)
+			continue
+		}
+		if !j.Submitted || j.Poll == nil {
+			j.finishLocked(jobFailed, "", "interrupted by a server restart before the tool finished; check the operation and re-run it if needed")
+			continue
+		}
+		go j.poll()
+	}
+	saveJobsLocked()
+}
+
+// startJob registers a job for an async tool call and runs it in the
+// background, returning the job ID.
+func startJob(sess *session, params CallToolParams) (string, error) {
+	pollFor, ok := asyncTools[params.Name]
+	if !ok {
+		return "", fmt.Errorf("%s does not support async", params.Name)
+	}
+	args := map[string]interface{}{}
+	for k, v := range params.Arguments {
+		if k != "async" {
+			args[k] = v
+		}
+	}
+	// The poller needs the workflow job ID, so pick one if the caller didn't.
+	if params.Name == "workflow_job_run" {
+		if id, _ := args["jobId"].(string); id == "" {
+			args["jobId"] = "wb-mcp-" + randomHex(6)
+		}
+	}
+	workspaceID, workspaceUUID := sess.currentWorkspace()
+	now := time.Now().UTC()
+	j := &job{
+		ID:            "job-" + randomHex(6),
+		Tool:          params.Name,
+		Arguments:     args,
+		WorkspaceID:   workspaceID,
+		WorkspaceUUID: workspaceUUID,
+		Status:        jobRunning,
+		Progress:      "starting " + params.Name,
+		Poll:          pollFor(args),
+		Created:       now,
+		Updated:       now,
+		done:          make(chan struct{}),
+	}
+	jobsMu.Lock()
+	jobs[j.ID] = j
+	pruneJobsLocked()
+	saveJobsLocked()
+	jobsMu.Unlock()
+
+	go j.run()
+	return j.ID, nil
+}
+
+func randomHex(n int) string {
+	buf := make([]byte, n)
+	if _, err := rand.Read(buf); err != nil {
+		return fmt.Sprintf("%x", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(buf)
+}
+
+// session returns a session pinned to the workspace the job was started in,
+// so a later workspace_use in the caller's session doesn't redirect it.
+func (j *job) session() *session {
+	return &session{id: j.ID, workspaceID: j.WorkspaceID, workspaceUUID: j.WorkspaceUUID}
+}
+
+// run executes the tool, then hands over to the poller if the operation
+// continues outside the server.
+func (j *job) run() {
+	sess := j.session()
+	ctx, span := startSpan(context.Background(), "job "+j.Tool, spanKindInternal)
+	span.setAttr("wb.job.id", j.ID)
+	result := withErrorDetails(handleCallTool(ctx, sess, CallToolParams{Name: j.Tool, Arguments: j.Arguments}), toolError{})
+	text := ""
+	if len(result.Content) > 0 {
+		text = result.Content[0].Text
+	}
+	if result.IsError {
+		span.setError(truncate(text, 200))
+	}
+	span.end(nil)
+
+	jobsMu.Lock()
+	defer jobsMu.Unlock()
+	if result.IsError {
+		if details, ok := result.StructuredContent.(map[string]interface{}); ok {
+			if te, ok := details["error"].(toolError); ok {
+				j.ErrorDetails = &te
+			}
+		}
+		j.finishLocked(jobFailed, "", strings.TrimSpace(strings.TrimPrefix(text, "Error: ")))
+		return
+	}
+	j.Submitted = true
+	j.Result = text
+	if j.Poll == nil {
+		j.finishLocked(jobSucceeded, text, "")
+		return
+	}
+	timeout, _ := time.ParseDuration(j.Poll.Timeout)
+	j.Poll.Deadline = time.Now().Add(timeout).UTC()
+	j.Progress = "submitted; waiting for " + j.Poll.Target
+	j.Updated = time.Now().UTC()
+	saveJobsLocked()
+	go j.poll()
+}
+
+// poll follows the job's wb status until it succeeds, fails or times out.
+func (j *job) poll() {
+	jobsMu.Lock()
+	p := *j.Poll
+	sess := j.session()
+	jobsMu.Unlock()
+
+	for {
+		state, detail, err := pollStatus(context.Background(), sess, p)
+		jobsMu.Lock()
+		j.Updated = time.Now().UTC()
+		switch {
+		case err != nil:
+			j.Progress = "status check failed: " + err.Error()
+		case state == jobRunning:
+			j.Progress = detail
+		default:
+			j.Progress = detail
+			j.finishLocked(state, firstNonEmpty(j.Result, detail), failureDetail(state, detail))
+			jobsMu.Unlock()
+			return
+		}
+		if !p.Deadline.IsZero() && time.Now().After(p.Deadline) {
+			j.finishLocked(jobFailed, "", fmt.Sprintf("timed out after %s waiting for %s (last status: %s)", p.Timeout, p.Target, j.Progress))
+			jobsMu.Unlock()
+			return
+		}
+		saveJobsLocked()
+		jobsMu.Unlock()
+		time.Sleep(jobPollInterval)
+	}
+}
+
+func failureDetail(state, detail string) string {
+	if state == jobFailed {
+		return detail
+	}
+	return ""
+}
+
+// pollStatus runs the wb describe command for a polled job and classifies the
+// status it reports.
+func pollStatus(ctx context.Context, sess *session, p jobPoll) (state, detail string, err error) {
+	var out string
+	var done, failed []string
+	switch p.Kind {
+	case "workflow":
+		parts := strings.SplitN(p.Target, "/", 2)
+		if len(parts) != 2 {
+			return "", "", fmt.Errorf("bad workflow target %q", p.Target)
+		}
+		out, err = executeWbCommand(ctx, []string{"workflow", "job", "describe", "--workspace=" + parts[0], "--job-id=" + parts[1], "--format=json"})
+		done = []string{"SUCCEEDED", "SUCCESS", "COMPLETED", "COMPLETE", "DONE"}
+		failed = []string{"FAILED", "FAILURE", "ERROR", "SYSTEM_ERROR", "EXECUTOR_ERROR", "ABORTED", "CANCELED", "CANCELLED"}
+	case "resource":
+		out, err = executeWbCommand(ctx, sess.wbArgs("resource", "describe", "--id="+p.Target, "--format=json"))
+		done = []string{"RUNNING", "ACTIVE", "READY"}
+		failed = []string{"ERROR", "FAILED", "DELETED", "DELETING"}
+	default:
+		return "", "", fmt.Errorf("unknown poll kind %q", p.Kind)
+	}
+	if err != nil {
+		return "", "", fmt.Errorf("%v: %s", err, truncate(strings.TrimSpace(out), 200))
+	}
+	var doc interface{}
+	if err := json.Unmarshal([]byte(out), &doc); err != nil {
+		return "", "", fmt.Errorf("unparseable status: %s", truncate(out, 200))
+	}
+	status := findStatus(doc)
+	if status == "" {
+		return jobRunning, "status not reported yet", nil
+	}
+	upper := strings.ToUpper(status)
+	for _, s := range done {
+		if upper == s {
+			return jobSucceeded, p.Target + " is " + status, nil
+		}
+	}
+	for _, s := range failed {
+		if upper == s {
+			return jobFailed, p.Target + " is " + status, nil
+		}
+	}
+	return jobRunning, p.Target + " is " + status, nil
+}
+
+// findStatus returns the first status-like string field in a describe
+// document.
+func findStatus(doc interface{}) string {
+	return findField(doc, "status", "state", "jobstatus", "instancestatus", "clusterstatus")
+}
+
+// findField returns the first non-empty string field named one of keys
+// (lowercase), searching breadth-first so top-level fields win.
+func findField(doc interface{}, keys ...string) string {
+	queue := []interface{}{doc}
+	for len(queue) > 0 {
+		m, ok := queue[0].(map[string]interface{})
+		queue = queue[1:]
+		if !ok {
+			continue
+		}
+		for _, key := range keys {
+			for k, v := range m {
+				if s, ok := v.(string); ok && strings.ToLower(k) == key && s != "" {
+					return s
+				}
+			}
+		}
+		for _, v := range m {
+			queue = append(queue, v)
+		}
+	}
+	return ""
+}
+
+// finishLocked records a terminal state. Callers hold jobsMu.
+func (j *job) finishLocked(status, result, errMsg string) {
+	if j.Status != jobRunning {
+		return
+	}
+	j.Status = status
+	if result != "" {
+		j.Result = result
+	}
+	j.Error = errMsg
+	j.Updated = time.Now().UTC()
+	close(j.done)
+	saveJobsLocked()
+}
+
+// pruneJobsLocked drops the oldest finished jobs beyond maxJobs.
+func pruneJobsLocked() {
+	if len(jobs) <= maxJobs {
+		return
+	}
+	var finished []*job
+	for _, j := range jobs {
+		if j.Status != jobRunning {
+			finished = append(finished, j)
+		}
+	}
+	sort.Slice(finished, func(a, b int) bool { return finished[a].Updated.Before(finished[b].Updated) })
+	for i := 0; i < len(jobs)-maxJobs && i < len(finished); i++ {
+		delete(jobs, finished[i].ID)
+	}
+}
+
+func lookupJob(args map[string]interface{}) (*job, error) {
+	id, err := requireString(args, "jobId")
+	if err != nil {
+		return nil, err
+	}
+	jobsMu.Lock()
+	j, ok := jobs[id]
+	jobsMu.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown job %q; use job_list to see jobs", id)
+	}
+	return j, nil
+}
+
+// jobJSON renders a job for a tool result.
+func jobJSON(j *job) (string, error) {
+	jobsMu.Lock()
+	outputBytes, err := json.MarshalIndent(j, "", "  ")
+	jobsMu.Unlock()
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal result: %w", err)
+	}
+	return string(outputBytes), nil
+}
+
+// handleJobStatus implements job_status.
+func handleJobStatus(args map[string]interface{}) (string, error) {
+	j, err := lookupJob(args)
+	if err != nil {
+		return "", err
+	}
+	return jobJSON(j)
+}
+
+// handleJobWait implements job_wait: block until the job finishes or the
+// timeout passes, then report it either way.
+func handleJobWait(args map[string]interface{}) (string, error) {
+	j, err := lookupJob(args)
+	if err != nil {
+		return "", err
+	}
+	timeout := 60 * time.Second
+	if t, ok := args["timeoutSeconds"].(float64); ok && t > 0 {
+		timeout = time.Duration(t) * time.Second
+	}
+	if timeout > 10*time.Minute {
+		timeout = 10 * time.Minute
+	}
+	select {
+	case <-j.done:
+	case <-time.After(timeout):
+	}
+	return jobJSON(j)
+}
+
+// handleJobList implements job_list: newest first, optionally filtered by
+// status.
+func handleJobList(args map[string]interface{}) (string, error) {
+	status, _ := args["status"].(string)
+	type summary struct {
+		ID       string    `json:"id"`
+		Tool     string    `json:"tool"`
+		Status   string    `json:"status"`
+		Progress string    `json:"progress,omitempty"`
+		Error    string    `json:"error,omitempty"`
+		Created  time.Time `json:"created"`
+		Updated  time.Time `json:"updated"`
+	}
+	var list []summary
+	jobsMu.Lock()
+	for _, j := range jobs {
+		if status == "" || j.Status == status {
+			list = append(list, summary{j.ID, j.Tool, j.Status, j.Progress, truncate(j.Error, 200), j.Created, j.Updated})
+		}
+	}
+	jobsMu.Unlock()
+	sort.Slice(list, func(a, b int) bool { return list[a].Created.After(list[b].Created) })
+	outputBytes, err := json.MarshalIndent(map[string]interface{}{"jobs": list}, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal result: %w", err)
+	}
+	return string(outputBytes), nil
+}
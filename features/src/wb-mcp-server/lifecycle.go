@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// notebook_start, cluster_start and app_start can wait until the instance is
+// usable: its status is RUNNING (as classified by the job poller) and its
+// proxy URL answers. The URL counts as answering once the proxy stops
+// returning 5xx; a login redirect or 401/403 still means it's up.
+
+const defaultStartTimeout = 15 * time.Minute
+
+var (
+	readinessPollInterval = 10 * time.Second
+	launchURLPattern      = regexp.MustCompile(`https?://[^\s"'<>]+`)
+	probeClient           = &http.Client{
+		Timeout: 10 * time.Second,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+)
+
+// startStatusHint tells the caller how to follow up on a start that didn't
+// become ready in time, per kind.
+var startStatusHint = map[string]string{
+	"app":      "check its status with app_list, and get its URL with app_get_url",
+	"notebook": "check its status with workspace_list_resources, and get its URL with notebook_launch",
+	"cluster":  "check its status with workspace_list_resources, and get its URL with cluster_launch",
+}
+
+// probeURL returns the status the URL answers with, or an error if it
+// doesn't answer.
+func probeURL(ctx context.Context, url string) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return 0, err
+	}
+	resp, err := probeClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	return resp.StatusCode, nil
+}
+
+// handleStart starts a notebook, cluster or app (kind is the wb command
+// group) and, with wait=true, waits for it to be ready and returns its URL.
+func handleStart(ctx context.Context, sess *session, kind, idArg string, args map[string]interface{}) (string, error) {
+	id, err := requireString(args, idArg)
+	if err != nil {
+		return "", err
+	}
+	out, err := executeWbCommand(ctx, sess.wbArgs(kind, "start", "--id="+id))
+	if err != nil {
+		return out, err
+	}
+	if wait, _ := args["wait"].(bool); !wait || dryRunFrom(ctx) != nil {
+		return out, nil
+	}
+	timeout := defaultStartTimeout
+	if t, ok := args["timeoutSeconds"].(float64); ok && t > 0 {
+		timeout = time.Duration(t) * time.Second
+	}
+
+	ctx, span := startSpan(ctx, "wait for "+kind, spanKindInternal)
+	span.setAttr("wb.resource.id", id)
+	start := time.Now()
+	deadline := start.Add(timeout)
+	progress := func(msg string) {
+		reportProgress(ctx, time.Since(start).Seconds(), timeout.Seconds(), msg)
+	}
+	sleep := func() error {
+		if time.Now().Add(readinessPollInterval).After(deadline) {
+			return fmt.Errorf("timed out after %s", timeout)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(readinessPollInterval):
+			return nil
+		}
+	}
+	fail := func(stage string, err error) (string, error) {
+		span.end(err)
+		return "", fmt.Errorf("%s %s was started but is not ready: %s: %w\nIt may still come up; %s", kind, id, stage, err, startStatusHint[kind])
+	}
+
+	// Wait for RUNNING.
+	for {
+		state, detail, err := pollStatus(ctx, sess, jobPoll{Kind: "resource", Target: id})
+		if err != nil {
+			detail = "status check failed: " + err.Error()
+		}
+		progress(detail)
+		if state == jobSucceeded {
+			break
+		}
+		if state == jobFailed {
+			return fail("status", fmt.Errorf("%s", detail))
+		}
+		if err := sleep(); err != nil {
+			return fail("waiting for RUNNING ("+detail+")", err)
+		}
+	}
+
+	// Find the URL, then wait for the proxy to answer.
+	var url string
+	for {
+		launch, err := executeWbCommand(ctx, sess.wbArgs(kind, "launch", "--id="+id))
+		if err == nil {
+			url = launchURLPattern.FindString(launch)
+		}
+		if url != "" {
+			break
+		}
+		progress("waiting for a launch URL")
+		if err := sleep(); err != nil {
+			return fail("no launch URL reported", err)
+		}
+	}
+	for {
+		status, err := probeURL(ctx, url)
+		if err == nil && status < 500 {
+			elapsed := time.Since(start).Round(time.Second)
+			progress(fmt.Sprintf("%s answers with HTTP %d", url, status))
+			span.setAttr("wb.wait.seconds", elapsed.Seconds())
+			span.end(nil)
+			return fmt.Sprintf("%s\n%s %s is RUNNING and its URL answers (HTTP %d) after %s.\nURL: %s", strings.TrimSpace(out), kind, id, status, elapsed, url), nil
+		}
+		detail := fmt.Sprintf("proxy returned HTTP %d", status)
+		if err != nil {
+			detail = "proxy not reachable: " + err.Error()
+		}
+		progress(detail)
+		if err := sleep(); err != nil {
+			return fail("waiting for "+url+" ("+detail+")", err)
+		}
+	}
+}
@@ -0,0 +1,420 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// The local_* tools and the bucket transfer tools only touch files under the
+// local root: $WB_MCP_LOCAL_ROOT, else the home directory. Paths are relative
+// to it (absolute paths must point inside it). local_* go through os.Root, so
+// a symlink can't lead them out; transfers hand a path to gsutil or aws, so
+// for those the path is resolved and checked before the command runs, and so
+// is every symlink in a directory copied recursively, since both follow them.
+
+// localRoot returns the real path of the local root.
+func localRoot() (string, error) {
+	dir := os.Getenv("WB_MCP_LOCAL_ROOT")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", fmt.Errorf("failed to locate home directory: %w", err)
+		}
+		dir = home
+	}
+	return filepath.EvalSymlinks(dir)
+}
+
+// localRelPath turns p into a clean path relative to root, rejecting paths
+// that leave it.
+func localRelPath(root, p string) (string, error) {
+	if p == "~" || strings.HasPrefix(p, "~/") {
+		p = "." + p[1:]
+	}
+	full := p
+	if !filepath.IsAbs(p) {
+		full = filepath.Join(root, p)
+	}
+	rel, err := filepath.Rel(root, filepath.Clean(full))
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("path %q is outside %s; local file tools are not allowed to leave it", p, root)
+	}
+	return rel, nil
+}
+
+// localPathError rewords os.Root's error for a symlink that leads out of the
+// root.
+func localPathError(root *os.Root, p string, err error) error {
+	if err != nil && strings.Contains(err.Error(), "path escapes from parent") {
+		return fmt.Errorf("path %q leads outside %s through a symlink; local file tools are not allowed to leave it", p, root.Name())
+	}
+	return err
+}
+
+// openLocalRoot opens the local root for the local_* tools.
+func openLocalRoot(p string) (*os.Root, string, error) {
+	dir, err := localRoot()
+	if err != nil {
+		return nil, "", err
+	}
+	rel, err := localRelPath(dir, p)
+	if err != nil {
+		return nil, "", err
+	}
+	root, err := os.OpenRoot(dir)
+	if err != nil {
+		return nil, "", err
+	}
+	return root, rel, nil
+}
+
+// localRealPath resolves p, following symlinks in its existing part, and
+// checks the result is still under the local root. p need not exist yet.
+func localRealPath(p string) (string, error) {
+	dir, err := localRoot()
+	if err != nil {
+		return "", err
+	}
+	rel, err := localRelPath(dir, p)
+	if err != nil {
+		return "", err
+	}
+	full := filepath.Join(dir, rel)
+	existing, rest := full, ""
+	for {
+		real, err := filepath.EvalSymlinks(existing)
+		if err == nil {
+			full = filepath.Join(real, rest)
+			break
+		}
+		if !errors.Is(err, fs.ErrNotExist) || existing == dir {
+			return "", err
+		}
+		rest = filepath.Join(filepath.Base(existing), rest)
+		existing = filepath.Dir(existing)
+	}
+	if _, err := localRelPath(dir, full); err != nil {
+		return "", fmt.Errorf("path %q resolves to %s, outside %s; local file tools are not allowed to leave it", p, full, dir)
+	}
+	return full, nil
+}
+
+// checkLocalTree rejects symlinks under dir, a real path under the local
+// root, that resolve outside the root. Dangling symlinks are rejected too,
+// since what they point to could appear before the copy runs.
+func checkLocalTree(dir string) error {
+	root, err := localRoot()
+	if err != nil {
+		return err
+	}
+	return filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.Type()&fs.ModeSymlink == 0 {
+			return err
+		}
+		real, err := filepath.EvalSymlinks(p)
+		if err != nil {
+			target, _ := os.Readlink(p)
+			return fmt.Errorf("symlink %s points to %s, which can't be resolved; local file tools are not allowed to follow it", p, target)
+		}
+		if _, err := localRelPath(root, real); err != nil {
+			return fmt.Errorf("symlink %s resolves to %s, outside %s; local file tools are not allowed to leave it", p, real, root)
+		}
+		return nil
+	})
+}
+
+// localEntry is one file in local_list output.
+type localEntry struct {
+	Path     string `json:"path"`
+	Type     string `json:"type"` // file, dir or symlink
+	Size     int64  `json:"size,omitempty"`
+	Modified string `json:"modified"`
+}
+
+// handleLocalList implements local_list.
+func handleLocalList(ctx context.Context, args map[string]interface{}) (string, error) {
+	root, rel, err := openLocalRoot(firstNonEmpty(str(args["path"]), "."))
+	if err != nil {
+		return "", err
+	}
+	defer root.Close()
+	recursive, _ := args["recursive"].(bool)
+	maxEntries := 1000
+	if v, ok := args["maxEntries"].(float64); ok && v > 0 {
+		maxEntries = int(v)
+	}
+
+	var entries []localEntry
+	truncated := false
+	add := func(p string, d fs.DirEntry) error {
+		if len(entries) >= maxEntries {
+			truncated = true
+			return fs.SkipAll
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil // removed while listing
+		}
+		e := localEntry{Path: filepath.ToSlash(p), Type: "file", Size: info.Size(), Modified: info.ModTime().UTC().Format(time.RFC3339)}
+		switch {
+		case d.Type()&fs.ModeSymlink != 0:
+			e.Type, e.Size = "symlink", 0
+		case d.IsDir():
+			e.Type, e.Size = "dir", 0
+		}
+		entries = append(entries, e)
+		return nil
+	}
+	fsys := root.FS()
+	if recursive {
+		err = fs.WalkDir(fsys, filepath.ToSlash(rel), func(p string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if p == filepath.ToSlash(rel) {
+				return nil
+			}
+			return add(p, d)
+		})
+	} else {
+		var list []fs.DirEntry
+		list, err = fs.ReadDir(fsys, filepath.ToSlash(rel))
+		for _, d := range list {
+			if add(path.Join(filepath.ToSlash(rel), d.Name()), d) != nil {
+				break
+			}
+		}
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to list %s: %w", rel, localPathError(root, rel, err))
+	}
+
+	result := map[string]interface{}{"root": root.Name(), "path": rel, "entries": entries}
+	if truncated {
+		result["truncated"] = fmt.Sprintf("stopped at maxEntries=%d", maxEntries)
+	}
+	out, err := json.MarshalIndent(result, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	return string(out), nil
+}
+
+// handleLocalRead implements local_read. Binary files are refused; move them
+// with upload_to_resource instead.
+func handleLocalRead(ctx context.Context, args map[string]interface{}) (string, error) {
+	p, err := requireString(args, "path")
+	if err != nil {
+		return "", err
+	}
+	root, rel, err := openLocalRoot(p)
+	if err != nil {
+		return "", err
+	}
+	defer root.Close()
+	maxBytes := 1048576 // 1MB default
+	if mb, ok := args["maxBytes"].(float64); ok && mb > 0 {
+		maxBytes = int(mb)
+	}
+
+	f, err := root.Open(rel)
+	if err != nil {
+		return "", localPathError(root, rel, err)
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return "", err
+	}
+	if info.IsDir() {
+		return "", fmt.Errorf("%s is a directory; use local_list", rel)
+	}
+	data, err := io.ReadAll(io.LimitReader(f, int64(maxBytes)))
+	if err != nil {
+		return "", err
+	}
+	if bytes.IndexByte(data[:min(len(data), 8192)], 0) >= 0 {
+		return "", fmt.Errorf("%s is a binary file (%d bytes); use upload_to_resource to move it", rel, info.Size())
+	}
+	if info.Size() > int64(len(data)) {
+		return string(data) + fmt.Sprintf("\n\n--- TRUNCATED (showing %d of %d bytes) ---", len(data), info.Size()), nil
+	}
+	return string(data), nil
+}
+
+// handleLocalWrite implements local_write, creating parent directories as
+// needed.
+func handleLocalWrite(ctx context.Context, args map[string]interface{}) (string, error) {
+	vals, err := requireStrings(args, "path", "content")
+	if err != nil {
+		return "", err
+	}
+	content := vals[1]
+	root, rel, err := openLocalRoot(vals[0])
+	if err != nil {
+		return "", err
+	}
+	defer root.Close()
+	appendMode, _ := args["append"].(bool)
+	target := filepath.Join(root.Name(), rel)
+
+	if r := dryRunFrom(ctx); r != nil {
+		op := "WRITE"
+		if appendMode {
+			op = "APPEND"
+		}
+		r.record(dryRunAction{Method: op, URL: fmt.Sprintf("%s (%d bytes)", target, len(content))})
+		return "", nil
+	}
+	if dir := filepath.Dir(rel); dir != "." {
+		if err := root.MkdirAll(dir, 0755); err != nil {
+			return "", localPathError(root, rel, err)
+		}
+	}
+	flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	if appendMode {
+		flags = os.O_WRONLY | os.O_CREATE | os.O_APPEND
+	}
+	f, err := root.OpenFile(rel, flags, 0644)
+	if err != nil {
+		return "", localPathError(root, rel, err)
+	}
+	if _, err := f.WriteString(content); err != nil {
+		f.Close()
+		return "", err
+	}
+	if err := f.Close(); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("Wrote %d bytes to %s", len(content), target), nil
+}
+
+// bucketURI resolves a bucket resource to a gs:// or s3:// URI ending in "/",
+// and reports which it is.
+func bucketURI(ctx context.Context, sess *session, resourceName string) (string, string, error) {
+	var res map[string]interface{}
+	if err := wbJSON(ctx, sess.wbArgs("resource", "describe", "--id="+resourceName, "--format=json"), &res); err != nil {
+		return "", "", fmt.Errorf("failed to describe resource: %w", err)
+	}
+	resourceType := strings.ToUpper(findField(res, "resourcetype", "type"))
+	switch {
+	case strings.Contains(resourceType, "GCS"):
+		bucket := findField(res, "bucketname")
+		if bucket == "" {
+			return "", "", fmt.Errorf("resource %s has no bucketName", resourceName)
+		}
+		return "gs://" + bucket + "/", "gcs", nil
+	case strings.Contains(resourceType, "S3"), strings.Contains(resourceType, "AWS"):
+		uri, err := getS3ResourcePath(ctx, sess, resourceName)
+		return uri, "s3", err
+	}
+	return "", "", fmt.Errorf("resource %s is a %s, which is unsupported; use a GCS bucket or S3 folder resource", resourceName, firstNonEmpty(resourceType, "resource of unknown type"))
+}
+
+// copyObjects runs gsutil (through wb) or aws s3 cp.
+func copyObjects(ctx context.Context, sess *session, cloud, resourceName, src, dst string, recursive bool) (string, error) {
+	if cloud == "gcs" {
+		args := append(sess.wbArgs("gsutil"), "cp")
+		if recursive {
+			args = append(args, "-r")
+		}
+		return executeWbCommand(ctx, append(args, src, dst))
+	}
+	args := []string{"s3", "cp", src, dst}
+	if recursive {
+		args = append(args, "--recursive")
+	}
+	return executeAWSCommand(ctx, sess, resourceName, args...)
+}
+
+// handleUploadToResource implements upload_to_resource.
+func handleUploadToResource(ctx context.Context, sess *session, args map[string]interface{}) (string, error) {
+	vals, err := requireStrings(args, "resourceName", "localPath")
+	if err != nil {
+		return "", err
+	}
+	resourceName := vals[0]
+	src, err := localRealPath(vals[1])
+	if err != nil {
+		return "", err
+	}
+	info, err := os.Stat(src)
+	if err != nil {
+		return "", err
+	}
+	recursive, _ := args["recursive"].(bool)
+	if info.IsDir() && !recursive {
+		return "", fmt.Errorf("%s is a directory; pass recursive=true to upload it", vals[1])
+	}
+	if info.IsDir() {
+		if err := checkLocalTree(src); err != nil {
+			return "", err
+		}
+	}
+	uri, cloud, err := bucketURI(ctx, sess, resourceName)
+	if err != nil {
+		return "", err
+	}
+	dst := uri + strings.TrimPrefix(str(args["path"]), "/")
+	if strings.HasSuffix(dst, "/") {
+		dst += filepath.Base(src)
+	}
+	out, err := copyObjects(ctx, sess, cloud, resourceName, src, dst, recursive)
+	if err != nil {
+		return out, err
+	}
+	return strings.TrimSpace(out + fmt.Sprintf("\nUploaded %s to %s", src, dst)), nil
+}
+
+// handleDownloadFromResource implements download_from_resource.
+func handleDownloadFromResource(ctx context.Context, sess *session, args map[string]interface{}) (string, error) {
+	vals, err := requireStrings(args, "resourceName", "path")
+	if err != nil {
+		return "", err
+	}
+	resourceName, objectPath := vals[0], strings.TrimPrefix(vals[1], "/")
+	recursive, _ := args["recursive"].(bool)
+	localPath := str(args["localPath"])
+	if localPath == "" {
+		localPath = path.Base(strings.TrimSuffix(objectPath, "/"))
+	}
+	dst, err := localRealPath(localPath)
+	if err != nil {
+		return "", err
+	}
+	if info, err := os.Stat(dst); err == nil && info.IsDir() && recursive {
+		// The copy writes through any symlinks already in the directory.
+		if err := checkLocalTree(dst); err != nil {
+			return "", err
+		}
+	}
+	uri, cloud, err := bucketURI(ctx, sess, resourceName)
+	if err != nil {
+		return "", err
+	}
+	if dryRunFrom(ctx) == nil {
+		parent := dst
+		if !recursive {
+			parent = filepath.Dir(dst)
+		}
+		if err := os.MkdirAll(parent, 0755); err != nil {
+			return "", fmt.Errorf("failed to create %s: %w", parent, err)
+		}
+	}
+	src := uri + objectPath
+	out, err := copyObjects(ctx, sess, cloud, resourceName, src, dst, recursive)
+	if err != nil {
+		return out, err
+	}
+	return strings.TrimSpace(out + fmt.Sprintf("\nDownloaded %s to %s", src, dst)), nil
+}
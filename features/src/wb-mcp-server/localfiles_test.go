@@ -0,0 +1,54 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCheckLocalTree(t *testing.T) {
+	root, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	outside := t.TempDir()
+	t.Setenv("WB_MCP_LOCAL_ROOT", root)
+	for _, dir := range []string{"data", "up/sub"} {
+		if err := os.MkdirAll(filepath.Join(root, dir), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tests := []struct {
+		name    string
+		link    string
+		wantErr string
+	}{
+		{"no symlinks", "", ""},
+		{"symlink inside the root", filepath.Join("..", "..", "data"), ""},
+		{"symlink outside the root", outside, "outside " + root},
+		{"relative symlink outside the root", filepath.Join("..", "..", ".."), "outside " + root},
+		{"dangling symlink", filepath.Join(root, "missing"), "can't be resolved"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			link := filepath.Join(root, "up", "sub", "link")
+			os.Remove(link)
+			if tt.link != "" {
+				if err := os.Symlink(tt.link, link); err != nil {
+					t.Fatal(err)
+				}
+			}
+			err := checkLocalTree(filepath.Join(root, "up"))
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("got %v, want error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -3,7 +3,9 @@ package main
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
@@ -11,6 +13,7 @@ import (
 	"net/http"
 	"os"
 	"os/exec"
+	"sort"
 	"strings"
 	"time"
 )
@@ -76,11 +79,13 @@ type InputSchema struct {
 type CallToolParams struct {
 	Name      string                 `json:"name"`
 	Arguments map[string]interface{} `json:"arguments,omitempty"`
+	Meta      map[string]interface{} `json:"_meta,omitempty"`
 }
 
 type CallToolResult struct {
-	Content []ContentItem `json:"content"`
-	IsError bool          `json:"isError,omitempty"`
+	Content           []ContentItem `json:"content"`
+	StructuredContent interface{}   `json:"structuredContent,omitempty"`
+	IsError           bool          `json:"isError,omitempty"`
 }
 
 type ContentItem struct {
@@ -90,10 +95,10 @@ type ContentItem struct {
 
 // Global variables
 var (
-	workspaceBaseURL     string
-	dataExplorerURL      string
-	cachedWorkspaceUUID  string // populated once at startup from wb status
-	httpClient           = &http.Client{Timeout: 60 * time.Second}
+	workspaceBaseURL    string
+	dataExplorerURL     string
+	cachedWorkspaceUUID string // wb CLI's active workspace, populated once at startup; sessions may override via workspace_use
+	httpClient          = &http.Client{Timeout: 60 * time.Second}
 )
 
 // Tool definitions
@@ -127,6 +132,22 @@ var wbTools = []Tool{
 			Required: []string{"command"},
 		},
 	},
+	{
+		Name: "workspace_use",
+		Description: `Select the workspace that workspace-scoped tools target for the rest of this session.
+
+Use this when working across more than one workspace. It does NOT run 'wb workspace set', so the user's terminal and other sessions keep their active workspace.
+
+After this call, resource_*, folder_*, app_*, notebook_*, cluster_*, resolve, aurora_*, s3_* and workspace_list_data_collections act on the selected workspace. Tools that take an explicit workspaceId are unaffected.
+
+Call with an empty workspaceId to clear the selection and follow the wb CLI's active workspace again.`,
+		InputSchema: InputSchema{
+			Type: "object",
+			Properties: map[string]interface{}{
+				"workspaceId": map[string]interface{}{"type": "string", "description": "User-facing workspace ID or UUID (empty to clear)"},
+			},
+		},
+	},
 
 	{
 		Name:        "workspace_create",
@@ -167,6 +188,30 @@ var wbTools = []Tool{
 			Required: []string{"workspaceId"},
 		},
 	},
+	{
+		Name:        "workspace_apply",
+		Description: "Bring a workspace in line with a declarative spec (YAML or JSON): workspace name/description, properties, users and roles, folders, and resources (gcs-bucket, bq-dataset, s3-folder). Creates the workspace if it doesn't exist (spec needs podId). Always shows the plan as a diff against the current state (+ add, ~ change, = unchanged, ! conflict, ? in workspace but not in spec); changes are only made with apply=true. Only adds and updates - nothing is removed - so re-applying the same spec is a no-op. Use this for reproducible workspace setup or templates.",
+		InputSchema: InputSchema{
+			Type: "object",
+			Properties: map[string]interface{}{
+				"spec":     map[string]interface{}{"description": "Workspace spec as an object, or YAML/JSON text. Fields: id (required), name, description, podId, organizationId, properties {key: value}, users [{email, role}], folders [{id, displayName, description, parentId}], resources [{type: gcs-bucket|bq-dataset|s3-folder, id, bucketName|datasetId|folderName, description}]"},
+				"specPath": map[string]interface{}{"type": "string", "description": "Path to a YAML/JSON spec file instead of spec, under the home directory or $WB_MCP_LOCAL_ROOT"},
+				"apply":    map[string]interface{}{"type": "boolean", "description": "Make the planned changes (default: false, plan only)"},
+			},
+		},
+	},
+	{
+		Name:        "workspace_export_manifest",
+		Description: "Export a versioned JSON manifest of a workspace: metadata and properties, users and roles, folder tree, resources (with lineage, stewardship and cloud attributes), apps and workflows. Lists are sorted so two manifests can be diffed. Also includes a 'spec' section that workspace_apply can use to recreate the structure elsewhere. Use this for audits, comparing workspaces, or templating.",
+		InputSchema: InputSchema{
+			Type: "object",
+			Properties: map[string]interface{}{
+				"workspaceId": map[string]interface{}{"type": "string", "description": "User-facing workspace ID (default: the session's workspace)"},
+				"outputPath":  map[string]interface{}{"type": "string", "description": "Write the manifest to this file instead of returning it: relative to the home directory (or WB_MCP_LOCAL_ROOT), and must stay inside it"},
+				"overwrite":   map[string]interface{}{"type": "boolean", "description": "Replace outputPath if it already exists (default false)"},
+			},
+		},
+	},
 	{
 		Name:        "workspace_duplicate",
 		Description: "Duplicate an existing workspace. Use this when user wants to copy a workspace structure (including resources and folder organization) to a new workspace. Useful for creating similar workspaces or templates.",
@@ -431,7 +476,9 @@ Each result includes rich metadata sourced directly from the data collection:
 
 Present results in a human-readable summary grouped by relevance. For each matching collection,
 highlight the most relevant fields for the user's query (e.g. patient count and modality for
-clinical searches, underlay name for schema exploration).`,
+clinical searches, underlay name for schema exploration).
+
+To find collections by the content of their schemas (entities, attributes), use catalog_search.`,
 		InputSchema: InputSchema{
 			Type: "object",
 			Properties: map[string]interface{}{
@@ -447,6 +494,43 @@ clinical searches, underlay name for schema exploration).`,
 		},
 	},
 
+	{
+		Name: "catalog_search",
+		Description: `Ranked full-text search over every data collection the user can read, including the entities, attributes and criteria selectors of each collection's underlay.
+
+Use this when a user asks:
+- "Which collection has lab measurements for HbA1c?"
+- "Where can I find ECG waveforms / medication exposures / survey answers?"
+- "Which datasets have a date of death attribute?"
+
+Unlike platform_list_data_collections (substring match on metadata), this searches a local index
+that also covers underlay schemas, so agents don't need to call underlay_get_schema on each
+collection. Matches in names and tags rank above matches in descriptions, entities and attributes.
+
+The index is stored in the server's state directory and rebuilt once a day; pass refresh=true to
+rebuild it now (e.g. after gaining access to a new collection). Each result lists the matched
+terms and fields, and the matching entity/attribute/selector names. Follow up with
+underlay_get_schema or data_sample_instances on the result's underlayName to confirm the data.`,
+		InputSchema: InputSchema{
+			Type: "object",
+			Properties: map[string]interface{}{
+				"query": map[string]interface{}{
+					"type":        "string",
+					"description": "Free-text query, e.g. 'lab measurements hba1c'",
+				},
+				"limit": map[string]interface{}{
+					"type":        "number",
+					"description": "Maximum number of results to return (default: 10)",
+				},
+				"refresh": map[string]interface{}{
+					"type":        "boolean",
+					"description": "Rebuild the index before searching (default: false)",
+				},
+			},
+			Required: []string{"query"},
+		},
+	},
+
 	{
 		Name:        "group_create",
 		Description: "Create a user group. Use this when managing multiple users with same access needs. Groups simplify permission management - grant access to group instead of individual users.",
@@ -515,6 +599,26 @@ clinical searches, underlay name for schema exploration).`,
 			Required: []string{"groupId", "email"},
 		},
 	},
+	{
+		Name:        "access_review",
+		Description: "Access review report: every workspace role (direct and through group membership), group membership and, optionally, pod role - for one user or across workspaces - as JSON or CSV. Flags grants worth revisiting: stale (workspace unchanged for staleDays), redundant (already implied by another grant), many-owners, and external (domain not in allowedDomains). Use this for periodic access reviews instead of calling workspace_list_users, group_describe and pod_role_list one at a time. Only workspaces the caller owns can be reviewed; others are listed in warnings.",
+		InputSchema: InputSchema{
+			Type: "object",
+			Properties: map[string]interface{}{
+				"email":          map[string]interface{}{"type": "string", "description": "Review only this user's grants"},
+				"workspaceIds":   map[string]interface{}{"type": "array", "items": map[string]interface{}{"type": "string"}, "description": "Workspaces to review (default: all visible workspaces, up to maxWorkspaces)"},
+				"maxWorkspaces":  map[string]interface{}{"type": "integer", "description": "Cap when reviewing all workspaces (default: 100)"},
+				"groupIds":       map[string]interface{}{"type": "array", "items": map[string]interface{}{"type": "string"}, "description": "Groups to expand (default: all of the caller's groups)"},
+				"organizationId": map[string]interface{}{"type": "string", "description": "Organization ID, to include pod roles (with podId)"},
+				"podId":          map[string]interface{}{"type": "string", "description": "Pod ID, to include pod roles (with organizationId)"},
+				"staleDays":      map[string]interface{}{"type": "integer", "description": "Flag grants on workspaces not updated in this many days (default: 90)"},
+				"maxOwners":      map[string]interface{}{"type": "integer", "description": "Flag owners of workspaces with more owners than this (default: 3)"},
+				"allowedDomains": map[string]interface{}{"type": "array", "items": map[string]interface{}{"type": "string"}, "description": "Email domains considered internal; others are flagged external"},
+				"onlyFlagged":    map[string]interface{}{"type": "boolean", "description": "Return only flagged grants (default: false)"},
+				"format":         map[string]interface{}{"type": "string", "enum": []string{"json", "csv"}, "description": "Output format (default: json). csv returns the grants as CSV text in the 'csv' field, alongside the summary and warnings"},
+			},
+		},
+	},
 
 	{
 		Name:        "app_create",
@@ -527,6 +631,7 @@ clinical searches, underlay name for schema exploration).`,
 				"machineType": map[string]interface{}{"type": "string", "description": "Machine type (e.g., 'n1-standard-4')"},
 				"description": map[string]interface{}{"type": "string", "description": "Description of the app"},
 				"location":    map[string]interface{}{"type": "string", "description": "GCP location/zone"},
+				"async":       map[string]interface{}{"type": "boolean", "description": "Return a job ID immediately and run in the background; follow with job_wait or job_status"},
 			},
 			Required: []string{"appId", "appConfig"},
 		},
@@ -550,13 +655,27 @@ clinical searches, underlay name for schema exploration).`,
 			Properties: map[string]interface{}{},
 		},
 	},
+	{
+		Name:        "workspace_cost_summary",
+		Description: "Estimate what a workspace's running apps, notebooks and clusters cost: machine types, uptime, hourly cost and cost since start, with totals. Flags those whose last reported activity is older than idleHours (activity \"unknown\" when none is reported) and suggests the matching app_stop, notebook_stop or cluster_stop. Prices are built-in us-central1 on-demand list prices for vCPUs, memory and GPUs (no disks, discounts or BigQuery), overridable with prices.json in the server's state directory; treat the numbers as estimates.",
+		InputSchema: InputSchema{
+			Type: "object",
+			Properties: map[string]interface{}{
+				"workspaceId": map[string]interface{}{"type": "string", "description": "Workspace ID (default: the active workspace)"},
+				"idleHours":   map[string]interface{}{"type": "number", "description": "Flag running apps, notebooks and clusters with no activity for this many hours (default: 2)"},
+			},
+		},
+	},
 	{
 		Name:        "app_start",
-		Description: "Start a stopped application. Use this to resume an application that was stopped to save costs. Takes a few minutes to become ready.",
+		Description: "Start a stopped application. Use this to resume an application that was stopped to save costs. Takes a few minutes to become ready; pass wait=true to wait for it and get its URL.",
 		InputSchema: InputSchema{
 			Type: "object",
 			Properties: map[string]interface{}{
-				"appId": map[string]interface{}{"type": "string", "description": "Application ID to start"},
+				"appId":          map[string]interface{}{"type": "string", "description": "Application ID to start"},
+				"async":          map[string]interface{}{"type": "boolean", "description": "Return a job ID immediately and run in the background; follow with job_wait or job_status"},
+				"wait":           map[string]interface{}{"type": "boolean", "description": "Wait until it is RUNNING and its URL answers, then return the URL (default: false)"},
+				"timeoutSeconds": map[string]interface{}{"type": "integer", "description": "How long to wait with wait=true (default: 900)"},
 			},
 			Required: []string{"appId"},
 		},
@@ -620,6 +739,47 @@ clinical searches, underlay name for schema exploration).`,
 			Properties: map[string]interface{}{},
 		},
 	},
+	{
+		Name:        "server_config",
+		Description: "Show the Workbench service endpoints this MCP server talks to (Workspace Manager, Data Explorer, UI), the named environment in use, where each URL came from (config file, env var, flag, wb status), and which environments are configured. Use this when API calls go to an unexpected deployment.",
+		InputSchema: InputSchema{
+			Type:       "object",
+			Properties: map[string]interface{}{},
+		},
+	},
+	{
+		Name:        "job_status",
+		Description: "Get the status, progress, result and error of a background job started with async=true.",
+		InputSchema: InputSchema{
+			Type: "object",
+			Properties: map[string]interface{}{
+				"jobId": map[string]interface{}{"type": "string"},
+			},
+			Required: []string{"jobId"},
+		},
+	},
+	{
+		Name:        "job_list",
+		Description: "List background jobs (newest first), including jobs from before a server restart.",
+		InputSchema: InputSchema{
+			Type: "object",
+			Properties: map[string]interface{}{
+				"status": map[string]interface{}{"type": "string", "enum": []string{"running", "succeeded", "failed"}},
+			},
+		},
+	},
+	{
+		Name:        "job_wait",
+		Description: "Wait for a background job to finish, up to timeoutSeconds (default 60, max 600), then return its status. Call again if it is still running.",
+		InputSchema: InputSchema{
+			Type: "object",
+			Properties: map[string]interface{}{
+				"jobId":          map[string]interface{}{"type": "string"},
+				"timeoutSeconds": map[string]interface{}{"type": "integer", "default": 60},
+			},
+			Required: []string{"jobId"},
+		},
+	},
 	{
 		Name:        "server_list_regions",
 		Description: "List valid cloud regions for a platform. Use this when creating resources to see available regions.",
@@ -751,11 +911,13 @@ clinical searches, underlay name for schema exploration).`,
 
 	{
 		Name:        "notebook_start",
-		Description: "Start a stopped notebook instance. Use this to resume a notebook that was stopped to save costs. Convenience wrapper for app start.",
+		Description: "Start a stopped notebook instance. Use this to resume a notebook that was stopped to save costs. Convenience wrapper for app start. Pass wait=true to wait until it is ready and get its URL.",
 		InputSchema: InputSchema{
 			Type: "object",
 			Properties: map[string]interface{}{
-				"notebookId": map[string]interface{}{"type": "string", "description": "Notebook instance ID"},
+				"notebookId":     map[string]interface{}{"type": "string", "description": "Notebook instance ID"},
+				"wait":           map[string]interface{}{"type": "boolean", "description": "Wait until it is RUNNING and its URL answers, then return the URL (default: false)"},
+				"timeoutSeconds": map[string]interface{}{"type": "integer", "description": "How long to wait with wait=true (default: 900)"},
 			},
 			Required: []string{"notebookId"},
 		},
@@ -785,11 +947,14 @@ clinical searches, underlay name for schema exploration).`,
 
 	{
 		Name:        "cluster_start",
-		Description: "Start a stopped Dataproc cluster. Use this to resume a Spark cluster that was stopped to save costs.",
+		Description: "Start a stopped Dataproc cluster. Use this to resume a Spark cluster that was stopped to save costs. Pass wait=true to wait until it is ready and get its URL.",
 		InputSchema: InputSchema{
 			Type: "object",
 			Properties: map[string]interface{}{
-				"clusterId": map[string]interface{}{"type": "string", "description": "Cluster ID"},
+				"clusterId":      map[string]interface{}{"type": "string", "description": "Cluster ID"},
+				"async":          map[string]interface{}{"type": "boolean", "description": "Return a job ID immediately and run in the background; follow with job_wait or job_status"},
+				"wait":           map[string]interface{}{"type": "boolean", "description": "Wait until it is RUNNING and its URL answers, then return the URL (default: false)"},
+				"timeoutSeconds": map[string]interface{}{"type": "integer", "description": "How long to wait with wait=true (default: 900)"},
 			},
 			Required: []string{"clusterId"},
 		},
@@ -856,6 +1021,19 @@ clinical searches, underlay name for schema exploration).`,
 			Required: []string{"workspaceId", "workflowId"},
 		},
 	},
+	{
+		Name:        "workflow_validate_inputs",
+		Description: "Show a workflow's declared inputs (from its WDL input block or Nextflow params: type, optional, default) and, if 'inputs' is given, check them the way workflow_job_run will: unknown or missing inputs, type mismatches, and wb://<resource>/<path> references resolved to cloud URIs.",
+		InputSchema: InputSchema{
+			Type: "object",
+			Properties: map[string]interface{}{
+				"workspaceId": map[string]interface{}{"type": "string", "description": "Workspace ID"},
+				"workflowId":  map[string]interface{}{"type": "string", "description": "Workflow ID"},
+				"inputs":      map[string]interface{}{"type": "object", "description": "Optional: inputs to check, as for workflow_job_run"},
+			},
+			Required: []string{"workspaceId", "workflowId"},
+		},
+	},
 	{
 		Name:        "workflow_job_list",
 		Description: "List all workflow jobs. Use this to see job history, status, and details.",
@@ -878,21 +1056,56 @@ clinical searches, underlay name for schema exploration).`,
 	},
 	{
 		Name:        "workflow_job_run",
-		Description: "Start a workflow job. Use this to execute a workflow with specific inputs.",
+		Description: "Start a workflow job. Use this to execute a workflow with specific inputs. Inputs are first checked against the workflow's WDL (names, required inputs, types); on mismatch nothing is submitted.",
 		InputSchema: InputSchema{
 			Type: "object",
 			Properties: map[string]interface{}{
-				"workspaceId":      map[string]interface{}{"type": "string", "description": "Workspace ID"},
-				"workflowId":       map[string]interface{}{"type": "string", "description": "Workflow ID"},
-				"outputBucketId":   map[string]interface{}{"type": "string", "description": "BUCKET NAME (not UUID) for outputs - e.g., 'cohort_exports'"},
-				"jobId":            map[string]interface{}{"type": "string", "description": "Optional job ID"},
-				"description":      map[string]interface{}{"type": "string", "description": "Job description"},
-				"outputPath":       map[string]interface{}{"type": "string", "description": "Output path in bucket"},
-				"inputs":           map[string]interface{}{"type": "object", "description": "Job inputs as key-value pairs"},
+				"workspaceId":    map[string]interface{}{"type": "string", "description": "Workspace ID"},
+				"workflowId":     map[string]interface{}{"type": "string", "description": "Workflow ID"},
+				"outputBucketId": map[string]interface{}{"type": "string", "description": "BUCKET NAME (not UUID) for outputs - e.g., 'cohort_exports'"},
+				"jobId":          map[string]interface{}{"type": "string", "description": "Optional job ID"},
+				"description":    map[string]interface{}{"type": "string", "description": "Job description"},
+				"outputPath":     map[string]interface{}{"type": "string", "description": "Output path in bucket"},
+				"inputs":         map[string]interface{}{"type": "object", "description": "Job inputs as key-value pairs. File values may be wb://<resource>/<path>, resolved to the bucket URI."},
+				"validate":       map[string]interface{}{"type": "boolean", "description": "Check inputs against the workflow definition before submitting (default: true)"},
+				"async":          map[string]interface{}{"type": "boolean", "description": "Return a job ID immediately and run in the background; follow with job_wait or job_status"},
 			},
 			Required: []string{"workspaceId", "workflowId", "outputBucketId"},
 		},
 	},
+	{
+		Name:        "workflow_job_outputs",
+		Description: "List a workflow job's output files (path, size, whether it is a task log), or read one with 'path'. The output bucket and path are the ones recorded by workflow_job_run; for jobs started elsewhere, pass outputBucketId and outputPath.",
+		InputSchema: InputSchema{
+			Type: "object",
+			Properties: map[string]interface{}{
+				"workspaceId":    map[string]interface{}{"type": "string", "description": "Workspace ID"},
+				"jobId":          map[string]interface{}{"type": "string", "description": "Job ID"},
+				"path":           map[string]interface{}{"type": "string", "description": "Optional: file to read, relative to the job's output root (as listed)"},
+				"maxBytes":       map[string]interface{}{"type": "number", "description": "Maximum bytes to read from 'path' (default: 1048576, max: 8388608)"},
+				"outputBucketId": map[string]interface{}{"type": "string", "description": "Override: bucket resource name the job wrote to"},
+				"outputPath":     map[string]interface{}{"type": "string", "description": "Override: output path in the bucket"},
+			},
+			Required: []string{"workspaceId", "jobId"},
+		},
+	},
+	{
+		Name:        "workflow_job_logs",
+		Description: "Show the tail of a workflow job's task logs (stdout, stderr, *.log) from its output bucket, stderr first. Use this to find out why a task failed.",
+		InputSchema: InputSchema{
+			Type: "object",
+			Properties: map[string]interface{}{
+				"workspaceId":    map[string]interface{}{"type": "string", "description": "Workspace ID"},
+				"jobId":          map[string]interface{}{"type": "string", "description": "Job ID"},
+				"task":           map[string]interface{}{"type": "string", "description": "Optional: only logs whose path contains this (e.g. a task or call name)"},
+				"tailBytes":      map[string]interface{}{"type": "number", "description": "Bytes to show from the end of each log (default: 16384, max: 8388608)"},
+				"maxFiles":       map[string]interface{}{"type": "number", "description": "Maximum number of logs to show (default: 5)"},
+				"outputBucketId": map[string]interface{}{"type": "string", "description": "Override: bucket resource name the job wrote to"},
+				"outputPath":     map[string]interface{}{"type": "string", "description": "Override: output path in the bucket"},
+			},
+			Required: []string{"workspaceId", "jobId"},
+		},
+	},
 	{
 		Name:        "workflow_job_cancel",
 		Description: "Cancel a running workflow job. Use this to stop a job that is in progress.",
@@ -1379,7 +1592,9 @@ BUILDING selectionData by plugin type:
 
 CRITICAL:
 - Each criterion goes in its own criteriaGroup. Operator "AND" means all groups must match.
-- Use study_list_cohorts to examine working cohorts and learn correct formats.`,
+- Use study_list_cohorts to examine working cohorts and learn correct formats.
+
+HISTORY: The cohort's criteria and count are saved as a version before each update. Use cohort_history, cohort_diff and cohort_revert to review or undo changes.`,
 		InputSchema: InputSchema{
 			Type: "object",
 			Properties: map[string]interface{}{
@@ -1392,6 +1607,67 @@ CRITICAL:
 			Required: []string{"studyId", "cohortId"},
 		},
 	},
+	{
+		Name:        "cohort_history",
+		Description: "List the saved versions of a cohort (taken before each cohort_update_criteria or cohort_revert) with their criteria and participant counts, followed by the current state.",
+		InputSchema: InputSchema{
+			Type: "object",
+			Properties: map[string]interface{}{
+				"studyId":  map[string]interface{}{"type": "string"},
+				"cohortId": map[string]interface{}{"type": "string"},
+			},
+			Required: []string{"studyId", "cohortId"},
+		},
+	},
+	{
+		Name:        "cohort_diff",
+		Description: "Show which criteria sections were added, removed or changed between two cohort versions, and how the participant count moved. Defaults to the latest saved version vs. the current cohort.",
+		InputSchema: InputSchema{
+			Type: "object",
+			Properties: map[string]interface{}{
+				"studyId":  map[string]interface{}{"type": "string"},
+				"cohortId": map[string]interface{}{"type": "string"},
+				"from":     map[string]interface{}{"type": "string", "description": "Version number (e.g. '2' or 'v2') or 'current'. Default: latest saved version"},
+				"to":       map[string]interface{}{"type": "string", "description": "Version number or 'current'. Default: current"},
+			},
+			Required: []string{"studyId", "cohortId"},
+		},
+	},
+	{
+		Name:        "cohort_revert",
+		Description: "Restore a cohort's criteria, name and description from a saved version (see cohort_history). The state being replaced is saved as a new version first, so a revert can itself be undone.",
+		InputSchema: InputSchema{
+			Type: "object",
+			Properties: map[string]interface{}{
+				"studyId":  map[string]interface{}{"type": "string"},
+				"cohortId": map[string]interface{}{"type": "string"},
+				"version":  map[string]interface{}{"type": "string", "description": "Version number to restore (e.g. '2' or 'v2')"},
+			},
+			Required: []string{"studyId", "cohortId", "version"},
+		},
+	},
+	{
+		Name: "cohort_attrition",
+		Description: `Attrition report: how many participants each criteria section removes.
+
+Applies the sections one at a time (section 1, then 1-2, then 1-3, ...) and counts after each step, returning a funnel table with the count, the number removed, and percentages of the previous step and of all participants. The source cohort is not modified; a temporary cohort in the same study is used and deleted.
+
+INPUT:
+- studyId + cohortId: use the cohort's saved criteria, in order
+- or studyId + underlayName + criteriaGroupSections (e.g. from cohort_compile_criteria) to try criteria before saving them
+- groupByAttributes: optional primary-entity attributes (e.g. ["gender"]) to break each step down by demographic group`,
+		InputSchema: InputSchema{
+			Type: "object",
+			Properties: map[string]interface{}{
+				"studyId":               map[string]interface{}{"type": "string"},
+				"cohortId":              map[string]interface{}{"type": "string", "description": "Cohort whose criteria to analyze"},
+				"underlayName":          map[string]interface{}{"type": "string", "description": "Required when cohortId is omitted"},
+				"criteriaGroupSections": map[string]interface{}{"type": "array", "items": map[string]interface{}{"type": "object"}, "description": "Sections to apply instead of the cohort's own"},
+				"groupByAttributes":     map[string]interface{}{"type": "array", "items": map[string]interface{}{"type": "string"}},
+			},
+			Required: []string{"studyId"},
+		},
+	},
 	{
 		Name:        "cohort_count_instances",
 		Description: "Count instances matching cohort criteria",
@@ -1518,19 +1794,52 @@ WORKFLOW:
 		},
 	},
 
+	{
+		Name: "export_cohort_to_files",
+		Description: `Export cohort data and download the files, ready for a notebook.
+
+Runs the export (like export_cohort), waits for the files to become available, downloads them and returns file paths with row counts.
+
+INPUT:
+- studyId, cohortId: the cohort to export
+- exportModel: model name from export_list_models; inputs: model parameters
+- format: "csv", "parquet" or "any" (default) - which files to keep
+- localDir: where to save the files, under the home directory or $WB_MCP_LOCAL_ROOT (default ~/exports/<cohortId>)
+- resourceName (+ optional path): also copy the files into this GCS or S3 bucket resource. Without localDir, only the bucket copies are kept
+- timeoutSeconds: how long to wait for the files (default 600)`,
+		InputSchema: InputSchema{
+			Type: "object",
+			Properties: map[string]interface{}{
+				"studyId":        map[string]interface{}{"type": "string"},
+				"cohortId":       map[string]interface{}{"type": "string"},
+				"exportModel":    map[string]interface{}{"type": "string", "description": "Export model name from export_list_models"},
+				"inputs":         map[string]interface{}{"type": "object", "description": "Model input parameters"},
+				"format":         map[string]interface{}{"type": "string", "enum": []string{"any", "csv", "parquet"}},
+				"localDir":       map[string]interface{}{"type": "string"},
+				"resourceName":   map[string]interface{}{"type": "string", "description": "GCS or S3 bucket resource to upload to"},
+				"path":           map[string]interface{}{"type": "string", "description": "Folder within the bucket resource"},
+				"timeoutSeconds": map[string]interface{}{"type": "integer", "default": 600},
+				"async":          map[string]interface{}{"type": "boolean", "description": "Return a job ID immediately and run in the background; follow with job_wait or job_status"},
+			},
+			Required: []string{"studyId", "cohortId", "exportModel"},
+		},
+	},
+
 	{
 		Name:        "filter_build_attribute",
-		Description: "Build attribute filter (e.g., age > 65). For cohort creation, use the criteriaGroupSections structure in cohort_create_in_workspace.",
+		Description: "Build attribute filter (e.g., age > 65). Values are checked against dataType; pass underlayName to also check the attribute exists and the operator suits its type. For cohort creation, use the criteriaGroupSections structure in cohort_create_in_workspace.",
 		InputSchema: InputSchema{
 			Type: "object",
 			Properties: map[string]interface{}{
-				"attribute": map[string]interface{}{"type": "string"},
-				"operator":  map[string]interface{}{"type": "string", "enum": []string{"EQUALS", "NOT_EQUALS", "LESS_THAN", "GREATER_THAN", "LESS_THAN_OR_EQUAL", "GREATER_THAN_OR_EQUAL", "IN", "NOT_IN", "BETWEEN", "IS_NULL", "IS_NOT_NULL"}},
-				"value":     map[string]interface{}{},
-				"values":    map[string]interface{}{"type": "array", "items": map[string]interface{}{}},
-				"dataType":  map[string]interface{}{"type": "string", "enum": []string{"BOOLEAN", "INT64", "STRING", "DATE", "TIMESTAMP", "DOUBLE"}},
+				"attribute":    map[string]interface{}{"type": "string"},
+				"operator":     map[string]interface{}{"type": "string", "enum": []string{"EQUALS", "NOT_EQUALS", "LESS_THAN", "GREATER_THAN", "LESS_THAN_OR_EQUAL", "GREATER_THAN_OR_EQUAL", "IN", "NOT_IN", "BETWEEN", "IS_NULL", "IS_NOT_NULL"}},
+				"value":        map[string]interface{}{},
+				"values":       map[string]interface{}{"type": "array", "items": map[string]interface{}{}},
+				"dataType":     map[string]interface{}{"type": "string", "enum": []string{"BOOLEAN", "INT64", "STRING", "DATE", "TIMESTAMP", "DOUBLE"}, "description": "Required unless underlayName is given, in which case it defaults to the attribute's type"},
+				"underlayName": map[string]interface{}{"type": "string", "description": "Optional: validate attribute, type and values against this underlay's schema"},
+				"entity":       map[string]interface{}{"type": "string", "description": "Entity the attribute belongs to (default: the underlay's primary entity)"},
 			},
-			Required: []string{"attribute", "operator", "dataType"},
+			Required: []string{"attribute", "operator"},
 		},
 	},
 	{
@@ -1541,6 +1850,7 @@ WORKFLOW:
 			Properties: map[string]interface{}{
 				"relatedEntity": map[string]interface{}{"type": "string"},
 				"subfilter":     map[string]interface{}{"type": "object"},
+				"underlayName":  map[string]interface{}{"type": "string", "description": "Optional: validate relatedEntity and the subfilter against this underlay's schema"},
 			},
 			Required: []string{"relatedEntity"},
 		},
@@ -1559,17 +1869,88 @@ WORKFLOW:
 	},
 	{
 		Name:        "filter_build_hierarchy",
-		Description: "Build hierarchy filter (e.g., all descendants of concept). For cohort creation, use the criteriaGroupSections structure in cohort_create_in_workspace.",
+		Description: "Build hierarchy filter (e.g., all descendants of concept). Use concept_search to find concept IDs for 'values'. For cohort creation, use the criteriaGroupSections structure in cohort_create_in_workspace.",
 		InputSchema: InputSchema{
 			Type: "object",
 			Properties: map[string]interface{}{
-				"hierarchy": map[string]interface{}{"type": "string"},
-				"operator":  map[string]interface{}{"type": "string", "enum": []string{"CHILD_OF", "DESCENDANT_OF_INCLUSIVE", "IS_ROOT", "IS_MEMBER", "IS_LEAF"}},
-				"values":    map[string]interface{}{"type": "array", "items": map[string]interface{}{}},
+				"hierarchy":    map[string]interface{}{"type": "string"},
+				"operator":     map[string]interface{}{"type": "string", "enum": []string{"CHILD_OF", "DESCENDANT_OF_INCLUSIVE", "IS_ROOT", "IS_MEMBER", "IS_LEAF"}},
+				"values":       map[string]interface{}{"type": "array", "items": map[string]interface{}{}},
+				"underlayName": map[string]interface{}{"type": "string", "description": "Optional: check the hierarchy exists on the entity in this underlay"},
+				"entity":       map[string]interface{}{"type": "string", "description": "Entity with the hierarchy (e.g., 'condition')"},
 			},
 			Required: []string{"hierarchy", "operator"},
 		},
 	},
+	{
+		Name: "concept_search",
+		Description: `Find concepts (e.g. conditions, measurements, drugs) in an underlay entity by text or ID, with their place in the hierarchy and participant counts. Use this to get the concept IDs for filter_build_hierarchy or cohort_compile_criteria.
+
+Each match includes:
+- id, name
+- path (root → ... → concept) and ancestors with IDs
+- count (participants with exactly this concept) and rollupCount (including descendants)
+- numChildren, isRoot
+- hierarchyFilter: a ready-to-use DESCENDANT_OF_INCLUSIVE filter for the concept
+
+Set expand=children or expand=descendants to list related concepts (with counts) under the first few matches.`,
+		InputSchema: InputSchema{
+			Type: "object",
+			Properties: map[string]interface{}{
+				"underlayName": map[string]interface{}{"type": "string", "description": "Underlay name (from underlay_list)"},
+				"entity":       map[string]interface{}{"type": "string", "description": "Concept entity to search (e.g., 'condition', 'measurement', 'ingredient')"},
+				"text":         map[string]interface{}{"type": "string", "description": "Text to search for (e.g., 'hba1c', 'type 2 diabetes')"},
+				"conceptId":    map[string]interface{}{"type": "number", "description": "Look up this concept ID instead of searching by text"},
+				"hierarchy":    map[string]interface{}{"type": "string", "description": "Hierarchy to report paths in (default: the entity's first hierarchy, usually 'default')"},
+				"expand":       map[string]interface{}{"type": "string", "enum": []string{"none", "children", "descendants"}, "description": "List children or descendants of the matches (default: none)"},
+				"limit":        map[string]interface{}{"type": "number", "description": "Maximum matches, and related concepts per expanded match (default: 10, max: 100)"},
+			},
+			Required: []string{"underlayName", "entity"},
+		},
+	},
+	{
+		Name:        "filter_validate",
+		Description: "Check a composed filter (from the filter_build_* tools) against an underlay schema before using it: attribute and entity names, value types, operators and hierarchies. Errors suggest near-miss names. data_sample_instances runs the same check automatically.",
+		InputSchema: InputSchema{
+			Type: "object",
+			Properties: map[string]interface{}{
+				"underlayName": map[string]interface{}{"type": "string", "description": "Underlay name (from underlay_list)"},
+				"entity":       map[string]interface{}{"type": "string", "description": "Entity the filter applies to (e.g., 'person')"},
+				"filter":       map[string]interface{}{"type": "object"},
+			},
+			Required: []string{"underlayName", "entity", "filter"},
+		},
+	},
+	{
+		Name: "cohort_compile_criteria",
+		Description: `Compile a compact criteria expression into criteriaGroupSections, validated against the underlay schema. Use instead of hand-building criteriaJson.
+
+EXAMPLES:
+  person.age >= 18 AND has condition in hierarchy "Type 2 diabetes mellitus"
+  age BETWEEN 18 AND 65 AND gender = "Female"
+  (has condition 201826 OR has condition 4329847) AND NOT has drug 1503297
+
+SYNTAX:
+- Criteria are an AND of clauses. Each clause becomes one section; NOT excludes it.
+- OR'd terms must be parenthesized: (a OR b). AND inside parentheses is not allowed.
+- Attribute terms on the primary entity: attr op value, with op one of = < <= > >=,
+  attr BETWEEN lo AND hi, or attr IN (v1, v2). Enum values may be given by display name.
+- HAS <selector-or-entity> [IN HIERARCHY] concept(s): concepts are IDs or exact quoted names;
+  with IN HIERARCHY a concept also matches its descendants.
+
+RESPONSE:
+- summary: how each section was interpreted
+- criteriaGroupSections: pass to cohort_update_criteria
+- criteriaJson: pass to cohort_create_in_workspace`,
+		InputSchema: InputSchema{
+			Type: "object",
+			Properties: map[string]interface{}{
+				"underlayName": map[string]interface{}{"type": "string", "description": "Underlay name (e.g., 'AoU_2024')"},
+				"criteria":     map[string]interface{}{"type": "string", "description": "Criteria expression (see tool description)"},
+			},
+			Required: []string{"underlayName", "criteria"},
+		},
+	},
 
 	// --- Aurora Database Tools ---
 	{
@@ -1680,6 +2061,73 @@ WORKFLOW:
 		},
 	},
 
+	// --- Local File Tools ---
+	{
+		Name:        "local_list",
+		Description: "List files in the local home directory (or WB_MCP_LOCAL_ROOT), with type, size and modification time. Paths are relative to that directory; nothing outside it, including through symlinks, is reachable.",
+		InputSchema: InputSchema{
+			Type: "object",
+			Properties: map[string]interface{}{
+				"path":       map[string]interface{}{"type": "string", "description": "Directory to list (default: the home directory)"},
+				"recursive":  map[string]interface{}{"type": "boolean", "description": "List subdirectories too (default: false)"},
+				"maxEntries": map[string]interface{}{"type": "integer", "description": "Maximum entries to return (default: 1000)"},
+			},
+		},
+	},
+	{
+		Name:        "local_read",
+		Description: "Read a text file under the local home directory (or WB_MCP_LOCAL_ROOT). Binary files are refused; move them with upload_to_resource.",
+		InputSchema: InputSchema{
+			Type: "object",
+			Properties: map[string]interface{}{
+				"path":     map[string]interface{}{"type": "string", "description": "File path, relative to the home directory"},
+				"maxBytes": map[string]interface{}{"type": "integer", "description": "Maximum bytes to read (default: 1048576)"},
+			},
+			Required: []string{"path"},
+		},
+	},
+	{
+		Name:        "local_write",
+		Description: "Write a text file under the local home directory (or WB_MCP_LOCAL_ROOT), creating parent directories. Overwrites an existing file unless append is true.",
+		InputSchema: InputSchema{
+			Type: "object",
+			Properties: map[string]interface{}{
+				"path":    map[string]interface{}{"type": "string", "description": "File path, relative to the home directory"},
+				"content": map[string]interface{}{"type": "string", "description": "File content to write"},
+				"append":  map[string]interface{}{"type": "boolean", "description": "Append instead of overwriting (default: false)"},
+			},
+			Required: []string{"path", "content"},
+		},
+	},
+	{
+		Name:        "upload_to_resource",
+		Description: "Upload a local file or directory to a GCS bucket or S3 folder resource. The bucket and credentials are resolved from the resource name through wb. The local path must be under the home directory (or WB_MCP_LOCAL_ROOT).",
+		InputSchema: InputSchema{
+			Type: "object",
+			Properties: map[string]interface{}{
+				"resourceName": map[string]interface{}{"type": "string", "description": "Workspace bucket resource name"},
+				"localPath":    map[string]interface{}{"type": "string", "description": "Local file or directory, relative to the home directory"},
+				"path":         map[string]interface{}{"type": "string", "description": "Object path within the resource; ending in '/' uploads into that folder (default: the file name at the top)"},
+				"recursive":    map[string]interface{}{"type": "boolean", "description": "Upload a directory (default: false)"},
+			},
+			Required: []string{"resourceName", "localPath"},
+		},
+	},
+	{
+		Name:        "download_from_resource",
+		Description: "Download a file or folder from a GCS bucket or S3 folder resource to the local home directory (or WB_MCP_LOCAL_ROOT). The bucket and credentials are resolved from the resource name through wb.",
+		InputSchema: InputSchema{
+			Type: "object",
+			Properties: map[string]interface{}{
+				"resourceName": map[string]interface{}{"type": "string", "description": "Workspace bucket resource name"},
+				"path":         map[string]interface{}{"type": "string", "description": "Object path within the resource"},
+				"localPath":    map[string]interface{}{"type": "string", "description": "Local destination, relative to the home directory (default: the object's name)"},
+				"recursive":    map[string]interface{}{"type": "boolean", "description": "Download a folder (default: false)"},
+			},
+			Required: []string{"resourceName", "path"},
+		},
+	},
+
 	// --- AWS Resource Lifecycle Tools ---
 	{
 		Name:        "resource_create_aurora_database",
@@ -1724,66 +2172,32 @@ WORKFLOW:
 	},
 }
 
-func initializeConfig() error {
-	// Default to production Verily URLs
-	workspaceBaseURL = "https://workbench.verily.com/api/wsm"
-	dataExplorerURL = "https://workbench.verily.com/api/de"
-
-	cmd := exec.Command("wb", "status", "--format=json")
-	output, err := cmd.CombinedOutput()
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Warning: wb status failed, using default URLs: %v\n", err)
-	} else {
-		var status map[string]interface{}
-		if err := json.Unmarshal(output, &status); err != nil {
-			fmt.Fprintf(os.Stderr, "Warning: failed to parse wb status JSON, using default URLs: %v\n", err)
-		} else {
-			// Extract server URLs
-			if server, ok := status["server"].(map[string]interface{}); ok {
-				if wsURL, ok := server["workspaceManagerUri"].(string); ok && wsURL != "" {
-					workspaceBaseURL = wsURL
-					dataExplorerURL = strings.Replace(wsURL, "/api/wsm", "/api/de", 1)
-				}
-			} else {
-				fmt.Fprintf(os.Stderr, "Warning: server info not found in wb status, using default URLs\n")
-			}
-			// Best-effort workspace UUID cache at startup. If this fails (e.g. auth not
-			// ready yet), getCurrentWorkspaceUUID() will retry lazily at call time.
-			if _, startupErr := getCurrentWorkspaceUUID(); startupErr != nil {
-				fmt.Fprintf(os.Stderr, "Warning: could not resolve workspace UUID at startup (will retry on first use): %v\n", startupErr)
-			}
-		}
-	}
-
-	// Final safety check - ensure URLs are never empty
-	if workspaceBaseURL == "" {
-		workspaceBaseURL = "https://workbench.verily.com/api/wsm"
-	}
-	if dataExplorerURL == "" {
-		dataExplorerURL = "https://workbench.verily.com/api/de"
-	}
-
-	fmt.Fprintf(os.Stderr, "Initialized - Workspace: %s, DataExplorer: %s\n", workspaceBaseURL, dataExplorerURL)
-	return nil
-}
+// errWorkspaceNotFound is returned by resolveWorkspaceId when the workspace
+// list was read but has no matching workspace.
+var errWorkspaceNotFound = errors.New("not found")
 
 // resolveWorkspaceId resolves an arbitrary user-facing workspace ID to its UUID
 // by searching the full workspace list. Used by tools that accept an explicit
-// workspaceId parameter. For the CURRENT workspace, use getCurrentWorkspaceUUID().
-func resolveWorkspaceId(workspaceId string) (string, error) {
+// workspaceId parameter. For the CURRENT workspace, use session.resolveWorkspaceUUID(ctx).
+func resolveWorkspaceId(ctx context.Context, workspaceId string) (string, error) {
 	if isUUID(workspaceId) {
 		return workspaceId, nil // already a UUID
 	}
+	var listErr error
+	listed := false
 	for _, limit := range []int{100, 5000} {
 		listUrl := fmt.Sprintf("%s/api/workspaces/v1?offset=0&limit=%d", workspaceBaseURL, limit)
-		listResp, apiErr := makeAPIRequest("GET", listUrl, nil)
+		listResp, apiErr := makeAPIRequest(ctx, "GET", listUrl, nil)
 		if apiErr != nil {
+			listErr = apiErr
 			continue
 		}
 		var listData map[string]interface{}
-		if json.Unmarshal(listResp, &listData) != nil {
+		if err := json.Unmarshal(listResp, &listData); err != nil {
+			listErr = fmt.Errorf("failed to parse workspace list: %w", err)
 			continue
 		}
+		listed = true
 		workspaces, _ := listData["workspaces"].([]interface{})
 		for _, ws := range workspaces {
 			wsMap, ok := ws.(map[string]interface{})
@@ -1797,7 +2211,10 @@ func resolveWorkspaceId(workspaceId string) (string, error) {
 			}
 		}
 	}
-	return "", fmt.Errorf("workspace '%s' not found", workspaceId)
+	if !listed {
+		return "", fmt.Errorf("failed to list workspaces to resolve '%s': %w", workspaceId, listErr)
+	}
+	return "", fmt.Errorf("workspace '%s' %w", workspaceId, errWorkspaceNotFound)
 }
 
 // isUUID returns true if s looks like a UUID (8-4-4-4-12 hex format).
@@ -1829,15 +2246,15 @@ func isUUID(s string) bool {
 //     using the userFacingId obtained from layer 2.
 //
 // The result is cached so subsequent calls within the same server session are instant.
-func getCurrentWorkspaceUUID() (string, error) {
+func getCurrentWorkspaceUUID(ctx context.Context) (string, error) {
 	if cachedWorkspaceUUID != "" {
 		return cachedWorkspaceUUID, nil
 	}
 
 	// Layer 1: wb workspace describe — most direct path.
 	userFacingId := ""
-	cmd := exec.Command("wb", "workspace", "describe", "--format=json")
-	if out, err := cmd.CombinedOutput(); err == nil {
+	cmd := exec.CommandContext(ctx, "wb", "workspace", "describe", "--format=json")
+	if out, err := runCommand(ctx, cmd); err == nil {
 		var desc map[string]interface{}
 		if json.Unmarshal(out, &desc) == nil {
 			// Some Workbench versions return uuid directly.
@@ -1864,8 +2281,8 @@ func getCurrentWorkspaceUUID() (string, error) {
 
 	// Layer 2: fall back to wb status for userFacingId if describe didn't give it.
 	if userFacingId == "" {
-		cmd2 := exec.Command("wb", "status", "--format=json")
-		if out, err := cmd2.CombinedOutput(); err == nil {
+		cmd2 := exec.CommandContext(ctx, "wb", "status", "--format=json")
+		if out, err := runCommand(ctx, cmd2); err == nil {
 			var status map[string]interface{}
 			if json.Unmarshal(out, &status) == nil {
 				if ws, ok := status["workspace"].(map[string]interface{}); ok {
@@ -1891,7 +2308,7 @@ func getCurrentWorkspaceUUID() (string, error) {
 	// Try a small page first to avoid fetching 5,000 workspaces for common cases.
 	for _, limit := range []int{100, 5000} {
 		listUrl := fmt.Sprintf("%s/api/workspaces/v1?offset=0&limit=%d", workspaceBaseURL, limit)
-		listResp, apiErr := makeAPIRequest("GET", listUrl, nil)
+		listResp, apiErr := makeAPIRequest(ctx, "GET", listUrl, nil)
 		if apiErr != nil {
 			continue
 		}
@@ -1922,9 +2339,9 @@ func getCurrentWorkspaceUUID() (string, error) {
 	return "", fmt.Errorf("workspace '%s' not found in accessible workspaces", userFacingId)
 }
 
-func getToken() (string, error) {
-	cmd := exec.Command("wb", "auth", "print-access-token")
-	output, err := cmd.CombinedOutput()
+func getToken(ctx context.Context) (string, error) {
+	cmd := exec.CommandContext(ctx, "wb", "auth", "print-access-token")
+	output, err := runCommand(ctx, cmd)
 	if err != nil {
 		return "", fmt.Errorf("failed to get access token: %v", err)
 	}
@@ -1932,8 +2349,17 @@ func getToken() (string, error) {
 }
 
 
-func makeAPIRequest(method, url string, body interface{}) ([]byte, error) {
-	token, err := getToken()
+func makeAPIRequest(ctx context.Context, method, url string, body interface{}) (respBody []byte, err error) {
+	ctx, span := startSpan(ctx, method, spanKindClient)
+	span.setAttr("http.request.method", method)
+	span.setAttr("url.full", url)
+	defer func() { span.end(err) }()
+
+	if dryRunRequest(ctx, method, url, body) {
+		return dryRunResponse, nil
+	}
+
+	token, err := getToken(ctx)
 	if err != nil {
 		return nil, err
 	}
@@ -1954,60 +2380,66 @@ func makeAPIRequest(method, url string, body interface{}) ([]byte, error) {
 
 	req.Header.Set("Authorization", "Bearer "+token)
 	req.Header.Set("Content-Type", "application/json")
+	if span != nil {
+		req.Header.Set("traceparent", span.traceparent())
+	}
 
 	resp, err := httpClient.Do(req)
 	if err != nil {
+		recordAPIRequest(method, 0)
 		return nil, err
 	}
 	defer resp.Body.Close()
+	recordAPIRequest(method, resp.StatusCode)
+	span.setAttr("http.response.status_code", resp.StatusCode)
 
-	respBody, err := io.ReadAll(resp.Body)
+	respBody, err = io.ReadAll(resp.Body)
 	if err != nil {
 		return nil, err
 	}
 
 	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-		return nil, fmt.Errorf("API error (%d): %s", resp.StatusCode, string(respBody))
+		return nil, &apiError{Status: resp.StatusCode, Body: string(respBody)}
 	}
 
 	return respBody, nil
 }
 
-func executeWbCommand(args []string) (string, error) {
-	cmd := exec.Command("wb", args...)
-	output, err := cmd.CombinedOutput()
+func executeWbCommand(ctx context.Context, args []string) (string, error) {
+	cmd := exec.CommandContext(ctx, "wb", args...)
+	output, err := runCommand(ctx, cmd)
 	return string(output), err
 }
 
-func executeShellCommand(name string, args ...string) (string, error) {
-	cmd := exec.Command(name, args...)
-	output, err := cmd.CombinedOutput()
+func executeShellCommand(ctx context.Context, name string, args ...string) (string, error) {
+	cmd := exec.CommandContext(ctx, name, args...)
+	output, err := runCommand(ctx, cmd)
 	return string(output), err
 }
 
-func getAuroraConnString(resourceName, accessMode string) (string, error) {
+func getAuroraConnString(ctx context.Context, sess *session, resourceName, accessMode string) (string, error) {
 	if accessMode == "" {
 		accessMode = "READ_ONLY"
 	}
 	args := []string{"resource", "resolve", "--id=" + resourceName,
 		"--access-mode", accessMode, "--include-password"}
-	connStr, err := executeWbCommand(args)
+	connStr, err := executeWbCommand(ctx, sess.wbArgs(args...))
 	if err != nil {
 		return "", fmt.Errorf("failed to resolve Aurora connection: %w\n%s", err, connStr)
 	}
 	return strings.TrimSpace(connStr), nil
 }
 
-func executeAuroraQuery(resourceName, accessMode, query string) (string, error) {
-	connStr, err := getAuroraConnString(resourceName, accessMode)
+func executeAuroraQuery(ctx context.Context, sess *session, resourceName, accessMode, query string) (string, error) {
+	connStr, err := getAuroraConnString(ctx, sess, resourceName, accessMode)
 	if err != nil {
 		return "", err
 	}
-	return executeShellCommand("psql", connStr, "--csv", "-c", query)
+	return executeShellCommand(ctx, "psql", connStr, "--csv", "-c", query)
 }
 
-func getS3ResourcePath(resourceName string) (string, error) {
-	descOutput, err := executeWbCommand([]string{"resource", "describe", "--id=" + resourceName, "--format=json"})
+func getS3ResourcePath(ctx context.Context, sess *session, resourceName string) (string, error) {
+	descOutput, err := executeWbCommand(ctx, sess.wbArgs("resource", "describe", "--id="+resourceName, "--format=json"))
 	if err != nil {
 		return "", fmt.Errorf("failed to describe resource: %w\n%s", err, descOutput)
 	}
@@ -2030,30 +2462,41 @@ func getS3ResourcePath(resourceName string) (string, error) {
 	return s3Path, nil
 }
 
-func ensureAWSConfig() string {
+// ensureAWSConfig returns the AWS config file for the session's workspace,
+// generating it with `wb workspace configure-aws` if needed.
+func ensureAWSConfig(ctx context.Context, sess *session) string {
+	selectedId, selectedUUID := sess.currentWorkspace()
+	workspaceUUID := selectedUUID
+	if workspaceUUID == "" {
+		workspaceUUID = cachedWorkspaceUUID
+	}
+
 	// Look for existing AWS config generated by wb workspace configure-aws
 	home, _ := os.UserHomeDir()
 	wbDir := home + "/.workbench/aws"
 	entries, err := os.ReadDir(wbDir)
 	if err == nil {
-		// Prefer config matching the current workspace UUID
-		if cachedWorkspaceUUID != "" {
-			target := cachedWorkspaceUUID + ".conf"
+		// Prefer config matching the target workspace UUID
+		if workspaceUUID != "" {
+			target := workspaceUUID + ".conf"
 			for _, e := range entries {
 				if e.Name() == target {
 					return wbDir + "/" + e.Name()
 				}
 			}
 		}
-		// Fall back to any .conf file
-		for _, e := range entries {
-			if strings.HasSuffix(e.Name(), ".conf") {
-				return wbDir + "/" + e.Name()
+		// Fall back to any .conf file, unless the session explicitly selected a
+		// workspace — another workspace's credentials would be wrong there.
+		if selectedId == "" {
+			for _, e := range entries {
+				if strings.HasSuffix(e.Name(), ".conf") {
+					return wbDir + "/" + e.Name()
+				}
 			}
 		}
 	}
 	// Try to generate it
-	out, err := executeWbCommand([]string{"workspace", "configure-aws"})
+	out, err := executeWbCommand(ctx, sess.wbArgs("workspace", "configure-aws"))
 	if err != nil {
 		return ""
 	}
@@ -2067,16 +2510,16 @@ func ensureAWSConfig() string {
 	return ""
 }
 
-func executeAWSCommand(profile string, args ...string) (string, error) {
-	configFile := ensureAWSConfig()
-	cmd := exec.Command("aws", args...)
+func executeAWSCommand(ctx context.Context, sess *session, profile string, args ...string) (string, error) {
+	configFile := ensureAWSConfig(ctx, sess)
+	cmd := exec.CommandContext(ctx, "aws", args...)
 	if configFile != "" {
 		cmd.Env = append(os.Environ(), "AWS_CONFIG_FILE="+configFile)
 	}
 	if profile != "" {
 		cmd.Args = append(cmd.Args, "--profile", profile)
 	}
-	output, err := cmd.CombinedOutput()
+	output, err := runCommand(ctx, cmd)
 	return string(output), err
 }
 
@@ -2104,25 +2547,40 @@ func requireStrings(args map[string]interface{}, keys ...string) ([]string, erro
 	return vals, nil
 }
 
-func handleCallTool(params CallToolParams) CallToolResult {
+func handleCallTool(ctx context.Context, sess *session, params CallToolParams) CallToolResult {
 	var output string
 	var err error
 
+	if isDryRun(ctx, params.Arguments) {
+		return handleDryRun(ctx, sess, params)
+	}
+
+	if async, _ := params.Arguments["async"].(bool); async {
+		jobId, jobErr := startJob(sess, params)
+		if jobErr != nil {
+			return CallToolResult{Content: []ContentItem{{Type: "text", Text: "Error: " + jobErr.Error()}}, IsError: true}
+		}
+		return CallToolResult{Content: []ContentItem{{Type: "text", Text: fmt.Sprintf("Started job %s (%s). Use job_wait or job_status with jobId %q to follow it.", jobId, params.Name, jobId)}}}
+	}
+
 	switch params.Name {
 	case "wb_status":
-		output, err = executeWbCommand([]string{"status"})
+		output, err = executeWbCommand(ctx, []string{"status"})
 	case "wb_workspace_list":
 		args := []string{"workspace", "list"}
 		if format, ok := params.Arguments["format"].(string); ok && format == "json" {
 			args = append(args, "--format=json")
 		}
-		output, err = executeWbCommand(args)
+		output, err = executeWbCommand(ctx, args)
 	case "wb_execute":
 		command, ok := params.Arguments["command"].(string)
 		if !ok {
 			return CallToolResult{Content: []ContentItem{{Type: "text", Text: "Error: 'command' required"}}, IsError: true}
 		}
-		output, err = executeWbCommand(strings.Fields(command))
+		output, err = executeWbCommand(ctx, strings.Fields(command))
+
+	case "workspace_use":
+		output, err = handleWorkspaceUse(ctx, sess, params.Arguments)
 
 	case "workspace_list_all":
 		limit, offset := 100, 0
@@ -2143,7 +2601,7 @@ func handleCallTool(params CallToolParams) CallToolResult {
 			}
 			body["properties"] = propsArray
 		}
-		respBody, apiErr := makeAPIRequest("POST", workspaceBaseURL+"/api/workspaces/v2/filtered", body)
+		respBody, apiErr := makeAPIRequest(ctx, "POST", workspaceBaseURL+"/api/workspaces/v2/filtered", body)
 		if apiErr != nil {
 			err = apiErr
 		} else {
@@ -2169,7 +2627,7 @@ func handleCallTool(params CallToolParams) CallToolResult {
 				{"key": "terra-type", "value": "data-collection"},
 			},
 		}
-		respBody, apiErr := makeAPIRequest("POST", workspaceBaseURL+"/api/workspaces/v2/filtered", body)
+		respBody, apiErr := makeAPIRequest(ctx, "POST", workspaceBaseURL+"/api/workspaces/v2/filtered", body)
 		if apiErr != nil {
 			err = apiErr
 			break
@@ -2201,22 +2659,11 @@ func handleCallTool(params CallToolParams) CallToolResult {
 			}
 			desc, _ := ws["description"].(string)
 
-			// Derive the Workbench UI URL for this data collection
-			// workspaceBaseURL is e.g. https://workbench.verily.com/api/wsm
-			workbenchBaseUI := strings.Replace(workspaceBaseURL, "/api/wsm", "", 1)
-			collectionURL := fmt.Sprintf("%s/data-collections/%s", workbenchBaseUI, userFacingId)
+			// Workbench UI URL for this data collection
+			collectionURL := fmt.Sprintf("%s/data-collections/%s", workbenchUIURL, userFacingId)
 
 			// Extract all terra-* workspace properties into a flat map
-			props := make(map[string]string)
-			if propsArray, ok := ws["properties"].([]interface{}); ok {
-				for _, p := range propsArray {
-					if prop, ok := p.(map[string]interface{}); ok {
-						k, _ := prop["key"].(string)
-						v, _ := prop["value"].(string)
-						props[k] = v
-					}
-				}
-			}
+			props := workspaceProperties(ws)
 
 			// Apply optional keyword filter across name, description, short description,
 			// modality tags, and therapeutic tags so searches like "genomics" or "imaging" work.
@@ -2339,18 +2786,21 @@ func handleCallTool(params CallToolParams) CallToolResult {
 			output = string(resultBytes)
 		}
 
+	case "catalog_search":
+		output, err = handleCatalogSearch(ctx, params.Arguments)
+
 	case "workspace_get":
 		workspaceId, ok := params.Arguments["workspaceId"].(string)
 		if !ok {
 			return CallToolResult{Content: []ContentItem{{Type: "text", Text: "Error: 'workspaceId' required"}}, IsError: true}
 		}
 		// Resolve user-facing ID to UUID
-		workspaceUuid, err := resolveWorkspaceId(workspaceId)
+		workspaceUuid, err := resolveWorkspaceId(ctx, workspaceId)
 		if err != nil {
 			return CallToolResult{Content: []ContentItem{{Type: "text", Text: err.Error()}}, IsError: true}
 		}
 		url := fmt.Sprintf("%s/api/workspaces/v1/%s", workspaceBaseURL, workspaceUuid)
-		respBody, apiErr := makeAPIRequest("GET", url, nil)
+		respBody, apiErr := makeAPIRequest(ctx, "GET", url, nil)
 		if apiErr != nil {
 			err = apiErr
 		} else {
@@ -2371,12 +2821,12 @@ func handleCallTool(params CallToolParams) CallToolResult {
 			limit = int(val)
 		}
 		// Resolve user-facing ID to UUID
-		workspaceUuid, err := resolveWorkspaceId(workspaceId)
+		workspaceUuid, err := resolveWorkspaceId(ctx, workspaceId)
 		if err != nil {
 			return CallToolResult{Content: []ContentItem{{Type: "text", Text: err.Error()}}, IsError: true}
 		}
 		url := fmt.Sprintf("%s/api/workspaces/v1/%s/resources?offset=%d&limit=%d", workspaceBaseURL, workspaceUuid, offset, limit)
-		respBody, apiErr := makeAPIRequest("GET", url, nil)
+		respBody, apiErr := makeAPIRequest(ctx, "GET", url, nil)
 		if apiErr != nil {
 			err = apiErr
 		} else {
@@ -2384,7 +2834,7 @@ func handleCallTool(params CallToolParams) CallToolResult {
 		}
 
 	case "underlay_list":
-		respBody, apiErr := makeAPIRequest("GET", dataExplorerURL+"/v2/underlays", nil)
+		respBody, apiErr := makeAPIRequest(ctx, "GET", dataExplorerURL+"/v2/underlays", nil)
 		if apiErr != nil {
 			err = apiErr
 		} else {
@@ -2397,7 +2847,7 @@ func handleCallTool(params CallToolParams) CallToolResult {
 			return CallToolResult{Content: []ContentItem{{Type: "text", Text: "Error: 'underlayName' required"}}, IsError: true}
 		}
 		url := fmt.Sprintf("%s/v2/underlays/%s", dataExplorerURL, underlayName)
-		respBody, apiErr := makeAPIRequest("GET", url, nil)
+		respBody, apiErr := makeAPIRequest(ctx, "GET", url, nil)
 		if apiErr != nil {
 			err = apiErr
 		} else {
@@ -2410,7 +2860,7 @@ func handleCallTool(params CallToolParams) CallToolResult {
 			return CallToolResult{Content: []ContentItem{{Type: "text", Text: "Error: 'underlayName' required"}}, IsError: true}
 		}
 		url := fmt.Sprintf("%s/v2/underlays/%s/entities", dataExplorerURL, underlayName)
-		respBody, apiErr := makeAPIRequest("GET", url, nil)
+		respBody, apiErr := makeAPIRequest(ctx, "GET", url, nil)
 		if apiErr != nil {
 			err = apiErr
 		} else {
@@ -2427,7 +2877,7 @@ func handleCallTool(params CallToolParams) CallToolResult {
 			return CallToolResult{Content: []ContentItem{{Type: "text", Text: "Error: 'entityName' required"}}, IsError: true}
 		}
 		url := fmt.Sprintf("%s/v2/underlays/%s/entities/%s", dataExplorerURL, underlayName, entityName)
-		respBody, apiErr := makeAPIRequest("GET", url, nil)
+		respBody, apiErr := makeAPIRequest(ctx, "GET", url, nil)
 		if apiErr != nil {
 			err = apiErr
 		} else {
@@ -2441,7 +2891,7 @@ func handleCallTool(params CallToolParams) CallToolResult {
 		}
 		// Get the schema
 		url := fmt.Sprintf("%s/v2/underlays/%s", dataExplorerURL, underlayName)
-		respBody, apiErr := makeAPIRequest("GET", url, nil)
+		respBody, apiErr := makeAPIRequest(ctx, "GET", url, nil)
 		if apiErr != nil {
 			err = apiErr
 			break
@@ -2513,7 +2963,7 @@ func handleCallTool(params CallToolParams) CallToolResult {
 			return CallToolResult{Content: []ContentItem{{Type: "text", Text: "Error: 'entityName' required"}}, IsError: true}
 		}
 		url := fmt.Sprintf("%s/v2/studies/%s/cohorts/%s/entities/%s/hints", dataExplorerURL, studyId, cohortId, entityName)
-		respBody, apiErr := makeAPIRequest("POST", url, map[string]interface{}{})
+		respBody, apiErr := makeAPIRequest(ctx, "POST", url, map[string]interface{}{})
 		if apiErr != nil {
 			err = apiErr
 		} else {
@@ -2538,13 +2988,20 @@ func handleCallTool(params CallToolParams) CallToolResult {
 			body["includeAttributes"] = attrs
 		}
 		if filter, ok := params.Arguments["filter"].(map[string]interface{}); ok {
+			// Check the filter locally so schema mistakes come back with suggestions
+			// instead of an opaque API error.
+			if underlayName, ulErr := cohortUnderlay(ctx, studyId, cohortId); ulErr != nil {
+				fmt.Fprintf(os.Stderr, "Warning: skipping filter validation: %v\n", ulErr)
+			} else if err = checkFilter(ctx, underlayName, entityName, filter); err != nil {
+				break
+			}
 			body["filter"] = filter
 		}
 		if limit, ok := params.Arguments["limit"].(float64); ok {
 			body["limit"] = int(limit)
 		}
 		url := fmt.Sprintf("%s/v2/studies/%s/cohorts/%s/entities/%s/instances", dataExplorerURL, studyId, cohortId, entityName)
-		respBody, apiErr := makeAPIRequest("POST", url, body)
+		respBody, apiErr := makeAPIRequest(ctx, "POST", url, body)
 		if apiErr != nil {
 			err = apiErr
 		} else {
@@ -2560,7 +3017,7 @@ func handleCallTool(params CallToolParams) CallToolResult {
 			limit = int(l)
 		}
 		url := fmt.Sprintf("%s/v2/studies?offset=%d&limit=%d", dataExplorerURL, offset, limit)
-		respBody, apiErr := makeAPIRequest("GET", url, nil)
+		respBody, apiErr := makeAPIRequest(ctx, "GET", url, nil)
 		if apiErr != nil {
 			err = apiErr
 		} else {
@@ -2580,7 +3037,7 @@ func handleCallTool(params CallToolParams) CallToolResult {
 			limit = int(l)
 		}
 		url := fmt.Sprintf("%s/v2/studies/%s/cohorts?offset=%d&limit=%d", dataExplorerURL, studyId, offset, limit)
-		respBody, apiErr := makeAPIRequest("GET", url, nil)
+		respBody, apiErr := makeAPIRequest(ctx, "GET", url, nil)
 		if apiErr != nil {
 			err = apiErr
 		} else {
@@ -2588,114 +3045,7 @@ func handleCallTool(params CallToolParams) CallToolResult {
 		}
 
 	case "cohort_create_in_workspace":
-		workspaceId, ok := params.Arguments["workspaceId"].(string)
-		if !ok {
-			return CallToolResult{Content: []ContentItem{{Type: "text", Text: "Error: 'workspaceId' required"}}, IsError: true}
-		}
-		_, ok = params.Arguments["underlayId"].(string) // underlayId kept for validation but not used
-		if !ok {
-			return CallToolResult{Content: []ContentItem{{Type: "text", Text: "Error: 'underlayId' required"}}, IsError: true}
-		}
-		underlayName, ok := params.Arguments["underlayName"].(string)
-		if !ok {
-			return CallToolResult{Content: []ContentItem{{Type: "text", Text: "Error: 'underlayName' required"}}, IsError: true}
-		}
-		name, ok := params.Arguments["name"].(string)
-		if !ok {
-			return CallToolResult{Content: []ContentItem{{Type: "text", Text: "Error: 'name' required"}}, IsError: true}
-		}
-		displayName := name
-		if dn, ok := params.Arguments["displayName"].(string); ok {
-			displayName = dn
-		}
-		description := ""
-		if desc, ok := params.Arguments["description"].(string); ok {
-			description = desc
-		}
-
-		// Step 1: Create cohort in Data Explorer
-		createBody := map[string]interface{}{
-			"studyCreateInfo": map[string]interface{}{
-				"displayName": displayName + " Study",
-			},
-			"cohortCreateInfo": map[string]interface{}{
-				"underlayName": underlayName,
-				"displayName":  displayName,
-				"description":  description,
-			},
-		}
-		createResp, apiErr := makeAPIRequest("POST", dataExplorerURL+"/v2/createCohortInStudy", createBody)
-		if apiErr != nil {
-			err = fmt.Errorf("Step 1 failed (create cohort): %w", apiErr)
-			break
-		}
-
-		// Parse response to get studyId and cohortId
-		var createResult map[string]interface{}
-		if err := json.Unmarshal(createResp, &createResult); err != nil {
-			return CallToolResult{Content: []ContentItem{{Type: "text", Text: fmt.Sprintf("Error parsing create response: %v", err)}}, IsError: true}
-		}
-		study, _ := createResult["study"].(map[string]interface{})
-		cohort, _ := createResult["cohort"].(map[string]interface{})
-		studyId, _ := study["id"].(string)
-		cohortId, _ := cohort["id"].(string)
-
-		// Step 2: Update criteria if provided
-		if criteriaJson, ok := params.Arguments["criteriaJson"].(string); ok && criteriaJson != "" {
-			var updateBody interface{}
-			if unmarshalErr := json.Unmarshal([]byte(criteriaJson), &updateBody); unmarshalErr != nil {
-				err = fmt.Errorf("Step 2 failed (parse criteria): %w", unmarshalErr)
-				break
-			}
-			_, apiErr = makeAPIRequest("PATCH", fmt.Sprintf("%s/v2/studies/%s/cohorts/%s", dataExplorerURL, studyId, cohortId), updateBody)
-			if apiErr != nil {
-				err = fmt.Errorf("Step 2 failed (update criteria): %w", apiErr)
-				break
-			}
-		}
-
-		// Step 3: Save cohort to workspace
-		// Resolve user-facing ID to UUID
-		workspaceUuid, err := resolveWorkspaceId(workspaceId)
-		if err != nil {
-			return CallToolResult{Content: []ContentItem{{Type: "text", Text: fmt.Sprintf("Step 3 failed: %v", err)}}, IsError: true}
-		}
-
-		saveBody := map[string]interface{}{
-			"common": map[string]interface{}{
-				"displayName":         displayName,
-				"description":         description,
-				"accessScope":         "SHARED_ACCESS",
-				"managedBy":           "USER",
-				"cloningInstructions": "COPY_RESOURCE",
-			},
-			"dataExplorerCohort": map[string]interface{}{
-				"studyId":  studyId,
-				"cohortId": cohortId,
-			},
-		}
-		if folderId, ok := params.Arguments["folderId"].(string); ok {
-			saveBody["common"].(map[string]interface{})["folderId"] = folderId
-		}
-		saveUrl := fmt.Sprintf("%s/api/workspaces/v1/%s/resources/controlled/data-explorer/cohort/save", workspaceBaseURL, workspaceUuid)
-		respBody, apiErr := makeAPIRequest("POST", saveUrl, saveBody)
-		if apiErr != nil {
-			err = fmt.Errorf("Step 3 failed (save to workspace): %w", apiErr)
-		} else {
-			// Parse workspace response and add studyId/cohortId at top level for easy extraction
-			var workspaceResp map[string]interface{}
-			if err := json.Unmarshal(respBody, &workspaceResp); err == nil {
-				workspaceResp["studyId"] = studyId
-				workspaceResp["cohortId"] = cohortId
-				if modifiedResp, err := json.Marshal(workspaceResp); err == nil {
-					output = string(modifiedResp)
-				} else {
-					output = string(respBody)
-				}
-			} else {
-				output = string(respBody)
-			}
-		}
+		output, err = handleCohortCreateInWorkspace(ctx, params.Arguments)
 
 	case "cohort_update_criteria":
 		studyId, ok := params.Arguments["studyId"].(string)
@@ -2716,14 +3066,25 @@ func handleCallTool(params CallToolParams) CallToolResult {
 		if description, ok := params.Arguments["description"].(string); ok {
 			body["description"] = description
 		}
-		url := fmt.Sprintf("%s/v2/studies/%s/cohorts/%s", dataExplorerURL, studyId, cohortId)
-		respBody, apiErr := makeAPIRequest("PATCH", url, body)
+		respBody, apiErr := updateCohort(ctx, studyId, cohortId, body, "before cohort_update_criteria")
 		if apiErr != nil {
 			err = apiErr
 		} else {
 			output = string(respBody)
 		}
 
+	case "cohort_history":
+		output, err = handleCohortHistory(ctx, params.Arguments)
+
+	case "cohort_diff":
+		output, err = handleCohortDiff(ctx, params.Arguments)
+
+	case "cohort_revert":
+		output, err = handleCohortRevert(ctx, params.Arguments)
+
+	case "cohort_attrition":
+		output, err = handleCohortAttrition(ctx, params.Arguments)
+
 	case "cohort_count_instances":
 		studyId, ok := params.Arguments["studyId"].(string)
 		if !ok {
@@ -2741,7 +3102,7 @@ func handleCallTool(params CallToolParams) CallToolResult {
 			body["groupByAttributes"] = attrs
 		}
 		url := fmt.Sprintf("%s/v2/studies/%s/cohorts/%s/counts", dataExplorerURL, studyId, cohortId)
-		respBody, apiErr := makeAPIRequest("POST", url, body)
+		respBody, apiErr := makeAPIRequest(ctx, "POST", url, body)
 		if apiErr != nil {
 			err = apiErr
 		} else {
@@ -2754,7 +3115,7 @@ func handleCallTool(params CallToolParams) CallToolResult {
 			return CallToolResult{Content: []ContentItem{{Type: "text", Text: "Error: 'underlayName' required"}}, IsError: true}
 		}
 		url := fmt.Sprintf("%s/v2/underlays/%s/exportModels", dataExplorerURL, underlayName)
-		respBody, apiErr := makeAPIRequest("GET", url, nil)
+		respBody, apiErr := makeAPIRequest(ctx, "GET", url, nil)
 		if apiErr != nil {
 			err = apiErr
 		} else {
@@ -2775,7 +3136,7 @@ func handleCallTool(params CallToolParams) CallToolResult {
 			body["allCriteriaFromCohort"] = allCriteria
 		}
 		url := fmt.Sprintf("%s/v2/studies/%s/cohorts/%s/describeExport", dataExplorerURL, studyId, cohortId)
-		respBody, apiErr := makeAPIRequest("POST", url, body)
+		respBody, apiErr := makeAPIRequest(ctx, "POST", url, body)
 		if apiErr != nil {
 			err = apiErr
 		} else {
@@ -2807,7 +3168,7 @@ func handleCallTool(params CallToolParams) CallToolResult {
 			body["inputs"] = inputs
 		}
 		url := fmt.Sprintf("%s/v2/studies/%s/cohorts/%s/previewExport", dataExplorerURL, studyId, cohortId)
-		respBody, apiErr := makeAPIRequest("POST", url, body)
+		respBody, apiErr := makeAPIRequest(ctx, "POST", url, body)
 		if apiErr != nil {
 			err = apiErr
 		} else {
@@ -2831,113 +3192,36 @@ func handleCallTool(params CallToolParams) CallToolResult {
 			"exportRequests": exportRequests,
 		}
 		url := fmt.Sprintf("%s/v2/studies/%s/cohorts/%s/export", dataExplorerURL, studyId, cohortId)
-		respBody, apiErr := makeAPIRequest("POST", url, body)
+		respBody, apiErr := makeAPIRequest(ctx, "POST", url, body)
 		if apiErr != nil {
 			err = apiErr
 		} else {
 			output = string(respBody)
 		}
 
+	case "export_cohort_to_files":
+		output, err = handleExportCohortToFiles(ctx, sess, params.Arguments)
+
 	case "filter_build_attribute":
-		attribute, ok := params.Arguments["attribute"].(string)
-		if !ok {
-			return CallToolResult{Content: []ContentItem{{Type: "text", Text: "Error: 'attribute' required"}}, IsError: true}
-		}
-		operator, ok := params.Arguments["operator"].(string)
-		if !ok {
-			return CallToolResult{Content: []ContentItem{{Type: "text", Text: "Error: 'operator' required"}}, IsError: true}
-		}
-		dataType, ok := params.Arguments["dataType"].(string)
-		if !ok {
-			return CallToolResult{Content: []ContentItem{{Type: "text", Text: "Error: 'dataType' required"}}, IsError: true}
-		}
-		filter := map[string]interface{}{
-			"filterType": "ATTRIBUTE",
-			"filterUnion": map[string]interface{}{
-				"attributeFilter": map[string]interface{}{
-					"attribute": attribute,
-					"operator":  operator,
-				},
-			},
-		}
-		if operator != "IS_NULL" && operator != "IS_NOT_NULL" {
-			values := []interface{}{}
-			if val, ok := params.Arguments["value"]; ok {
-				values = append(values, buildLiteral(dataType, val))
-			}
-			if vals, ok := params.Arguments["values"].([]interface{}); ok {
-				for _, v := range vals {
-					values = append(values, buildLiteral(dataType, v))
-				}
-			}
-			filter["filterUnion"].(map[string]interface{})["attributeFilter"].(map[string]interface{})["values"] = values
-		}
-		outputBytes, _ := json.MarshalIndent(filter, "", "  ")
-		output = string(outputBytes)
+		output, err = buildAttributeFilter(ctx, params.Arguments)
 
 	case "filter_build_relationship":
-		relatedEntity, ok := params.Arguments["relatedEntity"].(string)
-		if !ok {
-			return CallToolResult{Content: []ContentItem{{Type: "text", Text: "Error: 'relatedEntity' required"}}, IsError: true}
-		}
-		filter := map[string]interface{}{
-			"filterType": "RELATIONSHIP",
-			"filterUnion": map[string]interface{}{
-				"relationshipFilter": map[string]interface{}{
-					"entity": relatedEntity,
-				},
-			},
-		}
-		if subfilter, ok := params.Arguments["subfilter"].(map[string]interface{}); ok {
-			filter["filterUnion"].(map[string]interface{})["relationshipFilter"].(map[string]interface{})["subfilter"] = subfilter
-		}
-		outputBytes, _ := json.MarshalIndent(filter, "", "  ")
-		output = string(outputBytes)
+		output, err = buildRelationshipFilter(ctx, params.Arguments)
 
 	case "filter_build_boolean_logic":
-		operator, ok := params.Arguments["operator"].(string)
-		if !ok {
-			return CallToolResult{Content: []ContentItem{{Type: "text", Text: "Error: 'operator' required"}}, IsError: true}
-		}
-		subfilters, ok := params.Arguments["subfilters"].([]interface{})
-		if !ok {
-			return CallToolResult{Content: []ContentItem{{Type: "text", Text: "Error: 'subfilters' required"}}, IsError: true}
-		}
-		filter := map[string]interface{}{
-			"filterType": "BOOLEAN_LOGIC",
-			"filterUnion": map[string]interface{}{
-				"booleanLogicFilter": map[string]interface{}{
-					"operator":   operator,
-					"subfilters": subfilters,
-				},
-			},
-		}
-		outputBytes, _ := json.MarshalIndent(filter, "", "  ")
-		output = string(outputBytes)
+		output, err = buildBooleanLogicFilter(params.Arguments)
 
 	case "filter_build_hierarchy":
-		hierarchy, ok := params.Arguments["hierarchy"].(string)
-		if !ok {
-			return CallToolResult{Content: []ContentItem{{Type: "text", Text: "Error: 'hierarchy' required"}}, IsError: true}
-		}
-		operator, ok := params.Arguments["operator"].(string)
-		if !ok {
-			return CallToolResult{Content: []ContentItem{{Type: "text", Text: "Error: 'operator' required"}}, IsError: true}
-		}
-		filter := map[string]interface{}{
-			"filterType": "HIERARCHY",
-			"filterUnion": map[string]interface{}{
-				"hierarchyFilter": map[string]interface{}{
-					"hierarchy": hierarchy,
-					"operator":  operator,
-				},
-			},
-		}
-		if values, ok := params.Arguments["values"].([]interface{}); ok {
-			filter["filterUnion"].(map[string]interface{})["hierarchyFilter"].(map[string]interface{})["values"] = values
-		}
-		outputBytes, _ := json.MarshalIndent(filter, "", "  ")
-		output = string(outputBytes)
+		output, err = buildHierarchyFilter(ctx, params.Arguments)
+
+	case "concept_search":
+		output, err = handleConceptSearch(ctx, params.Arguments)
+
+	case "filter_validate":
+		output, err = handleFilterValidate(ctx, params.Arguments)
+
+	case "cohort_compile_criteria":
+		output, err = handleCohortCompileCriteria(ctx, params.Arguments)
 
 	case "workspace_create":
 		vals, reqErr := requireStrings(params.Arguments, "id", "podId")
@@ -2955,14 +3239,14 @@ func handleCallTool(params CallToolParams) CallToolResult {
 		if orgId, ok := params.Arguments["organizationId"].(string); ok {
 			args = append(args, "--org="+orgId)
 		}
-		output, err = executeWbCommand(args)
+		output, err = executeWbCommand(ctx, args)
 
 	case "workspace_delete":
 		workspaceId, reqErr := requireString(params.Arguments, "workspaceId")
 		if reqErr != nil {
 			return CallToolResult{Content: []ContentItem{{Type: "text", Text: "Error: " + reqErr.Error()}}, IsError: true}
 		}
-		output, err = executeWbCommand([]string{"workspace", "delete", "--workspace=" + workspaceId})
+		output, err = executeWbCommand(ctx, []string{"workspace", "delete", "--workspace=" + workspaceId})
 
 	case "workspace_update":
 		workspaceId, reqErr := requireString(params.Arguments, "workspaceId")
@@ -2976,7 +3260,13 @@ func handleCallTool(params CallToolParams) CallToolResult {
 		if desc, ok := params.Arguments["description"].(string); ok {
 			args = append(args, "--description="+desc)
 		}
-		output, err = executeWbCommand(args)
+		output, err = executeWbCommand(ctx, args)
+
+	case "workspace_apply":
+		output, err = handleWorkspaceApply(ctx, params.Arguments)
+
+	case "workspace_export_manifest":
+		output, err = handleWorkspaceExportManifest(ctx, sess, params.Arguments)
 
 	case "workspace_duplicate":
 		vals, reqErr := requireStrings(params.Arguments, "sourceWorkspaceId", "destWorkspaceId")
@@ -2988,42 +3278,42 @@ func handleCallTool(params CallToolParams) CallToolResult {
 		if name, ok := params.Arguments["name"].(string); ok {
 			args = append(args, "--name="+name)
 		}
-		output, err = executeWbCommand(args)
+		output, err = executeWbCommand(ctx, args)
 
 	case "workspace_set_property":
 		vals, reqErr := requireStrings(params.Arguments, "workspaceId", "key", "value")
 		if reqErr != nil {
 			return CallToolResult{Content: []ContentItem{{Type: "text", Text: "Error: " + reqErr.Error()}}, IsError: true}
 		}
-		output, err = executeWbCommand([]string{"workspace", "set-property", "--workspace=" + vals[0], "--key=" + vals[1], "--value=" + vals[2]})
+		output, err = executeWbCommand(ctx, []string{"workspace", "set-property", "--workspace=" + vals[0], "--key=" + vals[1], "--value=" + vals[2]})
 
 	case "workspace_delete_property":
 		vals, reqErr := requireStrings(params.Arguments, "workspaceId", "key")
 		if reqErr != nil {
 			return CallToolResult{Content: []ContentItem{{Type: "text", Text: "Error: " + reqErr.Error()}}, IsError: true}
 		}
-		output, err = executeWbCommand([]string{"workspace", "delete-property", "--workspace=" + vals[0], "--key=" + vals[1]})
+		output, err = executeWbCommand(ctx, []string{"workspace", "delete-property", "--workspace=" + vals[0], "--key=" + vals[1]})
 
 	case "workspace_add_user":
 		vals, reqErr := requireStrings(params.Arguments, "workspaceId", "email", "role")
 		if reqErr != nil {
 			return CallToolResult{Content: []ContentItem{{Type: "text", Text: "Error: " + reqErr.Error()}}, IsError: true}
 		}
-		output, err = executeWbCommand([]string{"workspace", "add-user", "--workspace=" + vals[0], "--email=" + vals[1], "--role=" + vals[2]})
+		output, err = executeWbCommand(ctx, []string{"workspace", "add-user", "--workspace=" + vals[0], "--email=" + vals[1], "--role=" + vals[2]})
 
 	case "workspace_remove_user":
 		vals, reqErr := requireStrings(params.Arguments, "workspaceId", "email")
 		if reqErr != nil {
 			return CallToolResult{Content: []ContentItem{{Type: "text", Text: "Error: " + reqErr.Error()}}, IsError: true}
 		}
-		output, err = executeWbCommand([]string{"workspace", "remove-user", "--workspace=" + vals[0], "--email=" + vals[1]})
+		output, err = executeWbCommand(ctx, []string{"workspace", "remove-user", "--workspace=" + vals[0], "--email=" + vals[1]})
 
 	case "workspace_list_users":
 		workspaceId, reqErr := requireString(params.Arguments, "workspaceId")
 		if reqErr != nil {
 			return CallToolResult{Content: []ContentItem{{Type: "text", Text: "Error: " + reqErr.Error()}}, IsError: true}
 		}
-		output, err = executeWbCommand([]string{"workspace", "list-users", "--workspace=" + workspaceId})
+		output, err = executeWbCommand(ctx, []string{"workspace", "list-users", "--workspace=" + workspaceId})
 
 	case "resource_create_bucket":
 		vals, reqErr := requireStrings(params.Arguments, "resourceId", "bucketName")
@@ -3035,7 +3325,7 @@ func handleCallTool(params CallToolParams) CallToolResult {
 		if desc, ok := params.Arguments["description"].(string); ok {
 			args = append(args, "--description="+desc)
 		}
-		output, err = executeWbCommand(args)
+		output, err = executeWbCommand(ctx, sess.wbArgs(args...))
 
 	case "resource_create_bq_dataset":
 		vals, reqErr := requireStrings(params.Arguments, "resourceId", "datasetId")
@@ -3047,14 +3337,14 @@ func handleCallTool(params CallToolParams) CallToolResult {
 		if desc, ok := params.Arguments["description"].(string); ok {
 			args = append(args, "--description="+desc)
 		}
-		output, err = executeWbCommand(args)
+		output, err = executeWbCommand(ctx, sess.wbArgs(args...))
 
 	case "resource_delete":
 		resourceId, reqErr := requireString(params.Arguments, "resourceId")
 		if reqErr != nil {
 			return CallToolResult{Content: []ContentItem{{Type: "text", Text: "Error: " + reqErr.Error()}}, IsError: true}
 		}
-		output, err = executeWbCommand([]string{"resource", "delete", "--name=" + resourceId})
+		output, err = executeWbCommand(ctx, sess.wbArgs("resource", "delete", "--name="+resourceId))
 
 	case "resource_update":
 		resourceId, reqErr := requireString(params.Arguments, "resourceId")
@@ -3068,7 +3358,7 @@ func handleCallTool(params CallToolParams) CallToolResult {
 		if desc, ok := params.Arguments["description"].(string); ok {
 			args = append(args, "--description="+desc)
 		}
-		output, err = executeWbCommand(args)
+		output, err = executeWbCommand(ctx, sess.wbArgs(args...))
 
 	case "resource_add_reference":
 		vals, reqErr := requireStrings(params.Arguments, "resourceId", "resourceType", "path")
@@ -3080,21 +3370,21 @@ func handleCallTool(params CallToolParams) CallToolResult {
 		if desc, ok := params.Arguments["description"].(string); ok {
 			args = append(args, "--description="+desc)
 		}
-		output, err = executeWbCommand(args)
+		output, err = executeWbCommand(ctx, sess.wbArgs(args...))
 
 	case "resource_check_access":
 		resourceId, reqErr := requireString(params.Arguments, "resourceId")
 		if reqErr != nil {
 			return CallToolResult{Content: []ContentItem{{Type: "text", Text: "Error: " + reqErr.Error()}}, IsError: true}
 		}
-		output, err = executeWbCommand([]string{"resource", "check-access", "--name=" + resourceId})
+		output, err = executeWbCommand(ctx, sess.wbArgs("resource", "check-access", "--name="+resourceId))
 
 	case "resource_move":
 		vals, reqErr := requireStrings(params.Arguments, "resourceId", "folderId")
 		if reqErr != nil {
 			return CallToolResult{Content: []ContentItem{{Type: "text", Text: "Error: " + reqErr.Error()}}, IsError: true}
 		}
-		output, err = executeWbCommand([]string{"resource", "move", "--name=" + vals[0], "--folder-id=" + vals[1]})
+		output, err = executeWbCommand(ctx, sess.wbArgs("resource", "move", "--name="+vals[0], "--folder-id="+vals[1]))
 
 	case "folder_create":
 		vals, reqErr := requireStrings(params.Arguments, "folderId", "displayName")
@@ -3109,14 +3399,14 @@ func handleCallTool(params CallToolParams) CallToolResult {
 		if parentId, ok := params.Arguments["parentId"].(string); ok {
 			args = append(args, "--parent-folder-id="+parentId)
 		}
-		output, err = executeWbCommand(args)
+		output, err = executeWbCommand(ctx, sess.wbArgs(args...))
 
 	case "folder_delete":
 		folderId, reqErr := requireString(params.Arguments, "folderId")
 		if reqErr != nil {
 			return CallToolResult{Content: []ContentItem{{Type: "text", Text: "Error: " + reqErr.Error()}}, IsError: true}
 		}
-		output, err = executeWbCommand([]string{"folder", "delete", "--id=" + folderId})
+		output, err = executeWbCommand(ctx, sess.wbArgs("folder", "delete", "--id="+folderId))
 
 	case "folder_update":
 		folderId, reqErr := requireString(params.Arguments, "folderId")
@@ -3130,23 +3420,23 @@ func handleCallTool(params CallToolParams) CallToolResult {
 		if desc, ok := params.Arguments["description"].(string); ok {
 			args = append(args, "--description="+desc)
 		}
-		output, err = executeWbCommand(args)
+		output, err = executeWbCommand(ctx, sess.wbArgs(args...))
 
 	case "folder_list_tree":
-		output, err = executeWbCommand([]string{"folder", "tree"})
+		output, err = executeWbCommand(ctx, sess.wbArgs("folder", "tree"))
 
 	case "workspace_list_data_collections":
 		var workspaceUuid string
 		var uuidErr error
-		workspaceUuid, uuidErr = getCurrentWorkspaceUUID()
+		workspaceUuid, uuidErr = sess.resolveWorkspaceUUID(ctx)
 		if uuidErr != nil {
-			output = fmt.Sprintf("Could not determine active workspace: %v\n\nTo fix: run `wb workspace set --id=<workspace-id>` in your terminal, then retry.", uuidErr)
+			output = fmt.Sprintf("Could not determine active workspace: %v\n\nTo fix: call workspace_use with a workspace ID, or run `wb workspace set --id=<workspace-id>` in your terminal, then retry.", uuidErr)
 			break
 		}
 
 		// List all resources (same API call as workspace_list_resources which works)
 		resourcesUrl := fmt.Sprintf("%s/api/workspaces/v1/%s/resources?offset=0&limit=1000", workspaceBaseURL, workspaceUuid)
-		resourcesResp, apiErr := makeAPIRequest("GET", resourcesUrl, nil)
+		resourcesResp, apiErr := makeAPIRequest(ctx, "GET", resourcesUrl, nil)
 		if apiErr != nil {
 			err = fmt.Errorf("failed to list resources via API: %w", apiErr)
 			break
@@ -3173,7 +3463,7 @@ func handleCallTool(params CallToolParams) CallToolResult {
 				{"key": "terra-type", "value": "data-collection"},
 			},
 		}
-		if batchResp, batchErr := makeAPIRequest("POST", workspaceBaseURL+"/api/workspaces/v2/filtered", batchBody); batchErr == nil {
+		if batchResp, batchErr := makeAPIRequest(ctx, "POST", workspaceBaseURL+"/api/workspaces/v2/filtered", batchBody); batchErr == nil {
 			var batchData map[string]interface{}
 			if json.Unmarshal(batchResp, &batchData) == nil {
 				if wsList, ok := batchData["workspaces"].([]interface{}); ok {
@@ -3294,38 +3584,41 @@ func handleCallTool(params CallToolParams) CallToolResult {
 		if desc, ok := params.Arguments["description"].(string); ok {
 			args = append(args, "--description="+desc)
 		}
-		output, err = executeWbCommand(args)
+		output, err = executeWbCommand(ctx, args)
 
 	case "group_delete":
 		groupId, reqErr := requireString(params.Arguments, "groupId")
 		if reqErr != nil {
 			return CallToolResult{Content: []ContentItem{{Type: "text", Text: "Error: " + reqErr.Error()}}, IsError: true}
 		}
-		output, err = executeWbCommand([]string{"group", "delete", "--id=" + groupId})
+		output, err = executeWbCommand(ctx, []string{"group", "delete", "--id=" + groupId})
 
 	case "group_list":
-		output, err = executeWbCommand([]string{"group", "list"})
+		output, err = executeWbCommand(ctx, []string{"group", "list"})
 
 	case "group_describe":
 		groupId, reqErr := requireString(params.Arguments, "groupId")
 		if reqErr != nil {
 			return CallToolResult{Content: []ContentItem{{Type: "text", Text: "Error: " + reqErr.Error()}}, IsError: true}
 		}
-		output, err = executeWbCommand([]string{"group", "describe", "--id=" + groupId})
+		output, err = executeWbCommand(ctx, []string{"group", "describe", "--id=" + groupId})
 
 	case "group_add_user":
 		vals, reqErr := requireStrings(params.Arguments, "groupId", "email", "role")
 		if reqErr != nil {
 			return CallToolResult{Content: []ContentItem{{Type: "text", Text: "Error: " + reqErr.Error()}}, IsError: true}
 		}
-		output, err = executeWbCommand([]string{"group", "member", "add", "--group-id=" + vals[0], "--email=" + vals[1], "--role=" + vals[2]})
+		output, err = executeWbCommand(ctx, []string{"group", "member", "add", "--group-id=" + vals[0], "--email=" + vals[1], "--role=" + vals[2]})
 
 	case "group_remove_user":
 		vals, reqErr := requireStrings(params.Arguments, "groupId", "email")
 		if reqErr != nil {
 			return CallToolResult{Content: []ContentItem{{Type: "text", Text: "Error: " + reqErr.Error()}}, IsError: true}
 		}
-		output, err = executeWbCommand([]string{"group", "member", "remove", "--group-id=" + vals[0], "--email=" + vals[1]})
+		output, err = executeWbCommand(ctx, []string{"group", "member", "remove", "--group-id=" + vals[0], "--email=" + vals[1]})
+
+	case "access_review":
+		output, err = handleAccessReview(ctx, params.Arguments)
 
 	case "app_create":
 		vals, reqErr := requireStrings(params.Arguments, "appId", "appConfig")
@@ -3343,95 +3636,106 @@ func handleCallTool(params CallToolParams) CallToolResult {
 		if location, ok := params.Arguments["location"].(string); ok {
 			args = append(args, "--location="+location)
 		}
-		output, err = executeWbCommand(args)
+		output, err = executeWbCommand(ctx, sess.wbArgs(args...))
 
 	case "app_delete":
 		appId, reqErr := requireString(params.Arguments, "appId")
 		if reqErr != nil {
 			return CallToolResult{Content: []ContentItem{{Type: "text", Text: "Error: " + reqErr.Error()}}, IsError: true}
 		}
-		output, err = executeWbCommand([]string{"app", "delete", "--id=" + appId, "--quiet"})
+		output, err = executeWbCommand(ctx, sess.wbArgs("app", "delete", "--id="+appId, "--quiet"))
 
 	case "app_list":
-		output, err = executeWbCommand([]string{"app", "list"})
+		output, err = executeWbCommand(ctx, sess.wbArgs("app", "list"))
+
+	case "workspace_cost_summary":
+		output, err = handleWorkspaceCostSummary(ctx, sess, params.Arguments)
 
 	case "app_start":
-		appId, reqErr := requireString(params.Arguments, "appId")
-		if reqErr != nil {
-			return CallToolResult{Content: []ContentItem{{Type: "text", Text: "Error: " + reqErr.Error()}}, IsError: true}
-		}
-		output, err = executeWbCommand([]string{"app", "start", "--id=" + appId})
+		output, err = handleStart(ctx, sess, "app", "appId", params.Arguments)
 
 	case "app_stop":
 		appId, reqErr := requireString(params.Arguments, "appId")
 		if reqErr != nil {
 			return CallToolResult{Content: []ContentItem{{Type: "text", Text: "Error: " + reqErr.Error()}}, IsError: true}
 		}
-		output, err = executeWbCommand([]string{"app", "stop", "--id=" + appId})
+		output, err = executeWbCommand(ctx, sess.wbArgs("app", "stop", "--id="+appId))
 
 	case "app_get_url":
 		appId, reqErr := requireString(params.Arguments, "appId")
 		if reqErr != nil {
 			return CallToolResult{Content: []ContentItem{{Type: "text", Text: "Error: " + reqErr.Error()}}, IsError: true}
 		}
-		output, err = executeWbCommand([]string{"app", "launch", "--id=" + appId})
+		output, err = executeWbCommand(ctx, sess.wbArgs("app", "launch", "--id="+appId))
 
 	case "auth_status":
-		output, err = executeWbCommand([]string{"auth", "status"})
+		output, err = executeWbCommand(ctx, []string{"auth", "status"})
 
 	case "server_list":
-		output, err = executeWbCommand([]string{"server", "list"})
+		output, err = executeWbCommand(ctx, []string{"server", "list"})
 
 	case "server_set":
 		serverName, reqErr := requireString(params.Arguments, "serverName")
 		if reqErr != nil {
 			return CallToolResult{Content: []ContentItem{{Type: "text", Text: "Error: " + reqErr.Error()}}, IsError: true}
 		}
-		output, err = executeWbCommand([]string{"server", "set", "--name=" + serverName})
+		output, err = executeWbCommand(ctx, []string{"server", "set", "--name=" + serverName})
 
 	case "server_status":
-		output, err = executeWbCommand([]string{"server", "status"})
+		output, err = executeWbCommand(ctx, []string{"server", "status"})
+
+	case "server_config":
+		output, err = handleServerConfig()
+
+	case "job_status":
+		output, err = handleJobStatus(params.Arguments)
+
+	case "job_list":
+		output, err = handleJobList(params.Arguments)
+
+	case "job_wait":
+		output, err = handleJobWait(params.Arguments)
 
 	case "server_list_regions":
 		cloudPlatform, reqErr := requireString(params.Arguments, "cloudPlatform")
 		if reqErr != nil {
 			return CallToolResult{Content: []ContentItem{{Type: "text", Text: "Error: " + reqErr.Error()}}, IsError: true}
 		}
-		output, err = executeWbCommand([]string{"server", "list-regions", "--platform=" + cloudPlatform})
+		output, err = executeWbCommand(ctx, []string{"server", "list-regions", "--platform=" + cloudPlatform})
 
 	case "pod_list":
-		output, err = executeWbCommand([]string{"pod", "list"})
+		output, err = executeWbCommand(ctx, []string{"pod", "list"})
 
 	case "pod_describe":
 		podId, reqErr := requireString(params.Arguments, "podId")
 		if reqErr != nil {
 			return CallToolResult{Content: []ContentItem{{Type: "text", Text: "Error: " + reqErr.Error()}}, IsError: true}
 		}
-		output, err = executeWbCommand([]string{"pod", "describe", "--id=" + podId})
+		output, err = executeWbCommand(ctx, []string{"pod", "describe", "--id=" + podId})
 
 	case "pod_role_list":
 		vals, reqErr := requireStrings(params.Arguments, "organizationId", "podId")
 		if reqErr != nil {
 			return CallToolResult{Content: []ContentItem{{Type: "text", Text: "Error: " + reqErr.Error()}}, IsError: true}
 		}
-		output, err = executeWbCommand([]string{"pod", "role", "list", "--organization=" + vals[0], "--pod=" + vals[1]})
+		output, err = executeWbCommand(ctx, []string{"pod", "role", "list", "--organization=" + vals[0], "--pod=" + vals[1]})
 
 	case "pod_role_grant":
 		vals, reqErr := requireStrings(params.Arguments, "organizationId", "podId", "email", "role")
 		if reqErr != nil {
 			return CallToolResult{Content: []ContentItem{{Type: "text", Text: "Error: " + reqErr.Error()}}, IsError: true}
 		}
-		output, err = executeWbCommand([]string{"pod", "role", "grant", "user", "--organization=" + vals[0], "--pod=" + vals[1], "--email=" + vals[2], "--role=" + vals[3]})
+		output, err = executeWbCommand(ctx, []string{"pod", "role", "grant", "user", "--organization=" + vals[0], "--pod=" + vals[1], "--email=" + vals[2], "--role=" + vals[3]})
 
 	case "pod_role_revoke":
 		vals, reqErr := requireStrings(params.Arguments, "organizationId", "podId", "email", "role")
 		if reqErr != nil {
 			return CallToolResult{Content: []ContentItem{{Type: "text", Text: "Error: " + reqErr.Error()}}, IsError: true}
 		}
-		output, err = executeWbCommand([]string{"pod", "role", "revoke", "user", "--organization=" + vals[0], "--pod=" + vals[1], "--email=" + vals[2], "--role=" + vals[3]})
+		output, err = executeWbCommand(ctx, []string{"pod", "role", "revoke", "user", "--organization=" + vals[0], "--pod=" + vals[1], "--email=" + vals[2], "--role=" + vals[3]})
 
 	case "organization_list":
-		output, err = executeWbCommand([]string{"organization", "list"})
+		output, err = executeWbCommand(ctx, []string{"organization", "list"})
 
 	case "resource_credentials":
 		resourceId, reqErr := requireString(params.Arguments, "resourceId")
@@ -3442,72 +3746,64 @@ func handleCallTool(params CallToolParams) CallToolResult {
 		if duration, ok := params.Arguments["duration"].(float64); ok {
 			args = append(args, fmt.Sprintf("--duration=%d", int(duration)))
 		}
-		output, err = executeWbCommand(args)
+		output, err = executeWbCommand(ctx, sess.wbArgs(args...))
 
 	case "resource_open_console":
 		resourceId, reqErr := requireString(params.Arguments, "resourceId")
 		if reqErr != nil {
 			return CallToolResult{Content: []ContentItem{{Type: "text", Text: "Error: " + reqErr.Error()}}, IsError: true}
 		}
-		output, err = executeWbCommand([]string{"resource", "open-console", "--name=" + resourceId})
+		output, err = executeWbCommand(ctx, sess.wbArgs("resource", "open-console", "--name="+resourceId))
 
 	case "resource_list_tree":
-		output, err = executeWbCommand([]string{"resource", "list-tree"})
+		output, err = executeWbCommand(ctx, sess.wbArgs("resource", "list-tree"))
 
 	case "resource_mount":
-		output, err = executeWbCommand([]string{"resource", "mount"})
+		output, err = executeWbCommand(ctx, sess.wbArgs("resource", "mount"))
 
 	case "resource_unmount":
-		output, err = executeWbCommand([]string{"resource", "unmount"})
+		output, err = executeWbCommand(ctx, sess.wbArgs("resource", "unmount"))
 
 	case "notebook_start":
-		notebookId, reqErr := requireString(params.Arguments, "notebookId")
-		if reqErr != nil {
-			return CallToolResult{Content: []ContentItem{{Type: "text", Text: "Error: " + reqErr.Error()}}, IsError: true}
-		}
-		output, err = executeWbCommand([]string{"notebook", "start", "--id=" + notebookId})
+		output, err = handleStart(ctx, sess, "notebook", "notebookId", params.Arguments)
 
 	case "notebook_stop":
 		notebookId, reqErr := requireString(params.Arguments, "notebookId")
 		if reqErr != nil {
 			return CallToolResult{Content: []ContentItem{{Type: "text", Text: "Error: " + reqErr.Error()}}, IsError: true}
 		}
-		output, err = executeWbCommand([]string{"notebook", "stop", "--id=" + notebookId})
+		output, err = executeWbCommand(ctx, sess.wbArgs("notebook", "stop", "--id="+notebookId))
 
 	case "notebook_launch":
 		notebookId, reqErr := requireString(params.Arguments, "notebookId")
 		if reqErr != nil {
 			return CallToolResult{Content: []ContentItem{{Type: "text", Text: "Error: " + reqErr.Error()}}, IsError: true}
 		}
-		output, err = executeWbCommand([]string{"notebook", "launch", "--id=" + notebookId})
+		output, err = executeWbCommand(ctx, sess.wbArgs("notebook", "launch", "--id="+notebookId))
 
 	case "cluster_start":
-		clusterId, reqErr := requireString(params.Arguments, "clusterId")
-		if reqErr != nil {
-			return CallToolResult{Content: []ContentItem{{Type: "text", Text: "Error: " + reqErr.Error()}}, IsError: true}
-		}
-		output, err = executeWbCommand([]string{"cluster", "start", "--id=" + clusterId})
+		output, err = handleStart(ctx, sess, "cluster", "clusterId", params.Arguments)
 
 	case "cluster_stop":
 		clusterId, reqErr := requireString(params.Arguments, "clusterId")
 		if reqErr != nil {
 			return CallToolResult{Content: []ContentItem{{Type: "text", Text: "Error: " + reqErr.Error()}}, IsError: true}
 		}
-		output, err = executeWbCommand([]string{"cluster", "stop", "--id=" + clusterId})
+		output, err = executeWbCommand(ctx, sess.wbArgs("cluster", "stop", "--id="+clusterId))
 
 	case "cluster_launch":
 		clusterId, reqErr := requireString(params.Arguments, "clusterId")
 		if reqErr != nil {
 			return CallToolResult{Content: []ContentItem{{Type: "text", Text: "Error: " + reqErr.Error()}}, IsError: true}
 		}
-		output, err = executeWbCommand([]string{"cluster", "launch", "--id=" + clusterId})
+		output, err = executeWbCommand(ctx, sess.wbArgs("cluster", "launch", "--id="+clusterId))
 
 	case "workflow_list":
 		workspaceId, reqErr := requireString(params.Arguments, "workspaceId")
 		if reqErr != nil {
 			return CallToolResult{Content: []ContentItem{{Type: "text", Text: "Error: " + reqErr.Error()}}, IsError: true}
 		}
-		output, err = executeWbCommand([]string{"workflow", "list", "--workspace=" + workspaceId})
+		output, err = executeWbCommand(ctx, []string{"workflow", "list", "--workspace=" + workspaceId})
 
 	case "workflow_create":
 		vals, reqErr := requireStrings(params.Arguments, "workspaceId", "workflowId", "bucketId", "path")
@@ -3522,24 +3818,27 @@ func handleCallTool(params CallToolParams) CallToolResult {
 		if description, ok := params.Arguments["description"].(string); ok {
 			args = append(args, "--description="+description)
 		}
-		output, err = executeWbCommand(args)
+		output, err = executeWbCommand(ctx, args)
 
 	case "workflow_describe":
 		vals, reqErr := requireStrings(params.Arguments, "workspaceId", "workflowId")
 		if reqErr != nil {
 			return CallToolResult{Content: []ContentItem{{Type: "text", Text: "Error: " + reqErr.Error()}}, IsError: true}
 		}
-		output, err = executeWbCommand([]string{"workflow", "describe", "--workspace=" + vals[0], "--workflow=" + vals[1]})
+		output, err = executeWbCommand(ctx, []string{"workflow", "describe", "--workspace=" + vals[0], "--workflow=" + vals[1]})
+
+	case "workflow_validate_inputs":
+		output, err = handleWorkflowValidateInputs(ctx, sess, params.Arguments)
 
 	case "workflow_job_list":
-		output, err = executeWbCommand([]string{"workflow", "job", "list"})
+		output, err = executeWbCommand(ctx, sess.wbArgs("workflow", "job", "list"))
 
 	case "workflow_job_describe":
 		vals, reqErr := requireStrings(params.Arguments, "workspaceId", "jobId")
 		if reqErr != nil {
 			return CallToolResult{Content: []ContentItem{{Type: "text", Text: "Error: " + reqErr.Error()}}, IsError: true}
 		}
-		output, err = executeWbCommand([]string{"workflow", "job", "describe", "--workspace=" + vals[0], "--job-id=" + vals[1]})
+		output, err = executeWbCommand(ctx, []string{"workflow", "job", "describe", "--workspace=" + vals[0], "--job-id=" + vals[1]})
 
 	case "workflow_job_run":
 		vals, reqErr := requireStrings(params.Arguments, "workspaceId", "workflowId", "outputBucketId")
@@ -3547,80 +3846,125 @@ func handleCallTool(params CallToolParams) CallToolResult {
 			return CallToolResult{Content: []ContentItem{{Type: "text", Text: "Error: " + reqErr.Error()}}, IsError: true}
 		}
 		workspaceId, workflowId, outputBucketId := vals[0], vals[1], vals[2]
-		args := []string{"workflow", "job", "run", "--workspace=" + workspaceId, "--workflow=" + workflowId, "--output-bucket-id=" + outputBucketId}
-		if jobId, ok := params.Arguments["jobId"].(string); ok {
-			args = append(args, "--job-id="+jobId)
+		// Always pass a job ID so the run can be recorded for workflow_job_outputs.
+		jobId, _ := params.Arguments["jobId"].(string)
+		if jobId == "" {
+			jobId = "wb-mcp-" + randomHex(6)
 		}
+		args := []string{"workflow", "job", "run", "--workspace=" + workspaceId, "--workflow=" + workflowId, "--output-bucket-id=" + outputBucketId, "--job-id=" + jobId}
 		if description, ok := params.Arguments["description"].(string); ok {
 			args = append(args, "--description="+description)
 		}
-		if outputPath, ok := params.Arguments["outputPath"].(string); ok {
+		outputPath, _ := params.Arguments["outputPath"].(string)
+		if outputPath != "" {
 			args = append(args, "--output-path="+outputPath)
 		}
-		if inputs, ok := params.Arguments["inputs"].(map[string]interface{}); ok {
+		inputs, _ := params.Arguments["inputs"].(map[string]interface{})
+		var notes []string
+		if validate, ok := params.Arguments["validate"].(bool); !ok || validate {
+			def, defErr := loadWorkflowDefinition(ctx, sess, workspaceId, workflowId)
+			if defErr != nil {
+				fmt.Fprintf(os.Stderr, "Warning: skipping input validation: %v\n", defErr)
+				notes = append(notes, "Inputs not validated: "+defErr.Error())
+			} else {
+				v := validateWorkflowInputs(ctx, sess.inWorkspace(workspaceId), def, inputs)
+				if len(v.Errors) > 0 {
+					return CallToolResult{Content: []ContentItem{{Type: "text", Text: fmt.Sprintf("Error: inputs do not match workflow %s (%s); nothing was submitted:\n- %s\nUse workflow_validate_inputs to see the declared inputs, or validate=false to submit anyway.", workflowId, def.URI, strings.Join(v.Errors, "\n- "))}}, IsError: true}
+				}
+				if inputs != nil {
+					inputs = v.Inputs
+				}
+				notes = append(notes, v.Warnings...)
+				for ref, uri := range v.Resolved {
+					notes = append(notes, fmt.Sprintf("Resolved %s -> %s", ref, uri))
+				}
+			}
+		}
+		if inputs != nil {
 			inputsJSON, _ := json.Marshal(inputs)
 			args = append(args, "--inputs="+string(inputsJSON))
 		}
-		output, err = executeWbCommand(args)
+		output, err = executeWbCommand(ctx, args)
+		if err == nil && dryRunFrom(ctx) == nil {
+			recordWorkflowRun(workflowRun{
+				WorkspaceID:    workspaceId,
+				JobID:          jobId,
+				WorkflowID:     workflowId,
+				OutputBucketID: outputBucketId,
+				OutputPath:     outputPath,
+				Submitted:      time.Now().UTC(),
+			})
+			output = strings.TrimRight(output, "\n") + "\nJob ID: " + jobId + " (use workflow_job_outputs / workflow_job_logs once tasks finish)"
+			sort.Strings(notes)
+			for _, n := range notes {
+				output += "\n" + n
+			}
+		}
+
+	case "workflow_job_outputs":
+		output, err = handleWorkflowJobOutputs(ctx, sess, params.Arguments)
+
+	case "workflow_job_logs":
+		output, err = handleWorkflowJobLogs(ctx, sess, params.Arguments)
 
 	case "workflow_job_cancel":
 		vals, reqErr := requireStrings(params.Arguments, "workspaceId", "jobId")
 		if reqErr != nil {
 			return CallToolResult{Content: []ContentItem{{Type: "text", Text: "Error: " + reqErr.Error()}}, IsError: true}
 		}
-		output, err = executeWbCommand([]string{"workflow", "job", "cancel", "--workspace=" + vals[0], "--job-id=" + vals[1]})
+		output, err = executeWbCommand(ctx, []string{"workflow", "job", "cancel", "--workspace=" + vals[0], "--job-id=" + vals[1]})
 
 	case "cromwell_generate_config":
 		path, reqErr := requireString(params.Arguments, "path")
 		if reqErr != nil {
 			return CallToolResult{Content: []ContentItem{{Type: "text", Text: "Error: " + reqErr.Error()}}, IsError: true}
 		}
-		output, err = executeWbCommand([]string{"cromwell", "generate-config", "--path=" + path})
+		output, err = executeWbCommand(ctx, []string{"cromwell", "generate-config", "--path=" + path})
 
 	case "workspace_configure_aws":
 		workspaceId, reqErr := requireString(params.Arguments, "workspaceId")
 		if reqErr != nil {
 			return CallToolResult{Content: []ContentItem{{Type: "text", Text: "Error: " + reqErr.Error()}}, IsError: true}
 		}
-		output, err = executeWbCommand([]string{"workspace", "configure-aws", "--workspace=" + workspaceId})
+		output, err = executeWbCommand(ctx, []string{"workspace", "configure-aws", "--workspace=" + workspaceId})
 
 	case "resolve":
 		resourceId, reqErr := requireString(params.Arguments, "resourceId")
 		if reqErr != nil {
 			return CallToolResult{Content: []ContentItem{{Type: "text", Text: "Error: " + reqErr.Error()}}, IsError: true}
 		}
-		output, err = executeWbCommand([]string{"resolve", "--name=" + resourceId})
+		output, err = executeWbCommand(ctx, sess.wbArgs("resolve", "--name="+resourceId))
 
 	case "version":
-		output, err = executeWbCommand([]string{"version"})
+		output, err = executeWbCommand(ctx, []string{"version"})
 
 	case "bq_execute":
 		command, reqErr := requireString(params.Arguments, "command")
 		if reqErr != nil {
 			return CallToolResult{Content: []ContentItem{{Type: "text", Text: "Error: " + reqErr.Error()}}, IsError: true}
 		}
-		output, err = executeWbCommand(append([]string{"bq"}, strings.Fields(command)...))
+		output, err = executeWbCommand(ctx, append(sess.wbArgs("bq"), strings.Fields(command)...))
 
 	case "gcloud_execute":
 		command, reqErr := requireString(params.Arguments, "command")
 		if reqErr != nil {
 			return CallToolResult{Content: []ContentItem{{Type: "text", Text: "Error: " + reqErr.Error()}}, IsError: true}
 		}
-		output, err = executeWbCommand(append([]string{"gcloud"}, strings.Fields(command)...))
+		output, err = executeWbCommand(ctx, append(sess.wbArgs("gcloud"), strings.Fields(command)...))
 
 	case "gsutil_execute":
 		command, reqErr := requireString(params.Arguments, "command")
 		if reqErr != nil {
 			return CallToolResult{Content: []ContentItem{{Type: "text", Text: "Error: " + reqErr.Error()}}, IsError: true}
 		}
-		output, err = executeWbCommand(append([]string{"gsutil"}, strings.Fields(command)...))
+		output, err = executeWbCommand(ctx, append(sess.wbArgs("gsutil"), strings.Fields(command)...))
 
 	case "git_execute":
 		command, reqErr := requireString(params.Arguments, "command")
 		if reqErr != nil {
 			return CallToolResult{Content: []ContentItem{{Type: "text", Text: "Error: " + reqErr.Error()}}, IsError: true}
 		}
-		output, err = executeWbCommand(append([]string{"git"}, strings.Fields(command)...))
+		output, err = executeWbCommand(ctx, append([]string{"git"}, strings.Fields(command)...))
 
 	// --- Aurora Database Tools ---
 	case "aurora_query":
@@ -3636,7 +3980,7 @@ func handleCallTool(params CallToolParams) CallToolResult {
 		if accessMode == "" {
 			accessMode = "READ_ONLY"
 		}
-		output, err = executeAuroraQuery(resourceName, accessMode, query)
+		output, err = executeAuroraQuery(ctx, sess, resourceName, accessMode, query)
 
 	case "aurora_list_tables":
 		resourceName, reqErr := requireString(params.Arguments, "resourceName")
@@ -3648,7 +3992,7 @@ func handleCallTool(params CallToolParams) CallToolResult {
 			schema = "public"
 		}
 		query := fmt.Sprintf("SELECT tablename FROM pg_tables WHERE schemaname = '%s' ORDER BY tablename;", schema)
-		output, err = executeAuroraQuery(resourceName, "READ_ONLY", query)
+		output, err = executeAuroraQuery(ctx, sess, resourceName, "READ_ONLY", query)
 
 	case "aurora_describe_table":
 		resourceName, reqErr := requireString(params.Arguments, "resourceName")
@@ -3664,7 +4008,7 @@ func handleCallTool(params CallToolParams) CallToolResult {
 			schema = "public"
 		}
 		query := fmt.Sprintf("SELECT column_name, data_type, is_nullable, column_default FROM information_schema.columns WHERE table_schema = '%s' AND table_name = '%s' ORDER BY ordinal_position;", schema, tableName)
-		output, err = executeAuroraQuery(resourceName, "READ_ONLY", query)
+		output, err = executeAuroraQuery(ctx, sess, resourceName, "READ_ONLY", query)
 
 	case "aurora_resolve_connection":
 		resourceName, reqErr := requireString(params.Arguments, "resourceName")
@@ -3675,7 +4019,7 @@ func handleCallTool(params CallToolParams) CallToolResult {
 		if accessMode == "" {
 			accessMode = "READ_ONLY"
 		}
-		connStr, connErr := getAuroraConnString(resourceName, accessMode)
+		connStr, connErr := getAuroraConnString(ctx, sess, resourceName, accessMode)
 		if connErr != nil {
 			return CallToolResult{Content: []ContentItem{{Type: "text", Text: "Error: " + connErr.Error()}}, IsError: true}
 		}
@@ -3705,7 +4049,7 @@ func handleCallTool(params CallToolParams) CallToolResult {
 		if reqErr != nil {
 			return CallToolResult{Content: []ContentItem{{Type: "text", Text: "Error: " + reqErr.Error()}}, IsError: true}
 		}
-		s3Path, pathErr := getS3ResourcePath(resourceName)
+		s3Path, pathErr := getS3ResourcePath(ctx, sess, resourceName)
 		if pathErr != nil {
 			return CallToolResult{Content: []ContentItem{{Type: "text", Text: "Error: " + pathErr.Error()}}, IsError: true}
 		}
@@ -3716,7 +4060,7 @@ func handleCallTool(params CallToolParams) CallToolResult {
 		if recursive, ok := params.Arguments["recursive"].(bool); ok && recursive {
 			args = append(args, "--recursive")
 		}
-		output, err = executeAWSCommand(resourceName, args...)
+		output, err = executeAWSCommand(ctx, sess, resourceName, args...)
 
 	case "s3_read_file":
 		resourceName, reqErr := requireString(params.Arguments, "resourceName")
@@ -3727,7 +4071,7 @@ func handleCallTool(params CallToolParams) CallToolResult {
 		if reqErr != nil {
 			return CallToolResult{Content: []ContentItem{{Type: "text", Text: "Error: " + reqErr.Error()}}, IsError: true}
 		}
-		s3Path, pathErr := getS3ResourcePath(resourceName)
+		s3Path, pathErr := getS3ResourcePath(ctx, sess, resourceName)
 		if pathErr != nil {
 			return CallToolResult{Content: []ContentItem{{Type: "text", Text: "Error: " + pathErr.Error()}}, IsError: true}
 		}
@@ -3739,17 +4083,17 @@ func handleCallTool(params CallToolParams) CallToolResult {
 		}
 
 		// Check file size first
-		headOutput, headErr := executeAWSCommand(resourceName, "s3api", "head-object", "--bucket", "", "--key", "")
+		headOutput, headErr := executeAWSCommand(ctx, sess, resourceName, "s3api", "head-object", "--bucket", "", "--key", "")
 		_ = headOutput
 		_ = headErr
 
 		// Stream the file content, limited by maxBytes
-		configFile := ensureAWSConfig()
-		cmd := exec.Command("aws", "s3", "cp", s3Path, "-", "--profile", resourceName)
+		configFile := ensureAWSConfig(ctx, sess)
+		cmd := exec.CommandContext(ctx, "aws", "s3", "cp", s3Path, "-", "--profile", resourceName)
 		if configFile != "" {
 			cmd.Env = append(os.Environ(), "AWS_CONFIG_FILE="+configFile)
 		}
-		outBytes, readErr := cmd.CombinedOutput()
+		outBytes, readErr := runCommand(ctx, cmd)
 		if readErr != nil {
 			err = readErr
 			output = string(outBytes)
@@ -3772,7 +4116,7 @@ func handleCallTool(params CallToolParams) CallToolResult {
 		if reqErr != nil {
 			return CallToolResult{Content: []ContentItem{{Type: "text", Text: "Error: " + reqErr.Error()}}, IsError: true}
 		}
-		s3Path, pathErr := getS3ResourcePath(resourceName)
+		s3Path, pathErr := getS3ResourcePath(ctx, sess, resourceName)
 		if pathErr != nil {
 			return CallToolResult{Content: []ContentItem{{Type: "text", Text: "Error: " + pathErr.Error()}}, IsError: true}
 		}
@@ -3788,7 +4132,7 @@ func handleCallTool(params CallToolParams) CallToolResult {
 		tmpFile.WriteString(content)
 		tmpFile.Close()
 
-		output, err = executeAWSCommand(resourceName, "s3", "cp", tmpPath, s3Path)
+		output, err = executeAWSCommand(ctx, sess, resourceName, "s3", "cp", tmpPath, s3Path)
 
 	case "s3_copy":
 		// Resolve source: prefer resource name, fall back to raw URI
@@ -3797,7 +4141,7 @@ func handleCallTool(params CallToolParams) CallToolResult {
 		sourceUri, _ := params.Arguments["sourceUri"].(string)
 		sourceProfile := sourceResource
 		if sourceResource != "" {
-			resolved, pathErr := getS3ResourcePath(sourceResource)
+			resolved, pathErr := getS3ResourcePath(ctx, sess, sourceResource)
 			if pathErr != nil {
 				return CallToolResult{Content: []ContentItem{{Type: "text", Text: "Error resolving source resource: " + pathErr.Error()}}, IsError: true}
 			}
@@ -3813,7 +4157,7 @@ func handleCallTool(params CallToolParams) CallToolResult {
 		destUri, _ := params.Arguments["destUri"].(string)
 		destProfile := destResource
 		if destResource != "" {
-			resolved, pathErr := getS3ResourcePath(destResource)
+			resolved, pathErr := getS3ResourcePath(ctx, sess, destResource)
 			if pathErr != nil {
 				return CallToolResult{Content: []ContentItem{{Type: "text", Text: "Error resolving dest resource: " + pathErr.Error()}}, IsError: true}
 			}
@@ -3840,7 +4184,7 @@ func handleCallTool(params CallToolParams) CallToolResult {
 			if recursive {
 				dlArgs = append(dlArgs, "--recursive")
 			}
-			dlOutput, dlErr := executeAWSCommand(sourceProfile, dlArgs...)
+			dlOutput, dlErr := executeAWSCommand(ctx, sess, sourceProfile, dlArgs...)
 			if dlErr != nil {
 				return CallToolResult{Content: []ContentItem{{Type: "text", Text: "Error downloading from source: " + dlOutput}}, IsError: true}
 			}
@@ -3849,7 +4193,7 @@ func handleCallTool(params CallToolParams) CallToolResult {
 			if recursive {
 				ulArgs = append(ulArgs, "--recursive")
 			}
-			output, err = executeAWSCommand(destProfile, ulArgs...)
+			output, err = executeAWSCommand(ctx, sess, destProfile, ulArgs...)
 		} else {
 			// Same profile or one side is raw URI: single-step copy
 			profile := sourceProfile
@@ -3860,9 +4204,25 @@ func handleCallTool(params CallToolParams) CallToolResult {
 			if recursive {
 				args = append(args, "--recursive")
 			}
-			output, err = executeAWSCommand(profile, args...)
+			output, err = executeAWSCommand(ctx, sess, profile, args...)
 		}
 
+	// --- Local File Tools ---
+	case "local_list":
+		output, err = handleLocalList(ctx, params.Arguments)
+
+	case "local_read":
+		output, err = handleLocalRead(ctx, params.Arguments)
+
+	case "local_write":
+		output, err = handleLocalWrite(ctx, params.Arguments)
+
+	case "upload_to_resource":
+		output, err = handleUploadToResource(ctx, sess, params.Arguments)
+
+	case "download_from_resource":
+		output, err = handleDownloadFromResource(ctx, sess, params.Arguments)
+
 	// --- AWS Resource Lifecycle Tools ---
 	case "resource_create_aurora_database":
 		name, reqErr := requireString(params.Arguments, "name")
@@ -3877,7 +4237,7 @@ func handleCallTool(params CallToolParams) CallToolResult {
 		if desc, ok := params.Arguments["description"].(string); ok && desc != "" {
 			args = append(args, "--description="+desc)
 		}
-		output, err = executeWbCommand(args)
+		output, err = executeWbCommand(ctx, sess.wbArgs(args...))
 
 	case "resource_create_s3_folder":
 		name, reqErr := requireString(params.Arguments, "name")
@@ -3892,7 +4252,7 @@ func handleCallTool(params CallToolParams) CallToolResult {
 		if desc, ok := params.Arguments["description"].(string); ok && desc != "" {
 			args = append(args, "--description="+desc)
 		}
-		output, err = executeWbCommand(args)
+		output, err = executeWbCommand(ctx, sess.wbArgs(args...))
 
 	case "resource_create_s3_external_bucket":
 		vals, reqErr := requireStrings(params.Arguments, "name", "bucketName", "account", "region")
@@ -3904,42 +4264,42 @@ func handleCallTool(params CallToolParams) CallToolResult {
 		if desc, ok := params.Arguments["description"].(string); ok && desc != "" {
 			args = append(args, "--description="+desc)
 		}
-		output, err = executeWbCommand(args)
+		output, err = executeWbCommand(ctx, sess.wbArgs(args...))
 
 	default:
-		return CallToolResult{Content: []ContentItem{{Type: "text", Text: fmt.Sprintf("Unknown tool: %s", params.Name)}}, IsError: true}
+		plugin, ok := plugins[params.Name]
+		if !ok {
+			return CallToolResult{Content: []ContentItem{{Type: "text", Text: fmt.Sprintf("Unknown tool: %s", params.Name)}}, IsError: true}
+		}
+		output, err = callPlugin(ctx, sess, plugin, params.Arguments)
 	}
 
 	if err != nil {
-		errMsg := fmt.Sprintf("Error: %s", err.Error())
-		if output != "" {
-			errMsg += "\n" + output
-		}
-		return CallToolResult{Content: []ContentItem{{Type: "text", Text: errMsg}}, IsError: true}
+		return errorResult(err, output)
 	}
 	return CallToolResult{Content: []ContentItem{{Type: "text", Text: output}}, IsError: false}
 }
 
-func buildLiteral(dataType string, value interface{}) map[string]interface{} {
-	literal := map[string]interface{}{"dataType": dataType, "valueUnion": map[string]interface{}{}}
-	switch dataType {
-	case "BOOLEAN":
-		literal["valueUnion"].(map[string]interface{})["boolVal"] = value
-	case "INT64":
-		literal["valueUnion"].(map[string]interface{})["int64Val"] = fmt.Sprintf("%v", value)
-	case "STRING":
-		literal["valueUnion"].(map[string]interface{})["stringVal"] = fmt.Sprintf("%v", value)
-	case "DATE":
-		literal["valueUnion"].(map[string]interface{})["dateVal"] = fmt.Sprintf("%v", value)
-	case "TIMESTAMP":
-		literal["valueUnion"].(map[string]interface{})["timestampVal"] = fmt.Sprintf("%v", value)
-	case "DOUBLE":
-		literal["valueUnion"].(map[string]interface{})["doubleVal"] = value
+// handleRequest serves one JSON-RPC request inside a server span; tool calls
+// add their API requests and subprocesses as child spans.
+func handleRequest(ctx context.Context, sess *session, req JSONRPCRequest) JSONRPCResponse {
+	ctx, span := startSpan(ctx, req.Method, spanKindServer)
+	span.setAttr("rpc.system", "jsonrpc")
+	span.setAttr("rpc.method", req.Method)
+	if req.ID != nil {
+		span.setAttr("rpc.jsonrpc.request_id", fmt.Sprint(req.ID))
 	}
-	return literal
+	span.setAttr("mcp.session.id", sess.id)
+
+	response := dispatchRequest(ctx, span, sess, req)
+	if response.Error != nil {
+		span.setError(response.Error.Message)
+	}
+	span.end(nil)
+	return response
 }
 
-func handleRequest(req JSONRPCRequest) JSONRPCResponse {
+func dispatchRequest(ctx context.Context, span *span, sess *session, req JSONRPCRequest) JSONRPCResponse {
 	switch req.Method {
 	case "initialize":
 		return JSONRPCResponse{
@@ -3962,7 +4322,20 @@ func handleRequest(req JSONRPCRequest) JSONRPCResponse {
 		if err := json.Unmarshal(req.Params, &params); err != nil {
 			return JSONRPCResponse{JSONRPC: "2.0", ID: req.ID, Error: &RPCError{Code: -32602, Message: "Invalid params"}}
 		}
-		return JSONRPCResponse{JSONRPC: "2.0", ID: req.ID, Result: handleCallTool(params)}
+		span.setName("tools/call " + params.Name)
+		span.setAttr("mcp.tool.name", params.Name)
+		if workspace := toolWorkspace(sess, params); workspace != "" {
+			span.setAttr("wb.workspace.id", workspace)
+		}
+		start := time.Now()
+		ctx = withProgressToken(ctx, params.Meta["progressToken"])
+		result := withErrorDetails(handleCallTool(ctx, sess, params), toolError{})
+		recordToolCall(params.Name, result.IsError, time.Since(start))
+		span.setAttr("mcp.tool.is_error", result.IsError)
+		if result.IsError && len(result.Content) > 0 {
+			span.setError(result.Content[0].Text)
+		}
+		return JSONRPCResponse{JSONRPC: "2.0", ID: req.ID, Result: result}
 	default:
 		return JSONRPCResponse{JSONRPC: "2.0", ID: req.ID, Error: &RPCError{Code: -32601, Message: "Method not found"}}
 	}
@@ -3970,10 +4343,10 @@ func handleRequest(req JSONRPCRequest) JSONRPCResponse {
 
 // HTTP handler for MCP requests
 func handleHTTP(w http.ResponseWriter, r *http.Request) {
-	// Set CORS headers for local access
-	w.Header().Set("Access-Control-Allow-Origin", "http://127.0.0.1")
-	w.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS")
-	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+	// Set CORS headers for local access (Origin is validated by withHTTPAuth)
+	w.Header().Set("Access-Control-Allow-Methods", "POST, DELETE, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, Mcp-Session-Id")
+	w.Header().Set("Access-Control-Expose-Headers", "Mcp-Session-Id")
 
 	// Handle preflight
 	if r.Method == http.MethodOptions {
@@ -3981,6 +4354,16 @@ func handleHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Client-initiated session termination
+	if r.Method == http.MethodDelete {
+		if id := r.Header.Get("Mcp-Session-Id"); id != "" && endSession(id) {
+			w.WriteHeader(http.StatusOK)
+		} else {
+			http.Error(w, "Session not found", http.StatusNotFound)
+		}
+		return
+	}
+
 	if r.Method != http.MethodPost {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
@@ -3992,15 +4375,51 @@ func handleHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	response := handleRequest(req)
+	// Each initialize starts a new session; later requests carry its ID.
+	// Clients that never send an ID share defaultSession.
+	sess := defaultSession
+	if req.Method == "initialize" {
+		sess = newSession()
+		w.Header().Set("Mcp-Session-Id", sess.id)
+	} else if id := r.Header.Get("Mcp-Session-Id"); id != "" {
+		var ok bool
+		if sess, ok = lookupSession(id); !ok {
+			http.Error(w, "Session not found", http.StatusNotFound)
+			return
+		}
+	}
+
+	ctx := contextWithTraceparent(r.Context(), r.Header.Get("traceparent"))
+	response := handleRequest(ctx, sess, req)
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(response)
 }
 
 // Run server in HTTP mode
-func runHTTPServer(port string) {
-	http.HandleFunc("/", handleHTTP)
+func runHTTPServer(port string, authOpts httpAuthOptions) {
+	if err := loadHTTPAuth(authOpts, port); err != nil {
+		log.Fatalf("Error configuring HTTP auth: %v", err)
+	}
+
+	http.HandleFunc("/", withHTTPAuth(handleHTTP))
+	// /healthz stays open for probes and says nothing beyond "ok".
+	http.HandleFunc("/healthz", handleHealthz)
+	http.HandleFunc("/readyz", withHTTPAuth(handleReadyz))
+	http.HandleFunc("/metrics", withHTTPAuth(handleMetrics))
+
+	if authOpts.Socket != "" {
+		listener, err := listenUnixSocket(authOpts.Socket)
+		if err != nil {
+			log.Fatalf("HTTP server failed: %v", err)
+		}
+		log.Printf("Starting HTTP MCP server on unix socket %s\n", authOpts.Socket)
+		log.Printf("Ready - %d tools available\n", len(wbTools))
+		if err := http.Serve(listener, nil); err != nil {
+			log.Fatalf("HTTP server failed: %v", err)
+		}
+		return
+	}
 
 	addr := "127.0.0.1:" + port
 	log.Printf("Starting HTTP MCP server on %s (port arg: %q)\n", addr, port)
@@ -4017,6 +4436,9 @@ func runStdioServer() {
 	log.Println("Starting stdio MCP server")
 	log.Printf("Ready - %d tools available\n", len(wbTools))
 
+	ctx := withNotifier(context.Background(), func(method string, params interface{}) {
+		writeStdio(map[string]interface{}{"jsonrpc": "2.0", "method": method, "params": params})
+	})
 	scanner := bufio.NewScanner(os.Stdin)
 	for scanner.Scan() {
 		line := scanner.Text()
@@ -4029,11 +4451,10 @@ func runStdioServer() {
 			continue
 		}
 
-		response := handleRequest(req)
+		response := handleRequest(ctx, defaultSession, req)
 		// Only send response if there's a result or error (skip empty responses for notifications)
 		if response.Result != nil || response.Error != nil {
-			responseBytes, _ := json.Marshal(response)
-			fmt.Println(string(responseBytes))
+			writeStdio(response)
 		}
 	}
 }
@@ -4041,21 +4462,46 @@ func runStdioServer() {
 func main() {
 	var httpMode bool
 	var port string
+	var cfgOpts configOptions
+	var authOpts httpAuthOptions
+	var traceOpts tracingOptions
+	var dryRun bool
+	var toolsDir string
 
 	flag.BoolVar(&httpMode, "http", false, "Run in HTTP mode instead of stdio")
 	flag.StringVar(&port, "port", "9242", "Port for HTTP server")
+	flag.StringVar(&cfgOpts.ConfigPath, "config", "", "Path to JSON config file (default: $WB_MCP_CONFIG, ~/.config/wb-mcp-server/config.json, /opt/wb-mcp-server/config.json)")
+	flag.StringVar(&cfgOpts.Environment, "env", "", "Named environment from the config file (default: $WB_MCP_ENV, or follow the wb CLI server)")
+	flag.StringVar(&cfgOpts.WSMURL, "wsm-url", "", "Workspace Manager URL override (default: $WB_MCP_WSM_URL)")
+	flag.StringVar(&cfgOpts.DEURL, "de-url", "", "Data Explorer URL override (default: $WB_MCP_DE_URL)")
+	flag.StringVar(&cfgOpts.UIURL, "ui-url", "", "Workbench UI URL override (default: $WB_MCP_UI_URL)")
+	flag.StringVar(&authOpts.TokenFile, "token-file", "", "File holding the bearer token HTTP clients must send (default: $WB_MCP_TOKEN_FILE, "+defaultTokenFile+")")
+	flag.StringVar(&authOpts.Socket, "socket", "", "Listen on this Unix domain socket instead of TCP (HTTP mode)")
+	flag.StringVar(&authOpts.AllowedOrigins, "allowed-origins", "", "Comma-separated browser origins allowed besides loopback (default: $WB_MCP_ALLOWED_ORIGINS)")
+	flag.BoolVar(&authOpts.NoAuth, "no-auth", false, "Serve HTTP without a bearer token when no token file exists (not recommended)")
+	flag.StringVar(&traceOpts.Endpoint, "otlp-endpoint", "", "OTLP/HTTP collector to export trace spans to, e.g. http://127.0.0.1:4318 (default: $OTEL_EXPORTER_OTLP_ENDPOINT)")
+	flag.StringVar(&traceOpts.File, "trace-file", "", "File to append trace spans to as OTLP JSON lines (default: $WB_MCP_TRACE_FILE)")
+	flag.BoolVar(&dryRun, "dry-run", false, "Make every tool call a dry run unless it passes dryRun=false (default: $WB_MCP_DRY_RUN)")
+	flag.StringVar(&toolsDir, "tools-dir", "", "Directory of plugin tools (default: $WB_MCP_TOOLS_DIR, ~/.config/wb-mcp-server/tools.d, /opt/wb-mcp-server/tools.d)")
 	flag.Parse()
 
 	log.SetOutput(os.Stderr)
 	log.Println("Workbench MCP Server v2.0 starting...")
 
-	if err := initializeConfig(); err != nil {
+	if err := initTracing(traceOpts); err != nil {
+		log.Fatalf("Error initializing tracing: %v\n", err)
+	}
+	if err := initializeConfig(cfgOpts); err != nil {
 		log.Fatalf("Error initializing: %v\n", err)
 	}
+	initJobs()
+	initPlugins(toolsDir)
+	initDryRun(dryRun)
 
 	if httpMode {
-		runHTTPServer(port)
+		runHTTPServer(port, authOpts)
 	} else {
 		runStdioServer()
+		shutdownTracing()
 	}
 }
@@ -0,0 +1,374 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// manifestVersion is bumped whenever the manifest layout changes in a way
+// that breaks readers.
+const manifestVersion = 1
+
+// workspaceManifest is a point-in-time description of a workspace. Lists are
+// sorted so two manifests can be compared with a plain diff.
+type workspaceManifest struct {
+	ManifestVersion int                    `json:"manifestVersion"`
+	GeneratedAt     string                 `json:"generatedAt"`
+	Workspace       manifestWorkspace      `json:"workspace"`
+	Users           []manifestUser         `json:"users"`
+	Folders         []manifestFolder       `json:"folders"`
+	Resources       []manifestResource     `json:"resources"`
+	Apps            interface{}            `json:"apps"`
+	Workflows       interface{}            `json:"workflows"`
+	Spec            map[string]interface{} `json:"spec"`
+	Warnings        []string               `json:"warnings,omitempty"`
+}
+
+type manifestWorkspace struct {
+	ID            string            `json:"id"`
+	UUID          string            `json:"uuid"`
+	Name          string            `json:"name"`
+	Description   string            `json:"description,omitempty"`
+	CloudPlatform string            `json:"cloudPlatform,omitempty"`
+	Properties    map[string]string `json:"properties"`
+}
+
+type manifestUser struct {
+	Email string   `json:"email"`
+	Roles []string `json:"roles"`
+}
+
+type manifestFolder struct {
+	ID          string `json:"id"`
+	DisplayName string `json:"displayName"`
+	Description string `json:"description,omitempty"`
+	Path        string `json:"path"`
+	ParentID    string `json:"parentId,omitempty"`
+}
+
+type manifestResource struct {
+	Name                string                 `json:"name"`
+	Type                string                 `json:"type"`
+	Description         string                 `json:"description,omitempty"`
+	Stewardship         string                 `json:"stewardship,omitempty"`
+	CloningInstructions string                 `json:"cloningInstructions,omitempty"`
+	Folder              string                 `json:"folder,omitempty"`
+	Lineage             []interface{}          `json:"lineage,omitempty"`
+	Attributes          map[string]interface{} `json:"attributes,omitempty"`
+}
+
+// folderPaths returns each folder's path ("Parent/Child") by ID.
+func folderPaths(folders []workspaceFolder) map[string]string {
+	byID := make(map[string]workspaceFolder, len(folders))
+	for _, f := range folders {
+		byID[f.ID] = f
+	}
+	paths := make(map[string]string, len(folders))
+	for _, f := range folders {
+		parts := []string{f.DisplayName}
+		seen := map[string]bool{f.ID: true}
+		for p := f.ParentFolderID; p != "" && !seen[p]; p = byID[p].ParentFolderID {
+			seen[p] = true
+			parent, ok := byID[p]
+			if !ok {
+				break
+			}
+			parts = append([]string{parent.DisplayName}, parts...)
+		}
+		paths[f.ID] = strings.Join(parts, "/")
+	}
+	return paths
+}
+
+// folderSpecID turns a folder path into a spec folder ID not yet in used:
+// "Analysis/QC runs" becomes "analysis-qc-runs".
+func folderSpecID(folderPath string, used map[string]bool) string {
+	base := strings.Trim(strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= '0' && r <= '9' {
+			return r
+		}
+		return '-'
+	}, strings.ToLower(folderPath)), "-")
+	for strings.Contains(base, "--") {
+		base = strings.ReplaceAll(base, "--", "-")
+	}
+	base = firstNonEmpty(base, "folder")
+	id := base
+	for n := 2; used[id]; n++ {
+		id = fmt.Sprintf("%s-%d", base, n)
+	}
+	used[id] = true
+	return id
+}
+
+// resourceSpec turns a controlled resource into a workspace_apply resource
+// entry, or returns nil if workspace_apply can't create it.
+func resourceSpec(r manifestResource) map[string]interface{} {
+	if r.Stewardship != "CONTROLLED" {
+		return nil
+	}
+	attr := func(kind, key string) string {
+		m, _ := r.Attributes[kind].(map[string]interface{})
+		v, _ := m[key].(string)
+		return v
+	}
+	entry := map[string]interface{}{"id": r.Name}
+	switch r.Type {
+	case "GCS_BUCKET":
+		entry["type"], entry["bucketName"] = "gcs-bucket", attr("gcpGcsBucket", "bucketName")
+	case "BIG_QUERY_DATASET":
+		entry["type"], entry["datasetId"] = "bq-dataset", attr("gcpBqDataset", "datasetId")
+	case "AWS_S3_STORAGE_FOLDER":
+		entry["type"], entry["folderName"] = "s3-folder", attr("awsS3StorageFolder", "prefix")
+	default:
+		return nil
+	}
+	for k, v := range entry {
+		if v == "" {
+			delete(entry, k) // left for the user to fill in
+		}
+	}
+	if r.Description != "" {
+		entry["description"] = r.Description
+	}
+	return entry
+}
+
+// buildWorkspaceManifest reads everything the manifest records. Apps and
+// workflows come from the wb CLI; if they can't be listed the manifest is
+// still produced, with a warning.
+func buildWorkspaceManifest(ctx context.Context, sess *session, workspaceId string) (*workspaceManifest, error) {
+	var uuid string
+	var err error
+	if workspaceId != "" {
+		uuid, err = resolveWorkspaceId(ctx, workspaceId)
+	} else {
+		uuid, err = sess.resolveWorkspaceUUID(ctx)
+	}
+	if err != nil {
+		return nil, err
+	}
+	base := fmt.Sprintf("%s/api/workspaces/v1/%s", workspaceBaseURL, uuid)
+
+	respBody, err := makeAPIRequest(ctx, "GET", base, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read workspace: %w", err)
+	}
+	var ws map[string]interface{}
+	if err := json.Unmarshal(respBody, &ws); err != nil {
+		return nil, fmt.Errorf("failed to parse workspace: %w", err)
+	}
+	m := &workspaceManifest{
+		ManifestVersion: manifestVersion,
+		GeneratedAt:     time.Now().UTC().Format(time.RFC3339),
+		Users:           []manifestUser{},
+		Folders:         []manifestFolder{},
+		Resources:       []manifestResource{},
+	}
+	m.Workspace.UUID = uuid
+	m.Workspace.ID, _ = ws["userFacingId"].(string)
+	m.Workspace.Name, _ = ws["displayName"].(string)
+	m.Workspace.Description, _ = ws["description"].(string)
+	m.Workspace.CloudPlatform, _ = ws["cloudPlatform"].(string)
+	m.Workspace.Properties = workspaceProperties(ws)
+
+	roles, err := getWorkspaceRoles(ctx, base)
+	if err != nil {
+		return nil, err
+	}
+	for _, email := range sortedKeys(roles) {
+		m.Users = append(m.Users, manifestUser{Email: email, Roles: sortedKeys(roles[email])})
+	}
+
+	folders, err := getWorkspaceFolders(ctx, base)
+	if err != nil {
+		return nil, err
+	}
+	paths := folderPaths(folders)
+	for _, f := range folders {
+		m.Folders = append(m.Folders, manifestFolder{ID: f.ID, DisplayName: f.DisplayName, Description: f.Description, Path: paths[f.ID], ParentID: f.ParentFolderID})
+	}
+	sort.Slice(m.Folders, func(i, j int) bool { return m.Folders[i].Path < m.Folders[j].Path })
+
+	resources, err := getWorkspaceResources(ctx, base)
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range resources {
+		mr := manifestResource{
+			Name:                r.Metadata.Name,
+			Type:                r.Metadata.ResourceType,
+			Description:         r.Metadata.Description,
+			Stewardship:         r.Metadata.StewardshipType,
+			CloningInstructions: r.Metadata.CloningInstructions,
+			Lineage:             r.Metadata.ResourceLineage,
+			Attributes:          r.ResourceAttributes,
+		}
+		for _, p := range r.Metadata.Properties {
+			if prop, ok := p.(map[string]interface{}); ok && prop["key"] == "terra-folder-id" {
+				id, _ := prop["value"].(string)
+				mr.Folder = firstNonEmpty(paths[id], id)
+			}
+		}
+		m.Resources = append(m.Resources, mr)
+	}
+	sort.Slice(m.Resources, func(i, j int) bool { return m.Resources[i].Name < m.Resources[j].Name })
+
+	wsFlag := "--workspace=" + m.Workspace.ID
+	for _, list := range []struct {
+		name string
+		args []string
+		dst  *interface{}
+	}{
+		{"apps", []string{"app", "list", "--format=json", wsFlag}, &m.Apps},
+		{"workflows", []string{"workflow", "list", "--format=json", wsFlag}, &m.Workflows},
+	} {
+		out, err := executeWbCommand(ctx, list.args)
+		if err == nil {
+			err = json.Unmarshal([]byte(out), list.dst)
+		}
+		if err != nil {
+			m.Warnings = append(m.Warnings, fmt.Sprintf("could not list %s: %v", list.name, err))
+		}
+	}
+
+	m.Spec = manifestSpec(m)
+	return m, nil
+}
+
+// manifestSpec derives a workspace_apply spec that recreates the workspace's
+// structure. The ID, podId and any globally unique names (bucket names) need
+// to be changed before applying it elsewhere.
+func manifestSpec(m *workspaceManifest) map[string]interface{} {
+	spec := map[string]interface{}{"id": m.Workspace.ID, "name": m.Workspace.Name}
+	if m.Workspace.Description != "" {
+		spec["description"] = m.Workspace.Description
+	}
+	props := map[string]interface{}{}
+	for k, v := range m.Workspace.Properties {
+		// terra-* properties are maintained by the platform.
+		if !strings.HasPrefix(k, "terra-") {
+			props[k] = v
+		}
+	}
+	if len(props) > 0 {
+		spec["properties"] = props
+	}
+	var users []map[string]interface{}
+	for _, u := range m.Users {
+		for _, role := range u.Roles {
+			users = append(users, map[string]interface{}{"email": u.Email, "role": role})
+		}
+	}
+	if users != nil {
+		spec["users"] = users
+	}
+	// Folder IDs belong to this workspace, so the spec names folders by
+	// their path instead and refers to parents by those names. m.Folders is
+	// sorted by path, so parents come first.
+	var folders []map[string]interface{}
+	specIDs := map[string]string{}
+	used := map[string]bool{}
+	for _, f := range m.Folders {
+		id := folderSpecID(f.Path, used)
+		specIDs[f.ID] = id
+		entry := map[string]interface{}{"id": id, "displayName": f.DisplayName}
+		if f.Description != "" {
+			entry["description"] = f.Description
+		}
+		if parent, ok := specIDs[f.ParentID]; ok {
+			entry["parentId"] = parent
+		}
+		folders = append(folders, entry)
+	}
+	if folders != nil {
+		spec["folders"] = folders
+	}
+	var resources []map[string]interface{}
+	var skipped []string
+	for _, r := range m.Resources {
+		if entry := resourceSpec(r); entry != nil {
+			resources = append(resources, entry)
+		} else {
+			skipped = append(skipped, r.Name)
+		}
+	}
+	if resources != nil {
+		spec["resources"] = resources
+	}
+	if len(skipped) > 0 {
+		m.Warnings = append(m.Warnings, fmt.Sprintf("spec omits resources workspace_apply can't create (referenced or unsupported types): %s", strings.Join(skipped, ", ")))
+	}
+	return spec
+}
+
+// handleWorkspaceExportManifest implements workspace_export_manifest. With
+// outputPath the manifest is written to a file under the local root (see
+// localfiles.go) and a summary returned; an existing file is only replaced
+// with overwrite=true.
+func handleWorkspaceExportManifest(ctx context.Context, sess *session, args map[string]interface{}) (string, error) {
+	workspaceId, _ := args["workspaceId"].(string)
+	outputPath, _ := args["outputPath"].(string)
+	var root *os.Root
+	var rel string
+	if outputPath != "" {
+		var err error
+		if root, rel, err = openLocalRoot(outputPath); err != nil {
+			return "", err
+		}
+		defer root.Close()
+	}
+	m, err := buildWorkspaceManifest(ctx, sess, workspaceId)
+	if err != nil {
+		return "", err
+	}
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal result: %w", err)
+	}
+	if root == nil {
+		return string(data), nil
+	}
+	target := filepath.Join(root.Name(), rel)
+	if r := dryRunFrom(ctx); r != nil {
+		r.record(dryRunAction{Method: "WRITE", URL: fmt.Sprintf("%s (%d bytes)", target, len(data)+1)})
+		return "", nil
+	}
+	flags := os.O_WRONLY | os.O_CREATE | os.O_EXCL
+	if overwrite, _ := args["overwrite"].(bool); overwrite {
+		flags = os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	}
+	if dir := filepath.Dir(rel); dir != "." {
+		if err := root.MkdirAll(dir, 0o755); err != nil {
+			return "", fmt.Errorf("failed to write manifest: %w", localPathError(root, rel, err))
+		}
+	}
+	f, err := root.OpenFile(rel, flags, 0o644)
+	if errors.Is(err, fs.ErrExist) {
+		return "", fmt.Errorf("%s already exists; pass overwrite=true to replace it", target)
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to write manifest: %w", localPathError(root, rel, err))
+	}
+	_, err = f.Write(append(data, '\n'))
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to write manifest: %w", err)
+	}
+	summary := fmt.Sprintf("Wrote manifest v%d for workspace %s to %s: %d users, %d folders, %d resources.",
+		manifestVersion, m.Workspace.ID, target, len(m.Users), len(m.Folders), len(m.Resources))
+	for _, w := range m.Warnings {
+		summary += "\nWarning: " + w
+	}
+	return summary, nil
+}
@@ -0,0 +1,290 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Metrics are kept in-process and rendered in the Prometheus text exposition
+// format on /metrics, so the server needs no client library.
+
+// toolDurationBuckets are the histogram upper bounds, in seconds. Tools range
+// from pure JSON builders (<10ms) to multi-step cohort creation (tens of seconds).
+var toolDurationBuckets = []float64{0.01, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 120}
+
+type histogram struct {
+	counts []uint64 // one per bucket, non-cumulative
+	sum    float64
+	count  uint64
+}
+
+func (h *histogram) observe(v float64) {
+	if h.counts == nil {
+		h.counts = make([]uint64, len(toolDurationBuckets))
+	}
+	for i, le := range toolDurationBuckets {
+		if v <= le {
+			h.counts[i]++
+			break
+		}
+	}
+	h.sum += v
+	h.count++
+}
+
+type labelPair struct{ a, b string }
+
+var (
+	metricsMu            sync.Mutex
+	serverStartTime      = time.Now()
+	toolCalls            = map[labelPair]uint64{} // {tool, status}
+	toolDurations        = map[string]*histogram{}
+	subprocessCalls      = map[labelPair]uint64{} // {command, status}
+	subprocessesInFlight int64
+	apiRequests          = map[labelPair]uint64{} // {method, code}
+)
+
+// recordToolCall counts one tools/call and its latency.
+func recordToolCall(tool string, isError bool, elapsed time.Duration) {
+	status := "ok"
+	if isError {
+		status = "error"
+	}
+	// Keep label cardinality bounded: clients can send arbitrary tool names.
+	if !isKnownTool(tool) {
+		tool = "unknown"
+	}
+	metricsMu.Lock()
+	defer metricsMu.Unlock()
+	toolCalls[labelPair{tool, status}]++
+	h := toolDurations[tool]
+	if h == nil {
+		h = &histogram{}
+		toolDurations[tool] = h
+	}
+	h.observe(elapsed.Seconds())
+}
+
+func isKnownTool(name string) bool {
+	for _, t := range wbTools {
+		if t.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// recordAPIRequest counts one outbound Workbench API request. code is the
+// HTTP status, or 0 if the request failed before a response arrived.
+func recordAPIRequest(method string, code int) {
+	label := "error"
+	if code != 0 {
+		label = fmt.Sprintf("%d", code)
+	}
+	metricsMu.Lock()
+	apiRequests[labelPair{method, label}]++
+	metricsMu.Unlock()
+}
+
+// runCommand runs cmd and returns its combined output, counting it in the
+// subprocess metrics and tracing it as a child span of ctx. All wb/aws/psql
+// invocations go through here. Build cmd with exec.CommandContext(ctx, ...)
+// so a cancelled call or a client disconnect kills the process.
+func runCommand(ctx context.Context, cmd *exec.Cmd) ([]byte, error) {
+	return traceCommand(ctx, cmd, func() ([]byte, []byte, error) {
+		out, err := cmd.CombinedOutput()
+		return out, out, err
+	})
+}
+
+// runCommandSplit is runCommand for commands whose stdout is a result that
+// stderr mustn't be mixed into.
+func runCommandSplit(ctx context.Context, cmd *exec.Cmd) (stdout, stderr []byte, err error) {
+	var errBuf bytes.Buffer
+	cmd.Stderr = &errBuf
+	stdout, err = traceCommand(ctx, cmd, func() ([]byte, []byte, error) {
+		out, err := cmd.Output()
+		return out, errBuf.Bytes(), err
+	})
+	return stdout, errBuf.Bytes(), err
+}
+
+// traceCommand does the dry-run check, metrics and span around run, which
+// returns the command's output and what to report in the span if it fails.
+func traceCommand(ctx context.Context, cmd *exec.Cmd, run func() ([]byte, []byte, error)) ([]byte, error) {
+	if dryRunCommand(ctx, cmd.Args) {
+		return nil, nil
+	}
+	name := filepath.Base(cmd.Args[0])
+	_, span := startSpan(ctx, "exec "+name, spanKindInternal)
+	span.setAttr("process.executable.name", name)
+	span.setAttr("process.command_line", redactCommandLine(cmd.Args))
+	metricsMu.Lock()
+	subprocessesInFlight++
+	metricsMu.Unlock()
+
+	output, diag, err := run()
+
+	status := "ok"
+	if err != nil {
+		status = "error"
+	}
+	metricsMu.Lock()
+	subprocessesInFlight--
+	subprocessCalls[labelPair{name, status}]++
+	metricsMu.Unlock()
+
+	if cmd.ProcessState != nil {
+		span.setAttr("process.exit.code", cmd.ProcessState.ExitCode())
+	}
+	if err != nil {
+		span.setError(fmt.Sprintf("%v: %s", err, strings.TrimSpace(string(diag))))
+	}
+	span.end(nil)
+	return output, err
+}
+
+// handleMetrics serves /metrics in Prometheus text format.
+func handleMetrics(w http.ResponseWriter, r *http.Request) {
+	var b strings.Builder
+	metricsMu.Lock()
+
+	b.WriteString("# HELP wb_mcp_tool_calls_total Tool calls by tool and outcome.\n")
+	b.WriteString("# TYPE wb_mcp_tool_calls_total counter\n")
+	for _, k := range sortedPairs(toolCalls) {
+		fmt.Fprintf(&b, "wb_mcp_tool_calls_total{tool=%s,status=%s} %d\n", quoteLabel(k.a), quoteLabel(k.b), toolCalls[k])
+	}
+
+	b.WriteString("# HELP wb_mcp_tool_duration_seconds Tool call latency.\n")
+	b.WriteString("# TYPE wb_mcp_tool_duration_seconds histogram\n")
+	tools := make([]string, 0, len(toolDurations))
+	for tool := range toolDurations {
+		tools = append(tools, tool)
+	}
+	sort.Strings(tools)
+	for _, tool := range tools {
+		h := toolDurations[tool]
+		var cumulative uint64
+		for i, le := range toolDurationBuckets {
+			cumulative += h.counts[i]
+			fmt.Fprintf(&b, "wb_mcp_tool_duration_seconds_bucket{tool=%s,le=\"%g\"} %d\n", quoteLabel(tool), le, cumulative)
+		}
+		fmt.Fprintf(&b, "wb_mcp_tool_duration_seconds_bucket{tool=%s,le=\"+Inf\"} %d\n", quoteLabel(tool), h.count)
+		fmt.Fprintf(&b, "wb_mcp_tool_duration_seconds_sum{tool=%s} %g\n", quoteLabel(tool), h.sum)
+		fmt.Fprintf(&b, "wb_mcp_tool_duration_seconds_count{tool=%s} %d\n", quoteLabel(tool), h.count)
+	}
+
+	b.WriteString("# HELP wb_mcp_subprocess_total Subprocesses run (wb, aws, psql, ...) by command and outcome.\n")
+	b.WriteString("# TYPE wb_mcp_subprocess_total counter\n")
+	for _, k := range sortedPairs(subprocessCalls) {
+		fmt.Fprintf(&b, "wb_mcp_subprocess_total{command=%s,status=%s} %d\n", quoteLabel(k.a), quoteLabel(k.b), subprocessCalls[k])
+	}
+	b.WriteString("# HELP wb_mcp_subprocesses_in_flight Subprocesses currently running.\n")
+	b.WriteString("# TYPE wb_mcp_subprocesses_in_flight gauge\n")
+	fmt.Fprintf(&b, "wb_mcp_subprocesses_in_flight %d\n", subprocessesInFlight)
+
+	b.WriteString("# HELP wb_mcp_api_requests_total Workbench API requests by method and HTTP status (\"error\" if no response).\n")
+	b.WriteString("# TYPE wb_mcp_api_requests_total counter\n")
+	for _, k := range sortedPairs(apiRequests) {
+		fmt.Fprintf(&b, "wb_mcp_api_requests_total{method=%s,code=%s} %d\n", quoteLabel(k.a), quoteLabel(k.b), apiRequests[k])
+	}
+
+	metricsMu.Unlock()
+
+	b.WriteString("# HELP wb_mcp_start_time_seconds Unix time the server started.\n")
+	b.WriteString("# TYPE wb_mcp_start_time_seconds gauge\n")
+	fmt.Fprintf(&b, "wb_mcp_start_time_seconds %d\n", serverStartTime.Unix())
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.Write([]byte(b.String()))
+}
+
+func sortedPairs(m map[labelPair]uint64) []labelPair {
+	keys := make([]labelPair, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].a != keys[j].a {
+			return keys[i].a < keys[j].a
+		}
+		return keys[i].b < keys[j].b
+	})
+	return keys
+}
+
+// quoteLabel quotes a label value per the Prometheus text format.
+func quoteLabel(v string) string {
+	v = strings.ReplaceAll(v, `\`, `\\`)
+	v = strings.ReplaceAll(v, "\n", `\n`)
+	v = strings.ReplaceAll(v, `"`, `\"`)
+	return `"` + v + `"`
+}
+
+// handleHealthz reports liveness: the process is up and serving HTTP. It
+// needs no token, so it reports nothing else.
+func handleHealthz(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	io.WriteString(w, "ok\n")
+}
+
+// readinessCacheTTL bounds how often /readyz shells out to wb, so frequent
+// probes don't spawn a subprocess each time.
+const readinessCacheTTL = 10 * time.Second
+
+var (
+	readinessMu      sync.Mutex
+	readinessChecked time.Time
+	readinessResult  map[string]interface{}
+	readinessOK      bool
+)
+
+// handleReadyz reports readiness: wb can mint an access token and the active
+// workspace resolves to a UUID. Returns 503 until both succeed.
+func handleReadyz(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	readinessMu.Lock()
+	if time.Since(readinessChecked) > readinessCacheTTL {
+		checks := map[string]interface{}{}
+		readinessOK = true
+
+		if _, err := getToken(ctx); err != nil {
+			checks["auth"] = map[string]interface{}{"ok": false, "error": err.Error()}
+			readinessOK = false
+		} else {
+			checks["auth"] = map[string]interface{}{"ok": true}
+		}
+
+		if _, err := getCurrentWorkspaceUUID(ctx); err != nil {
+			checks["workspace"] = map[string]interface{}{"ok": false, "error": err.Error()}
+			readinessOK = false
+		} else {
+			checks["workspace"] = map[string]interface{}{"ok": true}
+		}
+
+		status := "ready"
+		if !readinessOK {
+			status = "not ready"
+		}
+		readinessResult = map[string]interface{}{"status": status, "checks": checks}
+		readinessChecked = time.Now()
+	}
+	result, ok := readinessResult, readinessOK
+	readinessMu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	if !ok {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(result)
+}
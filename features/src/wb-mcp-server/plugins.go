@@ -0,0 +1,270 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Plugins add site-specific tools without changing the server. At startup
+// each file in the tools directories is loaded:
+//   - a .yaml, .yml or .json file declares one tool and the command that
+//     implements it
+//   - an executable is run as `<file> describe` and prints its tools as JSON
+//     (one tool object or a list); calls run `<file> call`
+//
+// A call runs the tool's command with one JSON object on stdin,
+//
+//	{"tool": "...", "arguments": {...}, "workspaceId": "..."}
+//
+// and returns its stdout. A non-zero exit status fails the call, with stderr
+// in the error.
+
+// defaultToolsDirs are loaded when no -tools-dir flag or WB_MCP_TOOLS_DIR
+// variable is given. Missing directories are skipped.
+var defaultToolsDirs = []string{
+	"$HOME/.config/wb-mcp-server/tools.d",
+	"/opt/wb-mcp-server/tools.d",
+}
+
+const (
+	defaultPluginTimeout  = 5 * time.Minute
+	pluginDescribeTimeout = 10 * time.Second
+)
+
+// pluginTool is a tool definition, as declared in a file or printed by an
+// executable's describe.
+type pluginTool struct {
+	Name        string      `json:"name"`
+	Description string      `json:"description"`
+	InputSchema InputSchema `json:"inputSchema"`
+	// Command is the argv to run; {{name}} in an element is replaced with
+	// the argument of that name. Relative paths are resolved against the
+	// definition's directory.
+	Command []string `json:"command,omitempty"`
+	// ReadOnly tools still run in dry runs.
+	ReadOnly       bool `json:"readOnly,omitempty"`
+	TimeoutSeconds int  `json:"timeoutSeconds,omitempty"`
+
+	source string
+}
+
+var (
+	plugins           = map[string]*pluginTool{}
+	pluginNamePattern = regexp.MustCompile(`^[a-zA-Z0-9_-]{1,64}$`)
+	pluginPlaceholder = regexp.MustCompile(`\{\{\s*([a-zA-Z0-9_]+)\s*\}\}`)
+)
+
+// initPlugins loads plugin tools from dir, or the default directories, and
+// appends them to wbTools. Bad plugins are skipped with a warning; a plugin
+// can't replace a built-in tool.
+func initPlugins(dir string) {
+	if dir == "" {
+		dir = os.Getenv("WB_MCP_TOOLS_DIR")
+	}
+	dirs := defaultToolsDirs
+	if dir != "" {
+		dirs = []string{dir}
+	}
+	taken := map[string]bool{}
+	for _, t := range wbTools {
+		taken[t.Name] = true
+	}
+	for _, d := range dirs {
+		d = os.ExpandEnv(d)
+		entries, err := os.ReadDir(d)
+		if err != nil {
+			if dir != "" || !errors.Is(err, os.ErrNotExist) {
+				log.Printf("Warning: failed to read tools directory %s: %v\n", d, err)
+			}
+			continue
+		}
+		loaded := 0
+		for _, e := range entries {
+			if e.IsDir() || strings.HasPrefix(e.Name(), ".") {
+				continue
+			}
+			path := filepath.Join(d, e.Name())
+			tools, err := loadPluginFile(path)
+			if err != nil {
+				log.Printf("Warning: skipping plugin %s: %v\n", path, err)
+				continue
+			}
+			for _, t := range tools {
+				switch {
+				case !pluginNamePattern.MatchString(t.Name):
+					log.Printf("Warning: skipping plugin tool %q from %s: names may only use letters, digits, _ and -\n", t.Name, path)
+				case taken[t.Name]:
+					log.Printf("Warning: skipping plugin tool %s from %s: the name is already taken\n", t.Name, path)
+				default:
+					taken[t.Name] = true
+					plugins[t.Name] = t
+					wbTools = append(wbTools, Tool{Name: t.Name, Description: t.Description, InputSchema: t.InputSchema})
+					loaded++
+				}
+			}
+		}
+		if loaded > 0 {
+			log.Printf("Loaded %d plugin tool(s) from %s\n", loaded, d)
+		}
+	}
+}
+
+// loadPluginFile reads the tools from one file in a tools directory. Files
+// that are neither definitions nor executables are ignored.
+func loadPluginFile(path string) ([]*pluginTool, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	var tools []*pluginTool
+	switch ext := strings.ToLower(filepath.Ext(path)); {
+	case ext == ".yaml" || ext == ".yml" || ext == ".json":
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		v, err := parseYAML(string(data))
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse: %w", err)
+		}
+		t, err := decodePluginTool(v)
+		if err != nil {
+			return nil, err
+		}
+		if len(t.Command) == 0 {
+			return nil, fmt.Errorf("command is required")
+		}
+		if exe := t.Command[0]; strings.Contains(exe, "/") && !filepath.IsAbs(exe) {
+			t.Command[0] = filepath.Join(filepath.Dir(path), exe)
+		}
+		tools = append(tools, t)
+	case info.Mode()&0111 != 0:
+		ctx, cancel := context.WithTimeout(context.Background(), pluginDescribeTimeout)
+		defer cancel()
+		out, err := exec.CommandContext(ctx, path, "describe").Output()
+		if err != nil {
+			return nil, fmt.Errorf("describe failed: %w", err)
+		}
+		var list []interface{}
+		if v, err := parseYAML(string(out)); err != nil {
+			return nil, fmt.Errorf("describe printed invalid JSON: %w", err)
+		} else if l, ok := v.([]interface{}); ok {
+			list = l
+		} else {
+			list = []interface{}{v}
+		}
+		for _, v := range list {
+			t, err := decodePluginTool(v)
+			if err != nil {
+				return nil, err
+			}
+			t.Command = []string{path, "call"}
+			tools = append(tools, t)
+		}
+	default:
+		return nil, nil
+	}
+	for _, t := range tools {
+		t.source = path
+		if t.Description == "" {
+			return nil, fmt.Errorf("tool %s: description is required", t.Name)
+		}
+		if t.InputSchema.Type == "" {
+			t.InputSchema.Type = "object"
+		}
+		if t.InputSchema.Properties == nil {
+			t.InputSchema.Properties = map[string]interface{}{}
+		}
+	}
+	return tools, nil
+}
+
+// decodePluginTool decodes one tool definition, rejecting unknown fields so
+// typos don't go unnoticed.
+func decodePluginTool(v interface{}) (*pluginTool, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, fmt.Errorf("invalid tool definition: %w", err)
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	var t pluginTool
+	if err := dec.Decode(&t); err != nil {
+		return nil, fmt.Errorf("invalid tool definition: %w", err)
+	}
+	return &t, nil
+}
+
+// callPlugin runs a plugin tool.
+func callPlugin(ctx context.Context, sess *session, t *pluginTool, args map[string]interface{}) (string, error) {
+	for _, name := range t.InputSchema.Required {
+		if _, ok := args[name]; !ok {
+			return "", fmt.Errorf("missing required parameter: %s", name)
+		}
+	}
+	arguments := map[string]interface{}{}
+	for k, v := range args {
+		if k != "dryRun" && k != "async" {
+			arguments[k] = v
+		}
+	}
+	workspaceId, _ := sess.currentWorkspace()
+	request, err := json.Marshal(map[string]interface{}{"tool": t.Name, "arguments": arguments, "workspaceId": workspaceId})
+	if err != nil {
+		return "", err
+	}
+
+	// A value substituted at the start of an element could turn it into an
+	// option, so values there mustn't start with "-".
+	argv := make([]string, len(t.Command))
+	for i, a := range t.Command {
+		argv[i] = pluginPlaceholder.ReplaceAllStringFunc(a, func(m string) string {
+			switch v := arguments[pluginPlaceholder.FindStringSubmatch(m)[1]].(type) {
+			case nil:
+				return ""
+			case string:
+				return v
+			default:
+				b, _ := json.Marshal(v)
+				return string(b)
+			}
+		})
+		if strings.HasPrefix(argv[i], "-") && !strings.HasPrefix(a, "-") {
+			return "", fmt.Errorf("invalid arguments for %s: %q would be passed as an option; values substituted into %q must not start with -", t.Name, argv[i], a)
+		}
+	}
+
+	timeout := defaultPluginTimeout
+	if t.TimeoutSeconds > 0 {
+		timeout = time.Duration(t.TimeoutSeconds) * time.Second
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	if t.ReadOnly {
+		ctx = context.WithValue(ctx, dryRunKey{}, (*dryRunRecorder)(nil))
+	}
+	cmd := exec.CommandContext(ctx, argv[0], argv[1:]...)
+	cmd.Stdin = bytes.NewReader(request)
+	cmd.Env = append(os.Environ(), "WB_MCP_TOOL="+t.Name, "WB_MCP_WORKSPACE="+workspaceId)
+	out, stderr, err := runCommandSplit(ctx, cmd)
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return string(out), fmt.Errorf("plugin %s timed out after %s", t.Name, timeout)
+	}
+	if err != nil {
+		if msg := strings.TrimSpace(string(stderr)); msg != "" {
+			return string(out), fmt.Errorf("plugin %s (%s) failed: %w: %s", t.Name, t.source, err, truncate(msg, 2000))
+		}
+		return string(out), fmt.Errorf("plugin %s (%s) failed: %w", t.Name, t.source, err)
+	}
+	return string(out), nil
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestCallPlugin(t *testing.T) {
+	script := `echo out; echo err >&2; [ "$1" != fail ]`
+	tests := []struct {
+		name    string
+		command []string
+		args    map[string]interface{}
+		want    string
+		wantErr string
+	}{
+		{
+			name:    "substitutes arguments",
+			command: []string{"echo", "{{a}}", "--n={{n}}", "x{{missing}}"},
+			args:    map[string]interface{}{"a": "hello", "n": float64(3)},
+			want:    "hello --n=3 x\n",
+		},
+		{
+			name:    "a value can't become an option",
+			command: []string{"echo", "{{a}}"},
+			args:    map[string]interface{}{"a": "-n"},
+			wantErr: `"-n" would be passed as an option`,
+		},
+		{
+			name:    "a value can follow literal text or an option",
+			command: []string{"echo", "x{{a}}", "--a={{a}}"},
+			args:    map[string]interface{}{"a": "-n"},
+			want:    "x-n --a=-n\n",
+		},
+		{
+			name:    "returns stdout only",
+			command: []string{"sh", "-c", script, "sh", "{{mode}}"},
+			args:    map[string]interface{}{"mode": "ok"},
+			want:    "out\n",
+		},
+		{
+			name:    "puts stderr in the error",
+			command: []string{"sh", "-c", script, "sh", "{{mode}}"},
+			args:    map[string]interface{}{"mode": "fail"},
+			want:    "out\n",
+			wantErr: "failed: exit status 1: err",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tool := &pluginTool{Name: "site_test", Command: tt.command, ReadOnly: true, source: "test"}
+			got, err := callPlugin(context.Background(), defaultSession, tool, tt.args)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got error %v, want %q", err, tt.wantErr)
+				}
+			} else if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("got output %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecodePluginTool(t *testing.T) {
+	if _, err := decodePluginTool(map[string]interface{}{"name": "x", "comand": []interface{}{"y"}}); err == nil || !strings.Contains(err.Error(), `unknown field "comand"`) {
+		t.Errorf("got %v, want an unknown field error", err)
+	}
+	if _, err := decodePluginTool(map[string]interface{}{"name": func() {}}); err == nil || !strings.Contains(err.Error(), "invalid tool definition") {
+		t.Errorf("got %v, want an invalid tool definition error", err)
+	}
+}
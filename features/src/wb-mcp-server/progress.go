@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+)
+
+// MCP progress notifications. A transport that can send messages while a
+// request is in flight puts a notifier in the context; a tools/call request
+// that carries _meta.progressToken then gets a progressReporter, and
+// long-running tools call reportProgress. Only stdio has a notifier: HTTP
+// responses are single JSON bodies, so HTTP clients should use async=true and
+// job_status instead.
+
+type notifyFunc func(method string, params interface{})
+
+type (
+	notifierKey struct{}
+	progressKey struct{}
+)
+
+type progressReporter struct {
+	token  interface{}
+	notify notifyFunc
+}
+
+func withNotifier(ctx context.Context, notify notifyFunc) context.Context {
+	return context.WithValue(ctx, notifierKey{}, notify)
+}
+
+// withProgressToken enables progress reporting for a request that asked for
+// it, if the transport can deliver notifications.
+func withProgressToken(ctx context.Context, token interface{}) context.Context {
+	notify, _ := ctx.Value(notifierKey{}).(notifyFunc)
+	if token == nil || notify == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, progressKey{}, &progressReporter{token: token, notify: notify})
+}
+
+// reportProgress sends a notifications/progress message if the caller asked
+// for progress; total may be 0 if unknown.
+func reportProgress(ctx context.Context, progress, total float64, message string) {
+	p, _ := ctx.Value(progressKey{}).(*progressReporter)
+	if p == nil {
+		return
+	}
+	params := map[string]interface{}{"progressToken": p.token, "progress": progress, "message": message}
+	if total > 0 {
+		params["total"] = total
+	}
+	p.notify("notifications/progress", params)
+}
+
+var stdoutMu sync.Mutex
+
+// writeStdio writes one JSON-RPC message per line to stdout.
+func writeStdio(msg interface{}) {
+	data, _ := json.Marshal(msg)
+	stdoutMu.Lock()
+	defer stdoutMu.Unlock()
+	fmt.Println(string(data))
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// saga tracks the compensating actions for the completed steps of a
+// multi-step tool, so a failure part way through can undo what was already
+// created instead of leaving orphans behind.
+//
+//	sg := &saga{}
+//	... create the cohort ...
+//	sg.undo("delete cohort "+id, func(ctx context.Context) error { ... })
+//	if err := nextStep(); err != nil {
+//		return sg.fail(ctx, err)
+//	}
+type saga struct {
+	compensations []compensation
+}
+
+type compensation struct {
+	desc string
+	fn   func(context.Context) error
+}
+
+// undo registers fn to run if a later step fails. Compensations run in
+// reverse order of registration.
+func (s *saga) undo(desc string, fn func(context.Context) error) {
+	s.compensations = append(s.compensations, compensation{desc, fn})
+}
+
+// fail runs the compensations and returns err extended with what was rolled
+// back and what wasn't. They run even if ctx was cancelled (e.g. a background
+// job that timed out), since the created objects exist either way.
+func (s *saga) fail(ctx context.Context, err error) error {
+	if len(s.compensations) == 0 {
+		return err
+	}
+	ctx, span := startSpan(context.WithoutCancel(ctx), "rollback", spanKindInternal)
+	var done, failed []string
+	for i := len(s.compensations) - 1; i >= 0; i-- {
+		c := s.compensations[i]
+		if cerr := c.fn(ctx); cerr != nil {
+			failed = append(failed, fmt.Sprintf("%s: %v", c.desc, cerr))
+		} else {
+			done = append(done, c.desc)
+		}
+	}
+	s.compensations = nil
+	span.setAttr("rollback.completed", len(done))
+	span.setAttr("rollback.failed", len(failed))
+
+	var b strings.Builder
+	if len(done) > 0 {
+		fmt.Fprintf(&b, "\nRolled back:\n- %s", strings.Join(done, "\n- "))
+	}
+	if len(failed) > 0 {
+		fmt.Fprintf(&b, "\nRollback incomplete; clean these up manually:\n- %s", strings.Join(failed, "\n- "))
+		span.end(fmt.Errorf("%d compensating action(s) failed", len(failed)))
+	} else {
+		span.end(nil)
+	}
+	return &rollbackError{err: err, report: b.String()}
+}
+
+// rollbackError is a failed step's error followed by the rollback report.
+// It unwraps to the step's error so classifyError still sees an *apiError
+// or *exec.ExitError underneath.
+type rollbackError struct {
+	err    error
+	report string
+}
+
+func (e *rollbackError) Error() string { return e.err.Error() + e.report }
+
+func (e *rollbackError) Unwrap() error { return e.err }
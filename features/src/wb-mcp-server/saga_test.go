@@ -0,0 +1,29 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestSagaFailKeepsCause(t *testing.T) {
+	sg := &saga{}
+	sg.undo("delete cohort c1", func(context.Context) error { return nil })
+	sg.undo("delete review r1", func(context.Context) error { return errors.New("boom") })
+	err := sg.fail(context.Background(), fmt.Errorf("Step 2 failed: %w", &apiError{Status: 409, Body: "conflict"}))
+
+	var ae *apiError
+	if !errors.As(err, &ae) || ae.Status != 409 {
+		t.Fatalf("errors.As(%v) didn't find the *apiError", err)
+	}
+	for _, want := range []string{"Step 2 failed: API error (409)", "Rolled back:\n- delete cohort c1", "clean these up manually:\n- delete review r1: boom"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("got %q, want it to contain %q", err, want)
+		}
+	}
+	if te := classifyError(err, ""); te.Source != "api" || te.Status != 409 || te.Category != errConflict {
+		t.Errorf("classifyError = %s/%d/%s, want api/409/%s", te.Source, te.Status, te.Category, errConflict)
+	}
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// sessionIdleTimeout is how long an HTTP session may go unused before it is
+// dropped. Clients that come back later get a 404 and re-initialize.
+const sessionIdleTimeout = 24 * time.Hour
+
+// session holds per-client state. In HTTP mode every MCP session (identified
+// by the Mcp-Session-Id header) gets its own; stdio mode uses defaultSession.
+type session struct {
+	id string
+
+	mu            sync.Mutex
+	workspaceID   string // user-facing ID selected via workspace_use; "" = wb CLI default
+	workspaceUUID string
+	lastUsed      time.Time
+}
+
+var (
+	sessionsMu sync.Mutex
+	sessions   = map[string]*session{}
+
+	// defaultSession serves stdio mode and HTTP clients that never negotiated
+	// a session ID.
+	defaultSession = &session{id: "default"}
+)
+
+// newSession creates and registers a session with a random ID, pruning any
+// sessions that have been idle longer than sessionIdleTimeout.
+func newSession() *session {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		// crypto/rand never fails on supported platforms; fall back to time.
+		buf = []byte(fmt.Sprintf("%016x", time.Now().UnixNano()))
+	}
+	s := &session{id: hex.EncodeToString(buf), lastUsed: time.Now()}
+
+	sessionsMu.Lock()
+	defer sessionsMu.Unlock()
+	for id, old := range sessions {
+		old.mu.Lock()
+		idle := time.Since(old.lastUsed)
+		old.mu.Unlock()
+		if idle > sessionIdleTimeout {
+			delete(sessions, id)
+		}
+	}
+	sessions[s.id] = s
+	return s
+}
+
+// lookupSession returns the registered session with the given ID.
+func lookupSession(id string) (*session, bool) {
+	sessionsMu.Lock()
+	s, ok := sessions[id]
+	sessionsMu.Unlock()
+	if ok {
+		s.mu.Lock()
+		s.lastUsed = time.Now()
+		s.mu.Unlock()
+	}
+	return s, ok
+}
+
+// endSession forgets a session, e.g. when the client sends HTTP DELETE.
+func endSession(id string) bool {
+	sessionsMu.Lock()
+	defer sessionsMu.Unlock()
+	if _, ok := sessions[id]; !ok {
+		return false
+	}
+	delete(sessions, id)
+	return true
+}
+
+// currentWorkspace returns the workspace selected with workspace_use, or empty
+// strings if the session still follows the wb CLI's active workspace.
+func (s *session) currentWorkspace() (id, uuid string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.workspaceID, s.workspaceUUID
+}
+
+func (s *session) setWorkspace(id, uuid string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.workspaceID = id
+	s.workspaceUUID = uuid
+}
+
+// inWorkspace returns a session that targets workspaceId, for tools that take
+// the workspace as an argument rather than following the session's selection.
+// An empty workspaceId returns s.
+func (s *session) inWorkspace(workspaceId string) *session {
+	if workspaceId == "" {
+		return s
+	}
+	return &session{id: s.id, workspaceID: workspaceId}
+}
+
+// resolveWorkspaceUUID returns the UUID of the workspace this session targets:
+// the one chosen via workspace_use, otherwise the wb CLI's active workspace.
+func (s *session) resolveWorkspaceUUID(ctx context.Context) (string, error) {
+	id, uuid := s.currentWorkspace()
+	if uuid != "" {
+		return uuid, nil
+	}
+	if id != "" {
+		return resolveWorkspaceId(ctx, id)
+	}
+	return getCurrentWorkspaceUUID(ctx)
+}
+
+// wbArgs appends --workspace=<id> to a workspace-scoped wb command when the
+// session has selected a workspace, so the command targets it without
+// changing the CLI's global context via `wb workspace set`.
+func (s *session) wbArgs(args ...string) []string {
+	if id, _ := s.currentWorkspace(); id != "" {
+		return append(args, "--workspace="+id)
+	}
+	return args
+}
+
+// handleWorkspaceUse implements the workspace_use tool. An empty workspaceId
+// clears the selection so the session follows the wb CLI's active workspace.
+func handleWorkspaceUse(ctx context.Context, sess *session, args map[string]interface{}) (string, error) {
+	workspaceId, _ := args["workspaceId"].(string)
+
+	result := map[string]interface{}{"sessionId": sess.id}
+	if workspaceId == "" {
+		if r := dryRunFrom(ctx); r != nil {
+			r.record(dryRunAction{Method: "SESSION", URL: "clear the workspace selection"})
+			return "", nil
+		}
+		sess.setWorkspace("", "")
+		uuid, err := getCurrentWorkspaceUUID(ctx)
+		if err != nil {
+			result["note"] = fmt.Sprintf("Selection cleared, but the wb CLI has no usable active workspace: %v", err)
+		} else {
+			result["workspaceUuid"] = uuid
+			result["note"] = "Selection cleared. Workspace-scoped tools in this session follow the wb CLI's active workspace."
+		}
+		resultBytes, _ := json.MarshalIndent(result, "", "  ")
+		return string(resultBytes), nil
+	}
+
+	uuid, err := resolveWorkspaceId(ctx, workspaceId)
+	if err != nil {
+		return "", err
+	}
+	// Fetch the workspace both to confirm access and to learn its user-facing
+	// ID, which is what the wb CLI expects in --workspace.
+	respBody, err := makeAPIRequest(ctx, "GET", fmt.Sprintf("%s/api/workspaces/v1/%s", workspaceBaseURL, uuid), nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to describe workspace %s: %w", workspaceId, err)
+	}
+	var ws map[string]interface{}
+	if err := json.Unmarshal(respBody, &ws); err != nil {
+		return "", fmt.Errorf("failed to parse workspace: %w", err)
+	}
+	userFacingId, _ := ws["userFacingId"].(string)
+	if userFacingId == "" {
+		userFacingId = workspaceId
+	}
+	if r := dryRunFrom(ctx); r != nil {
+		r.record(dryRunAction{Method: "SESSION", URL: "select workspace " + userFacingId})
+		return "", nil
+	}
+	sess.setWorkspace(userFacingId, uuid)
+
+	result["workspaceId"] = userFacingId
+	result["workspaceUuid"] = uuid
+	if name, ok := ws["displayName"].(string); ok && name != "" {
+		result["displayName"] = name
+	}
+	result["note"] = "Workspace-scoped tools in this session now target this workspace. The wb CLI's active workspace is unchanged."
+	resultBytes, _ := json.MarshalIndent(result, "", "  ")
+	return string(resultBytes), nil
+}
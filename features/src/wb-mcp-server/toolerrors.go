@@ -0,0 +1,192 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Failed tool calls keep their "Error: ..." text and also carry a toolError
+// in structuredContent, so agents can tell a bad argument (fix and retry)
+// from an outage (wait and retry) from missing access (ask someone).
+
+// Error categories.
+const (
+	errAuth                = "auth"
+	errNotFound            = "not_found"
+	errPermissionDenied    = "permission_denied"
+	errValidation          = "validation"
+	errConflict            = "conflict"
+	errUpstreamUnavailable = "upstream_unavailable"
+	errTimeout             = "timeout"
+	errInternal            = "internal"
+)
+
+// toolError is the machine-readable form of a failed call.
+type toolError struct {
+	Category  string `json:"category"`
+	Code      string `json:"code"`
+	Source    string `json:"source"` // api, cli or server
+	Status    int    `json:"status,omitempty"`
+	ExitCode  int    `json:"exitCode,omitempty"`
+	Retryable bool   `json:"retryable"`
+	Hint      string `json:"hint,omitempty"`
+	Message   string `json:"message"`
+}
+
+// apiError is a non-2xx response from a Workbench API.
+type apiError struct {
+	Status int
+	Body   string
+}
+
+func (e *apiError) Error() string {
+	return fmt.Sprintf("API error (%d): %s", e.Status, e.Body)
+}
+
+var errorHints = map[string]string{
+	errAuth:                "Credentials are missing or expired. Run `wb auth login` and retry.",
+	errNotFound:            "Check the ID or name; the list tools (workspace_list_all, workspace_list_resources, ...) show valid values.",
+	errPermissionDenied:    "The caller lacks the required role. workspace_list_users shows who can grant it.",
+	errValidation:          "Fix the arguments; retrying unchanged will fail the same way.",
+	errConflict:            "The object already exists or changed concurrently. Describe it, then retry or use another name.",
+	errUpstreamUnavailable: "A Workbench service is unavailable or rate limiting. Retry after a short wait.",
+	errTimeout:             "The operation timed out. Retry, or use async=true for long-running calls.",
+}
+
+// statusCategory maps HTTP statuses to categories.
+func statusCategory(status int) string {
+	switch {
+	case status == 401:
+		return errAuth
+	case status == 403:
+		return errPermissionDenied
+	case status == 404 || status == 410:
+		return errNotFound
+	case status == 409 || status == 412:
+		return errConflict
+	case status == 408 || status == 504:
+		return errTimeout
+	case status == 429 || status >= 500:
+		return errUpstreamUnavailable
+	case status >= 400:
+		return errValidation
+	}
+	return ""
+}
+
+// errorPatterns classify errors from text: wb CLI output, wrapped errors and
+// the server's own messages. The first match wins.
+var errorPatterns = []struct {
+	re       *regexp.Regexp
+	category string
+	code     string
+}{
+	{regexp.MustCompile(`(?i)failed to get access token|unauthenticated|not logged in|wb auth login|invalid_grant|token (has )?expired`), errAuth, "UNAUTHENTICATED"},
+	{regexp.MustCompile(`(?i)permission[_ ]denied|forbidden|not authorized|does not have (permission|access)|insufficient permission`), errPermissionDenied, "PERMISSION_DENIED"},
+	{regexp.MustCompile(`^Unknown tool: `), errValidation, "UNKNOWN_TOOL"},
+	{regexp.MustCompile(`(?i)missing required parameter|'[a-zA-Z]+' required`), errValidation, "MISSING_PARAMETER"},
+	{regexp.MustCompile(`(?i)did you mean|\(valid: `), errValidation, "UNKNOWN_NAME"},
+	{regexp.MustCompile(`(?i)already exists|conflict`), errConflict, "ALREADY_EXISTS"},
+	{regexp.MustCompile(`(?i)not found|does not exist|no such (resource|workspace|job)|unknown job`), errNotFound, "NOT_FOUND"},
+	{regexp.MustCompile(`(?i)timed out|deadline exceeded|timeout`), errTimeout, "DEADLINE_EXCEEDED"},
+	{regexp.MustCompile(`(?i)connection refused|no such host|service unavailable|temporarily unavailable|too many requests|rate limit|connection reset`), errUpstreamUnavailable, "UNAVAILABLE"},
+	{regexp.MustCompile(`(?i)invalid|must be|unsupported|cannot|can't|expected|malformed|not allowed|required`), errValidation, "INVALID_ARGUMENT"},
+}
+
+// cliStatus finds an HTTP status in wb CLI output, e.g. "API error (404)" or
+// "status code: 403".
+var cliStatus = regexp.MustCompile(`(?i)(?:status(?: code)?|error|code)\D{0,3}\(?([45]\d\d)\b`)
+
+// classifyError builds the toolError for err; output is whatever the failed
+// command printed.
+func classifyError(err error, output string) toolError {
+	te := toolError{Source: "server", Message: truncate(strings.TrimSpace(err.Error()), 500)}
+	text := err.Error() + "\n" + output
+
+	var ae *apiError
+	var ee *exec.ExitError
+	var ne net.Error
+	switch {
+	case errors.As(err, &ae):
+		te.Source, te.Status = "api", ae.Status
+	case errors.As(err, &ee):
+		te.Source, te.ExitCode = "cli", ee.ExitCode()
+		if m := cliStatus.FindStringSubmatch(output); m != nil {
+			te.Status, _ = strconv.Atoi(m[1])
+		}
+		if out := strings.TrimSpace(output); out != "" {
+			te.Message = truncate(out, 500)
+		}
+	case errors.Is(err, exec.ErrNotFound):
+		te.Source, te.Category, te.Code = "cli", errInternal, "CLI_NOT_INSTALLED"
+		te.Hint = "The wb or aws CLI isn't on PATH for the server; install it or fix PATH."
+	case errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &ne) && ne.Timeout()):
+		te.Source, te.Category, te.Code = "api", errTimeout, "DEADLINE_EXCEEDED"
+	case errors.As(err, &ne):
+		te.Source, te.Category, te.Code = "api", errUpstreamUnavailable, "UNAVAILABLE"
+	default:
+		if m := cliStatus.FindStringSubmatch(text); m != nil {
+			te.Status, _ = strconv.Atoi(m[1])
+		}
+	}
+
+	if te.Category == "" && te.Status != 0 {
+		te.Category, te.Code = statusCategory(te.Status), "HTTP_"+strconv.Itoa(te.Status)
+	}
+	if te.Category == "" {
+		for _, p := range errorPatterns {
+			if p.re.MatchString(text) {
+				te.Category, te.Code = p.category, p.code
+				break
+			}
+		}
+	}
+	if te.Category == "" {
+		te.Category, te.Code = errInternal, "UNKNOWN"
+		if te.Source == "cli" {
+			te.Code = "CLI_EXIT_" + strconv.Itoa(te.ExitCode)
+		}
+	}
+	te.Retryable = te.Category == errUpstreamUnavailable || te.Category == errTimeout
+	if te.Hint == "" {
+		te.Hint = errorHints[te.Category]
+	}
+	return te
+}
+
+// errorResult is the CallToolResult for a failed call.
+func errorResult(err error, output string) CallToolResult {
+	errMsg := fmt.Sprintf("Error: %s", err.Error())
+	if output != "" {
+		errMsg += "\n" + output
+	}
+	return withErrorDetails(CallToolResult{Content: []ContentItem{{Type: "text", Text: errMsg}}, IsError: true}, classifyError(err, output))
+}
+
+// withErrorDetails attaches te to a failed result. Given a zero toolError it
+// classifies the result's text, for the early returns in handleCallTool
+// that only build text.
+func withErrorDetails(res CallToolResult, te toolError) CallToolResult {
+	if !res.IsError || res.StructuredContent != nil {
+		return res
+	}
+	if te.Category == "" {
+		text := ""
+		for _, c := range res.Content {
+			text += c.Text
+		}
+		te = classifyError(errors.New(strings.TrimPrefix(text, "Error: ")), "")
+	}
+	res.StructuredContent = map[string]interface{}{"error": te}
+	if te.Hint != "" && len(res.Content) > 0 {
+		last := &res.Content[len(res.Content)-1]
+		last.Text = strings.TrimRight(last.Text, "\n") + "\nHint: " + te.Hint
+	}
+	return res
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"testing"
+)
+
+func TestClassifyError(t *testing.T) {
+	exit := func(code int) error {
+		return exec.Command("sh", "-c", fmt.Sprintf("exit %d", code)).Run()
+	}
+	tests := []struct {
+		name      string
+		err       error
+		output    string
+		source    string
+		category  string
+		code      string
+		status    int
+		retryable bool
+	}{
+		{"API 404", &apiError{Status: 404, Body: "cohort not found"}, "", "api", errNotFound, "HTTP_404", 404, false},
+		{"wrapped API 503", fmt.Errorf("list cohorts: %w", &apiError{Status: 503, Body: "down"}), "", "api", errUpstreamUnavailable, "HTTP_503", 503, true},
+		{"API 400", &apiError{Status: 400, Body: "bad filter"}, "", "api", errValidation, "HTTP_400", 400, false},
+		{"CLI status in output", exit(1), "[ERROR] API error (403): caller lacks access", "cli", errPermissionDenied, "HTTP_403", 403, false},
+		{"CLI not logged in", exit(1), "You are not logged in. Run `wb auth login`.", "cli", errAuth, "UNAUTHENTICATED", 0, false},
+		{"CLI already exists", exit(2), "Resource with name outbucket already exists", "cli", errConflict, "ALREADY_EXISTS", 0, false},
+		{"CLI not found", exit(1), "Workspace not found: ws-x", "cli", errNotFound, "NOT_FOUND", 0, false},
+		{"CLI connection refused", exit(1), "dial tcp: connection refused", "cli", errUpstreamUnavailable, "UNAVAILABLE", 0, true},
+		{"CLI without a message", exit(7), "", "cli", errInternal, "CLI_EXIT_7", 0, false},
+		{"CLI missing", fmt.Errorf("run wb: %w", exec.ErrNotFound), "", "cli", errInternal, "CLI_NOT_INSTALLED", 0, false},
+		{"deadline", fmt.Errorf("query: %w", context.DeadlineExceeded), "", "api", errTimeout, "DEADLINE_EXCEEDED", 0, true},
+		{"unknown tool", errors.New("Unknown tool: workspace_nope"), "", "server", errValidation, "UNKNOWN_TOOL", 0, false},
+		{"missing parameter", errors.New("missing required parameter: cohortId"), "", "server", errValidation, "MISSING_PARAMETER", 0, false},
+		{"unknown name", errors.New(`unknown entity "persn", did you mean "person"?`), "", "server", errValidation, "UNKNOWN_NAME", 0, false},
+		{"invalid argument", errors.New("limit must be positive"), "", "server", errValidation, "INVALID_ARGUMENT", 0, false},
+		{"status in text", errors.New("upload failed: status code: 429"), "", "server", errUpstreamUnavailable, "HTTP_429", 429, true},
+		{"unclassified", errors.New("something odd"), "", "server", errInternal, "UNKNOWN", 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			te := classifyError(tt.err, tt.output)
+			if te.Source != tt.source || te.Category != tt.category || te.Code != tt.code || te.Status != tt.status || te.Retryable != tt.retryable {
+				t.Errorf("got %s/%s/%s/%d/%v, want %s/%s/%s/%d/%v", te.Source, te.Category, te.Code, te.Status, te.Retryable,
+					tt.source, tt.category, tt.code, tt.status, tt.retryable)
+			}
+		})
+	}
+}
@@ -0,0 +1,473 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Tracing emits OpenTelemetry spans in the OTLP/JSON encoding, so the server
+// needs no SDK: one span per JSON-RPC request, with child spans for each
+// Workbench API request and each wb/aws/psql subprocess. Spans are batched and
+// POSTed to an OTLP/HTTP collector, appended to a file as one
+// ExportTraceServiceRequest per line (the format the collector's otlpjsonfile
+// receiver reads), or both. Tracing is off unless a destination is configured.
+
+// OTLP span kinds.
+const (
+	spanKindInternal = 1
+	spanKindServer   = 2
+	spanKindClient   = 3
+)
+
+// OTLP status codes.
+const (
+	statusCodeOK    = 1
+	statusCodeError = 2
+)
+
+const (
+	traceBatchSize     = 256
+	traceFlushInterval = 2 * time.Second
+	traceQueueSize     = 4096
+	maxAttrValueLen    = 1024
+)
+
+// tracingOptions carries the command-line flags for trace export.
+type tracingOptions struct {
+	Endpoint string // OTLP/HTTP collector base URL, e.g. http://127.0.0.1:4318
+	File     string
+}
+
+// spanContext identifies a span; it is what travels in a context.Context and
+// in W3C traceparent headers.
+type spanContext struct {
+	traceID [16]byte
+	spanID  [8]byte
+}
+
+type spanAttr struct {
+	key   string
+	value interface{}
+}
+
+type span struct {
+	spanContext
+	parentID [8]byte
+	name     string
+	kind     int
+	start    time.Time
+
+	mu        sync.Mutex
+	attrs     []spanAttr
+	errored   bool
+	statusMsg string
+}
+
+type spanContextKey struct{}
+
+var (
+	// tracer is nil when tracing is disabled; startSpan then returns a nil
+	// *span, whose methods are no-ops.
+	tracer *traceExporter
+)
+
+type traceExporter struct {
+	endpoint    string
+	headers     map[string]string
+	file        *os.File
+	serviceName string
+	queue       chan *spanRecord
+	flushReq    chan chan struct{}
+	exportFails bool // last export failed; suppresses repeated warnings
+}
+
+// spanRecord is a finished span, ready to encode.
+type spanRecord struct {
+	*span
+	end time.Time
+}
+
+// initTracing configures trace export from flags or, failing that, the
+// standard OTEL_EXPORTER_OTLP_* variables and WB_MCP_TRACE_FILE.
+func initTracing(opts tracingOptions) error {
+	// As in the OTel SDKs, a base endpoint gets /v1/traces appended while the
+	// traces-specific variable is used as-is.
+	var endpoint string
+	switch {
+	case opts.Endpoint != "":
+		endpoint = strings.TrimRight(opts.Endpoint, "/") + "/v1/traces"
+	case os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT") != "":
+		endpoint = os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT")
+	case os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT") != "":
+		endpoint = strings.TrimRight(os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT"), "/") + "/v1/traces"
+	}
+	filePath := firstNonEmpty(opts.File, os.Getenv("WB_MCP_TRACE_FILE"))
+	if endpoint == "" && filePath == "" {
+		return nil
+	}
+
+	exp := &traceExporter{
+		endpoint:    endpoint,
+		headers:     parseOTLPHeaders(os.Getenv("OTEL_EXPORTER_OTLP_HEADERS")),
+		serviceName: firstNonEmpty(os.Getenv("OTEL_SERVICE_NAME"), "wb-mcp-server"),
+		queue:       make(chan *spanRecord, traceQueueSize),
+		flushReq:    make(chan chan struct{}),
+	}
+	if endpoint != "" {
+		if err := validateEndpointURL(endpoint); err != nil {
+			return fmt.Errorf("invalid OTLP endpoint %q: %w", endpoint, err)
+		}
+	}
+	if filePath != "" {
+		f, err := os.OpenFile(filePath, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+		if err != nil {
+			return fmt.Errorf("failed to open trace file: %w", err)
+		}
+		exp.file = f
+	}
+	tracer = exp
+	go exp.run()
+
+	// Flush buffered spans before exiting on a signal, then let the signal
+	// take its default action.
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		sig := <-sigs
+		shutdownTracing()
+		signal.Stop(sigs)
+		syscall.Kill(os.Getpid(), sig.(syscall.Signal))
+	}()
+
+	var dests []string
+	if endpoint != "" {
+		dests = append(dests, endpoint)
+	}
+	if filePath != "" {
+		dests = append(dests, filePath)
+	}
+	log.Printf("Tracing enabled - exporting spans to %s", strings.Join(dests, ", "))
+	return nil
+}
+
+// shutdownTracing exports any buffered spans. Safe to call when tracing is off.
+func shutdownTracing() {
+	if tracer == nil {
+		return
+	}
+	done := make(chan struct{})
+	select {
+	case tracer.flushReq <- done:
+		<-done
+	case <-time.After(5 * time.Second):
+	}
+}
+
+// parseOTLPHeaders parses OTEL_EXPORTER_OTLP_HEADERS ("k1=v1,k2=v2").
+func parseOTLPHeaders(raw string) map[string]string {
+	headers := map[string]string{}
+	for _, pair := range strings.Split(raw, ",") {
+		k, v, ok := strings.Cut(pair, "=")
+		if !ok {
+			continue
+		}
+		if uv, err := url.QueryUnescape(strings.TrimSpace(v)); err == nil {
+			v = uv
+		}
+		headers[strings.TrimSpace(k)] = v
+	}
+	return headers
+}
+
+// startSpan starts a span as a child of the span in ctx, or a new trace if
+// there is none. The returned context carries the new span.
+func startSpan(ctx context.Context, name string, kind int) (context.Context, *span) {
+	if tracer == nil {
+		return ctx, nil
+	}
+	s := &span{name: name, kind: kind, start: time.Now()}
+	if parent, ok := ctx.Value(spanContextKey{}).(spanContext); ok {
+		s.traceID = parent.traceID
+		s.parentID = parent.spanID
+	} else {
+		rand.Read(s.traceID[:])
+	}
+	rand.Read(s.spanID[:])
+	return context.WithValue(ctx, spanContextKey{}, s.spanContext), s
+}
+
+// contextWithTraceparent returns ctx carrying the remote parent from a W3C
+// traceparent header, so a client's trace continues into the server.
+func contextWithTraceparent(ctx context.Context, header string) context.Context {
+	parts := strings.Split(strings.TrimSpace(header), "-")
+	if len(parts) != 4 || parts[0] != "00" || len(parts[1]) != 32 || len(parts[2]) != 16 {
+		return ctx
+	}
+	var sc spanContext
+	if _, err := hex.Decode(sc.traceID[:], []byte(parts[1])); err != nil {
+		return ctx
+	}
+	if _, err := hex.Decode(sc.spanID[:], []byte(parts[2])); err != nil {
+		return ctx
+	}
+	if sc.traceID == ([16]byte{}) || sc.spanID == ([8]byte{}) {
+		return ctx
+	}
+	return context.WithValue(ctx, spanContextKey{}, sc)
+}
+
+// traceparent formats the span as a W3C traceparent header value.
+func (s *span) traceparent() string {
+	return "00-" + hex.EncodeToString(s.traceID[:]) + "-" + hex.EncodeToString(s.spanID[:]) + "-01"
+}
+
+func (s *span) setName(name string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	s.name = name
+	s.mu.Unlock()
+}
+
+// setAttr records an attribute. Values may be string, bool, int, int64 or float64.
+func (s *span) setAttr(key string, value interface{}) {
+	if s == nil {
+		return
+	}
+	if str, ok := value.(string); ok {
+		value = truncate(str, maxAttrValueLen)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := range s.attrs {
+		if s.attrs[i].key == key {
+			s.attrs[i].value = value
+			return
+		}
+	}
+	s.attrs = append(s.attrs, spanAttr{key, value})
+}
+
+// setError marks the span failed with msg as its status message.
+func (s *span) setError(msg string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	s.errored = true
+	s.statusMsg = truncate(msg, maxAttrValueLen)
+	s.mu.Unlock()
+}
+
+// end finishes the span, recording err (if any) as its status, and queues it
+// for export. Spans are dropped rather than blocking when the queue is full.
+func (s *span) end(err error) {
+	if s == nil {
+		return
+	}
+	if err != nil {
+		s.setError(err.Error())
+	}
+	select {
+	case tracer.queue <- &spanRecord{span: s, end: time.Now()}:
+	default:
+	}
+}
+
+// toolWorkspace is the workspace a tool call targets for tracing: an explicit
+// workspaceId argument, else the session's selection. Empty means the wb CLI's
+// active workspace.
+func toolWorkspace(sess *session, params CallToolParams) string {
+	if id, ok := params.Arguments["workspaceId"].(string); ok && id != "" {
+		return id
+	}
+	id, _ := sess.currentWorkspace()
+	return id
+}
+
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[:n] + "..."
+}
+
+func (e *traceExporter) run() {
+	ticker := time.NewTicker(traceFlushInterval)
+	defer ticker.Stop()
+	var batch []*spanRecord
+	for {
+		select {
+		case rec := <-e.queue:
+			batch = append(batch, rec)
+			if len(batch) >= traceBatchSize {
+				e.export(batch)
+				batch = nil
+			}
+		case <-ticker.C:
+			if len(batch) > 0 {
+				e.export(batch)
+				batch = nil
+			}
+		case done := <-e.flushReq:
+			for drained := false; !drained; {
+				select {
+				case rec := <-e.queue:
+					batch = append(batch, rec)
+				default:
+					drained = true
+				}
+			}
+			if len(batch) > 0 {
+				e.export(batch)
+				batch = nil
+			}
+			close(done)
+		}
+	}
+}
+
+// export writes one batch as an OTLP ExportTraceServiceRequest.
+func (e *traceExporter) export(batch []*spanRecord) {
+	spans := make([]map[string]interface{}, 0, len(batch))
+	for _, rec := range batch {
+		spans = append(spans, rec.otlp())
+	}
+	payload := map[string]interface{}{
+		"resourceSpans": []interface{}{map[string]interface{}{
+			"resource": map[string]interface{}{
+				"attributes": otlpAttributes([]spanAttr{
+					{"service.name", e.serviceName},
+					{"service.version", "2.0.0"},
+				}),
+			},
+			"scopeSpans": []interface{}{map[string]interface{}{
+				"scope": map[string]interface{}{"name": "wb-mcp-server"},
+				"spans": spans,
+			}},
+		}},
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		log.Printf("Warning: failed to encode spans: %v", err)
+		return
+	}
+
+	var failures []string
+	if e.file != nil {
+		if _, err := e.file.Write(append(body, '\n')); err != nil {
+			failures = append(failures, err.Error())
+		}
+	}
+	if e.endpoint != "" {
+		if err := e.post(body); err != nil {
+			failures = append(failures, err.Error())
+		}
+	}
+	if len(failures) > 0 {
+		if !e.exportFails {
+			log.Printf("Warning: trace export failed (further failures not logged until it recovers): %s", strings.Join(failures, "; "))
+		}
+		e.exportFails = true
+	} else {
+		e.exportFails = false
+	}
+}
+
+func (e *traceExporter) post(body []byte) error {
+	req, err := http.NewRequest("POST", e.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range e.headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("OTLP collector returned %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// otlp encodes a finished span in the OTLP/JSON mapping: IDs as hex, 64-bit
+// integers as decimal strings.
+func (rec *spanRecord) otlp() map[string]interface{} {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	out := map[string]interface{}{
+		"traceId":           hex.EncodeToString(rec.traceID[:]),
+		"spanId":            hex.EncodeToString(rec.spanID[:]),
+		"name":              rec.name,
+		"kind":              rec.kind,
+		"startTimeUnixNano": strconv.FormatInt(rec.start.UnixNano(), 10),
+		"endTimeUnixNano":   strconv.FormatInt(rec.end.UnixNano(), 10),
+		"attributes":        otlpAttributes(rec.attrs),
+		"status":            map[string]interface{}{"code": statusCodeOK},
+	}
+	if rec.parentID != ([8]byte{}) {
+		out["parentSpanId"] = hex.EncodeToString(rec.parentID[:])
+	}
+	if rec.errored {
+		out["status"] = map[string]interface{}{"code": statusCodeError, "message": rec.statusMsg}
+	}
+	return out
+}
+
+func otlpAttributes(attrs []spanAttr) []interface{} {
+	out := make([]interface{}, 0, len(attrs))
+	for _, a := range attrs {
+		var v map[string]interface{}
+		switch val := a.value.(type) {
+		case bool:
+			v = map[string]interface{}{"boolValue": val}
+		case int:
+			v = map[string]interface{}{"intValue": strconv.Itoa(val)}
+		case int64:
+			v = map[string]interface{}{"intValue": strconv.FormatInt(val, 10)}
+		case float64:
+			v = map[string]interface{}{"doubleValue": val}
+		default:
+			v = map[string]interface{}{"stringValue": fmt.Sprint(val)}
+		}
+		out = append(out, map[string]interface{}{"key": a.key, "value": v})
+	}
+	return out
+}
+
+var passwordParam = regexp.MustCompile(`(?i)(password=)[^\s&]+`)
+
+// redactCommandLine joins a command line for a span attribute, masking
+// passwords in psql connection strings (URL or key=value form).
+func redactCommandLine(args []string) string {
+	redacted := make([]string, len(args))
+	for i, arg := range args {
+		if strings.Contains(arg, "://") {
+			if u, err := url.Parse(arg); err == nil && u.User != nil {
+				arg = u.Redacted()
+			}
+		}
+		redacted[i] = passwordParam.ReplaceAllString(arg, "${1}xxxxx")
+	}
+	return strings.Join(redacted, " ")
+}
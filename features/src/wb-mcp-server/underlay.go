@@ -0,0 +1,374 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// underlaySchemaTTL bounds how long a parsed underlay schema is reused.
+// Underlays change only on Data Explorer deploys.
+const underlaySchemaTTL = 10 * time.Minute
+
+// underlaySchema is the subset of an underlay's serializedConfiguration the
+// server reasons about locally: entities with their attributes and
+// hierarchies, entity groups, and criteria selectors.
+type underlaySchema struct {
+	Name          string
+	PrimaryEntity string
+	Entities      map[string]*underlayEntity
+	EntityGroups  map[string]*underlayEntityGroup
+	Selectors     []*criteriaSelector
+	loaded        time.Time
+}
+
+type underlayEntity struct {
+	Name        string              `json:"name"`
+	IDAttribute string              `json:"idAttribute"`
+	Attributes  []underlayAttribute `json:"attributes"`
+	Hierarchies []struct {
+		Name string `json:"name"`
+	} `json:"hierarchies"`
+}
+
+type underlayAttribute struct {
+	Name     string `json:"name"`
+	DataType string `json:"dataType"`
+}
+
+// underlayEntityGroup covers both criteria-occurrence groups (criteriaEntity)
+// and group-items groups (groupEntity/itemsEntity).
+type underlayEntityGroup struct {
+	Name           string `json:"name"`
+	CriteriaEntity string `json:"criteriaEntity"`
+	GroupEntity    string `json:"groupEntity"`
+	ItemsEntity    string `json:"itemsEntity"`
+}
+
+// criteriaSelector is a parsed entry of criteriaSelectors. PluginConfig is
+// kept as the raw string because criteria echo it back as uiConfig.
+type criteriaSelector struct {
+	Name         string `json:"name"`
+	DisplayName  string `json:"displayName"`
+	Plugin       string `json:"plugin"`
+	PluginConfig string `json:"pluginConfig"`
+
+	config map[string]interface{}
+}
+
+var (
+	underlaySchemasMu sync.Mutex
+	underlaySchemas   = map[string]*underlaySchema{}
+)
+
+// loadUnderlaySchema fetches and parses an underlay's configuration, caching it
+// for underlaySchemaTTL.
+func loadUnderlaySchema(ctx context.Context, underlayName string) (*underlaySchema, error) {
+	underlaySchemasMu.Lock()
+	cached := underlaySchemas[underlayName]
+	underlaySchemasMu.Unlock()
+	if cached != nil && time.Since(cached.loaded) < underlaySchemaTTL {
+		return cached, nil
+	}
+
+	respBody, err := makeAPIRequest(ctx, "GET", fmt.Sprintf("%s/v2/underlays/%s", dataExplorerURL, url.PathEscape(underlayName)), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load underlay %s: %w", underlayName, err)
+	}
+	var raw struct {
+		SerializedConfiguration struct {
+			Underlay                       string   `json:"underlay"`
+			Entities                       []string `json:"entities"`
+			GroupItemsEntityGroups         []string `json:"groupItemsEntityGroups"`
+			CriteriaOccurrenceEntityGroups []string `json:"criteriaOccurrenceEntityGroups"`
+			CriteriaSelectors              []string `json:"criteriaSelectors"`
+		} `json:"serializedConfiguration"`
+	}
+	if err := json.Unmarshal(respBody, &raw); err != nil {
+		return nil, fmt.Errorf("failed to parse underlay %s: %w", underlayName, err)
+	}
+	cfg := raw.SerializedConfiguration
+
+	schema := &underlaySchema{
+		Name:         underlayName,
+		Entities:     map[string]*underlayEntity{},
+		EntityGroups: map[string]*underlayEntityGroup{},
+		loaded:       time.Now(),
+	}
+	var ul struct {
+		PrimaryEntity string `json:"primaryEntity"`
+	}
+	if json.Unmarshal([]byte(cfg.Underlay), &ul) == nil {
+		schema.PrimaryEntity = ul.PrimaryEntity
+	}
+	for _, s := range cfg.Entities {
+		var e underlayEntity
+		if json.Unmarshal([]byte(s), &e) == nil && e.Name != "" {
+			schema.Entities[e.Name] = &e
+		}
+	}
+	for _, s := range append(cfg.CriteriaOccurrenceEntityGroups, cfg.GroupItemsEntityGroups...) {
+		var g underlayEntityGroup
+		if json.Unmarshal([]byte(s), &g) == nil && g.Name != "" {
+			schema.EntityGroups[g.Name] = &g
+		}
+	}
+	for _, s := range cfg.CriteriaSelectors {
+		var sel criteriaSelector
+		if json.Unmarshal([]byte(s), &sel) != nil || sel.Name == "" {
+			continue
+		}
+		json.Unmarshal([]byte(sel.PluginConfig), &sel.config)
+		schema.Selectors = append(schema.Selectors, &sel)
+	}
+	if schema.PrimaryEntity == "" {
+		schema.PrimaryEntity = "person"
+	}
+	if len(schema.Entities) == 0 {
+		return nil, fmt.Errorf("underlay %s has no entity definitions", underlayName)
+	}
+
+	underlaySchemasMu.Lock()
+	underlaySchemas[underlayName] = schema
+	underlaySchemasMu.Unlock()
+	return schema, nil
+}
+
+func (e *underlayEntity) attribute(name string) (underlayAttribute, bool) {
+	for _, a := range e.Attributes {
+		if a.Name == name {
+			return a, true
+		}
+	}
+	return underlayAttribute{}, false
+}
+
+func (e *underlayEntity) attributeNames() []string {
+	names := make([]string, len(e.Attributes))
+	for i, a := range e.Attributes {
+		names[i] = a.Name
+	}
+	return names
+}
+
+func (e *underlayEntity) hasHierarchy() bool {
+	return len(e.Hierarchies) > 0
+}
+
+func (u *underlaySchema) entityNames() []string {
+	names := make([]string, 0, len(u.Entities))
+	for name := range u.Entities {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (u *underlaySchema) selectorNames() []string {
+	names := make([]string, len(u.Selectors))
+	for i, s := range u.Selectors {
+		names[i] = s.Name
+	}
+	return names
+}
+
+// classificationGroups returns the entity group IDs an entityGroup selector
+// draws from, in pluginConfig order.
+func (s *criteriaSelector) classificationGroups() []string {
+	var ids []string
+	groups, _ := s.config["classificationEntityGroups"].([]interface{})
+	for _, g := range groups {
+		if gm, ok := g.(map[string]interface{}); ok {
+			if id, ok := gm["id"].(string); ok {
+				ids = append(ids, id)
+			}
+		}
+	}
+	return ids
+}
+
+// criteriaEntity returns the entity whose instances an entity group selects.
+func (g *underlayEntityGroup) criteriaEntity() string {
+	return firstNonEmpty(g.CriteriaEntity, g.GroupEntity)
+}
+
+// matchesName reports whether a user-supplied name refers to the selector:
+// its name or display name, ignoring case, spaces and a plural "s".
+func (s *criteriaSelector) matchesName(name string) bool {
+	n := normalizeName(name)
+	return n == normalizeName(s.Name) || n == normalizeName(s.DisplayName)
+}
+
+func normalizeName(s string) string {
+	s = strings.ToLower(strings.NewReplacer(" ", "", "_", "", "-", "").Replace(s))
+	return strings.TrimSuffix(s, "s")
+}
+
+// attributeHint is Data Explorer's display hint for one attribute: a numeric
+// range, or the enum values it takes with their display names.
+type attributeHint struct {
+	Min, Max *float64
+	Enum     []enumHintValue
+}
+
+type enumHintValue struct {
+	Value   map[string]interface{} // valueUnion, e.g. {"int64Val": 8532}
+	Display string
+}
+
+// loadAttributeHints fetches the underlay-wide display hints for an entity's
+// attributes, keyed by attribute name.
+func loadAttributeHints(ctx context.Context, underlayName, entityName string) (map[string]attributeHint, error) {
+	hintsURL := fmt.Sprintf("%s/v2/underlays/%s/entities/%s/hints", dataExplorerURL, url.PathEscape(underlayName), url.PathEscape(entityName))
+	respBody, err := makeAPIRequest(ctx, "POST", hintsURL, map[string]interface{}{})
+	if err != nil {
+		return nil, err
+	}
+	var raw struct {
+		DisplayHints []struct {
+			Attribute struct {
+				Name string `json:"name"`
+			} `json:"attribute"`
+			DisplayHint struct {
+				NumericRangeHint *struct {
+					Min *float64 `json:"min"`
+					Max *float64 `json:"max"`
+				} `json:"numericRangeHint"`
+				EnumHint *struct {
+					EnumHintValues []struct {
+						EnumVal struct {
+							Value struct {
+								ValueUnion map[string]interface{} `json:"valueUnion"`
+							} `json:"value"`
+							Display string `json:"display"`
+						} `json:"enumVal"`
+					} `json:"enumHintValues"`
+				} `json:"enumHint"`
+			} `json:"displayHint"`
+		} `json:"displayHints"`
+	}
+	if err := json.Unmarshal(respBody, &raw); err != nil {
+		return nil, fmt.Errorf("failed to parse hints: %w", err)
+	}
+	hints := map[string]attributeHint{}
+	for _, dh := range raw.DisplayHints {
+		var h attributeHint
+		if r := dh.DisplayHint.NumericRangeHint; r != nil {
+			h.Min, h.Max = r.Min, r.Max
+		}
+		if e := dh.DisplayHint.EnumHint; e != nil {
+			for _, v := range e.EnumHintValues {
+				value := map[string]interface{}{}
+				for k, val := range v.EnumVal.Value.ValueUnion {
+					if val != nil {
+						value[k] = val
+					}
+				}
+				h.Enum = append(h.Enum, enumHintValue{Value: value, Display: v.EnumVal.Display})
+			}
+		}
+		hints[dh.Attribute.Name] = h
+	}
+	return hints, nil
+}
+
+// underlayInstance is one row from an underlay-wide instance query.
+type underlayInstance struct {
+	ID   int64  `json:"id"`
+	Name string `json:"name"`
+}
+
+// searchInstances runs a text search over an entity (e.g. condition concepts)
+// and returns up to limit matches with their IDs and names.
+func searchInstances(ctx context.Context, underlayName, entityName, text string, limit int) ([]underlayInstance, error) {
+	raw, err := listInstances(ctx, underlayName, entityName, map[string]interface{}{
+		"includeAttributes": []string{"id", "name"},
+		"filter":            textFilter(text),
+		"limit":             limit,
+	})
+	if err != nil {
+		return nil, err
+	}
+	var out []underlayInstance
+	for _, inst := range raw {
+		out = append(out, inst.instance())
+	}
+	return out, nil
+}
+
+func textFilter(text string) map[string]interface{} {
+	return map[string]interface{}{
+		"filterType": "TEXT",
+		"filterUnion": map[string]interface{}{
+			"textFilter": map[string]interface{}{"matchType": "EXACT_MATCH", "text": text},
+		},
+	}
+}
+
+// rawInstance is one instance as returned by the list instances endpoint,
+// with the hierarchy and relationship fields requested alongside attributes.
+type rawInstance struct {
+	Attributes map[string]struct {
+		Value struct {
+			ValueUnion map[string]interface{} `json:"valueUnion"`
+		} `json:"value"`
+		Display string `json:"display"`
+	} `json:"attributes"`
+	HierarchyFields []struct {
+		Hierarchy   string  `json:"hierarchy"`
+		Path        *string `json:"path"`
+		NumChildren int64   `json:"numChildren"`
+		IsRoot      bool    `json:"isRoot"`
+		IsMember    bool    `json:"isMember"`
+	} `json:"hierarchyFields"`
+	RelationshipFields []struct {
+		RelatedEntity string `json:"relatedEntity"`
+		Hierarchy     string `json:"hierarchy"`
+		Count         int64  `json:"count"`
+	} `json:"relationshipFields"`
+}
+
+func (inst rawInstance) instance() underlayInstance {
+	var row underlayInstance
+	if id, ok := inst.Attributes["id"]; ok {
+		row.ID = literalInt64(id.Value.ValueUnion["int64Val"])
+	}
+	if name, ok := inst.Attributes["name"]; ok {
+		row.Name = firstNonEmpty(name.Display, fmt.Sprint(name.Value.ValueUnion["stringVal"]))
+	}
+	return row
+}
+
+// listInstances posts an instance query for an entity across the underlay.
+func listInstances(ctx context.Context, underlayName, entityName string, body map[string]interface{}) ([]rawInstance, error) {
+	instancesURL := fmt.Sprintf("%s/v2/underlays/%s/entities/%s/instances", dataExplorerURL, url.PathEscape(underlayName), url.PathEscape(entityName))
+	respBody, err := makeAPIRequest(ctx, "POST", instancesURL, body)
+	if err != nil {
+		return nil, err
+	}
+	var raw struct {
+		Instances []rawInstance `json:"instances"`
+	}
+	if err := json.Unmarshal(respBody, &raw); err != nil {
+		return nil, fmt.Errorf("failed to parse instances: %w", err)
+	}
+	return raw.Instances, nil
+}
+
+// literalInt64 reads an int64Val, which the API encodes as a number or string.
+func literalInt64(v interface{}) int64 {
+	switch n := v.(type) {
+	case float64:
+		return int64(n)
+	case string:
+		i, _ := strconv.ParseInt(n, 10, 64)
+		return i
+	}
+	return 0
+}
@@ -0,0 +1,417 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// workflow_job_run records where each job writes its outputs, since
+// `wb workflow job describe` doesn't report it. workflow_job_outputs and
+// workflow_job_logs read the record back, falling back to the describe
+// output and then to explicit arguments.
+
+const (
+	maxWorkflowRuns     = 500 // oldest run records beyond this are pruned
+	maxOutputListing    = 200
+	defaultOutputBytes  = 1 << 20
+	defaultLogTailBytes = 16 << 10
+	maxReadBytes        = 8 << 20
+)
+
+var workflowRunsMu sync.Mutex
+
+// workflowRun is the output location of one submitted workflow job.
+type workflowRun struct {
+	WorkspaceID    string    `json:"workspaceId"`
+	JobID          string    `json:"jobId"`
+	WorkflowID     string    `json:"workflowId"`
+	OutputBucketID string    `json:"outputBucketId"`
+	OutputPath     string    `json:"outputPath,omitempty"`
+	Submitted      time.Time `json:"submitted"`
+}
+
+// recordWorkflowRun adds a run to workflow-runs.json. Failures only warn: the
+// job was submitted either way.
+func recordWorkflowRun(run workflowRun) {
+	workflowRunsMu.Lock()
+	defer workflowRunsMu.Unlock()
+	path, err := statePath("workflow-runs.json")
+	if err == nil {
+		var runs []workflowRun
+		if err = readStateFile(path, &runs); err == nil {
+			runs = append(runs, run)
+			if len(runs) > maxWorkflowRuns {
+				runs = runs[len(runs)-maxWorkflowRuns:]
+			}
+			err = writeStateFile(path, runs)
+		}
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: failed to record workflow run %s: %v\n", run.JobID, err)
+	}
+}
+
+// workflowOutputRoot resolves a job's output bucket resource and path from the
+// arguments, the run record, or the job's describe output, in that order.
+func workflowOutputRoot(ctx context.Context, sess *session, args map[string]interface{}) (run workflowRun, uri string, err error) {
+	vals, err := requireStrings(args, "workspaceId", "jobId")
+	if err != nil {
+		return run, "", err
+	}
+	run = workflowRun{WorkspaceID: vals[0], JobID: vals[1]}
+	if p, err := statePath("workflow-runs.json"); err == nil {
+		var runs []workflowRun
+		workflowRunsMu.Lock()
+		readStateFile(p, &runs)
+		workflowRunsMu.Unlock()
+		for i := len(runs) - 1; i >= 0; i-- {
+			if runs[i].WorkspaceID == run.WorkspaceID && runs[i].JobID == run.JobID {
+				run = runs[i]
+				break
+			}
+		}
+	}
+	if run.OutputBucketID == "" {
+		out, err := executeWbCommand(ctx, []string{"workflow", "job", "describe", "--workspace=" + run.WorkspaceID, "--job-id=" + run.JobID, "--format=json"})
+		var doc interface{}
+		if err == nil && json.Unmarshal([]byte(out), &doc) == nil {
+			run.OutputBucketID = findField(doc, "outputbucketid", "outputbucket", "outputbucketname")
+			run.OutputPath = firstNonEmpty(run.OutputPath, findField(doc, "outputpath", "outputprefix"))
+		}
+	}
+	if b, ok := args["outputBucketId"].(string); ok && b != "" {
+		run.OutputBucketID = b
+	}
+	if p, ok := args["outputPath"].(string); ok && p != "" {
+		run.OutputPath = p
+	}
+	if run.OutputBucketID == "" {
+		return run, "", fmt.Errorf("no output bucket recorded for job %s; pass outputBucketId (and outputPath) as used in workflow_job_run", run.JobID)
+	}
+	uri, err = resolveBucketURI(ctx, sess, run.OutputBucketID)
+	if err != nil {
+		return run, "", err
+	}
+	if p := strings.Trim(run.OutputPath, "/"); p != "" {
+		uri += p + "/"
+	}
+	return run, uri, nil
+}
+
+// resolveBucketURI returns the gs:// or s3:// URI, ending in "/", of a bucket
+// or storage folder resource.
+func resolveBucketURI(ctx context.Context, sess *session, resourceName string) (string, error) {
+	descOutput, err := executeWbCommand(ctx, sess.wbArgs("resource", "describe", "--id="+resourceName, "--format=json"))
+	if err != nil {
+		return "", fmt.Errorf("failed to describe resource: %w\n%s", err, descOutput)
+	}
+	var res map[string]interface{}
+	if err := json.Unmarshal([]byte(descOutput), &res); err != nil {
+		return "", fmt.Errorf("failed to parse resource JSON: %w", err)
+	}
+	bucket := findField(res, "bucketname")
+	if bucket == "" {
+		return "", fmt.Errorf("resource %s has no bucketName — is it a bucket resource?", resourceName)
+	}
+	scheme := "s3://"
+	if strings.Contains(strings.ToUpper(findField(res, "resourcetype")), "GCS") {
+		scheme = "gs://"
+	}
+	uri := scheme + bucket + "/"
+	if prefix := strings.Trim(findField(res, "prefix"), "/"); prefix != "" {
+		uri += prefix + "/"
+	}
+	return uri, nil
+}
+
+// bucketObject is one listed file.
+type bucketObject struct {
+	Path    string `json:"path"` // relative to the listed root
+	Size    int64  `json:"size"`
+	Updated string `json:"updated,omitempty"`
+	Log     bool   `json:"log,omitempty"`
+}
+
+// listBucket lists every object under root (a gs:// or s3:// URI ending in
+// "/"), sorted by path.
+func listBucket(ctx context.Context, sess *session, resourceName, root string) ([]bucketObject, error) {
+	var objects []bucketObject
+	if strings.HasPrefix(root, "gs://") {
+		out, err := executeWbCommand(ctx, append(sess.wbArgs("gsutil"), "ls", "-l", "-r", root+"**"))
+		if err != nil {
+			if strings.Contains(out, "matched no objects") {
+				return nil, nil
+			}
+			return nil, fmt.Errorf("%v: %s", err, truncate(strings.TrimSpace(out), 300))
+		}
+		// "   1234  2024-05-01T10:00:00Z  gs://bucket/path"
+		for _, line := range strings.Split(out, "\n") {
+			fields := strings.Fields(line)
+			if len(fields) < 3 || !strings.HasPrefix(fields[2], root) {
+				continue
+			}
+			size, _ := strconv.ParseInt(fields[0], 10, 64)
+			name := strings.TrimPrefix(strings.Join(fields[2:], " "), root)
+			objects = append(objects, bucketObject{Path: name, Size: size, Updated: fields[1]})
+		}
+	} else {
+		out, err := executeAWSCommand(ctx, sess, resourceName, "s3", "ls", root, "--recursive")
+		if err != nil {
+			if strings.TrimSpace(out) == "" {
+				return nil, nil // aws exits 1 for an empty prefix
+			}
+			return nil, fmt.Errorf("%v: %s", err, truncate(strings.TrimSpace(out), 300))
+		}
+		// "2024-05-01 10:00:00       1234 prefix/path" with the key relative to the bucket.
+		bucketRoot := root[:strings.Index(root[len("s3://"):], "/")+len("s3://")+1]
+		keyPrefix := strings.TrimPrefix(root, bucketRoot)
+		for _, line := range strings.Split(out, "\n") {
+			fields := strings.Fields(line)
+			if len(fields) < 4 {
+				continue
+			}
+			size, err := strconv.ParseInt(fields[2], 10, 64)
+			if err != nil {
+				continue
+			}
+			key := strings.Join(fields[3:], " ")
+			if !strings.HasPrefix(key, keyPrefix) {
+				continue
+			}
+			objects = append(objects, bucketObject{Path: strings.TrimPrefix(key, keyPrefix), Size: size, Updated: fields[0] + "T" + fields[1]})
+		}
+	}
+	for i := range objects {
+		objects[i].Log = isLogFile(objects[i].Path)
+	}
+	sort.Slice(objects, func(i, j int) bool { return objects[i].Path < objects[j].Path })
+	return objects, nil
+}
+
+// readBucketObject reads part of an object: the first n bytes, or the last n
+// when tail is set.
+func readBucketObject(ctx context.Context, sess *session, resourceName, uri string, n int64, tail bool) ([]byte, error) {
+	rng := fmt.Sprintf("0-%d", n-1)
+	if tail {
+		rng = fmt.Sprintf("-%d", n)
+	}
+	if strings.HasPrefix(uri, "gs://") {
+		// gsutil prints warnings and progress on stderr; keep them out of the
+		// object's bytes.
+		cmd := exec.CommandContext(ctx, "wb", append(sess.wbArgs("gsutil"), "cat", "-r", rng, uri)...)
+		out, stderr, err := runCommandSplit(ctx, cmd)
+		if err != nil {
+			return nil, fmt.Errorf("%v: %s", err, truncate(strings.TrimSpace(string(stderr)), 300))
+		}
+		return out, nil
+	}
+	bucket, key, _ := strings.Cut(strings.TrimPrefix(uri, "s3://"), "/")
+	tmp, err := os.CreateTemp("", "wb-mcp-object-*")
+	if err != nil {
+		return nil, err
+	}
+	tmp.Close()
+	defer os.Remove(tmp.Name())
+	if out, err := executeAWSCommand(ctx, sess, resourceName, "s3api", "get-object", "--bucket", bucket, "--key", key, "--range", "bytes="+rng, tmp.Name()); err != nil {
+		return nil, fmt.Errorf("%v: %s", err, truncate(strings.TrimSpace(out), 300))
+	}
+	return os.ReadFile(tmp.Name())
+}
+
+// isLogFile reports whether a workflow output looks like a task log:
+// Cromwell's stdout/stderr files and *.log.
+func isLogFile(p string) bool {
+	base := strings.ToLower(path.Base(p))
+	return strings.HasSuffix(base, ".log") || strings.HasPrefix(base, "stdout") || strings.HasPrefix(base, "stderr") ||
+		strings.HasSuffix(base, "-stdout") || strings.HasSuffix(base, "-stderr")
+}
+
+// jobObjects lists the files under a job's output root, narrowed to the job's
+// own directory when its ID appears as a path segment (shared output paths).
+func jobObjects(ctx context.Context, sess *session, run workflowRun, root string) ([]bucketObject, error) {
+	objects, err := listBucket(ctx, sess, run.OutputBucketID, root)
+	if err != nil {
+		return nil, err
+	}
+	var own []bucketObject
+	for _, o := range objects {
+		if strings.Contains("/"+o.Path, "/"+run.JobID+"/") {
+			own = append(own, o)
+		}
+	}
+	if len(own) > 0 {
+		return own, nil
+	}
+	return objects, nil
+}
+
+// relativeObjectPath cleans a caller-supplied path and keeps it under the
+// output root.
+func relativeObjectPath(p string) (string, error) {
+	for _, seg := range strings.Split(p, "/") {
+		if seg == ".." {
+			return "", fmt.Errorf("invalid path %q: give a file path relative to the job's output root", p)
+		}
+	}
+	clean := path.Clean("/" + p)[1:]
+	if clean == "" {
+		return "", fmt.Errorf("invalid path %q: give a file path relative to the job's output root", p)
+	}
+	return clean, nil
+}
+
+func byteLimit(args map[string]interface{}, key string, def int64) int64 {
+	n := def
+	if v, ok := args[key].(float64); ok && v > 0 {
+		n = int64(v)
+	}
+	if n > maxReadBytes {
+		n = maxReadBytes
+	}
+	return n
+}
+
+// handleWorkflowJobOutputs implements workflow_job_outputs: list the job's
+// output files, or read one of them.
+func handleWorkflowJobOutputs(ctx context.Context, sess *session, args map[string]interface{}) (string, error) {
+	workspaceId, _ := args["workspaceId"].(string)
+	sess = sess.inWorkspace(workspaceId)
+	run, root, err := workflowOutputRoot(ctx, sess, args)
+	if err != nil {
+		return "", err
+	}
+	if p, ok := args["path"].(string); ok && p != "" {
+		rel, err := relativeObjectPath(p)
+		if err != nil {
+			return "", err
+		}
+		maxBytes := byteLimit(args, "maxBytes", defaultOutputBytes)
+		data, err := readBucketObject(ctx, sess, run.OutputBucketID, root+rel, maxBytes+1, false)
+		if err != nil {
+			return "", fmt.Errorf("failed to read %s: %w", root+rel, err)
+		}
+		if int64(len(data)) > maxBytes {
+			return string(data[:maxBytes]) + fmt.Sprintf("\n\n--- TRUNCATED (showing first %d bytes; raise maxBytes to read more) ---", maxBytes), nil
+		}
+		return string(data), nil
+	}
+
+	objects, err := jobObjects(ctx, sess, run, root)
+	if err != nil {
+		return "", fmt.Errorf("failed to list %s: %w", root, err)
+	}
+	var total int64
+	logs := 0
+	for _, o := range objects {
+		total += o.Size
+		if o.Log {
+			logs++
+		}
+	}
+	result := map[string]interface{}{
+		"jobId":          run.JobID,
+		"outputBucketId": run.OutputBucketID,
+		"root":           root,
+		"totalFiles":     len(objects),
+		"totalBytes":     total,
+		"logFiles":       logs,
+	}
+	if len(objects) > maxOutputListing {
+		objects = objects[:maxOutputListing]
+		result["truncated"] = fmt.Sprintf("showing the first %d files", maxOutputListing)
+	}
+	if objects == nil {
+		objects = []bucketObject{}
+		result["note"] = "no files yet; outputs appear as tasks finish"
+	}
+	result["files"] = objects
+	resultBytes, err := json.MarshalIndent(result, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal result: %w", err)
+	}
+	return string(resultBytes), nil
+}
+
+// handleWorkflowJobLogs implements workflow_job_logs: the tail of each task
+// log under the job's output root, optionally narrowed by task name.
+func handleWorkflowJobLogs(ctx context.Context, sess *session, args map[string]interface{}) (string, error) {
+	workspaceId, _ := args["workspaceId"].(string)
+	sess = sess.inWorkspace(workspaceId)
+	run, root, err := workflowOutputRoot(ctx, sess, args)
+	if err != nil {
+		return "", err
+	}
+	objects, err := jobObjects(ctx, sess, run, root)
+	if err != nil {
+		return "", fmt.Errorf("failed to list %s: %w", root, err)
+	}
+	task, _ := args["task"].(string)
+	var logs []bucketObject
+	for _, o := range objects {
+		if o.Log && (task == "" || strings.Contains(strings.ToLower(o.Path), strings.ToLower(task))) {
+			logs = append(logs, o)
+		}
+	}
+	if len(logs) == 0 {
+		if task != "" {
+			return fmt.Sprintf("No log files matching task %q under %s (%d files listed).", task, root, len(objects)), nil
+		}
+		return fmt.Sprintf("No log files under %s yet (%d files listed).", root, len(objects)), nil
+	}
+	// stderr first within each task: it is where failures show up.
+	sort.SliceStable(logs, func(i, j int) bool {
+		di, dj := path.Dir(logs[i].Path), path.Dir(logs[j].Path)
+		if di != dj {
+			return di < dj
+		}
+		return strings.Contains(path.Base(logs[i].Path), "stderr") && !strings.Contains(path.Base(logs[j].Path), "stderr")
+	})
+	maxFiles := 5
+	if m, ok := args["maxFiles"].(float64); ok && m > 0 {
+		maxFiles = int(m)
+	}
+	tailBytes := byteLimit(args, "tailBytes", defaultLogTailBytes)
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "Job %s: %d log file(s) under %s\n", run.JobID, len(logs), root)
+	for i, o := range logs {
+		if i == maxFiles {
+			fmt.Fprintf(&b, "\n... %d more log file(s) not shown; narrow with task or raise maxFiles:\n", len(logs)-maxFiles)
+			for _, rest := range logs[maxFiles:] {
+				fmt.Fprintf(&b, "  %s (%d bytes)\n", rest.Path, rest.Size)
+			}
+			break
+		}
+		fmt.Fprintf(&b, "\n===== %s (%d bytes) =====\n", o.Path, o.Size)
+		if o.Size == 0 {
+			b.WriteString("(empty)\n")
+			continue
+		}
+		n := tailBytes
+		if o.Size < n {
+			n = o.Size
+		}
+		data, err := readBucketObject(ctx, sess, run.OutputBucketID, root+o.Path, n, o.Size > tailBytes)
+		if err != nil {
+			fmt.Fprintf(&b, "(failed to read: %v)\n", err)
+			continue
+		}
+		if o.Size > tailBytes {
+			fmt.Fprintf(&b, "--- last %d bytes ---\n", tailBytes)
+		}
+		b.Write(data)
+		if len(data) > 0 && data[len(data)-1] != '\n' {
+			b.WriteString("\n")
+		}
+	}
+	return b.String(), nil
+}
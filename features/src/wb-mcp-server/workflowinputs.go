@@ -0,0 +1,574 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// workflow_job_run checks inputs against the workflow definition before
+// submitting, so a misspelled input or a string where an Int belongs fails
+// here rather than after the job is queued. Values of the form
+// wb://<resource>/<path> are resolved to the resource's gs:// or s3:// URI.
+
+const maxDefinitionBytes = 4 << 20
+
+// workflowInput is one declared input of a workflow definition.
+type workflowInput struct {
+	Name     string `json:"name"`
+	Type     string `json:"type"`
+	Optional bool   `json:"optional,omitempty"`
+	Default  string `json:"default,omitempty"`
+}
+
+// required reports whether the caller must supply the input.
+func (in workflowInput) required() bool {
+	return !in.Optional && in.Default == ""
+}
+
+// workflowDefinition is the parsed input section of a WDL or Nextflow file.
+type workflowDefinition struct {
+	URI      string          `json:"uri"`
+	Language string          `json:"language"` // "WDL" or "Nextflow"
+	Name     string          `json:"name,omitempty"`
+	Inputs   []workflowInput `json:"inputs"`
+}
+
+func (d *workflowDefinition) input(name string) (workflowInput, bool) {
+	for _, in := range d.Inputs {
+		if in.Name == name {
+			return in, true
+		}
+	}
+	return workflowInput{}, false
+}
+
+func (d *workflowDefinition) inputNames() []string {
+	names := make([]string, len(d.Inputs))
+	for i, in := range d.Inputs {
+		names[i] = in.Name
+	}
+	return names
+}
+
+// loadWorkflowDefinition finds a workflow's definition file from
+// `wb workflow describe` and parses its inputs.
+func loadWorkflowDefinition(ctx context.Context, sess *session, workspaceId, workflowId string) (*workflowDefinition, error) {
+	sess = sess.inWorkspace(workspaceId)
+	out, err := executeWbCommand(ctx, []string{"workflow", "describe", "--workspace=" + workspaceId, "--workflow=" + workflowId, "--format=json"})
+	if err != nil {
+		return nil, fmt.Errorf("failed to describe workflow %s: %v: %s", workflowId, err, truncate(strings.TrimSpace(out), 200))
+	}
+	var doc interface{}
+	if err := json.Unmarshal([]byte(out), &doc); err != nil {
+		return nil, fmt.Errorf("failed to parse workflow description: %w", err)
+	}
+	uri := findField(doc, "uri", "gcsuri", "sourceuri", "definitionuri", "workflowuri")
+	bucket := findField(doc, "bucketid", "bucketname", "bucket")
+	if !strings.HasPrefix(uri, "gs://") && !strings.HasPrefix(uri, "s3://") {
+		p := findField(doc, "path", "filepath", "sourcepath", "definitionpath")
+		if bucket == "" || p == "" {
+			return nil, fmt.Errorf("workflow %s description has no definition bucket and path", workflowId)
+		}
+		root, err := resolveBucketURI(ctx, sess, bucket)
+		if err != nil {
+			return nil, err
+		}
+		uri = root + strings.TrimPrefix(p, "/")
+	}
+	src, err := readBucketObject(ctx, sess, bucket, uri, maxDefinitionBytes, false)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read workflow definition %s: %w", uri, err)
+	}
+	var def *workflowDefinition
+	if strings.HasSuffix(uri, ".nf") {
+		def = parseNextflowParams(string(src))
+	} else {
+		def, err = parseWDLInputs(string(src))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", uri, err)
+		}
+	}
+	def.URI = uri
+	return def, nil
+}
+
+var (
+	wdlWorkflowRe  = regexp.MustCompile(`(?m)^\s*workflow\s+(\w+)\s*\{`)
+	wdlInputRe     = regexp.MustCompile(`(?m)^\s*input\s*\{`)
+	wdlVersionRe   = regexp.MustCompile(`(?m)^\s*version\s+(\S+)`)
+	wdlSectionRe   = regexp.MustCompile(`^(call|scatter|if|output|meta|parameter_meta|command|runtime)\b`)
+	nextflowRe     = regexp.MustCompile(`(?m)^\s*params\.(\w+)\s*=\s*(.+?)\s*$`)
+	identRe        = regexp.MustCompile(`^\w+$`)
+	typeNameRe     = regexp.MustCompile(`^[A-Z]\w*`)
+	intLiteralRe   = regexp.MustCompile(`^-?\d+$`)
+	floatLiteralRe = regexp.MustCompile(`^-?\d*\.\d+$`)
+)
+
+// parseWDLInputs reads the workflow's inputs: the input block for WDL 1.0 and
+// later, or the unassigned (and optional) top-level declarations for draft-2.
+func parseWDLInputs(src string) (*workflowDefinition, error) {
+	src = stripWDLComments(src)
+	m := wdlWorkflowRe.FindStringSubmatchIndex(src)
+	if m == nil {
+		return nil, fmt.Errorf("no workflow block found")
+	}
+	def := &workflowDefinition{Language: "WDL", Name: src[m[2]:m[3]], Inputs: []workflowInput{}}
+	body, ok := braceBlock(src, m[1]-1)
+	if !ok {
+		return nil, fmt.Errorf("unbalanced braces in workflow %s", def.Name)
+	}
+
+	draft2 := true
+	if v := wdlVersionRe.FindStringSubmatch(src); v != nil {
+		draft2 = v[1] == "draft-2"
+	}
+	if im := wdlInputRe.FindStringIndex(body); im != nil && !draft2 {
+		block, ok := braceBlock(body, im[1]-1)
+		if !ok {
+			return nil, fmt.Errorf("unbalanced braces in input block of workflow %s", def.Name)
+		}
+		for _, decl := range splitDeclarations(block) {
+			if in, ok := parseDeclaration(decl); ok {
+				def.Inputs = append(def.Inputs, in)
+			}
+		}
+		return def, nil
+	}
+	if !draft2 {
+		return def, nil
+	}
+	for _, decl := range splitDeclarations(body) {
+		if wdlSectionRe.MatchString(decl) {
+			continue
+		}
+		if in, ok := parseDeclaration(decl); ok && (in.Default == "" || in.Optional) {
+			def.Inputs = append(def.Inputs, in)
+		}
+	}
+	return def, nil
+}
+
+// stripWDLComments removes # comments outside string literals.
+func stripWDLComments(src string) string {
+	var b strings.Builder
+	var quote rune
+	comment := false
+	for _, r := range src {
+		switch {
+		case comment:
+			if r != '\n' {
+				continue
+			}
+			comment = false
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			}
+		case r == '"' || r == '\'':
+			quote = r
+		case r == '#':
+			comment = true
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// braceBlock returns the text between the brace at open and its match.
+func braceBlock(src string, open int) (string, bool) {
+	depth := 0
+	var quote byte
+	for i := open; i < len(src); i++ {
+		c := src[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '{':
+			depth++
+		case c == '}':
+			depth--
+			if depth == 0 {
+				return src[open+1 : i], true
+			}
+		}
+	}
+	return "", false
+}
+
+// splitDeclarations splits a block into its top-level statements: newlines
+// end a statement unless inside brackets, braces or a string. Nested blocks
+// (calls, scatters) come back as single statements.
+func splitDeclarations(block string) []string {
+	var out []string
+	depth := 0
+	var quote byte
+	start := 0
+	flush := func(end int) {
+		if s := strings.TrimSpace(block[start:end]); s != "" {
+			out = append(out, s)
+		}
+		start = end
+	}
+	for i := 0; i < len(block); i++ {
+		c := block[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '[' || c == '{' || c == '(':
+			depth++
+		case c == ']' || c == '}' || c == ')':
+			depth--
+			if depth == 0 && c == '}' {
+				flush(i + 1)
+			}
+		case c == '\n' && depth == 0:
+			flush(i)
+		}
+	}
+	flush(len(block))
+	return out
+}
+
+// parseDeclaration parses "Type name" or "Type name = expr".
+func parseDeclaration(decl string) (workflowInput, bool) {
+	lhs, rhs, assigned := strings.Cut(decl, "=")
+	fields := strings.Fields(lhs)
+	if len(fields) < 2 || strings.ContainsAny(decl, "{") && !assigned {
+		return workflowInput{}, false
+	}
+	name := fields[len(fields)-1]
+	typ := strings.Join(fields[:len(fields)-1], "")
+	if !identRe.MatchString(name) || !typeNameRe.MatchString(typ) {
+		return workflowInput{}, false
+	}
+	in := workflowInput{Name: name, Type: typ, Optional: strings.HasSuffix(typ, "?")}
+	if assigned {
+		in.Default = strings.Join(strings.Fields(rhs), " ")
+	}
+	return in, true
+}
+
+// parseNextflowParams reads `params.x = default` declarations. Nextflow
+// accepts any params, so every declared one is optional.
+func parseNextflowParams(src string) *workflowDefinition {
+	def := &workflowDefinition{Language: "Nextflow", Inputs: []workflowInput{}}
+	seen := map[string]bool{}
+	for _, m := range nextflowRe.FindAllStringSubmatch(src, -1) {
+		if seen[m[1]] {
+			continue
+		}
+		seen[m[1]] = true
+		value := m[2]
+		typ := "String"
+		switch {
+		case value == "true" || value == "false":
+			typ = "Boolean"
+		case intLiteralRe.MatchString(value):
+			typ = "Int"
+		case floatLiteralRe.MatchString(value):
+			typ = "Float"
+		case value == "null":
+			typ = "String?"
+		}
+		def.Inputs = append(def.Inputs, workflowInput{Name: m[1], Type: typ, Optional: true, Default: value})
+	}
+	return def
+}
+
+// wdlType splits a type into its base name and parameters, e.g.
+// "Array[File]+?" -> ("Array", ["File"], optional, nonEmpty).
+func wdlType(t string) (base string, params []string, optional, nonEmpty bool) {
+	t = strings.TrimSpace(t)
+	if strings.HasSuffix(t, "?") {
+		optional = true
+		t = t[:len(t)-1]
+	}
+	if strings.HasSuffix(t, "+") {
+		nonEmpty = true
+		t = t[:len(t)-1]
+	}
+	open := strings.Index(t, "[")
+	if open < 0 || !strings.HasSuffix(t, "]") {
+		return t, nil, optional, nonEmpty
+	}
+	base = t[:open]
+	inner := t[open+1 : len(t)-1]
+	depth, start := 0, 0
+	for i, c := range inner {
+		switch c {
+		case '[':
+			depth++
+		case ']':
+			depth--
+		case ',':
+			if depth == 0 {
+				params = append(params, inner[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return base, append(params, inner[start:]), optional, nonEmpty
+}
+
+// inputChecker validates values against WDL types and resolves wb://
+// resource references, collecting every problem rather than stopping at the
+// first.
+type inputChecker struct {
+	ctx      context.Context
+	sess     *session
+	errs     []string
+	resolved map[string]string // wb:// reference -> cloud URI
+	roots    map[string]string // resource name -> bucket URI
+}
+
+func (c *inputChecker) fail(path, format string, args ...interface{}) {
+	c.errs = append(c.errs, path+": "+fmt.Sprintf(format, args...))
+}
+
+// wdlTypeParams is how many type parameters each compound type takes.
+var wdlTypeParams = map[string]int{"Array": 1, "Map": 2, "Pair": 2}
+
+// check returns v converted for submission (numbers from strings, resolved
+// resource references).
+func (c *inputChecker) check(path, typ string, v interface{}) interface{} {
+	base, params, optional, nonEmpty := wdlType(typ)
+	if v == nil {
+		if !optional {
+			c.fail(path, "null is not allowed for non-optional %s", typ)
+		}
+		return v
+	}
+	if n, ok := wdlTypeParams[base]; ok && len(params) != n {
+		c.fail(path, "cannot check a value against %s: %s takes %d type parameter(s)", typ, base, n)
+		return v
+	}
+	switch base {
+	case "String":
+		switch s := v.(type) {
+		case string:
+			return c.resolveReference(path, s)
+		case float64:
+			return strconv.FormatFloat(s, 'f', -1, 64)
+		case bool:
+			return strconv.FormatBool(s)
+		}
+	case "File", "Directory":
+		if s, ok := v.(string); ok {
+			s = c.resolveReference(path, s)
+			if !strings.Contains(s, "://") {
+				c.fail(path, "%s %q is not a cloud URI; use gs://, s3:// or wb://<resource>/<path>", base, s)
+			}
+			return s
+		}
+	case "Int":
+		switch n := v.(type) {
+		case float64:
+			if n == math.Trunc(n) {
+				return v
+			}
+			c.fail(path, "%v is not an Int", n)
+			return v
+		case string:
+			if i, err := strconv.ParseInt(n, 10, 64); err == nil {
+				return i
+			}
+		}
+	case "Float":
+		switch n := v.(type) {
+		case float64:
+			return v
+		case string:
+			if f, err := strconv.ParseFloat(n, 64); err == nil {
+				return f
+			}
+		}
+	case "Boolean":
+		switch b := v.(type) {
+		case bool:
+			return v
+		case string:
+			if parsed, err := strconv.ParseBool(b); err == nil {
+				return parsed
+			}
+		}
+	case "Array":
+		if arr, ok := v.([]interface{}); ok {
+			if nonEmpty && len(arr) == 0 {
+				c.fail(path, "%s must not be empty", typ)
+			}
+			out := make([]interface{}, len(arr))
+			for i, item := range arr {
+				out[i] = c.check(fmt.Sprintf("%s[%d]", path, i), params[0], item)
+			}
+			return out
+		}
+	case "Map":
+		if m, ok := v.(map[string]interface{}); ok {
+			out := map[string]interface{}{}
+			for k, item := range m {
+				out[k] = c.check(path+"."+k, params[1], item)
+			}
+			return out
+		}
+	case "Pair":
+		if m, ok := v.(map[string]interface{}); ok {
+			_, hasLeft := m["left"]
+			_, hasRight := m["right"]
+			if !hasLeft || !hasRight {
+				c.fail(path, "%s needs {\"left\": ..., \"right\": ...}", typ)
+				return v
+			}
+			return map[string]interface{}{
+				"left":  c.check(path+".left", params[0], m["left"]),
+				"right": c.check(path+".right", params[1], m["right"]),
+			}
+		}
+	default:
+		// Object and struct types: require a JSON object, but the member
+		// types live in struct definitions that may be imported.
+		if _, ok := v.(map[string]interface{}); ok {
+			return v
+		}
+		c.fail(path, "%s needs a JSON object, got %s", typ, jsonKind(v))
+		return v
+	}
+	c.fail(path, "expected %s, got %s %s", typ, jsonKind(v), truncate(fmt.Sprint(v), 60))
+	return v
+}
+
+// resolveReference turns wb://<resource>/<path> into the resource's cloud URI
+// plus path. Other strings are returned unchanged.
+func (c *inputChecker) resolveReference(path, s string) string {
+	ref, ok := strings.CutPrefix(s, "wb://")
+	if !ok {
+		return s
+	}
+	name, rest, _ := strings.Cut(ref, "/")
+	root, ok := c.roots[name]
+	if !ok {
+		var err error
+		root, err = resolveBucketURI(c.ctx, c.sess, name)
+		if err != nil {
+			c.fail(path, "cannot resolve %s: %v", s, err)
+			return s
+		}
+		c.roots[name] = root
+	}
+	uri := root + rest
+	c.resolved[s] = uri
+	return uri
+}
+
+func jsonKind(v interface{}) string {
+	switch v.(type) {
+	case string:
+		return "string"
+	case float64:
+		return "number"
+	case bool:
+		return "boolean"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	}
+	return fmt.Sprintf("%T", v)
+}
+
+// inputValidation is the outcome of checking inputs against a definition.
+type inputValidation struct {
+	Errors   []string
+	Warnings []string
+	Resolved map[string]string      // wb:// reference -> cloud URI
+	Inputs   map[string]interface{} // as they should be submitted
+}
+
+// validateWorkflowInputs checks inputs against def. Keys may be plain input
+// names or qualified with the workflow name ("wf.x"); keys naming call
+// inputs ("wf.task.x") are passed through with a warning.
+func validateWorkflowInputs(ctx context.Context, sess *session, def *workflowDefinition, inputs map[string]interface{}) *inputValidation {
+	c := &inputChecker{ctx: ctx, sess: sess, resolved: map[string]string{}, roots: map[string]string{}}
+	v := &inputValidation{Inputs: map[string]interface{}{}}
+	supplied := map[string]bool{}
+	keys := make([]string, 0, len(inputs))
+	for k := range inputs {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		name := key
+		if def.Name != "" {
+			name = strings.TrimPrefix(key, def.Name+".")
+		}
+		in, ok := def.input(name)
+		switch {
+		case ok:
+			supplied[name] = true
+			v.Inputs[key] = c.check(key, in.Type, inputs[key])
+		case def.Language == "Nextflow":
+			v.Warnings = append(v.Warnings, fmt.Sprintf("%s: not a declared param; passed through", key))
+			v.Inputs[key] = inputs[key]
+			if text, ok := inputs[key].(string); ok {
+				v.Inputs[key] = c.resolveReference(key, text)
+			}
+		case strings.Contains(name, "."):
+			v.Warnings = append(v.Warnings, fmt.Sprintf("%s: call-level input, not checked", key))
+			v.Inputs[key] = inputs[key]
+		default:
+			c.fail(key, "%v", unknownName("input", name, "workflow "+def.Name, def.inputNames()))
+			v.Inputs[key] = inputs[key]
+		}
+	}
+	for _, in := range def.Inputs {
+		if in.required() && !supplied[in.Name] {
+			c.fail(in.Name, "required input (%s) is missing", in.Type)
+		}
+	}
+	v.Errors = c.errs
+	if len(c.resolved) > 0 {
+		v.Resolved = c.resolved
+	}
+	return v
+}
+
+// handleWorkflowValidateInputs implements workflow_validate_inputs.
+func handleWorkflowValidateInputs(ctx context.Context, sess *session, args map[string]interface{}) (string, error) {
+	vals, err := requireStrings(args, "workspaceId", "workflowId")
+	if err != nil {
+		return "", err
+	}
+	def, err := loadWorkflowDefinition(ctx, sess, vals[0], vals[1])
+	if err != nil {
+		return "", err
+	}
+	inputs, _ := args["inputs"].(map[string]interface{})
+	v := validateWorkflowInputs(ctx, sess.inWorkspace(vals[0]), def, inputs)
+	result := map[string]interface{}{"definition": def}
+	if inputs != nil {
+		result["valid"] = len(v.Errors) == 0
+		result["errors"] = v.Errors
+		result["warnings"] = v.Warnings
+		result["resolvedReferences"] = v.Resolved
+		result["inputs"] = v.Inputs
+	}
+	resultBytes, err := json.MarshalIndent(result, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal result: %w", err)
+	}
+	return string(resultBytes), nil
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseWDLInputs(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want *workflowDefinition
+	}{
+		{
+			name: "WDL 1.0 input block",
+			src: `version 1.0
+
+workflow align {
+  input {
+    File reads   # FASTQ
+    Int threads = 4
+    String? sample_name
+    Array[File]+ references
+    Map[String, Pair[Int, File]] lookup = {}
+  }
+  call bwa { input: reads = reads }
+  String not_an_input = "x"
+}`,
+			want: &workflowDefinition{Language: "WDL", Name: "align", Inputs: []workflowInput{
+				{Name: "reads", Type: "File"},
+				{Name: "threads", Type: "Int", Default: "4"},
+				{Name: "sample_name", Type: "String?", Optional: true},
+				{Name: "references", Type: "Array[File]+"},
+				{Name: "lookup", Type: "Map[String,Pair[Int,File]]", Default: "{}"},
+			}},
+		},
+		{
+			name: "WDL 1.1 without an input block has no inputs",
+			src: `version 1.1
+workflow w {
+  File f
+  call t
+}`,
+			want: &workflowDefinition{Language: "WDL", Name: "w", Inputs: []workflowInput{}},
+		},
+		{
+			name: "draft-2 top-level declarations",
+			src: `task t { File f command { cat ${f} } }
+
+workflow hello {
+  File input_file
+  String greeting = "hi # not a comment"
+  Int? retries = 3
+  Boolean flag
+  call t { input: f = input_file }
+  scatter (x in [1, 2]) {
+    Int inner
+  }
+  output {
+    File out = t.out
+  }
+}`,
+			want: &workflowDefinition{Language: "WDL", Name: "hello", Inputs: []workflowInput{
+				{Name: "input_file", Type: "File"},
+				{Name: "retries", Type: "Int?", Optional: true, Default: "3"},
+				{Name: "flag", Type: "Boolean"},
+			}},
+		},
+		{
+			name: "explicit draft-2 ignores an input block",
+			src: `version draft-2
+workflow w {
+  input {
+    File a
+  }
+  File b
+}`,
+			want: &workflowDefinition{Language: "WDL", Name: "w", Inputs: []workflowInput{
+				{Name: "b", Type: "File"},
+			}},
+		},
+		{
+			name: "multi-line default",
+			src: `version 1.0
+workflow w {
+  input {
+    Array[String] names = [
+      "a",
+      "b"
+    ]
+  }
+}`,
+			want: &workflowDefinition{Language: "WDL", Name: "w", Inputs: []workflowInput{
+				{Name: "names", Type: "Array[String]", Default: `[ "a", "b" ]`},
+			}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseWDLInputs(tt.src)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got  %+v\nwant %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseWDLInputsErrors(t *testing.T) {
+	tests := []struct {
+		src  string
+		want string
+	}{
+		{"version 1.0\ntask t { command {} }", "no workflow block found"},
+		{"version 1.0\nworkflow w {\n  input {\n", "unbalanced braces in workflow w"},
+		{"version 1.0\nworkflow w {\n  input {\n    File a\n}", "unbalanced braces in workflow w"},
+	}
+	for _, tt := range tests {
+		_, err := parseWDLInputs(tt.src)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("parseWDLInputs(%q) error = %v, want %q", tt.src, err, tt.want)
+		}
+	}
+}
+
+func TestParseNextflowParams(t *testing.T) {
+	got := parseNextflowParams(`
+params.reads = "data/*.fq"
+params.threads = 8
+params.ratio = 0.5
+params.skip_qc = false
+params.outdir = null
+params.threads = 16
+workflow { }
+`)
+	want := []workflowInput{
+		{Name: "reads", Type: "String", Optional: true, Default: `"data/*.fq"`},
+		{Name: "threads", Type: "Int", Optional: true, Default: "8"},
+		{Name: "ratio", Type: "Float", Optional: true, Default: "0.5"},
+		{Name: "skip_qc", Type: "Boolean", Optional: true, Default: "false"},
+		{Name: "outdir", Type: "String?", Optional: true, Default: "null"},
+	}
+	if !reflect.DeepEqual(got.Inputs, want) {
+		t.Errorf("got  %+v\nwant %+v", got.Inputs, want)
+	}
+}
+
+func TestWDLType(t *testing.T) {
+	tests := []struct {
+		typ      string
+		base     string
+		params   []string
+		optional bool
+		nonEmpty bool
+	}{
+		{"Int", "Int", nil, false, false},
+		{"File?", "File", nil, true, false},
+		{"Array[File]+", "Array", []string{"File"}, false, true},
+		{"Array[Int]+?", "Array", []string{"Int"}, true, true},
+		{"Map[String,Array[Int]]", "Map", []string{"String", "Array[Int]"}, false, false},
+		{"Pair[Map[String,Int],File]", "Pair", []string{"Map[String,Int]", "File"}, false, false},
+	}
+	for _, tt := range tests {
+		base, params, optional, nonEmpty := wdlType(tt.typ)
+		if base != tt.base || !reflect.DeepEqual(params, tt.params) || optional != tt.optional || nonEmpty != tt.nonEmpty {
+			t.Errorf("wdlType(%q) = %q, %q, %v, %v", tt.typ, base, params, optional, nonEmpty)
+		}
+	}
+}
+
+func TestInputCheckerCheck(t *testing.T) {
+	tests := []struct {
+		typ     string
+		value   interface{}
+		want    interface{}
+		wantErr string
+	}{
+		{"String", "x", "x", ""},
+		{"String", float64(1000000), "1000000", ""},
+		{"String", 1.10, "1.1", ""},
+		{"String", true, "true", ""},
+		{"Int", float64(3), float64(3), ""},
+		{"Int", "42", int64(42), ""},
+		{"Int", 1.5, 1.5, "1.5 is not an Int"},
+		{"Float", "0.25", 0.25, ""},
+		{"Boolean", "true", true, ""},
+		{"Boolean", "yes", "yes", "expected Boolean"},
+		{"File", "gs://b/x", "gs://b/x", ""},
+		{"File", "local.txt", "local.txt", "is not a cloud URI"},
+		{"File", "wb://refs/hg38.fa", "gs://refs-bucket/hg38.fa", ""},
+		{"File?", nil, nil, ""},
+		{"File", nil, nil, "null is not allowed"},
+		{"Array[Int]", []interface{}{"1", float64(2)}, []interface{}{int64(1), float64(2)}, ""},
+		{"Array[Int]+", []interface{}{}, []interface{}{}, "must not be empty"},
+		{"Map[String,Int]", map[string]interface{}{"a": "1"}, map[string]interface{}{"a": int64(1)}, ""},
+		{"Pair[Int,String]", map[string]interface{}{"left": float64(1)}, map[string]interface{}{"left": float64(1)}, "needs {\"left\""},
+		{"MyStruct", "x", "x", "needs a JSON object"},
+		{"Array", []interface{}{"a"}, []interface{}{"a"}, "Array takes 1 type parameter(s)"},
+		{"Map", map[string]interface{}{"a": "1"}, map[string]interface{}{"a": "1"}, "Map takes 2 type parameter(s)"},
+		{"Map[String]", map[string]interface{}{"a": "1"}, map[string]interface{}{"a": "1"}, "Map takes 2 type parameter(s)"},
+		{"Pair[Int]", map[string]interface{}{"left": float64(1)}, map[string]interface{}{"left": float64(1)}, "Pair takes 2 type parameter(s)"},
+	}
+	for _, tt := range tests {
+		c := &inputChecker{resolved: map[string]string{}, roots: map[string]string{"refs": "gs://refs-bucket/"}}
+		got := c.check("in", tt.typ, tt.value)
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("check(%s, %#v) = %#v, want %#v", tt.typ, tt.value, got, tt.want)
+		}
+		errs := strings.Join(c.errs, "; ")
+		if tt.wantErr == "" && errs != "" || !strings.Contains(errs, tt.wantErr) {
+			t.Errorf("check(%s, %#v) errors = %q, want %q", tt.typ, tt.value, errs, tt.wantErr)
+		}
+	}
+}
+
+func TestValidateWorkflowInputsNextflowPassthrough(t *testing.T) {
+	def := &workflowDefinition{Language: "Nextflow", Inputs: []workflowInput{}}
+	inputs := map[string]interface{}{
+		"max_reads": float64(1000000),
+		"ratio":     1.10,
+		"samples":   []interface{}{"a", "b"},
+		"opts":      map[string]interface{}{"x": true},
+		"name":      "run1",
+	}
+	v := validateWorkflowInputs(context.Background(), defaultSession, def, inputs)
+	if len(v.Errors) != 0 {
+		t.Fatalf("unexpected errors: %v", v.Errors)
+	}
+	if !reflect.DeepEqual(v.Inputs, inputs) {
+		t.Errorf("got %#v, want the inputs unchanged", v.Inputs)
+	}
+	if len(v.Warnings) != len(inputs) {
+		t.Errorf("got %d warnings, want one per undeclared param", len(v.Warnings))
+	}
+}
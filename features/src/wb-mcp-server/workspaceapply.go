@@ -0,0 +1,558 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// workspace_apply brings a workspace in line with a declarative spec. Reads
+// go through the Workspace Manager API; changes are made with the same wb
+// commands as the individual tools (workspace_create, workspace_set_property,
+// ...). Apply only adds and updates: anything in the workspace but not in the
+// spec is reported and left alone, so re-applying a spec is a no-op.
+
+// workspaceSpec is the declarative description of one workspace.
+type workspaceSpec struct {
+	ID             string                 `json:"id"`
+	Name           string                 `json:"name"`
+	Description    string                 `json:"description"`
+	PodID          string                 `json:"podId"`
+	OrganizationID string                 `json:"organizationId"`
+	Properties     map[string]interface{} `json:"properties"`
+	Users          []struct {
+		Email string `json:"email"`
+		Role  string `json:"role"`
+	} `json:"users"`
+	Folders []struct {
+		ID          string `json:"id"`
+		DisplayName string `json:"displayName"`
+		Description string `json:"description"`
+		ParentID    string `json:"parentId"`
+	} `json:"folders"`
+	Resources []struct {
+		Type        string `json:"type"`
+		ID          string `json:"id"`
+		BucketName  string `json:"bucketName"`
+		DatasetID   string `json:"datasetId"`
+		FolderName  string `json:"folderName"`
+		Description string `json:"description"`
+	} `json:"resources"`
+}
+
+var (
+	workspaceRoles = []string{"READER", "WRITER", "OWNER"}
+	// specResourceTypes maps spec resource types to Workspace Manager's.
+	specResourceTypes = map[string]string{
+		"gcs-bucket": "GCS_BUCKET",
+		"bq-dataset": "BIG_QUERY_DATASET",
+		"s3-folder":  "AWS_S3_STORAGE_FOLDER",
+	}
+)
+
+// workspaceState is what the plan compares the spec against.
+type workspaceState struct {
+	Exists      bool
+	UUID        string
+	Name        string
+	Description string
+	Properties  map[string]string
+	Roles       map[string]map[string]bool // lowercased email -> roles
+	Resources   map[string]string          // name -> resource type
+	Folders     []workspaceFolder
+}
+
+type workspaceFolder struct {
+	ID             string `json:"id"`
+	DisplayName    string `json:"displayName"`
+	Description    string `json:"description"`
+	ParentFolderID string `json:"parentFolderId"`
+}
+
+// applyStep is one line of the plan. Steps with args are changes; the rest
+// are context (unchanged items, conflicts, unmanaged items).
+type applyStep struct {
+	Mark    string // "+" add, "~" change, "!" conflict, "=" unchanged, "?" not in spec
+	Summary string
+	Args    []string
+}
+
+// loadWorkspaceSpec decodes spec (an object, or YAML/JSON text) or the file
+// at specPath under the local root, rejecting unknown fields so typos don't
+// silently do nothing.
+func loadWorkspaceSpec(args map[string]interface{}) (*workspaceSpec, error) {
+	raw := args["spec"]
+	if p, ok := args["specPath"].(string); ok && p != "" {
+		root, rel, err := openLocalRoot(p)
+		if err != nil {
+			return nil, err
+		}
+		defer root.Close()
+		data, err := root.ReadFile(rel)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read spec: %w", localPathError(root, rel, err))
+		}
+		raw = string(data)
+	}
+	if text, ok := raw.(string); ok {
+		v, err := parseYAML(text)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse spec: %w", err)
+		}
+		raw = v
+	}
+	if raw == nil {
+		return nil, fmt.Errorf("missing required parameter: spec (or specPath)")
+	}
+	data, err := json.Marshal(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid spec: %w", err)
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	dec.UseNumber()
+	var spec workspaceSpec
+	if err := dec.Decode(&spec); err != nil {
+		return nil, fmt.Errorf("invalid spec: %w", err)
+	}
+
+	var errs []string
+	if spec.ID == "" {
+		errs = append(errs, "id is required")
+	}
+	// Property values are strings. Numbers in YAML or JSON text keep the text
+	// they were written with; a spec passed as an object arrives already
+	// parsed, so quote values like 1.10 there.
+	for k, v := range spec.Properties {
+		switch v := v.(type) {
+		case string:
+		case json.Number:
+			spec.Properties[k] = v.String()
+		case bool:
+			spec.Properties[k] = strconv.FormatBool(v)
+		default:
+			errs = append(errs, fmt.Sprintf("properties.%s: must be a string, number or boolean", k))
+		}
+	}
+	for i, u := range spec.Users {
+		if u.Email == "" {
+			errs = append(errs, fmt.Sprintf("users[%d]: email is required", i))
+		}
+		role, err := checkOneOf("role", u.Role, workspaceRoles)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("users[%d]: %v", i, err))
+		}
+		spec.Users[i].Role = role
+	}
+	// parentId names another spec folder, which must come first so it's
+	// created first, or a folder already in the workspace.
+	folderIndex := map[string]int{}
+	for i := len(spec.Folders) - 1; i >= 0; i-- {
+		folderIndex[spec.Folders[i].ID] = i
+	}
+	for i, f := range spec.Folders {
+		if f.ID == "" || f.DisplayName == "" {
+			errs = append(errs, fmt.Sprintf("folders[%d]: id and displayName are required", i))
+		}
+		if j, ok := folderIndex[f.ParentID]; ok && f.ParentID != "" && j >= i {
+			errs = append(errs, fmt.Sprintf("folders[%d]: parent folder %q must be listed before it", i, f.ParentID))
+		}
+	}
+	types := sortedKeys(specResourceTypes)
+	for i, r := range spec.Resources {
+		if _, err := checkOneOf("resource type", r.Type, types); err != nil {
+			errs = append(errs, fmt.Sprintf("resources[%d]: %v", i, err))
+			continue
+		}
+		spec.Resources[i].Type = strings.ToLower(r.Type)
+		var field, value string
+		switch spec.Resources[i].Type {
+		case "gcs-bucket":
+			field, value = "bucketName", r.BucketName
+		case "bq-dataset":
+			field, value = "datasetId", r.DatasetID
+		case "s3-folder":
+			field, value = "folderName", r.FolderName
+		}
+		if r.ID == "" || value == "" {
+			errs = append(errs, fmt.Sprintf("resources[%d]: id and %s are required for %s", i, field, r.Type))
+		}
+	}
+	if len(errs) > 0 {
+		return nil, fmt.Errorf("invalid spec:\n- %s", strings.Join(errs, "\n- "))
+	}
+	return &spec, nil
+}
+
+// fetchWorkspaceState reads the workspace, its role bindings, resources and
+// folders. A workspace that doesn't exist is reported as such; any other
+// failure to look it up is returned, so apply never plans to create a
+// workspace it merely couldn't read.
+func fetchWorkspaceState(ctx context.Context, workspaceId string) (*workspaceState, error) {
+	st := &workspaceState{Properties: map[string]string{}, Roles: map[string]map[string]bool{}, Resources: map[string]string{}}
+	uuid, err := resolveWorkspaceId(ctx, workspaceId)
+	if errors.Is(err, errWorkspaceNotFound) {
+		return st, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	base := fmt.Sprintf("%s/api/workspaces/v1/%s", workspaceBaseURL, uuid)
+
+	respBody, err := makeAPIRequest(ctx, "GET", base, nil)
+	var ae *apiError
+	if errors.As(err, &ae) && ae.Status == http.StatusNotFound {
+		return st, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read workspace: %w", err)
+	}
+	st.Exists, st.UUID = true, uuid
+	var ws map[string]interface{}
+	if err := json.Unmarshal(respBody, &ws); err != nil {
+		return nil, fmt.Errorf("failed to parse workspace: %w", err)
+	}
+	st.Name, _ = ws["displayName"].(string)
+	st.Description, _ = ws["description"].(string)
+	st.Properties = workspaceProperties(ws)
+
+	if st.Roles, err = getWorkspaceRoles(ctx, base); err != nil {
+		return nil, err
+	}
+	resources, err := getWorkspaceResources(ctx, base)
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range resources {
+		st.Resources[r.Metadata.Name] = r.Metadata.ResourceType
+	}
+	if st.Folders, err = getWorkspaceFolders(ctx, base); err != nil {
+		return nil, err
+	}
+	return st, nil
+}
+
+// getWorkspaceRoles returns each member's roles, keyed by lowercased email.
+// base is the workspace's Workspace Manager URL.
+func getWorkspaceRoles(ctx context.Context, base string) (map[string]map[string]bool, error) {
+	respBody, err := makeAPIRequest(ctx, "GET", base+"/roles", nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read workspace roles: %w", err)
+	}
+	var bindings []struct {
+		Role    string        `json:"role"`
+		Members []interface{} `json:"members"`
+	}
+	if err := json.Unmarshal(respBody, &bindings); err != nil {
+		return nil, fmt.Errorf("failed to parse workspace roles: %w", err)
+	}
+	roles := map[string]map[string]bool{}
+	for _, b := range bindings {
+		for _, m := range b.Members {
+			email, _ := m.(string)
+			if mm, ok := m.(map[string]interface{}); ok {
+				email, _ = mm["email"].(string)
+			}
+			email = strings.ToLower(email)
+			if roles[email] == nil {
+				roles[email] = map[string]bool{}
+			}
+			roles[email][strings.ToUpper(b.Role)] = true
+		}
+	}
+	return roles, nil
+}
+
+// wsmResource is a resource as listed by Workspace Manager.
+type wsmResource struct {
+	Metadata struct {
+		Name                string        `json:"name"`
+		ResourceType        string        `json:"resourceType"`
+		Description         string        `json:"description"`
+		StewardshipType     string        `json:"stewardshipType"`
+		CloningInstructions string        `json:"cloningInstructions"`
+		ResourceLineage     []interface{} `json:"resourceLineage"`
+		Properties          []interface{} `json:"properties"`
+	} `json:"metadata"`
+	ResourceAttributes map[string]interface{} `json:"resourceAttributes"`
+}
+
+func getWorkspaceResources(ctx context.Context, base string) ([]wsmResource, error) {
+	respBody, err := makeAPIRequest(ctx, "GET", base+"/resources?offset=0&limit=1000", nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read workspace resources: %w", err)
+	}
+	var resources struct {
+		Resources []wsmResource `json:"resources"`
+	}
+	if err := json.Unmarshal(respBody, &resources); err != nil {
+		return nil, fmt.Errorf("failed to parse workspace resources: %w", err)
+	}
+	return resources.Resources, nil
+}
+
+func getWorkspaceFolders(ctx context.Context, base string) ([]workspaceFolder, error) {
+	respBody, err := makeAPIRequest(ctx, "GET", base+"/folders", nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read workspace folders: %w", err)
+	}
+	var folders struct {
+		Folders []workspaceFolder `json:"folders"`
+	}
+	if err := json.Unmarshal(respBody, &folders); err != nil {
+		return nil, fmt.Errorf("failed to parse workspace folders: %w", err)
+	}
+	return folders.Folders, nil
+}
+
+// planWorkspace compares spec with st and returns the plan, in the order the
+// steps must run.
+func planWorkspace(spec *workspaceSpec, st *workspaceState) []applyStep {
+	var steps []applyStep
+	ws := "--workspace=" + spec.ID
+
+	if !st.Exists {
+		args := []string{"workspace", "create", "--id=" + spec.ID, "--pod=" + spec.PodID}
+		if spec.Name != "" {
+			args = append(args, "--name="+spec.Name)
+		}
+		if spec.Description != "" {
+			args = append(args, "--description="+spec.Description)
+		}
+		if spec.OrganizationID != "" {
+			args = append(args, "--org="+spec.OrganizationID)
+		}
+		if spec.PodID == "" {
+			steps = append(steps, applyStep{Mark: "!", Summary: fmt.Sprintf("workspace %s does not exist and the spec has no podId to create it in", spec.ID)})
+		} else {
+			steps = append(steps, applyStep{Mark: "+", Summary: fmt.Sprintf("workspace %s (pod %s)", spec.ID, spec.PodID), Args: args})
+		}
+	} else {
+		args := []string{"workspace", "update", ws}
+		var changes []string
+		if spec.Name != "" && spec.Name != st.Name {
+			args = append(args, "--name="+spec.Name)
+			changes = append(changes, fmt.Sprintf("name %q -> %q", st.Name, spec.Name))
+		}
+		if spec.Description != "" && spec.Description != st.Description {
+			args = append(args, "--description="+spec.Description)
+			changes = append(changes, fmt.Sprintf("description %q -> %q", truncate(st.Description, 40), truncate(spec.Description, 40)))
+		}
+		if len(changes) > 0 {
+			steps = append(steps, applyStep{Mark: "~", Summary: "workspace " + strings.Join(changes, ", "), Args: args})
+		} else {
+			steps = append(steps, applyStep{Mark: "=", Summary: "workspace " + spec.ID})
+		}
+	}
+
+	keys := make([]string, 0, len(spec.Properties))
+	for k := range spec.Properties {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		value, _ := spec.Properties[k].(string)
+		current, ok := st.Properties[k]
+		switch {
+		case ok && current == value:
+			steps = append(steps, applyStep{Mark: "=", Summary: fmt.Sprintf("property %s = %q", k, value)})
+		case ok:
+			steps = append(steps, applyStep{Mark: "~", Summary: fmt.Sprintf("property %s %q -> %q", k, current, value), Args: []string{"workspace", "set-property", ws, "--key=" + k, "--value=" + value}})
+		default:
+			steps = append(steps, applyStep{Mark: "+", Summary: fmt.Sprintf("property %s = %q", k, value), Args: []string{"workspace", "set-property", ws, "--key=" + k, "--value=" + value}})
+		}
+	}
+
+	specEmails := map[string]bool{}
+	for _, u := range spec.Users {
+		email := strings.ToLower(u.Email)
+		specEmails[email] = true
+		roles := st.Roles[email]
+		switch {
+		case roles[u.Role]:
+			steps = append(steps, applyStep{Mark: "=", Summary: fmt.Sprintf("user %s %s", u.Email, u.Role)})
+		case len(roles) > 0:
+			steps = append(steps, applyStep{Mark: "+", Summary: fmt.Sprintf("user %s %s (currently %s; existing roles are kept)", u.Email, u.Role, strings.Join(sortedKeys(roles), ", ")), Args: []string{"workspace", "add-user", ws, "--email=" + u.Email, "--role=" + u.Role}})
+		default:
+			steps = append(steps, applyStep{Mark: "+", Summary: fmt.Sprintf("user %s %s", u.Email, u.Role), Args: []string{"workspace", "add-user", ws, "--email=" + u.Email, "--role=" + u.Role}})
+		}
+	}
+
+	// folderIDs maps spec folder IDs to the IDs of the matching folders in
+	// this workspace, which differ when a folder already exists under
+	// another ID.
+	specFolders := map[string]bool{}
+	folderIDs := map[string]string{}
+	for _, f := range spec.Folders {
+		specFolders[f.ID] = true
+		specFolders[f.DisplayName] = true
+		parent := ""
+		if f.ParentID != "" {
+			var ok bool
+			if parent, ok = folderIDs[f.ParentID]; !ok {
+				for _, cur := range st.Folders {
+					if cur.ID == f.ParentID {
+						parent, ok = cur.ID, true
+					}
+				}
+			}
+			if !ok {
+				steps = append(steps, applyStep{Mark: "!", Summary: fmt.Sprintf("folder %s %q: parent %q is neither a spec folder nor a folder in the workspace", f.ID, f.DisplayName, f.ParentID)})
+				continue
+			}
+		}
+		existing := ""
+		for _, cur := range st.Folders {
+			if cur.ID == f.ID || cur.DisplayName == f.DisplayName {
+				existing = cur.ID
+				break
+			}
+		}
+		if existing != "" {
+			folderIDs[f.ID] = existing
+			steps = append(steps, applyStep{Mark: "=", Summary: fmt.Sprintf("folder %s %q", f.ID, f.DisplayName)})
+			continue
+		}
+		folderIDs[f.ID] = f.ID
+		args := []string{"folder", "create", "--id=" + f.ID, "--display-name=" + f.DisplayName}
+		if f.Description != "" {
+			args = append(args, "--description="+f.Description)
+		}
+		if parent != "" {
+			args = append(args, "--parent-folder-id="+parent)
+		}
+		steps = append(steps, applyStep{Mark: "+", Summary: fmt.Sprintf("folder %s %q", f.ID, f.DisplayName), Args: append(args, ws)})
+	}
+
+	specResources := map[string]bool{}
+	for _, r := range spec.Resources {
+		specResources[r.ID] = true
+		want := specResourceTypes[r.Type]
+		if current, ok := st.Resources[r.ID]; ok {
+			if current == want {
+				steps = append(steps, applyStep{Mark: "=", Summary: fmt.Sprintf("resource %s %s", r.Type, r.ID)})
+			} else {
+				steps = append(steps, applyStep{Mark: "!", Summary: fmt.Sprintf("resource %s exists as %s but the spec wants %s", r.ID, current, r.Type)})
+			}
+			continue
+		}
+		var args []string
+		var detail string
+		switch r.Type {
+		case "gcs-bucket":
+			args = []string{"resource", "create", "gcs-bucket", "--id=" + r.ID, "--bucket-name=" + r.BucketName}
+			detail = "bucket " + r.BucketName
+		case "bq-dataset":
+			args = []string{"resource", "create", "bq-dataset", "--id=" + r.ID, "--dataset-id=" + r.DatasetID}
+			detail = "dataset " + r.DatasetID
+		case "s3-folder":
+			args = []string{"resource", "create", "s3-storage-folder", "--name=" + r.ID, "--folder-name=" + r.FolderName}
+			detail = "folder " + r.FolderName
+		}
+		if r.Description != "" {
+			args = append(args, "--description="+r.Description)
+		}
+		steps = append(steps, applyStep{Mark: "+", Summary: fmt.Sprintf("resource %s %s (%s)", r.Type, r.ID, detail), Args: append(args, ws)})
+	}
+
+	// Report what the spec doesn't manage, so drift is visible.
+	for _, email := range sortedKeys(st.Roles) {
+		if !specEmails[email] && len(spec.Users) > 0 {
+			steps = append(steps, applyStep{Mark: "?", Summary: fmt.Sprintf("user %s %s", email, strings.Join(sortedKeys(st.Roles[email]), ", "))})
+		}
+	}
+	for _, f := range st.Folders {
+		if !specFolders[f.ID] && !specFolders[f.DisplayName] && len(spec.Folders) > 0 {
+			steps = append(steps, applyStep{Mark: "?", Summary: fmt.Sprintf("folder %q", f.DisplayName)})
+		}
+	}
+	for _, name := range sortedKeys(st.Resources) {
+		if !specResources[name] && len(spec.Resources) > 0 {
+			steps = append(steps, applyStep{Mark: "?", Summary: fmt.Sprintf("resource %s %s", name, st.Resources[name])})
+		}
+	}
+	return steps
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// handleWorkspaceApply implements workspace_apply: show the plan, and with
+// apply=true run its changes in order, stopping at the first failure.
+func handleWorkspaceApply(ctx context.Context, args map[string]interface{}) (string, error) {
+	spec, err := loadWorkspaceSpec(args)
+	if err != nil {
+		return "", err
+	}
+	st, err := fetchWorkspaceState(ctx, spec.ID)
+	if err != nil {
+		return "", err
+	}
+	steps := planWorkspace(spec, st)
+
+	var b strings.Builder
+	counts := map[string]int{}
+	fmt.Fprintf(&b, "Plan for workspace %s:\n", spec.ID)
+	for _, s := range steps {
+		counts[s.Mark]++
+		fmt.Fprintf(&b, "  %s %s\n", s.Mark, s.Summary)
+	}
+	fmt.Fprintf(&b, "\n%d to add, %d to change, %d unchanged", counts["+"], counts["~"], counts["="])
+	if counts["?"] > 0 {
+		fmt.Fprintf(&b, ", %d not in spec (left as is)", counts["?"])
+	}
+	if counts["!"] > 0 {
+		fmt.Fprintf(&b, ", %d conflict(s)", counts["!"])
+	}
+	b.WriteString(".\n")
+
+	apply, _ := args["apply"].(bool)
+	switch {
+	case counts["+"]+counts["~"] == 0 && counts["!"] == 0:
+		b.WriteString("Workspace already matches the spec; nothing to do.\n")
+		return b.String(), nil
+	case counts["!"] > 0:
+		if apply {
+			return "", fmt.Errorf("%sResolve the conflicts (!) before applying; nothing was changed", b.String())
+		}
+		b.WriteString("Resolve the conflicts (!) before applying.\n")
+		return b.String(), nil
+	case !apply:
+		b.WriteString("This is a plan only. Call workspace_apply again with apply=true to make these changes.\n")
+		return b.String(), nil
+	}
+
+	b.WriteString("\nApplying:\n")
+	for i, s := range steps {
+		if s.Args == nil {
+			continue
+		}
+		out, err := executeWbCommand(ctx, s.Args)
+		if err != nil {
+			fmt.Fprintf(&b, "  FAILED %s %s: %v\n    %s\n", s.Mark, s.Summary, err, truncate(strings.TrimSpace(out), 300))
+			remaining := 0
+			for _, rest := range steps[i+1:] {
+				if rest.Args != nil {
+					remaining++
+				}
+			}
+			fmt.Fprintf(&b, "Stopped; %d change(s) not attempted. Fix the error and re-run: completed steps will show as unchanged.\n", remaining)
+			return "", fmt.Errorf("%s", b.String())
+		}
+		fmt.Fprintf(&b, "  done %s %s\n", s.Mark, s.Summary)
+	}
+	b.WriteString("Workspace matches the spec.\n")
+	return b.String(), nil
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestManifestSpecFolders(t *testing.T) {
+	m := &workspaceManifest{Folders: []manifestFolder{
+		{ID: "uuid-a", DisplayName: "Analysis", Path: "Analysis"},
+		{ID: "uuid-b", DisplayName: "QC runs", Path: "Analysis/QC runs", ParentID: "uuid-a"},
+		{ID: "uuid-c", DisplayName: "qc_runs", Path: "Analysis/qc_runs", ParentID: "uuid-a"},
+		{ID: "uuid-d", DisplayName: "Orphan", Path: "Orphan", ParentID: "uuid-gone"},
+	}}
+	got := manifestSpec(m)["folders"]
+	want := []map[string]interface{}{
+		{"id": "analysis", "displayName": "Analysis"},
+		{"id": "analysis-qc-runs", "displayName": "QC runs", "parentId": "analysis"},
+		{"id": "analysis-qc-runs-2", "displayName": "qc_runs", "parentId": "analysis"},
+		{"id": "orphan", "displayName": "Orphan"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got  %v\nwant %v", got, want)
+	}
+}
+
+func TestPlanWorkspaceFolderParents(t *testing.T) {
+	spec, err := loadWorkspaceSpec(map[string]interface{}{"spec": `id: ws
+folders:
+  - id: analysis
+    displayName: Analysis
+  - id: analysis-qc
+    displayName: QC
+    parentId: analysis
+  - id: raw
+    displayName: Raw
+  - id: raw-fastq
+    displayName: FASTQ
+    parentId: raw
+  - id: misc
+    displayName: Misc
+    parentId: existing-uuid
+  - id: lost
+    displayName: Lost
+    parentId: nowhere
+`})
+	if err != nil {
+		t.Fatal(err)
+	}
+	st := &workspaceState{Exists: true, Folders: []workspaceFolder{
+		{ID: "target-uuid", DisplayName: "Analysis"},
+		{ID: "existing-uuid", DisplayName: "Shared"},
+	}}
+	var got []string
+	for _, s := range planWorkspace(spec, st) {
+		if strings.HasPrefix(s.Summary, "folder ") {
+			got = append(got, s.Mark+" "+s.Summary+" "+strings.Join(s.Args, " "))
+		}
+	}
+	want := []string{
+		`= folder analysis "Analysis" `,
+		`+ folder analysis-qc "QC" folder create --id=analysis-qc --display-name=QC --parent-folder-id=target-uuid --workspace=ws`,
+		`+ folder raw "Raw" folder create --id=raw --display-name=Raw --workspace=ws`,
+		`+ folder raw-fastq "FASTQ" folder create --id=raw-fastq --display-name=FASTQ --parent-folder-id=raw --workspace=ws`,
+		`+ folder misc "Misc" folder create --id=misc --display-name=Misc --parent-folder-id=existing-uuid --workspace=ws`,
+		`! folder lost "Lost": parent "nowhere" is neither a spec folder nor a folder in the workspace `,
+		`? folder "Shared" `,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestLoadWorkspaceSpecFolderOrder(t *testing.T) {
+	for _, src := range []string{
+		"id: ws\nfolders:\n  - id: b\n    displayName: B\n    parentId: a\n  - id: a\n    displayName: A\n",
+		"id: ws\nfolders:\n  - id: a\n    displayName: A\n    parentId: a\n",
+	} {
+		_, err := loadWorkspaceSpec(map[string]interface{}{"spec": src})
+		if err == nil || !strings.Contains(err.Error(), "must be listed before it") {
+			t.Errorf("got %v, want a folder order error", err)
+		}
+	}
+}
@@ -0,0 +1,313 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// parseYAML decodes the block-style YAML subset used for workspace specs into
+// the same shapes encoding/json produces with UseNumber
+// (map[string]interface{}, []interface{}, string, json.Number, bool, nil):
+// nested mappings and sequences, plain and quoted scalars, | and > block
+// scalars, JSON-style flow collections, and # comments. Numbers keep their
+// source text, so 1.10 stays "1.10" when a string is wanted; plain scalars
+// that aren't JSON numbers (inf, .5, 0x1F, 1_000) are strings. Anchors, tags
+// and multi-document streams are not supported. Input starting with { or [ is
+// decoded as JSON.
+func parseYAML(src string) (interface{}, error) {
+	if t := strings.TrimSpace(src); strings.HasPrefix(t, "{") || strings.HasPrefix(t, "[") {
+		v, err := decodeJSONNumbers(t)
+		if err != nil {
+			return nil, fmt.Errorf("invalid JSON: %w", err)
+		}
+		return v, nil
+	}
+	p := &yamlParser{}
+	for i, raw := range strings.Split(strings.ReplaceAll(src, "\t", "    "), "\n") {
+		text := strings.TrimRight(stripYAMLComment(raw), " \r")
+		trimmed := strings.TrimLeft(text, " ")
+		if trimmed == "" || trimmed == "---" {
+			p.lines = append(p.lines, yamlLine{n: i + 1, indent: -1, raw: raw})
+			continue
+		}
+		p.lines = append(p.lines, yamlLine{n: i + 1, indent: len(text) - len(trimmed), text: trimmed, raw: raw})
+	}
+	p.skipBlank()
+	if p.pos >= len(p.lines) {
+		return nil, nil
+	}
+	v, err := p.block(p.lines[p.pos].indent)
+	if err != nil {
+		return nil, err
+	}
+	if p.skipBlank(); p.pos < len(p.lines) {
+		return nil, fmt.Errorf("line %d: unexpected indentation", p.lines[p.pos].n)
+	}
+	return v, nil
+}
+
+type yamlLine struct {
+	n      int
+	indent int // -1 for blank and comment lines
+	text   string
+	raw    string
+}
+
+type yamlParser struct {
+	lines []yamlLine
+	pos   int
+}
+
+func (p *yamlParser) skipBlank() {
+	for p.pos < len(p.lines) && p.lines[p.pos].indent < 0 {
+		p.pos++
+	}
+}
+
+// block parses the mapping or sequence whose lines start at indent.
+func (p *yamlParser) block(indent int) (interface{}, error) {
+	if l := p.lines[p.pos]; l.text == "-" || strings.HasPrefix(l.text, "- ") {
+		return p.sequence(indent)
+	}
+	return p.mapping(indent)
+}
+
+func (p *yamlParser) sequence(indent int) (interface{}, error) {
+	out := []interface{}{}
+	for p.skipBlank(); p.pos < len(p.lines); p.skipBlank() {
+		l := p.lines[p.pos]
+		isItem := l.text == "-" || strings.HasPrefix(l.text, "- ")
+		if l.indent < indent || (l.indent == indent && !isItem) {
+			// A key at the sequence's indentation ends a sequence nested
+			// at its parent key's column.
+			break
+		}
+		if l.indent > indent {
+			return nil, fmt.Errorf("line %d: expected a list item", l.n)
+		}
+		rest := strings.TrimLeft(strings.TrimPrefix(l.text, "-"), " ")
+		if rest == "" {
+			p.pos++
+			v, err := p.nested(indent)
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, v)
+			continue
+		}
+		if _, _, isKey := yamlKey(rest); isKey || strings.HasPrefix(rest, "- ") {
+			// "- key: value" starts a mapping indented to the key's column;
+			// rewrite the line so the mapping parser sees it that way.
+			p.lines[p.pos].indent = l.indent + len(l.text) - len(rest)
+			p.lines[p.pos].text = rest
+			v, err := p.block(p.lines[p.pos].indent)
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, v)
+			continue
+		}
+		p.pos++
+		v, err := yamlScalar(rest, l.n)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, v)
+	}
+	return out, nil
+}
+
+func (p *yamlParser) mapping(indent int) (interface{}, error) {
+	out := map[string]interface{}{}
+	for p.skipBlank(); p.pos < len(p.lines); p.skipBlank() {
+		l := p.lines[p.pos]
+		if l.indent < indent {
+			break
+		}
+		if l.indent > indent {
+			return nil, fmt.Errorf("line %d: unexpected indentation", l.n)
+		}
+		key, value, ok := yamlKey(l.text)
+		if !ok {
+			return nil, fmt.Errorf("line %d: expected \"key: value\"", l.n)
+		}
+		if _, dup := out[key]; dup {
+			return nil, fmt.Errorf("line %d: duplicate key %q", l.n, key)
+		}
+		p.pos++
+		var v interface{}
+		var err error
+		switch value {
+		case "":
+			v, err = p.nested(indent)
+		case "|", "|-", ">", ">-":
+			v = p.blockScalar(indent, value)
+		default:
+			v, err = yamlScalar(value, l.n)
+		}
+		if err != nil {
+			return nil, err
+		}
+		out[key] = v
+	}
+	return out, nil
+}
+
+// nested parses the block under a "key:" or "-" line, or returns nil if the
+// next line isn't indented further. A sequence may sit at the key's own
+// indentation, as in "users:\n- email: ...".
+func (p *yamlParser) nested(parent int) (interface{}, error) {
+	p.skipBlank()
+	if p.pos >= len(p.lines) {
+		return nil, nil
+	}
+	l := p.lines[p.pos]
+	if l.indent > parent || (l.indent == parent && (l.text == "-" || strings.HasPrefix(l.text, "- "))) {
+		return p.block(l.indent)
+	}
+	return nil, nil
+}
+
+// blockScalar reads the lines of a | (literal) or > (folded) scalar.
+func (p *yamlParser) blockScalar(parent int, style string) string {
+	var parts []string
+	indent := -1
+	for ; p.pos < len(p.lines); p.pos++ {
+		l := p.lines[p.pos]
+		if l.indent >= 0 && l.indent <= parent {
+			break
+		}
+		if indent < 0 && l.indent > 0 {
+			indent = l.indent
+		}
+		if l.indent < 0 {
+			parts = append(parts, "")
+			continue
+		}
+		// Use the raw line: comments inside block scalars are content.
+		parts = append(parts, strings.TrimRight(l.raw[min(indent, len(l.raw)):], " \r"))
+	}
+	for len(parts) > 0 && parts[len(parts)-1] == "" {
+		parts = parts[:len(parts)-1]
+	}
+	sep := "\n"
+	if strings.HasPrefix(style, ">") {
+		sep = " "
+	}
+	s := strings.Join(parts, sep)
+	if !strings.HasSuffix(style, "-") {
+		s += "\n"
+	}
+	return s
+}
+
+// yamlKey splits "key: value" (key optionally quoted).
+func yamlKey(text string) (key, value string, ok bool) {
+	if strings.HasPrefix(text, `"`) || strings.HasPrefix(text, `'`) {
+		end := strings.IndexByte(text[1:], text[0])
+		if end < 0 {
+			return "", "", false
+		}
+		rest := text[end+2:]
+		if !strings.HasPrefix(rest, ":") {
+			return "", "", false
+		}
+		return text[1 : end+1], strings.TrimSpace(rest[1:]), true
+	}
+	i := strings.Index(text, ": ")
+	if i < 0 {
+		if strings.HasSuffix(text, ":") {
+			i = len(text) - 1
+		} else {
+			return "", "", false
+		}
+	}
+	key = strings.TrimSpace(text[:i])
+	if key == "" || strings.ContainsAny(key, "{}[]") {
+		return "", "", false
+	}
+	return key, strings.TrimSpace(text[i+1:]), true
+}
+
+// yamlScalar decodes a plain, quoted or flow scalar.
+func yamlScalar(s string, line int) (interface{}, error) {
+	switch {
+	case strings.HasPrefix(s, `"`):
+		v, err := strconv.Unquote(s)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: bad double-quoted string %s", line, s)
+		}
+		return v, nil
+	case strings.HasPrefix(s, `'`):
+		if len(s) < 2 || !strings.HasSuffix(s, `'`) {
+			return nil, fmt.Errorf("line %d: bad single-quoted string %s", line, s)
+		}
+		return strings.ReplaceAll(s[1:len(s)-1], "''", "'"), nil
+	case strings.HasPrefix(s, "[") || strings.HasPrefix(s, "{"):
+		v, err := decodeJSONNumbers(s)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: flow collections must be valid JSON: %s", line, s)
+		}
+		return v, nil
+	}
+	switch s {
+	case "true", "True", "TRUE":
+		return true, nil
+	case "false", "False", "FALSE":
+		return false, nil
+	case "null", "Null", "NULL", "~":
+		return nil, nil
+	}
+	if yamlNumberRe.MatchString(s) {
+		return json.Number(s), nil
+	}
+	return s, nil
+}
+
+// yamlNumberRe matches the plain scalars read as numbers: JSON's number
+// syntax, so every json.Number parseYAML returns marshals back unchanged.
+var yamlNumberRe = regexp.MustCompile(`^-?(0|[1-9][0-9]*)(\.[0-9]+)?([eE][-+]?[0-9]+)?$`)
+
+// decodeJSONNumbers decodes one JSON value, keeping numbers as json.Number.
+func decodeJSONNumbers(s string) (interface{}, error) {
+	dec := json.NewDecoder(strings.NewReader(s))
+	dec.UseNumber()
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return nil, fmt.Errorf("unexpected data after the JSON value")
+	}
+	return v, nil
+}
+
+// stripYAMLComment removes a # comment that starts the line or follows a
+// space, outside quotes.
+func stripYAMLComment(line string) string {
+	var quote byte
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case quote == '"' && c == '\\':
+			i++ // skip the escaped character
+		case quote == '\'' && c == '\'' && i+1 < len(line) && line[i+1] == '\'':
+			i++ // '' is an escaped quote
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			if i == 0 || line[i-1] == ' ' || line[i-1] == ':' || line[i-1] == '-' {
+				quote = c
+			}
+		case c == '#' && (i == 0 || line[i-1] == ' '):
+			return line[:i]
+		}
+	}
+	return line
+}
@@ -0,0 +1,303 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type yamlMap = map[string]interface{}
+type yamlList = []interface{}
+
+func TestParseYAML(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want interface{}
+	}{
+		{"empty", "# nothing\n\n", nil},
+		{
+			name: "scalars",
+			src: `int: 1000000
+float: 1.10
+exp: 1e6
+neg: -0.5
+zero: 0
+octal-looking: 007
+plus: +1
+leading-dot: .5
+hex: 0x1F
+underscore: 1_000
+inf: inf
+nan: .nan
+yes: true
+no: False
+nothing: ~
+empty:
+plain: hello world
+colon: a:b
+url: https://example.com/x#frag
+double: "tab\tand \"quote\""
+single: 'it''s # not a comment'
+escaped: "say \"hi\" # still text"
+quoted-number: "1.10"
+`,
+			want: yamlMap{
+				"int":           json.Number("1000000"),
+				"float":         json.Number("1.10"),
+				"exp":           json.Number("1e6"),
+				"neg":           json.Number("-0.5"),
+				"zero":          json.Number("0"),
+				"octal-looking": "007",
+				"plus":          "+1",
+				"leading-dot":   ".5",
+				"hex":           "0x1F",
+				"underscore":    "1_000",
+				"inf":           "inf",
+				"nan":           ".nan",
+				"yes":           true,
+				"no":            false,
+				"nothing":       nil,
+				"empty":         nil,
+				"plain":         "hello world",
+				"colon":         "a:b",
+				"url":           "https://example.com/x#frag",
+				"double":        "tab\tand \"quote\"",
+				"single":        "it's # not a comment",
+				"escaped":       `say "hi" # still text`,
+				"quoted-number": "1.10",
+			},
+		},
+		{
+			name: "comments",
+			src: `# header
+a: 1 # trailing
+b: x#y
+"c d": 2
+`,
+			want: yamlMap{"a": json.Number("1"), "b": "x#y", "c d": json.Number("2")},
+		},
+		{
+			name: "block scalars",
+			src: `literal: |
+  line one
+    indented # kept
+
+  line three
+strip: |-
+  no newline
+folded: >
+  one
+  two
+folded-strip: >-
+  a
+  b
+after: x
+`,
+			want: yamlMap{
+				"literal":      "line one\n  indented # kept\n\nline three\n",
+				"strip":        "no newline",
+				"folded":       "one two\n",
+				"folded-strip": "a b",
+				"after":        "x",
+			},
+		},
+		{
+			name: "nested mappings",
+			src: `a:
+  b:
+    c: 1
+  d: 2
+e: 3
+`,
+			want: yamlMap{"a": yamlMap{"b": yamlMap{"c": json.Number("1")}, "d": json.Number("2")}, "e": json.Number("3")},
+		},
+		{
+			name: "sequences",
+			src: `indented:
+  - 1
+  - two
+at-key-indent:
+- a
+- b
+maps:
+  - email: a@x.com
+    role: reader
+  - email: b@x.com
+    role: owner
+nested:
+  - - 1
+    - 2
+  -
+    - 3
+  - - - 4
+after: end
+`,
+			want: yamlMap{
+				"indented":      yamlList{json.Number("1"), "two"},
+				"at-key-indent": yamlList{"a", "b"},
+				"maps": yamlList{
+					yamlMap{"email": "a@x.com", "role": "reader"},
+					yamlMap{"email": "b@x.com", "role": "owner"},
+				},
+				"nested": yamlList{
+					yamlList{json.Number("1"), json.Number("2")},
+					yamlList{json.Number("3")},
+					yamlList{yamlList{json.Number("4")}},
+				},
+				"after": "end",
+			},
+		},
+		{
+			name: "top-level sequence of mappings with nested lists",
+			src: `- name: a
+  tags:
+    - x
+    - y
+- name: b
+  tags: []
+`,
+			want: yamlList{
+				yamlMap{"name": "a", "tags": yamlList{"x", "y"}},
+				yamlMap{"name": "b", "tags": yamlList{}},
+			},
+		},
+		{
+			name: "flow collections keep numbers",
+			src:  `command: ["echo", "{{x}}"]` + "\n" + `limits: {"cpu": 1.50, "mem": 4}`,
+			want: yamlMap{
+				"command": yamlList{"echo", "{{x}}"},
+				"limits":  yamlMap{"cpu": json.Number("1.50"), "mem": json.Number("4")},
+			},
+		},
+		{
+			name: "JSON document",
+			src:  `  {"a": [1.10, "x"], "b": null}`,
+			want: yamlMap{"a": yamlList{json.Number("1.10"), "x"}, "b": nil},
+		},
+		{
+			name: "tabs count as indentation",
+			src:  "a:\n\tb: 1\n",
+			want: yamlMap{"a": yamlMap{"b": json.Number("1")}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseYAML(tt.src)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got  %#v\nwant %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseYAMLErrors(t *testing.T) {
+	tests := []struct {
+		src  string
+		want string
+	}{
+		{"a: 1\na: 2", `line 2: duplicate key "a"`},
+		{"a: 1\n  b: 2", "line 2: unexpected indentation"},
+		{"a:\n  - 1\n    - 2", "line 3: expected a list item"},
+		{"just text", `line 1: expected "key: value"`},
+		{`a: "unterminated`, "line 1: bad double-quoted string"},
+		{`a: 'unterminated`, "line 1: bad single-quoted string"},
+		{"a: [b, c]", "line 1: flow collections must be valid JSON"},
+		{`{"a": 1`, "invalid JSON"},
+		{`[1]]`, "invalid JSON"},
+	}
+	for _, tt := range tests {
+		_, err := parseYAML(tt.src)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("parseYAML(%q) error = %v, want %q", tt.src, err, tt.want)
+		}
+	}
+}
+
+func TestLoadWorkspaceSpecProperties(t *testing.T) {
+	tests := []struct {
+		name    string
+		spec    interface{}
+		want    map[string]interface{}
+		wantErr string
+	}{
+		{
+			name: "YAML keeps number text",
+			spec: "id: ws\nproperties:\n  max: 1000000\n  version: 1.10\n  enabled: true\n  limit: inf\n",
+			want: map[string]interface{}{"max": "1000000", "version": "1.10", "enabled": "true", "limit": "inf"},
+		},
+		{
+			name: "JSON text keeps number text",
+			spec: `{"id": "ws", "properties": {"version": 1.10}}`,
+			want: map[string]interface{}{"version": "1.10"},
+		},
+		{
+			name: "object spec formats numbers without exponents",
+			spec: map[string]interface{}{"id": "ws", "properties": map[string]interface{}{"max": float64(1000000)}},
+			want: map[string]interface{}{"max": "1000000"},
+		},
+		{
+			name:    "nested property values are rejected",
+			spec:    "id: ws\nproperties:\n  a:\n    b: 1\n",
+			wantErr: "properties.a: must be a string, number or boolean",
+		},
+		{
+			name:    "null property values are rejected",
+			spec:    "id: ws\nproperties:\n  a:\n",
+			wantErr: "properties.a: must be a string, number or boolean",
+		},
+		{
+			name:    "unknown fields are rejected",
+			spec:    "id: ws\nproperty:\n  a: 1\n",
+			wantErr: `unknown field "property"`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			spec, err := loadWorkspaceSpec(map[string]interface{}{"spec": tt.spec})
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got error %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(spec.Properties, tt.want) {
+				t.Errorf("got %#v, want %#v", spec.Properties, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadWorkspaceSpecPath(t *testing.T) {
+	root := t.TempDir()
+	t.Setenv("WB_MCP_LOCAL_ROOT", root)
+	if err := os.WriteFile(filepath.Join(root, "spec.yaml"), []byte("id: ws\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	outside := filepath.Join(t.TempDir(), "secret.yaml")
+	if err := os.WriteFile(outside, []byte("id: secret\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(outside, filepath.Join(root, "link.yaml")); err != nil {
+		t.Fatal(err)
+	}
+
+	spec, err := loadWorkspaceSpec(map[string]interface{}{"specPath": "spec.yaml"})
+	if err != nil || spec.ID != "ws" {
+		t.Fatalf("got (%v, %v), want the spec under the root", spec, err)
+	}
+	for _, p := range []string{outside, "../" + filepath.Base(filepath.Dir(outside)) + "/secret.yaml", "link.yaml"} {
+		if _, err := loadWorkspaceSpec(map[string]interface{}{"specPath": p}); err == nil || !strings.Contains(err.Error(), "not allowed to leave it") {
+			t.Errorf("specPath %q: got %v, want it refused", p, err)
+		}
+	}
+}